@@ -1,14 +1,15 @@
 package account
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"log"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/jsyzchen/pan/conf"
+	"github.com/jsyzchen/pan/errno"
 	"github.com/jsyzchen/pan/utils/httpclient"
 )
 
@@ -33,20 +34,69 @@ type QuotaResponse struct {
 }
 
 type Account struct {
-	AccessToken string
+	AccessToken   string
+	MaxRetries    int           // 可重试错误的最大重试次数
+	RetryInterval time.Duration // 重试间隔，每次重试翻倍
 }
 
 const UserInfoUri = "/rest/2.0/xpan/nas?method=uinfo"
 const QuotaUri = "/api/quota"
 
+const (
+	defaultMaxRetries    = 2
+	defaultRetryInterval = time.Second
+)
+
 func NewAccountClient(accessToken string) *Account {
 	return &Account{
-		AccessToken: accessToken,
+		AccessToken:   accessToken,
+		MaxRetries:    defaultMaxRetries,
+		RetryInterval: defaultRetryInterval,
+	}
+}
+
+// 设置重试次数和首次重试间隔，maxRetries为0时不重试
+func (a *Account) SetRetry(maxRetries int, retryInterval time.Duration) {
+	a.MaxRetries = maxRetries
+	a.RetryInterval = retryInterval
+}
+
+// 执行请求，遇到网络错误、5xx等可重试错误时按间隔翻倍重试，ctx结束时停止
+func (a *Account) withRetry(ctx context.Context, fn func() error) error {
+	interval := a.RetryInterval
+	var err error
+	for i := 0; ; i++ {
+		err = fn()
+		if err == nil || i >= a.MaxRetries || ctx.Err() != nil || !errno.IsRetryable(err) {
+			return err
+		}
+		log.Printf("account request failed, retry after %v, err: %v", interval, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(interval):
+		}
+		interval *= 2
 	}
 }
 
 // 获取网盘用户信息
 func (a *Account) UserInfo() (UserInfoResponse, error) {
+	return a.UserInfoWithContext(context.Background())
+}
+
+// 获取网盘用户信息，可重试错误按重试设置重试
+func (a *Account) UserInfoWithContext(ctx context.Context) (UserInfoResponse, error) {
+	var ret UserInfoResponse
+	err := a.withRetry(ctx, func() error {
+		var err error
+		ret, err = a.userInfo(ctx)
+		return err
+	})
+	return ret, err
+}
+
+func (a *Account) userInfo(ctx context.Context) (UserInfoResponse, error) {
 	ret := UserInfoResponse{}
 
 	v := url.Values{}
@@ -54,32 +104,47 @@ func (a *Account) UserInfo() (UserInfoResponse, error) {
 	query := v.Encode()
 
 	requestUrl := conf.OpenApiDomain + UserInfoUri + "&" + query
-	resp, err := httpclient.Get(nil, requestUrl, map[string]string{})
+	resp, err := httpclient.Get(ctx, requestUrl, map[string]string{})
 	if err != nil {
 		log.Println("httpclient.Get failed, err:", err)
 		return ret, err
 	}
 
 	if resp.StatusCode != 200 {
-		return ret, errors.New(fmt.Sprintf("HttpStatusCode is not equal to 200, httpStatusCode[%d], respBody[%s]", resp.StatusCode, string(resp.Body)))
+		return ret, &errno.HTTPError{StatusCode: resp.StatusCode, Body: string(resp.Body)}
 	}
 
 	if err := json.Unmarshal(resp.Body, &ret); err != nil {
 		return ret, err
 	}
 
-	if ret.ErrorCode != 0 { //错误码不为0
-		return ret, errors.New(fmt.Sprintf("error_code:%d, error_msg:%s", ret.ErrorCode, ret.ErrorMsg))
-	}
-
 	//兼容用户信息接口返回的request_id为string类型的问题
 	ret.RequestID, _ = strconv.ParseInt(ret.RequestIDStr, 10, 64)
 
+	if ret.ErrorCode != 0 { //错误码不为0
+		return ret, &errno.APIError{Code: ret.ErrorCode, Msg: ret.ErrorMsg, RequestID: uint64(ret.RequestID)}
+	}
+
 	return ret, nil
 }
 
 // 获取用户网盘容量信息
 func (a *Account) Quota() (QuotaResponse, error) {
+	return a.QuotaWithContext(context.Background())
+}
+
+// 获取用户网盘容量信息，可重试错误按重试设置重试
+func (a *Account) QuotaWithContext(ctx context.Context) (QuotaResponse, error) {
+	var ret QuotaResponse
+	err := a.withRetry(ctx, func() error {
+		var err error
+		ret, err = a.quota(ctx)
+		return err
+	})
+	return ret, err
+}
+
+func (a *Account) quota(ctx context.Context) (QuotaResponse, error) {
 	ret := QuotaResponse{}
 
 	v := url.Values{}
@@ -89,14 +154,14 @@ func (a *Account) Quota() (QuotaResponse, error) {
 	query := v.Encode()
 
 	requestUrl := conf.OpenApiDomain + QuotaUri + "?" + query
-	resp, err := httpclient.Get(nil, requestUrl, map[string]string{})
+	resp, err := httpclient.Get(ctx, requestUrl, map[string]string{})
 	if err != nil {
 		log.Println("httpclient.Get failed, err:", err)
 		return ret, err
 	}
 
 	if resp.StatusCode != 200 {
-		return ret, errors.New(fmt.Sprintf("HttpStatusCode is not equal to 200, httpStatusCode[%d], respBody[%s]", resp.StatusCode, string(resp.Body)))
+		return ret, &errno.HTTPError{StatusCode: resp.StatusCode, Body: string(resp.Body)}
 	}
 
 	if err := json.Unmarshal(resp.Body, &ret); err != nil {
@@ -104,7 +169,7 @@ func (a *Account) Quota() (QuotaResponse, error) {
 	}
 
 	if ret.ErrorCode != 0 { //错误码不为0
-		return ret, errors.New(fmt.Sprintf("error_code:%d, error_msg:%s", ret.ErrorCode, ret.ErrorMsg))
+		return ret, &errno.APIError{Code: ret.ErrorCode, Msg: ret.ErrorMsg, RequestID: ret.RequestID}
 	}
 
 	return ret, nil
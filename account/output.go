@@ -0,0 +1,32 @@
+package account
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/jsyzchen/pan/utils"
+)
+
+// 容量信息输出格式
+type quotaOutput struct {
+	Total  int64 `json:"total"`
+	Used   int64 `json:"used"`
+	Free   int64 `json:"free"`
+	Expire bool  `json:"expire"`
+}
+
+// 输出JSON对象，字段依次为total、used、free、expire
+func (r QuotaResponse) ToJSON() ([]byte, error) {
+	return json.Marshal(quotaOutput{r.Total, r.Used, r.Free, r.Expire})
+}
+
+// 输出文本表格，列依次为TOTAL、USED、FREE、EXPIRE
+func (r QuotaResponse) ToTable() string {
+	row := []string{
+		strconv.FormatInt(r.Total, 10),
+		strconv.FormatInt(r.Used, 10),
+		strconv.FormatInt(r.Free, 10),
+		strconv.FormatBool(r.Expire),
+	}
+	return utils.FormatTable([]string{"TOTAL", "USED", "FREE", "EXPIRE"}, [][]string{row})
+}
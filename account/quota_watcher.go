@@ -0,0 +1,118 @@
+package account
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultQuotaWatchInterval = 5 * time.Minute
+
+// QuotaWatcher 定时查询网盘容量，剩余空间越过阈值时回调，如备份程序在空间不足前暂停上传或发出告警
+type QuotaWatcher struct {
+	AccessToken  string
+	Interval     time.Duration
+	Thresholds   []int64                                                // 剩余空间阈值，单位字节
+	Handler      func(threshold int64, below bool, quota QuotaResponse) // below为true表示剩余空间降到阈值以下，false表示恢复到阈值以上
+	ErrorHandler func(error)                                            // 查询容量失败时回调
+
+	mu    sync.Mutex
+	below map[int64]bool
+}
+
+func NewQuotaWatcher(accessToken string, interval time.Duration) *QuotaWatcher {
+	if interval <= 0 {
+		interval = defaultQuotaWatchInterval
+	}
+	return &QuotaWatcher{
+		AccessToken: accessToken,
+		Interval:    interval,
+		below:       map[int64]bool{},
+	}
+}
+
+// 切换为其他用户的access_token，并重置各阈值的状态
+func (w *QuotaWatcher) Bind(accessToken string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.AccessToken = accessToken
+	w.below = map[int64]bool{}
+}
+
+// 添加剩余空间阈值
+func (w *QuotaWatcher) AddThreshold(free int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.Thresholds = append(w.Thresholds, free)
+}
+
+func (w *QuotaWatcher) SetHandler(handler func(threshold int64, below bool, quota QuotaResponse)) {
+	w.Handler = handler
+}
+
+func (w *QuotaWatcher) SetErrorHandler(errorHandler func(error)) {
+	w.ErrorHandler = errorHandler
+}
+
+// 开始轮询，阻塞直到ctx结束
+// 启动时剩余空间已低于阈值的也会回调一次
+func (w *QuotaWatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.Check()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// 查询一次容量并检查阈值，返回查询到的容量信息
+func (w *QuotaWatcher) Check() (QuotaResponse, error) {
+	w.mu.Lock()
+	accountClient := NewAccountClient(w.AccessToken)
+	w.mu.Unlock()
+	quota, err := accountClient.Quota()
+	if err != nil {
+		log.Println("quotaWatcher accountClient.Quota failed, err:", err)
+		if w.ErrorHandler != nil {
+			w.ErrorHandler(err)
+		}
+		return quota, err
+	}
+
+	w.mu.Lock()
+	thresholds := append([]int64{}, w.Thresholds...)
+	if w.below == nil {
+		w.below = map[int64]bool{}
+	}
+	type crossing struct {
+		threshold int64
+		below     bool
+	}
+	crossings := []crossing{}
+	for _, threshold := range thresholds {
+		below := quota.Free < threshold
+		if below != w.below[threshold] {
+			w.below[threshold] = below
+			crossings = append(crossings, crossing{threshold, below})
+		}
+	}
+	w.mu.Unlock()
+
+	// 按阈值从大到小回调，剩余空间持续减少时先触发较大的阈值
+	sort.Slice(crossings, func(i, j int) bool {
+		return crossings[i].threshold > crossings[j].threshold
+	})
+	if w.Handler != nil {
+		for _, c := range crossings {
+			w.Handler(c.threshold, c.below, quota)
+		}
+	}
+	return quota, nil
+}
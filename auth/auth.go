@@ -2,6 +2,9 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -68,6 +71,12 @@ type UserInfoResponse struct {
 	ErrorMsg     string `json:"errmsg"`
 }
 
+type PKCE struct {
+	CodeVerifier        string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
 const OAuthUri = "/oauth/2.0/authorize"
 const OAuthTokenUri = "/oauth/2.0/token"
 const DeviceCodeUri = "/oauth/2.0/device/code"
@@ -80,8 +89,33 @@ func NewAuthClient(clientID string, clientSecret string) *Auth {
 	}
 }
 
+// 生成PKCE参数，CodeVerifier需要保存到获取AccessToken时使用，CodeChallenge用于拼接授权页网址
+func NewPKCE() (PKCE, error) {
+	ret := PKCE{}
+
+	verifierBytes := make([]byte, 32)
+	if _, err := rand.Read(verifierBytes); err != nil {
+		return ret, err
+	}
+	ret.CodeVerifier = base64.RawURLEncoding.EncodeToString(verifierBytes)
+	challenge := sha256.Sum256([]byte(ret.CodeVerifier))
+	ret.CodeChallenge = base64.RawURLEncoding.EncodeToString(challenge[:])
+	ret.CodeChallengeMethod = "S256"
+
+	return ret, nil
+}
+
 // 获取授权页网址
 func (a *Auth) OAuthUrl(redirectUri string) string {
+	return a.oAuthUrl(redirectUri, "")
+}
+
+// 获取授权页网址(PKCE方式)，codeChallenge为NewPKCE返回的CodeChallenge
+func (a *Auth) OAuthUrlWithPKCE(redirectUri, codeChallenge string) string {
+	return a.oAuthUrl(redirectUri, codeChallenge)
+}
+
+func (a *Auth) oAuthUrl(redirectUri, codeChallenge string) string {
 	oAuthUrl := ""
 
 	v := url.Values{}
@@ -90,6 +124,10 @@ func (a *Auth) OAuthUrl(redirectUri string) string {
 	v.Add("redirect_uri", redirectUri)
 	v.Add("scope", "basic,netdisk")
 	v.Add("state", "STATE")
+	if codeChallenge != "" {
+		v.Add("code_challenge", codeChallenge)
+		v.Add("code_challenge_method", "S256")
+	}
 	query := v.Encode()
 
 	oAuthUrl = conf.BaiduOpenApiDomain + OAuthUri + "?" + query
@@ -99,14 +137,29 @@ func (a *Auth) OAuthUrl(redirectUri string) string {
 
 // 获取AccessToken(authenticationCode方式)
 func (a *Auth) AccessTokenByAuthCode(code, redirectUri string) (AccessTokenResponse, error) {
+	return a.accessTokenByAuthCode(code, redirectUri, "")
+}
+
+// 获取AccessToken(authenticationCode + PKCE方式)，codeVerifier为NewPKCE返回的CodeVerifier
+// 公共客户端无法安全保存ClientSecret时，ClientSecret可以为空
+func (a *Auth) AccessTokenByAuthCodeWithPKCE(code, redirectUri, codeVerifier string) (AccessTokenResponse, error) {
+	return a.accessTokenByAuthCode(code, redirectUri, codeVerifier)
+}
+
+func (a *Auth) accessTokenByAuthCode(code, redirectUri, codeVerifier string) (AccessTokenResponse, error) {
 	ret := AccessTokenResponse{}
 
 	v := url.Values{}
 	v.Add("grant_type", "authorization_code")
 	v.Add("code", code)
 	v.Add("client_id", a.ClientID)
-	v.Add("client_secret", a.ClientSecret)
+	if a.ClientSecret != "" {
+		v.Add("client_secret", a.ClientSecret)
+	}
 	v.Add("redirect_uri", redirectUri)
+	if codeVerifier != "" {
+		v.Add("code_verifier", codeVerifier)
+	}
 	query := v.Encode()
 
 	requestUrl := conf.BaiduOpenApiDomain + OAuthTokenUri + "?" + query
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/jsyzchen/pan/conf"
+	"github.com/jsyzchen/pan/utils/httpclient"
+)
+
+const IsAppUserUri = "/rest/2.0/passport/users/isAppUser"
+const ExpireSessionUri = "/rest/2.0/passport/auth/expireSession"
+const RevokeAuthorizationUri = "/rest/2.0/passport/auth/revokeAuthorization"
+
+// 百度passport接口的返回结果，result可能是数字或字符串
+type passportResultResponse struct {
+	Result    json.Number `json:"result"`
+	ErrorCode int         `json:"error_code"`
+	ErrorMsg  string      `json:"error_msg"`
+}
+
+// 用户是否已授权当前应用
+// 开放平台未提供授权设备列表的接口，应用需自行记录发放过的access_token
+func (a *Auth) IsAuthorized(accessToken string) (bool, error) {
+	v := url.Values{}
+	v.Add("access_token", accessToken)
+	ret, err := a.passportRequest(IsAppUserUri, v)
+	if err != nil {
+		return false, err
+	}
+	return ret.Result.String() == "1", nil
+}
+
+// 使当前access_token失效，同一用户的其他access_token不受影响，用于单个设备退出登录
+func (a *Auth) ExpireSession(accessToken string) error {
+	v := url.Values{}
+	v.Add("access_token", accessToken)
+	ret, err := a.passportRequest(ExpireSessionUri, v)
+	if err != nil {
+		return err
+	}
+	if ret.Result.String() != "1" {
+		return errors.New(fmt.Sprintf("ExpireSession failed, result: %s", ret.Result))
+	}
+	return nil
+}
+
+// 撤销用户对当前应用的授权，该用户的全部access_token和refresh_token失效，用于"解除百度账号绑定"
+func (a *Auth) RevokeAuthorization(accessToken string) error {
+	v := url.Values{}
+	v.Add("access_token", accessToken)
+	ret, err := a.passportRequest(RevokeAuthorizationUri, v)
+	if err != nil {
+		return err
+	}
+	if ret.Result.String() != "1" {
+		return errors.New(fmt.Sprintf("RevokeAuthorization failed, result: %s", ret.Result))
+	}
+	return nil
+}
+
+func (a *Auth) passportRequest(uri string, v url.Values) (passportResultResponse, error) {
+	ret := passportResultResponse{}
+
+	requestUrl := conf.BaiduOpenApiDomain + uri + "?" + v.Encode()
+	resp, err := httpclient.Get(nil, requestUrl, map[string]string{})
+	if err != nil {
+		log.Println("httpclient.Get failed, err:", err)
+		return ret, err
+	}
+
+	if resp.StatusCode != 200 {
+		return ret, errors.New(fmt.Sprintf("HttpStatusCode is not equal to 200, httpStatusCode[%d], respBody[%s]", resp.StatusCode, string(resp.Body)))
+	}
+
+	if err := json.Unmarshal(resp.Body, &ret); err != nil {
+		return ret, err
+	}
+
+	if ret.ErrorCode != 0 { //有错误
+		return ret, errors.New(fmt.Sprintf("error_code:%d, error_msg:%s", ret.ErrorCode, ret.ErrorMsg))
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,286 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jsyzchen/pan/file"
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+// 测试使用的后端，真实账号时mock为nil
+type backend struct {
+	accessToken string
+	dir         string
+	mock        *mockpan.Server
+	close       func()
+}
+
+func newBackend(t *testing.T) *backend {
+	if accessToken := os.Getenv("PAN_ACCESS_TOKEN"); accessToken != "" {
+		dir := os.Getenv("PAN_TEST_DIR")
+		if dir == "" {
+			t.Skip("PAN_TEST_DIR is required when PAN_ACCESS_TOKEN is set")
+		}
+		b := &backend{accessToken: accessToken, dir: path.Join(dir, strconv.FormatInt(time.Now().UnixNano(), 36))}
+		b.close = func() {
+			tasks, _ := json.Marshal([]string{b.dir})
+			file.NewFileClient(b.accessToken).Manage("delete", string(tasks))
+		}
+		return b
+	}
+	mock := mockpan.NewServer()
+	return &backend{accessToken: "mock-token", dir: "/apps/conformance", mock: mock, close: mock.Install()}
+}
+
+func (b *backend) path(name ...string) string {
+	return path.Join(append([]string{b.dir}, name...)...)
+}
+
+// 真实账号的部分操作异步生效，重试直到成功或超时
+func (b *backend) eventually(t *testing.T, fn func() error) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		err := fn()
+		if err == nil {
+			return
+		}
+		if b.mock != nil || time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (b *backend) put(t *testing.T, remotePath string, data []byte) file.UploadResponse {
+	t.Helper()
+	uploader := file.NewReaderUploader(b.accessToken, remotePath, bytes.NewReader(data), int64(len(data)))
+	ret, err := uploader.Upload(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("upload %s failed: %v", remotePath, err)
+	}
+	return ret
+}
+
+// 在父目录的列表中查找文件
+func (b *backend) stat(remotePath string) (file.FsItem, error) {
+	fileClient := file.NewFileClient(b.accessToken)
+	for start := 0; ; start += 100 {
+		ret, err := fileClient.List(path.Dir(remotePath), start, 100)
+		if err != nil {
+			return file.FsItem{}, err
+		}
+		for _, item := range ret.List {
+			if item.Path == remotePath {
+				return item, nil
+			}
+		}
+		if len(ret.List) < 100 {
+			return file.FsItem{}, fmt.Errorf("%s not found", remotePath)
+		}
+	}
+}
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestMkdir(t *testing.T) {
+	b := newBackend(t)
+	defer b.close()
+	fileClient := file.NewFileClient(b.accessToken)
+	ret, err := fileClient.CreateDir(b.path("a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.IsDir != 1 || ret.Path != b.path("a", "b") {
+		t.Errorf("CreateDir = %+v, want dir %s", ret, b.path("a", "b"))
+	}
+	if err := fileClient.MkdirAll(b.path("a", "b")); err != nil {
+		t.Errorf("MkdirAll on existing dir: %v", err)
+	}
+	b.eventually(t, func() error {
+		item, err := b.stat(b.path("a", "b"))
+		if err == nil && item.IsDir != 1 {
+			err = fmt.Errorf("%s is not a dir", item.Path)
+		}
+		return err
+	})
+}
+
+func TestPutGetMd5(t *testing.T) {
+	b := newBackend(t)
+	defer b.close()
+	data := randomData(9<<20 + 123) //3个4M分片，最后一个分片不足4M
+	localDir, err := ioutil.TempDir("", "conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(localDir)
+	localPath := filepath.Join(localDir, "src.bin")
+	if err := ioutil.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uploader := file.NewUploader(b.accessToken, b.path("put", "src.bin"), localPath)
+	uploader.SliceSize = 4 << 20
+	ret, _, err := uploader.Upload(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Size != int64(len(data)) {
+		t.Errorf("uploaded size = %d, want %d", ret.Size, len(data))
+	}
+
+	metas, err := file.NewFileClient(b.accessToken).Metas([]uint64{ret.FsID})
+	if err != nil || len(metas.List) != 1 {
+		t.Fatalf("Metas(%d) = %+v, %v", ret.FsID, metas, err)
+	}
+	if metas.List[0].FsID != ret.FsID {
+		t.Errorf("Metas fs_id = %d, want %d", metas.List[0].FsID, ret.FsID)
+	}
+	if metas.List[0].Md5 != md5Hex(data) {
+		t.Errorf("remote md5 = %s, want %s", metas.List[0].Md5, md5Hex(data))
+	}
+
+	savePath := filepath.Join(localDir, "dst.bin")
+	downloader := file.NewDownloaderWithFsID(b.accessToken, ret.FsID, savePath)
+	if _, err := downloader.Download(context.Background(), localDir, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(savePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md5Hex(got) != md5Hex(data) {
+		t.Errorf("downloaded md5 = %s size = %d, want %s size = %d", md5Hex(got), len(got), md5Hex(data), len(data))
+	}
+}
+
+func TestPathEscaping(t *testing.T) {
+	b := newBackend(t)
+	defer b.close()
+	names := []string{"空格 文件.txt", "a+b&c=d.txt", "100%#1.txt", "émoji😀.txt", "semi;colon,comma.txt"}
+	for _, name := range names {
+		ret := b.put(t, b.path("escape", name), []byte(name))
+		if ret.Path != b.path("escape", name) {
+			t.Errorf("uploaded path = %q, want %q", ret.Path, b.path("escape", name))
+		}
+	}
+	for _, name := range names {
+		name := name
+		b.eventually(t, func() error {
+			item, err := b.stat(b.path("escape", name))
+			if err == nil && item.ServerFileName != name {
+				err = fmt.Errorf("server_filename = %q, want %q", item.ServerFileName, name)
+			}
+			return err
+		})
+	}
+}
+
+func TestMove(t *testing.T) {
+	b := newBackend(t)
+	defer b.close()
+	src := b.path("move", "src.txt")
+	b.put(t, src, []byte("move me"))
+	fileClient := file.NewFileClient(b.accessToken)
+	tasks, _ := json.Marshal([]map[string]string{{"path": src, "dest": b.path("move", "dst"), "newname": "moved.txt"}})
+	if _, err := fileClient.Manage("move", string(tasks)); err != nil {
+		t.Fatal(err)
+	}
+	b.eventually(t, func() error {
+		if _, err := b.stat(src); err == nil {
+			return fmt.Errorf("%s still exists after move", src)
+		}
+		_, err := b.stat(b.path("move", "dst", "moved.txt"))
+		return err
+	})
+	if b.mock != nil {
+		if data, _ := b.mock.ReadFile(b.path("move", "dst", "moved.txt")); string(data) != "move me" {
+			t.Errorf("moved content = %q", data)
+		}
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	b := newBackend(t)
+	defer b.close()
+	const fileNum = 25
+	for i := 0; i < fileNum; i++ {
+		b.put(t, b.path("page", fmt.Sprintf("f%02d.txt", i)), []byte(strconv.Itoa(i)))
+	}
+	fileClient := file.NewFileClient(b.accessToken)
+	b.eventually(t, func() error {
+		seen := map[uint64]bool{}
+		for start := 0; ; start += 10 {
+			ret, err := fileClient.List(b.path("page"), start, 10)
+			if err != nil {
+				return err
+			}
+			for _, item := range ret.List {
+				if seen[item.FsID] {
+					return fmt.Errorf("fs_id %d listed twice", item.FsID)
+				}
+				seen[item.FsID] = true
+			}
+			if len(ret.List) < 10 {
+				break
+			}
+		}
+		if len(seen) != fileNum {
+			return fmt.Errorf("listed %d files, want %d", len(seen), fileNum)
+		}
+		items, err := fileClient.ListRecursive(b.dir)
+		if err != nil {
+			return err
+		}
+		files := 0
+		for _, item := range items {
+			if item.IsDir == 0 {
+				files++
+			}
+		}
+		if files != fileNum {
+			return fmt.Errorf("ListRecursive found %d files, want %d", files, fileNum)
+		}
+		return nil
+	})
+}
+
+func TestDelete(t *testing.T) {
+	b := newBackend(t)
+	defer b.close()
+	target := b.path("delete", "gone.txt")
+	b.put(t, target, []byte("bye"))
+	tasks, _ := json.Marshal([]string{target})
+	if _, err := file.NewFileClient(b.accessToken).Manage("delete", string(tasks)); err != nil {
+		t.Fatal(err)
+	}
+	b.eventually(t, func() error {
+		if _, err := b.stat(target); err == nil {
+			return fmt.Errorf("%s still exists after delete", target)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,4 @@
+// 网盘后端一致性测试，验证创建目录、上传、下载、移动、列表分页和md5等端到端行为
+// 默认使用utils/mockpan模拟服务运行，设置环境变量PAN_ACCESS_TOKEN和PAN_TEST_DIR时使用真实账号，
+// PAN_TEST_DIR为测试专用的网盘目录，如/apps/应用名/conformance，测试结束后删除
+package conformance
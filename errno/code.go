@@ -0,0 +1,93 @@
+package errno
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Code 接口返回的错误码
+type Code int
+
+const (
+	CodeOK                 Code = 0
+	CodeExpired            Code = -1    // 权益已过期
+	CodeFileNotFound       Code = -3    // 文件不存在
+	CodeAuthFailed         Code = -6    // 身份验证失败
+	CodeAccessDenied       Code = -7    // 文件或目录名错误或无权访问
+	CodeFileExists         Code = -8    // 文件或目录已存在
+	CodeDirNotFound        Code = -9    // 文件或目录不存在
+	CodeQuotaFull          Code = -10   // 云端容量已满
+	CodeParamError         Code = 2     // 参数错误
+	CodeUserDataDenied     Code = 6     // 不允许接入用户数据
+	CodeSuperFileFailed    Code = 10    // 创建文件失败
+	CodeTokenInvalid       Code = 111   // access token失效
+	CodeAppQuotaExceeded   Code = 20012 // 访问超限，调用次数已达上限
+	CodePermissionDenied   Code = 20013 // 权限不足
+	CodeInvalidParam       Code = 31023 // 参数错误
+	CodeNoPermission       Code = 31024 // 没有访问权限
+	CodeRateLimited        Code = 31034 // 命中接口频控
+	CodeRemoteFileExists   Code = 31061 // 文件已存在
+	CodeInvalidFileName    Code = 31062 // 文件名无效
+	CodeInvalidUploadPath  Code = 31064 // 上传路径错误
+	CodeRemoteNotFound     Code = 31066 // 文件不存在
+	CodeMd5NotFound        Code = 31079 // 未找到文件md5
+	CodeCreateNotFound     Code = 31190 // 创建文件时找不到分片
+	CodeFirstSliceTooSmall Code = 31299 // 第一个分片的大小小于4MB
+	CodeSliceMissing       Code = 31363 // 分片缺失
+	CodeSliceTooLarge      Code = 31364 // 超出分片大小限制
+)
+
+var codeNames = map[Code]string{
+	CodeOK:                 "ok",
+	CodeExpired:            "expired",
+	CodeFileNotFound:       "file not found",
+	CodeAuthFailed:         "auth failed",
+	CodeAccessDenied:       "access denied",
+	CodeFileExists:         "file exists",
+	CodeDirNotFound:        "file or dir not found",
+	CodeQuotaFull:          "quota full",
+	CodeParamError:         "param error",
+	CodeUserDataDenied:     "user data access denied",
+	CodeSuperFileFailed:    "create superfile failed",
+	CodeTokenInvalid:       "access token invalid",
+	CodeAppQuotaExceeded:   "app quota exceeded",
+	CodePermissionDenied:   "permission denied",
+	CodeInvalidParam:       "invalid param",
+	CodeNoPermission:       "no permission",
+	CodeRateLimited:        "rate limited",
+	CodeRemoteFileExists:   "remote file exists",
+	CodeInvalidFileName:    "invalid file name",
+	CodeInvalidUploadPath:  "invalid upload path",
+	CodeRemoteNotFound:     "remote file not found",
+	CodeMd5NotFound:        "md5 not found",
+	CodeCreateNotFound:     "create file not found",
+	CodeFirstSliceTooSmall: "first slice too small",
+	CodeSliceMissing:       "slice missing",
+	CodeSliceTooLarge:      "slice too large",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("errno %d", int(c))
+}
+
+// 判断错误码是否可以重试
+func (c Code) Retryable() bool {
+	return c != CodeOK && !fatalCodes[c]
+}
+
+// 获取错误码
+func (e *APIError) Errno() Code {
+	return Code(e.Code)
+}
+
+// 获取err中的接口错误码，err不是APIError时返回false
+func CodeOf(err error) (Code, bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Errno(), true
+	}
+	return CodeOK, false
+}
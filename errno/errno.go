@@ -0,0 +1,82 @@
+// 接口错误类型及重试分类
+package errno
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// HTTPError 接口返回的HTTP状态码不是2xx
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	if text := httpStatusText(e.StatusCode); text != "" {
+		return fmt.Sprintf("http status code: %d (%s), body: %s", e.StatusCode, text, e.Body)
+	}
+	return fmt.Sprintf("http status code: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// APIError 接口返回的错误码不为0
+type APIError struct {
+	Code      int
+	Msg       string
+	RequestID uint64
+}
+
+func (e *APIError) Error() string {
+	if CurrentLocale() != LocaleDefault {
+		return fmt.Sprintf("error_code:%d, error_msg:%s (%s)", e.Code, e.Msg, e.Errno().Text())
+	}
+	return fmt.Sprintf("error_code:%d, error_msg:%s", e.Code, e.Msg)
+}
+
+// 重试无意义的错误码，如参数错误、无权限、文件不存在
+var fatalCodes = map[Code]bool{
+	CodeAuthFailed:         true,
+	CodeAccessDenied:       true,
+	CodeFileExists:         true,
+	CodeDirNotFound:        true,
+	CodeQuotaFull:          true,
+	CodeParamError:         true,
+	CodeUserDataDenied:     true,
+	CodeTokenInvalid:       true,
+	CodePermissionDenied:   true,
+	CodeInvalidParam:       true,
+	CodeNoPermission:       true,
+	CodeInvalidFileName:    true,
+	CodeInvalidUploadPath:  true,
+	CodeFirstSliceTooSmall: true,
+	CodeSliceMissing:       true,
+	CodeSliceTooLarge:      true,
+}
+
+// 判断错误是否可以重试：5xx、408、429、超时及网络错误可以重试，其他4xx及已知的永久性错误码不重试
+// ctx取消或超时不重试，未能分类的错误默认可以重试
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		if httpErr.StatusCode >= 500 || httpErr.StatusCode == 408 || httpErr.StatusCode == 429 {
+			return true
+		}
+		return httpErr.StatusCode < 400
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Errno().Retryable()
+	}
+
+	// 超时、连接断开等网络错误
+	return true
+}
@@ -0,0 +1,122 @@
+package errno
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Locale 错误信息的语言
+type Locale string
+
+const (
+	LocaleDefault Locale = ""   // 保持原有的错误信息，不附加本地化说明
+	LocaleZh      Locale = "zh" // 中文
+	LocaleEn      Locale = "en" // 英文
+)
+
+var currentLocale atomic.Value
+
+// 设置错误信息的语言，设置后APIError、HTTPError等错误附加对应语言的说明
+func SetLocale(locale Locale) {
+	currentLocale.Store(locale)
+}
+
+// 当前错误信息的语言
+func CurrentLocale() Locale {
+	locale, _ := currentLocale.Load().(Locale)
+	return locale
+}
+
+// Message 多语言消息，按当前语言选择，LocaleDefault或对应语言为空时使用Default
+type Message struct {
+	Default string
+	Zh      string
+	En      string
+}
+
+func (m Message) String() string {
+	return m.In(CurrentLocale())
+}
+
+// 获取指定语言的消息
+func (m Message) In(locale Locale) string {
+	switch {
+	case locale == LocaleZh && m.Zh != "":
+		return m.Zh
+	case locale == LocaleEn && m.En != "":
+		return m.En
+	}
+	return m.Default
+}
+
+// LocalizedError 错误信息随当前语言变化的错误，可作为哨兵错误通过errors.Is判断
+type LocalizedError struct {
+	Message
+}
+
+func NewLocalizedError(message Message) *LocalizedError {
+	return &LocalizedError{message}
+}
+
+func (e *LocalizedError) Error() string {
+	return e.String()
+}
+
+// 错误码的说明
+var codeMessages = map[Code]Message{
+	CodeExpired:            {Zh: "权益已过期", En: "membership expired"},
+	CodeFileNotFound:       {Zh: "文件不存在", En: "file not found"},
+	CodeAuthFailed:         {Zh: "身份验证失败", En: "authentication failed"},
+	CodeAccessDenied:       {Zh: "文件或目录名错误或无权访问", En: "invalid name or access denied"},
+	CodeFileExists:         {Zh: "文件或目录已存在", En: "file or directory already exists"},
+	CodeDirNotFound:        {Zh: "文件或目录不存在", En: "file or directory not found"},
+	CodeQuotaFull:          {Zh: "云端容量已满", En: "cloud storage is full"},
+	CodeParamError:         {Zh: "参数错误", En: "invalid parameter"},
+	CodeUserDataDenied:     {Zh: "不允许接入用户数据", En: "access to user data is not allowed"},
+	CodeSuperFileFailed:    {Zh: "创建文件失败", En: "failed to create file"},
+	CodeTokenInvalid:       {Zh: "access token失效", En: "access token is invalid or expired"},
+	CodeAppQuotaExceeded:   {Zh: "访问超限，调用次数已达上限", En: "app call quota exceeded"},
+	CodePermissionDenied:   {Zh: "权限不足", En: "permission denied"},
+	CodeInvalidParam:       {Zh: "参数错误", En: "invalid parameter"},
+	CodeNoPermission:       {Zh: "没有访问权限", En: "no access permission"},
+	CodeRateLimited:        {Zh: "命中接口频控，请稍后重试", En: "rate limited, retry later"},
+	CodeRemoteFileExists:   {Zh: "文件已存在", En: "remote file already exists"},
+	CodeInvalidFileName:    {Zh: "文件名无效", En: "invalid file name"},
+	CodeInvalidUploadPath:  {Zh: "上传路径错误", En: "invalid upload path"},
+	CodeRemoteNotFound:     {Zh: "文件不存在", En: "remote file not found"},
+	CodeMd5NotFound:        {Zh: "未找到文件md5", En: "file md5 not found"},
+	CodeCreateNotFound:     {Zh: "创建文件时找不到分片", En: "slices not found when creating file"},
+	CodeFirstSliceTooSmall: {Zh: "第一个分片的大小小于4MB", En: "first slice is smaller than 4MB"},
+	CodeSliceMissing:       {Zh: "分片缺失", En: "slice missing"},
+	CodeSliceTooLarge:      {Zh: "超出分片大小限制", En: "slice exceeds size limit"},
+}
+
+// 错误码在当前语言下的说明，LocaleDefault时与String相同
+func (c Code) Text() string {
+	message, ok := codeMessages[c]
+	if !ok {
+		message = Message{}
+	}
+	message.Default = c.String()
+	return message.String()
+}
+
+// HTTP状态码的说明
+func httpStatusText(statusCode int) string {
+	message := Message{En: http.StatusText(statusCode)}
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		message.Zh = "请求过于频繁"
+	case statusCode == http.StatusRequestTimeout:
+		message.Zh = "请求超时"
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		message.Zh = "没有访问权限"
+	case statusCode == http.StatusNotFound:
+		message.Zh = "资源不存在"
+	case statusCode >= 500:
+		message.Zh = "服务器错误"
+	case statusCode >= 400:
+		message.Zh = "请求错误"
+	}
+	return message.String()
+}
@@ -0,0 +1,52 @@
+package errno
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// 非JSON响应的错误信息中保留的响应体长度，按字符计算，通过atomic读写
+var nonJSONSnippetLen int64 = 256
+
+// 设置非JSON响应的错误信息中保留的响应体长度，可以在请求进行中调用
+func SetNonJSONSnippetLen(n int) {
+	atomic.StoreInt64(&nonJSONSnippetLen, int64(n))
+}
+
+// NonJSONError 接口返回了HTML错误页面等非JSON的响应，通常是网关或负载均衡返回的错误页
+// 按HTTPError的规则判断是否可以重试，状态码为2xx或5xx时可以重试
+type NonJSONError struct {
+	HTTPError
+	ContentType string
+}
+
+func (e *NonJSONError) Error() string {
+	return fmt.Sprintf("non-json response, content-type: %s, %s", e.ContentType, e.HTTPError.Error())
+}
+
+func (e *NonJSONError) Unwrap() error {
+	return &e.HTTPError
+}
+
+// 检查响应是否为HTML等非JSON内容，是时返回NonJSONError，Body只保留压缩空白后的开头部分
+func CheckJSON(statusCode int, header http.Header, body []byte) error {
+	contentType := header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	trimmed := bytes.TrimSpace(body)
+	if mediaType != "text/html" && (len(trimmed) == 0 || trimmed[0] != '<') {
+		return nil
+	}
+	snippet := []rune(strings.Join(strings.Fields(string(trimmed)), " "))
+	snippetLen := int(atomic.LoadInt64(&nonJSONSnippetLen))
+	if snippetLen >= 0 && len(snippet) > snippetLen {
+		snippet = append(snippet[:snippetLen], []rune("...")...)
+	}
+	return &NonJSONError{
+		HTTPError:   HTTPError{StatusCode: statusCode, Body: string(snippet)},
+		ContentType: contentType,
+	}
+}
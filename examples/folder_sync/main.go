@@ -0,0 +1,56 @@
+// 目录同步示例，将本地目录上传到网盘目录，本地目录下的.panignore中的文件不上传
+//
+//	PAN_ACCESS_TOKEN=xxx go run ./examples/folder_sync -local ./photos -remote /apps/test/photos
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/jsyzchen/pan/file"
+)
+
+func main() {
+	localDir := flag.String("local", "", "本地目录")
+	remoteDir := flag.String("remote", "", "网盘目录")
+	followLinks := flag.Bool("follow-links", false, "是否跟随符号链接")
+	quotaAware := flag.Bool("quota-aware", false, "是否跳过网盘剩余空间放不下的文件，开启时从小到大上传")
+	rate := flag.Int64("rate", 0, "上传限速，单位字节/秒，为0时不限速")
+	flag.Parse()
+	accessToken := os.Getenv("PAN_ACCESS_TOKEN")
+	if accessToken == "" || *localDir == "" || *remoteDir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dirUploader := file.NewDirUploader(accessToken, *localDir, *remoteDir)
+	if *followLinks {
+		dirUploader.SetSymlinkPolicy(file.SymlinkFollow)
+	}
+	dirUploader.SetQuotaAware(*quotaAware, *quotaAware)
+	dirUploader.SetMaxUploadRate(*rate)
+	report, err := dirUploader.Upload(context.Background(), func(localPath string, status int, doneSize, totalSize int64) {
+		if status == 2 && doneSize == totalSize {
+			log.Printf("uploaded %s", localPath)
+		}
+	})
+	if err != nil {
+		log.Fatalln("folder sync failed, err:", err)
+	}
+
+	for _, failure := range report.Failed {
+		log.Printf("failed %s -> %s, err: %v", failure.LocalPath, failure.Path, failure.Err)
+	}
+	for _, skipped := range report.SkippedQuota {
+		log.Printf("skipped %s size: %d, insufficient quota", skipped.LocalPath, skipped.Size)
+	}
+	for _, link := range report.SkippedLinks {
+		log.Printf("skipped link %+v", link)
+	}
+	log.Printf("folder sync finished, uploaded: %d failed: %d", len(report.Uploaded), len(report.Failed))
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,75 @@
+// 断点续传上传示例，上传中断时将快照保存到本地文件，再次运行时从快照继续上传
+//
+//	PAN_ACCESS_TOKEN=xxx go run ./examples/resumable_upload -local ./a.zip -remote /apps/test/a.zip
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/jsyzchen/pan/file"
+	fileUtil "github.com/jsyzchen/pan/utils/file"
+)
+
+func main() {
+	localPath := flag.String("local", "", "本地文件路径")
+	remotePath := flag.String("remote", "", "网盘文件路径")
+	snapshotPath := flag.String("snapshot", "", "快照文件路径，默认为本地文件路径加.upload")
+	flag.Parse()
+	accessToken := os.Getenv("PAN_ACCESS_TOKEN")
+	if accessToken == "" || *localPath == "" || *remotePath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *snapshotPath == "" {
+		*snapshotPath = *localPath + ".upload"
+	}
+
+	// Ctrl+C时取消上传并保存快照
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	uploader := file.NewUploader(accessToken, *remotePath, *localPath)
+	progressHandler := func(status int, doneSize, totalSize int64) {
+		log.Printf("status: %d progress: %d/%d", status, doneSize, totalSize)
+	}
+
+	var (
+		res      file.UploadResult
+		snapshot fileUtil.UploadSnapshot
+		err      error
+	)
+	if data, readErr := ioutil.ReadFile(*snapshotPath); readErr == nil {
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			log.Fatalln("json.Unmarshal failed, err:", err)
+		}
+		res, snapshot, err = uploader.ResumeUploadWithResult(ctx, snapshot, progressHandler)
+	} else {
+		res, snapshot, err = uploader.UploadWithResult(ctx, progressHandler)
+	}
+
+	if err != nil {
+		if snapshot.Recoverable {
+			data, _ := json.Marshal(snapshot)
+			if writeErr := ioutil.WriteFile(*snapshotPath, data, 0644); writeErr != nil {
+				log.Println("save snapshot failed, err:", writeErr)
+			}
+			log.Fatalf("upload interrupted, run again to resume, err: %v", err)
+		}
+		os.Remove(*snapshotPath)
+		log.Fatalln("upload failed, err:", err)
+	}
+	os.Remove(*snapshotPath)
+	log.Printf("upload success, path: %s fs_id: %d rapid_upload: %v", res.Path, res.FsID, res.RapidUpload)
+}
@@ -0,0 +1,58 @@
+// 分享转存示例，列出分享链接根目录下的文件并全部转存到网盘目录
+//
+//	PAN_APP_ID=xxx PAN_ACCESS_TOKEN=xxx go run ./examples/share_transfer -url https://pan.baidu.com/s/1xxx -pwd abcd -dir /apps/test
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/jsyzchen/pan/share"
+)
+
+// 每页获取的文件数
+const pageSize = 100
+
+func main() {
+	shortUrl := flag.String("url", "", "分享链接")
+	pwd := flag.String("pwd", "", "提取码")
+	dir := flag.String("dir", "", "转存到的网盘目录")
+	flag.Parse()
+	appId := os.Getenv("PAN_APP_ID")
+	accessToken := os.Getenv("PAN_ACCESS_TOKEN")
+	if appId == "" || accessToken == "" || *shortUrl == "" || *dir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	shareClient := share.NewShareClient(appId, accessToken)
+	fsidList := []uint64{}
+	for page := 1; ; page++ {
+		res, err := shareClient.ListFiles(*shortUrl, *pwd, "/", page, pageSize)
+		if err != nil {
+			log.Fatalln("ListFiles failed, err:", err)
+		}
+		for _, info := range res.Data.List {
+			fsID, err := strconv.ParseUint(info.FsId, 10, 64)
+			if err != nil {
+				log.Fatalf("invalid fsid: %s", info.FsId)
+			}
+			log.Printf("found %s size: %d", info.Path, info.Size)
+			fsidList = append(fsidList, fsID)
+		}
+		if len(res.Data.List) < pageSize {
+			break
+		}
+	}
+	if len(fsidList) == 0 {
+		log.Println("no files to transfer")
+		return
+	}
+
+	if _, err := shareClient.TransferFiles(*shortUrl, *pwd, *dir, fsidList); err != nil {
+		log.Fatalln("TransferFiles failed, err:", err)
+	}
+	log.Printf("transferred %d files to %s", len(fsidList), *dir)
+}
@@ -0,0 +1,41 @@
+// 在线播放示例，获取音视频的m3u8播放列表并保存到本地，可直接用播放器打开
+//
+//	PAN_ACCESS_TOKEN=xxx go run ./examples/streaming -path /apps/test/a.mp4 -o a.m3u8
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/jsyzchen/pan/file"
+)
+
+func main() {
+	path := flag.String("path", "", "网盘音视频文件路径")
+	transcodingType := flag.String("type", "M3U8_AUTO_480", "转码类型，M3U8_AUTO_480、M3U8_FLV_264_480、M3U8_MP3_128或M3U8_HLS_MP3_128")
+	output := flag.String("o", "", "播放列表保存路径，为空时输出到标准输出")
+	flag.Parse()
+	accessToken := os.Getenv("PAN_ACCESS_TOKEN")
+	if accessToken == "" || *path == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fileClient := file.NewFileClient(accessToken)
+	playlist, err := fileClient.Streaming(*path, *transcodingType)
+	if err != nil {
+		log.Fatalln("Streaming failed, err:", err)
+	}
+
+	if *output == "" {
+		fmt.Print(playlist)
+		return
+	}
+	if err := ioutil.WriteFile(*output, []byte(playlist), 0644); err != nil {
+		log.Fatalln("save playlist failed, err:", err)
+	}
+	log.Printf("playlist saved to %s", *output)
+}
@@ -0,0 +1,51 @@
+package file
+
+import (
+	"path"
+	"strings"
+)
+
+const (
+	AppsDir        = "/apps"   // 应用数据目录在接口中的路径
+	AppsDisplayDir = "/我的应用数据" // 应用数据目录在网盘客户端中显示的路径
+)
+
+// 获取应用数据目录的接口路径，即/apps/应用名称
+// 只有应用数据目录权限的应用只能访问该目录下的文件
+func AppRoot(appName string) string {
+	return path.Join(AppsDir, appName)
+}
+
+// 拼接应用数据目录下的接口路径，parts中的..不会跳出应用数据目录
+func JoinAppPath(appName string, parts ...string) string {
+	rel := path.Join(append([]string{"/"}, parts...)...)
+	return path.Join(AppRoot(appName), rel)
+}
+
+// 判断接口路径是否在应用数据目录下
+func IsAppPath(appName, apiPath string) bool {
+	root := AppRoot(appName)
+	apiPath = path.Clean(apiPath)
+	return apiPath == root || strings.HasPrefix(apiPath, root+"/")
+}
+
+// 接口路径转换为网盘客户端显示的路径，如/apps/应用名称/a.txt转换为/我的应用数据/应用名称/a.txt
+func ToDisplayPath(apiPath string) string {
+	return replacePathPrefix(apiPath, AppsDir, AppsDisplayDir)
+}
+
+// 网盘客户端显示的路径转换为接口路径，如/我的应用数据/应用名称/a.txt转换为/apps/应用名称/a.txt
+func ToApiPath(displayPath string) string {
+	return replacePathPrefix(displayPath, AppsDisplayDir, AppsDir)
+}
+
+func replacePathPrefix(p, from, to string) string {
+	p = path.Clean(p)
+	if p == from {
+		return to
+	}
+	if strings.HasPrefix(p, from+"/") {
+		return to + strings.TrimPrefix(p, from)
+	}
+	return p
+}
@@ -0,0 +1,141 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/jsyzchen/pan/utils/httpclient"
+)
+
+// 追加写入时远程文件的默认大小上限，追加需要下载整个文件，只适合清单、索引等小文件
+const DefaultAppendMaxSize = 16 << 20
+
+// 远程文件被其他客户端修改时，追加写入的最多尝试次数
+const appendMaxAttempts = 3
+
+// 下载后、上传前远程文件被其他客户端修改，重试次数用尽后返回
+var ErrRemoteFileChanged = errors.New("remote file changed during append")
+
+// 远程文件超过追加写入的大小上限
+var ErrAppendTooLarge = errors.New("remote file too large to append")
+
+// 追加写入的远程文件状态，exists为false时文件不存在
+type appendState struct {
+	exists bool
+	item   FsItem
+}
+
+func (s appendState) same(other appendState) bool {
+	if s.exists != other.exists {
+		return false
+	}
+	return !s.exists || (s.item.FsID == other.item.FsID && s.item.Size == other.item.Size && strings.EqualFold(s.item.Md5, other.item.Md5))
+}
+
+// 追加数据到网盘文件末尾，文件不存在时创建，适合维护清单、索引等轻量状态文件
+// 下载文件、追加数据后覆盖上传，上传前比较文件md5，期间文件被其他客户端修改时重新读取，重试次数用尽返回ErrRemoteFileChanged
+// 比较与上传之间仍有很短的时间窗口，多个写入方需要严格互斥时配合RemoteLock使用
+func (f *File) AppendToRemoteFile(ctx context.Context, remotePath string, data []byte) (UploadResponse, error) {
+	return f.AppendToRemoteFileWithLimit(ctx, remotePath, data, DefaultAppendMaxSize)
+}
+
+// 同AppendToRemoteFile，maxSize为追加前远程文件的大小上限，为0时不限制
+func (f *File) AppendToRemoteFileWithLimit(ctx context.Context, remotePath string, data []byte, maxSize int64) (UploadResponse, error) {
+	var ret UploadResponse
+	remotePath = handleSpecialChar(remotePath)
+	for attempt := 0; attempt < appendMaxAttempts; attempt++ {
+		state, err := f.appendState(remotePath)
+		if err != nil {
+			return ret, err
+		}
+		var content []byte
+		if state.exists {
+			if maxSize > 0 && int64(state.item.Size) > maxSize {
+				return ret, fmt.Errorf("%w, path: %s size: %d maxSize: %d", ErrAppendTooLarge, remotePath, state.item.Size, maxSize)
+			}
+			if content, err = f.readRemoteFile(ctx, state.item); err != nil {
+				return ret, err
+			}
+		}
+		content = append(content, data...)
+
+		latest, err := f.appendState(remotePath)
+		if err != nil {
+			return ret, err
+		}
+		if !latest.same(state) {
+			log.Printf("AppendToRemoteFile remote file changed, retry path: %s attempt: %d", remotePath, attempt)
+			continue
+		}
+
+		uploader := NewReaderUploader(f.AccessToken, remotePath, bytes.NewReader(content), int64(len(content)))
+		return uploader.Upload(ctx, nil)
+	}
+	return ret, fmt.Errorf("%w, path: %s", ErrRemoteFileChanged, remotePath)
+}
+
+// 获取远程文件的当前状态
+func (f *File) appendState(remotePath string) (appendState, error) {
+	dir, name := path.Split(remotePath)
+	dir = path.Clean(dir)
+	for start := 0; ; start += listPageSize {
+		res, err := f.List(dir, start, listPageSize)
+		if err != nil {
+			log.Printf("AppendToRemoteFile List failed dir: %s err: %v", dir, err)
+			return appendState{}, err
+		}
+		for _, item := range res.List {
+			if item.ServerFileName != name {
+				continue
+			}
+			if item.IsDir == 1 {
+				return appendState{}, errors.New(fmt.Sprintf("AppendToRemoteFile path is a directory, path: %s", remotePath))
+			}
+			return appendState{exists: true, item: item}, nil
+		}
+		if len(res.List) < listPageSize {
+			return appendState{}, nil
+		}
+	}
+}
+
+// 下载远程文件的全部内容
+func (f *File) readRemoteFile(ctx context.Context, item FsItem) ([]byte, error) {
+	metas, err := f.Metas([]uint64{item.FsID})
+	if err != nil {
+		return nil, err
+	}
+	if len(metas.List) == 0 || metas.List[0].DLink == "" {
+		return nil, errors.New(fmt.Sprintf("AppendToRemoteFile get dlink failed, fs_id: %d", item.FsID))
+	}
+	req, err := http.NewRequest(http.MethodGet, metas.List[0].DLink+"&access_token="+f.AccessToken, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "pan.baidu.com")
+	resp, err := httpclient.GetClientWithContext(req.Context()).Do(req)
+	if err != nil {
+		log.Printf("AppendToRemoteFile download failed path: %s err: %v", item.Path, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return nil, errors.New(fmt.Sprintf("AppendToRemoteFile download failed, path: %s httpStatusCode: %d", item.Path, resp.StatusCode))
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(content)) != item.Size {
+		return nil, errors.New(fmt.Sprintf("AppendToRemoteFile download incomplete, path: %s size: %d read: %d", item.Path, item.Size, len(content)))
+	}
+	return content, nil
+}
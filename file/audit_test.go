@@ -0,0 +1,99 @@
+package file_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jsyzchen/pan/file"
+	"github.com/jsyzchen/pan/utils/audit"
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+func auditRecords(t *testing.T, buf *bytes.Buffer) []audit.Record {
+	records := []audit.Record{}
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for scanner.Scan() {
+		record := audit.Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// 两个客户端写入各自的审计日志，未设置审计的客户端不记录
+func TestAuditPerClient(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+	mock.PutFile("/apps/test/a.txt", []byte("a"))
+
+	var bufA, bufB bytes.Buffer
+	clientA := file.NewFileClient("tokenA")
+	clientA.SetAudit(audit.NewWriter(&bufA))
+	clientB := file.NewFileClient("tokenB")
+	clientB.SetAudit(audit.NewWriter(&bufB))
+	if _, err := clientA.List("/apps/test", 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientB.List("/apps/test", 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientB.List("/apps", 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.NewFileClient("tokenC").List("/apps/test", 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	recordsA, recordsB := auditRecords(t, &bufA), auditRecords(t, &bufB)
+	if len(recordsA) != 1 || len(recordsB) != 2 {
+		t.Fatalf("audit records A: %d B: %d, want 1 and 2", len(recordsA), len(recordsB))
+	}
+	if recordsA[0].Params["dir"] != "/apps/test" || recordsA[0].Params["access_token"] != "***" {
+		t.Fatalf("unexpected record params: %v", recordsA[0].Params)
+	}
+}
+
+// 上传器的审计日志包括上传结果和上传过程中的接口请求
+func TestAuditUploader(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+	dir, err := ioutil.TempDir("", "pantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(localPath, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	uploader := file.NewUploader("token", "/apps/test/a.txt", localPath)
+	uploader.SetAudit(audit.NewWriter(&buf))
+	if _, _, err := uploader.Upload(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]int{}
+	endpoints := []string{}
+	for _, record := range auditRecords(t, &buf) {
+		types[record.Type]++
+		endpoints = append(endpoints, record.Endpoint+"?"+record.Params["method"])
+	}
+	if types[audit.TypeUpload] != 1 {
+		t.Fatalf("upload records: %d, want 1", types[audit.TypeUpload])
+	}
+	joined := strings.Join(endpoints, " ")
+	for _, method := range []string{"precreate", "upload", "create"} {
+		if !strings.Contains(joined, "?"+method) {
+			t.Errorf("api call %s not audited, got %s", method, joined)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package file_test
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/jsyzchen/pan/file"
+	"github.com/jsyzchen/pan/utils/httpclient"
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+const benchFileSize = 32 << 20
+
+// 生成基准测试用的本地文件
+func benchFile(b *testing.B, size int) (string, func()) {
+	dir, err := ioutil.TempDir("", "panbench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	localPath := filepath.Join(dir, "bench.bin")
+	if err := ioutil.WriteFile(localPath, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+	return localPath, func() { os.RemoveAll(dir) }
+}
+
+// 接入模拟服务，loopback为true时经过本地TCP连接
+func benchServer(b *testing.B, loopback bool) (*mockpan.Server, func()) {
+	server := mockpan.NewServer()
+	if !loopback {
+		return server, server.Install()
+	}
+	rt, closeServer := server.StartLoopback()
+	httpclient.SetTransport(rt)
+	return server, func() {
+		httpclient.SetTransport(nil)
+		closeServer()
+	}
+}
+
+// 记录每GB数据分配的内存
+type allocMeter struct {
+	start runtime.MemStats
+}
+
+func startAllocMeter() *allocMeter {
+	m := &allocMeter{}
+	runtime.ReadMemStats(&m.start)
+	return m
+}
+
+func (m *allocMeter) report(b *testing.B, bytes int64) {
+	var end runtime.MemStats
+	runtime.ReadMemStats(&end)
+	if bytes > 0 {
+		b.ReportMetric(float64(end.TotalAlloc-m.start.TotalAlloc)/float64(bytes)*(1<<30), "allocB/GB")
+	}
+}
+
+func benchmarkUpload(b *testing.B, loopback bool, configure func(*file.Uploader)) {
+	localPath, cleanup := benchFile(b, benchFileSize)
+	defer cleanup()
+	_, closeServer := benchServer(b, loopback)
+	defer closeServer()
+
+	b.SetBytes(benchFileSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	meter := startAllocMeter()
+	for i := 0; i < b.N; i++ {
+		uploader := file.NewUploader("bench", "/apps/bench/bench.bin", localPath)
+		uploader.SliceSize = 4 << 20
+		uploader.SetConcurrency(4)
+		if configure != nil {
+			configure(uploader)
+		}
+		if _, _, err := uploader.Upload(context.Background(), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	meter.report(b, int64(b.N)*benchFileSize)
+}
+
+func BenchmarkUploadMock(b *testing.B)     { benchmarkUpload(b, false, nil) }
+func BenchmarkUploadLoopback(b *testing.B) { benchmarkUpload(b, true, nil) }
+
+// 对比分片缓冲区、ZeroCopy和Mmap三种读取方式
+func BenchmarkUploadReadMode(b *testing.B) {
+	b.Run("buffer", func(b *testing.B) { benchmarkUpload(b, true, nil) })
+	b.Run("zerocopy", func(b *testing.B) {
+		benchmarkUpload(b, true, func(u *file.Uploader) { u.SetZeroCopy(true) })
+	})
+	b.Run("mmap", func(b *testing.B) {
+		benchmarkUpload(b, true, func(u *file.Uploader) { u.SetMmap(true) })
+	})
+}
+
+func benchmarkDownload(b *testing.B, loopback bool) {
+	data := make([]byte, benchFileSize)
+	rand.New(rand.NewSource(2)).Read(data)
+	server, closeServer := benchServer(b, loopback)
+	defer closeServer()
+	server.VipType = 2 //超级会员分片并发下载
+	fsID := server.PutFile("/apps/bench/download.bin", data)
+	dir, err := ioutil.TempDir("", "panbench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b.SetBytes(benchFileSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	meter := startAllocMeter()
+	for i := 0; i < b.N; i++ {
+		savePath := filepath.Join(dir, "download.bin")
+		downloader := file.NewDownloaderWithFsID("bench", fsID, savePath)
+		if _, err := downloader.Download(context.Background(), dir, nil); err != nil {
+			b.Fatal(err)
+		}
+		os.Remove(savePath)
+	}
+	meter.report(b, int64(b.N)*benchFileSize)
+}
+
+func BenchmarkDownloadMock(b *testing.B)     { benchmarkDownload(b, false) }
+func BenchmarkDownloadLoopback(b *testing.B) { benchmarkDownload(b, true) }
+
+// 计算文件md5和分片md5的速度
+func BenchmarkHash(b *testing.B) {
+	localPath, cleanup := benchFile(b, benchFileSize)
+	defer cleanup()
+	b.SetBytes(benchFileSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		uploader := file.NewUploader("bench", "/apps/bench/bench.bin", localPath)
+		if _, err := uploader.GetFileInfo(false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 文件分类，接口返回的category字段
+type Category int
+
+const (
+	CategoryVideo Category = 1 // 视频
+	CategoryAudio Category = 2 // 音频
+	CategoryImage Category = 3 // 图片
+	CategoryDoc   Category = 4 // 文档
+	CategoryApp   Category = 5 // 应用
+	CategoryOther Category = 6 // 其他
+	CategoryBT    Category = 7 // 种子
+)
+
+var categoryNames = map[Category]string{
+	CategoryVideo: "video",
+	CategoryAudio: "audio",
+	CategoryImage: "image",
+	CategoryDoc:   "doc",
+	CategoryApp:   "app",
+	CategoryOther: "other",
+	CategoryBT:    "bt",
+}
+
+// 分类名称，如video，未知分类返回category(n)
+func (c Category) String() string {
+	if name, ok := categoryNames[c]; ok {
+		return name
+	}
+	return "category(" + strconv.Itoa(int(c)) + ")"
+}
+
+// 是否为已知的分类
+func (c Category) Valid() bool {
+	_, ok := categoryNames[c]
+	return ok
+}
+
+// 解析分类名称或数字，如video、1
+func ParseCategory(s string) (Category, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for c, name := range categoryNames {
+		if name == s {
+			return c, nil
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil && Category(n).Valid() {
+		return Category(n), nil
+	}
+	return 0, errors.New(fmt.Sprintf("unknown category: %s", s))
+}
+
+func categorySet(categories []Category) map[Category]bool {
+	set := make(map[Category]bool, len(categories))
+	for _, c := range categories {
+		set[c] = true
+	}
+	return set
+}
+
+// 筛选指定分类的文件，categories为空时返回全部文件
+func FilterFsItems(items []FsItem, categories ...Category) []FsItem {
+	if len(categories) == 0 {
+		return items
+	}
+	set := categorySet(categories)
+	ret := []FsItem{}
+	for _, item := range items {
+		if set[item.Category] {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
+// 筛选指定分类的文件信息，categories为空时返回全部文件信息
+func FilterMetasItems(items []MetasItem, categories ...Category) []MetasItem {
+	if len(categories) == 0 {
+		return items
+	}
+	set := categorySet(categories)
+	ret := []MetasItem{}
+	for _, item := range items {
+		if set[item.Category] {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,73 @@
+package file
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	fileUtil "github.com/jsyzchen/pan/utils/file"
+)
+
+// 上传检查点，每完成一个分片保存一次快照，进程崩溃后可从存储中的快照续传
+type uploadCheckpoint struct {
+	store    fileUtil.SnapshotStore
+	mu       sync.Mutex
+	snapshot fileUtil.UploadSnapshot
+}
+
+// 设置快照存储，设置后上传过程中每完成一个分片保存一次快照，上传成功后删除，失败且可续传时保存最终快照
+func (u *Uploader) SetCheckpointStore(store fileUtil.SnapshotStore) {
+	u.Checkpoint = store
+}
+
+// 开始记录检查点，未设置快照存储时返回nil
+func (u *Uploader) newCheckpoint(snapshot fileUtil.UploadSnapshot) *uploadCheckpoint {
+	if u.Checkpoint == nil {
+		return nil
+	}
+	c := &uploadCheckpoint{store: u.Checkpoint, snapshot: snapshot}
+	c.snapshot.Recoverable = true
+	c.snapshot.DoneSlices = make([]string, snapshot.SliceNum)
+	copy(c.snapshot.DoneSlices, snapshot.DoneSlices)
+	c.save()
+	return c
+}
+
+// 分片上传完成，保存快照
+func (c *uploadCheckpoint) done(partSeq int, md5 string, size int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if partSeq < 0 || partSeq >= len(c.snapshot.DoneSlices) || c.snapshot.DoneSlices[partSeq] != "" {
+		return
+	}
+	c.snapshot.DoneSlices[partSeq] = md5
+	c.snapshot.DoneSize += size
+	c.save()
+}
+
+// 调用方需持有锁或确保没有并发调用，保存失败只记录日志，不影响上传
+func (c *uploadCheckpoint) save() {
+	c.snapshot.UpdatedAt = time.Now().Unix()
+	if err := c.store.SaveUpload(c.snapshot); err != nil {
+		log.Printf("uploadCheckpoint SaveUpload failed path: %s err: %v", c.snapshot.Path, err)
+	}
+}
+
+// 上传结束后更新快照存储，成功或不可续传时删除快照，可续传时保存最终快照
+func (u *Uploader) finishCheckpoint(snapshot fileUtil.UploadSnapshot) {
+	if u.Checkpoint == nil || snapshot.UploadId == "" {
+		return
+	}
+	var err error
+	if snapshot.Recoverable {
+		err = u.Checkpoint.SaveUpload(snapshot)
+	} else {
+		err = u.Checkpoint.DeleteUpload(snapshot)
+	}
+	if err != nil {
+		log.Printf("uploadCheckpoint finish failed path: %s err: %v", snapshot.Path, err)
+	}
+}
@@ -0,0 +1,128 @@
+package file_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jsyzchen/pan/file"
+	"github.com/jsyzchen/pan/utils/httpclient"
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+// 创建文件请求在服务端成功后连接断开，模拟结果未知的超时
+type lostCreateTransport struct {
+	server *mockpan.Server
+	lost   int
+}
+
+func (t *lostCreateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Query().Get("method") != "create" || req.Body == nil {
+		return t.server.RoundTrip(req)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp, err := t.server.RoundTrip(req)
+	if err == nil && t.lost == 0 && strings.Contains(string(body), "isdir=0") {
+		t.lost++
+		resp.Body.Close()
+		return nil, errors.New("read: connection reset by peer")
+	}
+	return resp, err
+}
+
+func TestCreateRetryFindsRenamedCommit(t *testing.T) {
+	mock := mockpan.NewServer()
+	mock.PutFile("/apps/test/a.txt", []byte("old content"))
+	transport := &lostCreateTransport{server: mock}
+	httpclient.SetTransport(transport)
+	defer httpclient.SetTransport(nil)
+
+	dir, err := ioutil.TempDir("", "pantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(localPath, []byte("new content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uploader := file.NewUploader("token", "/apps/test/a.txt", localPath)
+	uploader.SetConflictPolicy(file.ConflictRename)
+	ret, _, err := uploader.Upload(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if transport.lost != 1 {
+		t.Fatalf("create response not dropped, lost: %d", transport.lost)
+	}
+	if ret.Path != "/apps/test/a(1).txt" {
+		t.Fatalf("Upload returned path %s, want /apps/test/a(1).txt", ret.Path)
+	}
+	if mock.Exists("/apps/test/a(2).txt") {
+		t.Fatal("retry created a duplicate copy")
+	}
+	if data, _ := mock.ReadFile("/apps/test/a.txt"); string(data) != "old content" {
+		t.Fatalf("existing file changed: %q", data)
+	}
+}
+
+// xpan创建文件失败，旧版createsuperfile接口返回文件已存在
+type fallbackExistsTransport struct {
+	server *mockpan.Server
+}
+
+func (t *fallbackExistsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body string
+	switch req.URL.Query().Get("method") {
+	case "create":
+		body = `{"errno":10,"request_id":1}`
+	case "createsuperfile":
+		body = `{"error_code":31061,"error_msg":"file already exists","request_id":2}`
+	default:
+		return t.server.RoundTrip(req)
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(body)), Request: req}, nil
+}
+
+// ConflictFail时旧版接口返回的文件已存在也转为RemoteExistsError
+func TestCommitFallbackRemoteExists(t *testing.T) {
+	mock := mockpan.NewServer()
+	httpclient.SetTransport(&fallbackExistsTransport{server: mock})
+	defer httpclient.SetTransport(nil)
+
+	dir, err := ioutil.TempDir("", "pantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(localPath, []byte("new content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uploader := file.NewUploader("token", "/apps/test/a.txt", localPath)
+	uploader.SetConflictPolicy(file.ConflictFail)
+	uploader.SetFallback(true)
+	_, _, err = uploader.Upload(context.Background(), nil)
+	if !errors.Is(err, file.ErrRemoteFileExists) {
+		t.Fatalf("Upload err: %v, want ErrRemoteFileExists", err)
+	}
+	var existsErr *file.RemoteExistsError
+	if !errors.As(err, &existsErr) || existsErr.Path != "/apps/test/a.txt" {
+		t.Fatalf("Upload err: %#v, want RemoteExistsError for /apps/test/a.txt", err)
+	}
+}
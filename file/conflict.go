@@ -0,0 +1,75 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jsyzchen/pan/errno"
+)
+
+// 上传时网盘路径已存在同名文件的处理方式
+type ConflictPolicy int
+
+const (
+	ConflictOverwrite ConflictPolicy = iota // 覆盖已存在的文件，默认方式
+	ConflictRename                          // 保存为其他路径，如"file(1).txt"，通过RenameHandler获取实际保存的路径
+	ConflictFail                            // 上传失败，返回RemoteExistsError，不会覆盖网盘文件
+)
+
+// 网盘路径已存在同名文件且处理方式为ConflictFail，可通过errors.Is判断，通过errors.As获取RemoteExistsError
+var ErrRemoteFileExists = errors.New("remote file already exists")
+
+// 网盘路径已存在同名文件
+type RemoteExistsError struct {
+	Path string
+	Err  error // 接口返回的原始错误
+}
+
+func (e *RemoteExistsError) Error() string {
+	return fmt.Sprintf("remote file already exists, path: %s err: %v", e.Path, e.Err)
+}
+
+func (e *RemoteExistsError) Is(target error) bool {
+	return target == ErrRemoteFileExists
+}
+
+func (e *RemoteExistsError) Unwrap() error {
+	return e.Err
+}
+
+// 预创建和创建文件接口的rtype参数
+func (p ConflictPolicy) rtype() string {
+	switch p {
+	case ConflictRename:
+		return "1" // 路径冲突时重命名
+	case ConflictFail:
+		return "0" // 路径冲突时返回错误
+	default:
+		return "3" // 覆盖
+	}
+}
+
+// 旧版createsuperfile接口的ondup参数
+func (p ConflictPolicy) ondup() string {
+	switch p {
+	case ConflictRename:
+		return "newcopy"
+	case ConflictFail:
+		return "fail"
+	default:
+		return "overwrite"
+	}
+}
+
+// 设置网盘路径已存在同名文件时的处理方式，不能覆盖网盘文件时使用ConflictFail
+func (u *Uploader) SetConflictPolicy(policy ConflictPolicy) {
+	u.Conflict = policy
+}
+
+// 文件已存在的错误转为RemoteExistsError，xpan接口返回-8，旧版createsuperfile接口返回31061
+func (u *Uploader) conflictError(code int, err error) error {
+	if code == int(errno.CodeFileExists) || code == int(errno.CodeRemoteFileExists) {
+		return &RemoteExistsError{Path: u.Path, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package file
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/jsyzchen/pan/utils/norm"
+)
+
+// 文件变化前后的信息
+type FsItemChange struct {
+	Prev FsItem
+	Curr FsItem
+}
+
+// 两次文件列表的差异，各列表按路径排序
+type DirDiff struct {
+	Added    []FsItem       // 新增的文件
+	Removed  []FsItem       // 删除的文件
+	Modified []FsItemChange // 内容变化的文件，按fs_id或路径匹配，md5、大小或修改时间不同
+	Moved    []FsItemChange // 移动或重命名的文件，fs_id相同路径不同，内容同时变化时也会出现在Modified中
+}
+
+// 是否没有任何变化
+func (d DirDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0 && len(d.Moved) == 0
+}
+
+// 比较两次文件列表，先按fs_id匹配，fs_id匹配不到时按路径匹配，路径按NFC规范化后比较
+func DiffDirs(prev, curr []FsItem) DirDiff {
+	diff := DirDiff{}
+	prevByFsID := make(map[uint64]int, len(prev))
+	prevByPath := make(map[string]int, len(prev))
+	for i, item := range prev {
+		prevByFsID[item.FsID] = i
+		prevByPath[norm.NFCString(item.Path)] = i
+	}
+
+	matched := make([]bool, len(prev))
+	for _, item := range curr {
+		i, ok := prevByFsID[item.FsID]
+		if !ok || matched[i] {
+			i, ok = prevByPath[norm.NFCString(item.Path)]
+		}
+		if !ok || matched[i] {
+			diff.Added = append(diff.Added, item)
+			continue
+		}
+		matched[i] = true
+		prevItem := prev[i]
+		if !norm.Equal(prevItem.Path, item.Path) {
+			diff.Moved = append(diff.Moved, FsItemChange{prevItem, item})
+		}
+		if fsItemModified(prevItem, item) {
+			diff.Modified = append(diff.Modified, FsItemChange{prevItem, item})
+		}
+	}
+	for i, item := range prev {
+		if !matched[i] {
+			diff.Removed = append(diff.Removed, item)
+		}
+	}
+
+	sortFsItems(diff.Added)
+	sortFsItems(diff.Removed)
+	sortFsItemChanges(diff.Modified)
+	sortFsItemChanges(diff.Moved)
+	return diff
+}
+
+func fsItemModified(prev, curr FsItem) bool {
+	if prev.IsDir != curr.IsDir {
+		return true
+	}
+	if curr.IsDir == 1 { // 目录的修改时间随子文件变化，不作为目录本身的变化
+		return false
+	}
+	return prev.Md5 != curr.Md5 || prev.Size != curr.Size || prev.ServerMtime != curr.ServerMtime
+}
+
+func sortFsItems(items []FsItem) {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Path < items[j].Path
+	})
+}
+
+func sortFsItemChanges(changes []FsItemChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Curr.Path < changes[j].Curr.Path
+	})
+}
+
+// 递归获取网盘目录的文件列表，与snapshotPath中保存的上一次列表比较，并将本次列表保存到snapshotPath
+// snapshotPath不存在时全部文件都作为新增
+func (f *File) DiffDirSnapshot(dir, snapshotPath string) (DirDiff, error) {
+	prev := []FsItem{}
+	data, err := ioutil.ReadFile(snapshotPath)
+	if err == nil {
+		if err := json.Unmarshal(data, &prev); err != nil {
+			log.Printf("DiffDirSnapshot json.Unmarshal failed snapshotPath: %s err: %v", snapshotPath, err)
+			return DirDiff{}, err
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("DiffDirSnapshot ioutil.ReadFile failed snapshotPath: %s err: %v", snapshotPath, err)
+		return DirDiff{}, err
+	}
+
+	curr, err := f.ListRecursive(dir)
+	if err != nil {
+		log.Printf("DiffDirSnapshot ListRecursive failed dir: %s err: %v", dir, err)
+		return DirDiff{}, err
+	}
+	diff := DiffDirs(prev, curr)
+
+	data, err = json.Marshal(curr)
+	if err != nil {
+		return diff, err
+	}
+	tmpPath := snapshotPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Println("DiffDirSnapshot ioutil.WriteFile failed, err:", err)
+		return diff, err
+	}
+	return diff, os.Rename(tmpPath, snapshotPath)
+}
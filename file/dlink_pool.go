@@ -0,0 +1,148 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDlinkTTL           = 8 * time.Hour    // 下载地址有效期
+	defaultDlinkRefreshBefore = 30 * time.Minute // 过期前多久刷新
+)
+
+type dlinkEntry struct {
+	link     string
+	expireAt time.Time
+}
+
+// DlinkPool 下载地址池，提前获取并在过期前刷新一组文件的下载地址，供代理、流媒体服务即时取用
+type DlinkPool struct {
+	AccessToken   string
+	TTL           time.Duration
+	RefreshBefore time.Duration
+
+	mu    sync.Mutex // 保护AccessToken和links，DlinkPool可并发使用
+	links map[uint64]dlinkEntry
+}
+
+func NewDlinkPool(accessToken string) *DlinkPool {
+	return &DlinkPool{
+		AccessToken:   accessToken,
+		TTL:           defaultDlinkTTL,
+		RefreshBefore: defaultDlinkRefreshBefore,
+		links:         map[uint64]dlinkEntry{},
+	}
+}
+
+// 切换为其他用户的access_token，并清空已缓存的下载地址
+func (p *DlinkPool) Bind(accessToken string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.AccessToken = accessToken
+	p.links = map[uint64]dlinkEntry{}
+}
+
+func (p *DlinkPool) SetTTL(ttl time.Duration) {
+	p.TTL = ttl
+}
+
+func (p *DlinkPool) SetRefreshBefore(refreshBefore time.Duration) {
+	p.RefreshBefore = refreshBefore
+}
+
+// 获取并缓存一组文件的下载地址
+func (p *DlinkPool) Prefetch(fsIDs []uint64) error {
+	p.mu.Lock()
+	fileClient := NewFileClient(p.AccessToken)
+	p.mu.Unlock()
+	for start := 0; start < len(fsIDs); start += MetasMaxFsIDs {
+		end := start + MetasMaxFsIDs
+		if end > len(fsIDs) {
+			end = len(fsIDs)
+		}
+		fetchTime := time.Now()
+		metas, err := fileClient.Metas(fsIDs[start:end])
+		if err != nil {
+			log.Println("dlinkPool fileClient.Metas failed, err:", err)
+			return err
+		}
+		p.mu.Lock()
+		if p.AccessToken != fileClient.AccessToken { // 获取期间已切换账号，丢弃结果
+			p.mu.Unlock()
+			return nil
+		}
+		for _, item := range metas.List {
+			if item.DLink == "" {
+				continue
+			}
+			p.links[item.FsID] = dlinkEntry{
+				link:     item.DLink,
+				expireAt: fetchTime.Add(p.TTL),
+			}
+		}
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+// 获取文件的下载地址，缓存中没有或已过期时立即获取，返回的地址下载时需要拼接access_token
+func (p *DlinkPool) Get(fsID uint64) (string, error) {
+	p.mu.Lock()
+	entry, ok := p.links[fsID]
+	p.mu.Unlock()
+	if ok && time.Now().Before(entry.expireAt) {
+		return entry.link, nil
+	}
+
+	if err := p.Prefetch([]uint64{fsID}); err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	entry, ok = p.links[fsID]
+	p.mu.Unlock()
+	if !ok {
+		return "", errors.New(fmt.Sprintf("dlink not found, fs_id: %d", fsID))
+	}
+	return entry.link, nil
+}
+
+// 从池中移除文件，不再刷新
+func (p *DlinkPool) Remove(fsID uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.links, fsID)
+}
+
+// 刷新即将过期的下载地址
+func (p *DlinkPool) Refresh() error {
+	deadline := time.Now().Add(p.RefreshBefore)
+	fsIDs := []uint64{}
+	p.mu.Lock()
+	for fsID, entry := range p.links {
+		if entry.expireAt.Before(deadline) {
+			fsIDs = append(fsIDs, fsID)
+		}
+	}
+	p.mu.Unlock()
+	return p.Prefetch(fsIDs)
+}
+
+// 定时刷新即将过期的下载地址，阻塞直到ctx结束
+func (p *DlinkPool) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if err := p.Refresh(); err != nil {
+			log.Println("dlinkPool refresh failed, err:", err)
+		}
+	}
+}
@@ -4,20 +4,42 @@ import (
 	"context"
 	"errors"
 	"log"
-	"os"
 	"sync"
+	"time"
 
 	"github.com/jsyzchen/pan/account"
+	"github.com/jsyzchen/pan/utils/audit"
 	"github.com/jsyzchen/pan/utils/file"
 )
 
 type DownloadProgressHandler = func(int, int64, int64)
 
 type Downloader struct {
-	LocalFilePath string
-	FsID          uint64
-	AccessToken   string
-	TotalPart     int
+	LocalFilePath   string
+	FsID            uint64
+	AccessToken     string
+	TotalPart       int
+	PathMapper      *file.PathMapper         // 本地路径映射，为nil时不做处理
+	LockTarget      bool                     // 下载期间锁定目标文件，防止多个进程同时下载到同一文件
+	ForceLock       bool                     // 目标文件已被锁定时强制接管
+	BufferSize      int64                    // 读写缓冲区大小，为0时使用默认值
+	RateLimiter     *file.RateLimiter        // 限速器，为nil时不限速
+	Audit           *audit.Writer            // 审计日志，记录传输结果和本次传输发起的接口请求，为nil时不记录
+	PartConcurrency int                      // 分片下载并发数上限，为0时按会员身份决定
+	PartLimiter     *file.ConcurrencyLimiter // 分片并发数上限，可在下载中调整，与PartConcurrency同时设置时取较小值，为nil时不限制
+	DeferMerge      bool                     // 分片下载完成后不合并，返回的快照状态为merge_pending，之后调用Merge合并
+	Validate        file.ValidateHook        // 下载完成后、移动到保存路径前的校验钩子，未通过时返回file.ValidationError
+	Debug           bool                     // 在快照中记录下载域名、请求ID和分片错误等诊断信息
+	ProgressHandler DownloadProgressHandler  // 调用时传入的进度回调为nil时使用，均为nil时不回调
+}
+
+// 下载结果
+type DownloadResult struct {
+	Path         string        // 最终保存的本地路径
+	BytesWritten int64         // 写入的字节数，断点续传时不包括之前已下载的部分
+	Duration     time.Duration // 耗时
+	Speed        float64       // 平均速度，单位字节/秒
+	PartsRetried int64         // 分片重试次数
 }
 
 const (
@@ -32,6 +54,96 @@ func NewDownloaderWithFsID(accessToken string, fsID uint64, localFilePath string
 	}
 }
 
+// 切换为其他用户的access_token，不能与正在进行的下载并发调用
+func (d *Downloader) Bind(accessToken string) {
+	d.AccessToken = accessToken
+}
+
+// 设置本地路径映射，windows下可使用file.NewPathMapper()处理长路径和保留文件名
+func (d *Downloader) SetPathMapper(pathMapper *file.PathMapper) {
+	d.PathMapper = pathMapper
+}
+
+// 设置读写缓冲区大小
+func (d *Downloader) SetBufferSize(bufferSize int64) {
+	d.BufferSize = bufferSize
+}
+
+// 设置限速器，多个下载任务可共享同一个限速器
+func (d *Downloader) SetRateLimiter(rateLimiter *file.RateLimiter) {
+	d.RateLimiter = rateLimiter
+}
+
+// 设置分片下载并发数上限，只能调低会员身份决定的并发数
+func (d *Downloader) SetPartConcurrency(partConcurrency int) {
+	d.PartConcurrency = partConcurrency
+}
+
+// 设置可在下载中调整的分片并发数上限，传输管理通过它调整正在运行的任务
+func (d *Downloader) SetPartLimiter(partLimiter *file.ConcurrencyLimiter) {
+	d.PartLimiter = partLimiter
+}
+
+// 设置分片下载完成后是否推迟合并，传输管理可将任务标记为待合并，在磁盘空闲时段再合并
+func (d *Downloader) SetDeferMerge(deferMerge bool) {
+	d.DeferMerge = deferMerge
+}
+
+// 设置下载完成后的校验钩子，如病毒扫描，未通过校验的文件被删除，不会出现在保存路径
+func (d *Downloader) SetValidateHook(validate file.ValidateHook) {
+	d.Validate = validate
+}
+
+// 设置是否在快照中记录诊断信息，用于事后排查下载停滞等问题
+func (d *Downloader) SetDebug(debug bool) {
+	d.Debug = debug
+}
+
+// 合并推迟合并的下载任务，合并前检查全部分片文件，合并成功后删除分片文件
+func (d *Downloader) Merge(ctx context.Context, snapshot file.DownloadSnapshot, progressHandler DownloadProgressHandler) (file.DownloadSnapshot, error) {
+	ctx = d.Audit.Context(ctx)
+	retSnapshot := snapshot
+	if d.LocalFilePath != "" {
+		retSnapshot.SavePath = d.LocalFilePath
+	}
+	d.LocalFilePath = retSnapshot.SavePath
+
+	jobLock, err := d.lockTarget()
+	if err != nil {
+		log.Printf("merge lockTarget failed err: %v savePath: %s", err, d.LocalFilePath)
+		return retSnapshot, err
+	}
+	defer jobLock.Unlock()
+
+	delFiles, err := file.MergeSnapshotValidated(ctx, &retSnapshot, d.PathMapper.ToLocal(retSnapshot.SavePath), d.Validate, progressHandlerOr(progressHandler, d.ProgressHandler))
+	retSnapshot.Status = file.ClassifyStatus(err)
+	retSnapshot.UpdatedAt = time.Now().Unix()
+	d.RemovePartFiles(delFiles)
+	if err != nil {
+		return retSnapshot, err
+	}
+	return retSnapshot, nil
+}
+
+// 设置审计日志，记录下载结果
+func (d *Downloader) SetAudit(auditWriter *audit.Writer) {
+	d.Audit = auditWriter
+}
+
+// 设置目标文件锁，enable开启锁定，force为true时强制接管已有的锁
+func (d *Downloader) SetLock(enable, force bool) {
+	d.LockTarget = enable
+	d.ForceLock = force
+}
+
+// 锁定下载目标文件，未开启时返回nil
+func (d *Downloader) lockTarget() (*file.JobLock, error) {
+	if !d.LockTarget {
+		return nil, nil
+	}
+	return file.LockJob(d.PathMapper.ToLocal(d.LocalFilePath), d.ForceLock)
+}
+
 // 获取下载地址
 func (d *Downloader) GetDownloadLinkInfo() (string, string, error) {
 	if d.FsID == 0 {
@@ -40,6 +152,7 @@ func (d *Downloader) GetDownloadLinkInfo() (string, string, error) {
 	downloadLink := ""
 	fileMd5 := ""
 	fileClient := NewFileClient(d.AccessToken)
+	fileClient.SetAudit(d.Audit)
 	metas, err := fileClient.Metas([]uint64{d.FsID})
 	if err != nil {
 		log.Println("getDownloadLinkInfo fileClient.Metas failed err:", err)
@@ -55,25 +168,89 @@ func (d *Downloader) GetDownloadLinkInfo() (string, string, error) {
 	return downloadLink, fileMd5, nil
 }
 
-// 执行下载
+// 执行下载，返回的快照中记录传输结束的原因
 func (d *Downloader) Download(ctx context.Context, tempDir string, progressHandler DownloadProgressHandler) (file.DownloadSnapshot, error) {
+	_, snapshot, err := d.DownloadWithResult(ctx, tempDir, progressHandler)
+	return snapshot, err
+}
+
+// 执行下载，同时返回保存路径、耗时、平均速度等下载结果
+// 进度回调在同一goroutine中按上报顺序依次调用，返回前全部回调已执行完
+func (d *Downloader) DownloadWithResult(ctx context.Context, tempDir string, progressHandler DownloadProgressHandler) (DownloadResult, file.DownloadSnapshot, error) {
+	ctx = d.Audit.Context(ctx)
+	startTime := time.Now()
+	dispatcher := file.NewProgressDispatcher(progressHandlerOr(progressHandler, d.ProgressHandler))
+	defer dispatcher.Close()
+	progressHandler = dispatcher.Handle
+	result := DownloadResult{}
+	snapshot, err := d.download(ctx, tempDir, progressHandler, &result)
+	snapshot.Status = file.ClassifyStatus(err)
+	snapshot.UpdatedAt = time.Now().Unix()
+	d.audit(startTime, snapshot, err)
+	result.finish(startTime, snapshot.DoneSize)
+	return result, snapshot, err
+}
+
+// 计算耗时和平均速度
+func (r *DownloadResult) finish(startTime time.Time, doneSize int64) {
+	r.BytesWritten = doneSize - r.BytesWritten
+	if r.BytesWritten < 0 {
+		r.BytesWritten = 0
+	}
+	r.Duration = time.Since(startTime)
+	if r.Duration > 0 {
+		r.Speed = float64(r.BytesWritten) / r.Duration.Seconds()
+	}
+}
+
+// 记录下载结果到审计日志
+func (d *Downloader) audit(startTime time.Time, snapshot file.DownloadSnapshot, err error) {
+	record := audit.Record{
+		Type:      audit.TypeDownload,
+		LocalPath: d.LocalFilePath,
+		FsID:      d.FsID,
+		Status:    string(snapshot.Status),
+		Bytes:     snapshot.DoneSize,
+	}
+	if auditErr := d.Audit.WriteTransfer(record, startTime, err); auditErr != nil {
+		log.Println("audit WriteTransfer failed, err:", auditErr)
+	}
+}
+
+func (d *Downloader) download(ctx context.Context, tempDir string, progressHandler DownloadProgressHandler, result *DownloadResult) (file.DownloadSnapshot, error) {
 	retSnapshot := file.DownloadSnapshot{}
 	retSnapshot.FsID = d.FsID
 	retSnapshot.SavePath = d.LocalFilePath
+	result.Path = d.PathMapper.ToLocal(d.LocalFilePath)
 
 	if d.LocalFilePath == "" || d.AccessToken == "" {
 		return retSnapshot, errors.New("download local file path or access token is empty")
 	}
 
+	jobLock, err := d.lockTarget()
+	if err != nil {
+		log.Printf("download lockTarget failed err: %v savePath: %s", err, d.LocalFilePath)
+		return retSnapshot, err
+	}
+	defer jobLock.Unlock()
+
 	downloadLink, fileMd5, err := d.GetDownloadLinkInfo()
 	if err != nil {
 		return retSnapshot, err
 	}
 	retSnapshot.FileMd5 = fileMd5
 
-	downloader := file.NewFileDownloader(downloadLink, d.LocalFilePath)
+	downloader := file.NewFileDownloader(downloadLink, d.PathMapper.ToLocal(d.LocalFilePath))
+	downloader.SetBufferSize(d.BufferSize)
+	downloader.SetRateLimiter(d.RateLimiter)
+	downloader.SetDeferMerge(d.DeferMerge)
+	downloader.SetValidateHook(d.Validate)
+	downloader.SetDebug(d.Debug)
+	defer func() {
+		result.PartsRetried = downloader.Retries()
+	}()
 	accountClient := account.NewAccountClient(d.AccessToken)
-	if userInfo, err := accountClient.UserInfo(); err == nil {
+	if userInfo, err := accountClient.UserInfoWithContext(ctx); err == nil {
 		log.Println("download VipType:", userInfo.VipType)
 		retSnapshot.VipType = userInfo.VipType
 		if userInfo.VipType == 2 { //当前用户是超级会员
@@ -82,6 +259,11 @@ func (d *Downloader) Download(ctx context.Context, tempDir string, progressHandl
 		}
 	}
 
+	if d.PartConcurrency > 0 && d.PartConcurrency < downloader.PartCoroutineNum {
+		downloader.SetCoroutineNum(d.PartConcurrency)
+	}
+	downloader.SetPartLimiter(d.PartLimiter)
+
 	supportRange, err := downloader.TryPrepare(ctx)
 	if err != nil {
 		log.Printf("download downloader.TryPrepare failed err: %v savePath: %s", err, d.LocalFilePath)
@@ -93,6 +275,7 @@ func (d *Downloader) Download(ctx context.Context, tempDir string, progressHandl
 		retSnapshot.PartSize = downloader.FileSize
 		retSnapshot.TotalPart = 1
 		err := downloader.DownloadWhole(ctx, downloader.FileSize, progressHandler)
+		retSnapshot.Diagnostics = downloader.Diagnostics()
 		if err == nil {
 			retSnapshot.DoneSize = downloader.FileSize
 		} else {
@@ -113,23 +296,62 @@ func (d *Downloader) Download(ctx context.Context, tempDir string, progressHandl
 
 // 从断点继续下载
 func (d *Downloader) ResumeDownload(ctx context.Context, snapshot file.DownloadSnapshot, tempDir string, progressHandler DownloadProgressHandler) (file.DownloadSnapshot, error) {
+	_, retSnapshot, err := d.ResumeDownloadWithResult(ctx, snapshot, tempDir, progressHandler)
+	return retSnapshot, err
+}
+
+// 从断点继续下载，同时返回下载结果
+func (d *Downloader) ResumeDownloadWithResult(ctx context.Context, snapshot file.DownloadSnapshot, tempDir string, progressHandler DownloadProgressHandler) (DownloadResult, file.DownloadSnapshot, error) {
+	ctx = d.Audit.Context(ctx)
+	startTime := time.Now()
+	dispatcher := file.NewProgressDispatcher(progressHandlerOr(progressHandler, d.ProgressHandler))
+	defer dispatcher.Close()
+	progressHandler = dispatcher.Handle
+	result := DownloadResult{}
+	retSnapshot, err := d.resumeDownload(ctx, snapshot, tempDir, progressHandler, &result)
+	retSnapshot.Status = file.ClassifyStatus(err)
+	retSnapshot.UpdatedAt = time.Now().Unix()
+	d.audit(startTime, retSnapshot, err)
+	result.finish(startTime, retSnapshot.DoneSize)
+	return result, retSnapshot, err
+}
+
+func (d *Downloader) resumeDownload(ctx context.Context, snapshot file.DownloadSnapshot, tempDir string, progressHandler DownloadProgressHandler, result *DownloadResult) (file.DownloadSnapshot, error) {
 	retSnapshot := snapshot
+	result.Path = d.PathMapper.ToLocal(d.LocalFilePath)
+	result.BytesWritten = snapshot.DoneSize // 续传前已下载的部分，结束时扣除
 	retSnapshot.DoneParts = make([]file.DownloadPartSnapshot, snapshot.TotalPart)
 	copy(retSnapshot.DoneParts, snapshot.DoneParts)
 
 	if d.LocalFilePath == "" || d.AccessToken == "" {
 		return retSnapshot, errors.New("resumeDownload local file path or access token is empty")
 	}
+	retSnapshot.SavePath = d.LocalFilePath
+
+	jobLock, err := d.lockTarget()
+	if err != nil {
+		log.Printf("resumeDownload lockTarget failed err: %v savePath: %s", err, d.LocalFilePath)
+		return retSnapshot, err
+	}
+	defer jobLock.Unlock()
 
 	downloadLink, fileMd5, err := d.GetDownloadLinkInfo()
 	if err != nil {
 		return retSnapshot, err
 	}
 
-	downloader := file.NewFileDownloader(downloadLink, d.LocalFilePath)
+	downloader := file.NewFileDownloader(downloadLink, d.PathMapper.ToLocal(d.LocalFilePath))
+	downloader.SetBufferSize(d.BufferSize)
+	downloader.SetRateLimiter(d.RateLimiter)
+	downloader.SetDeferMerge(d.DeferMerge)
+	downloader.SetValidateHook(d.Validate)
+	downloader.SetDebug(d.Debug)
+	defer func() {
+		result.PartsRetried = downloader.Retries()
+	}()
 	accountClient := account.NewAccountClient(d.AccessToken)
 	vipType := retSnapshot.VipType
-	if userInfo, err := accountClient.UserInfo(); err == nil {
+	if userInfo, err := accountClient.UserInfoWithContext(ctx); err == nil {
 		log.Println("resumeDownload VipType:", userInfo.VipType)
 		vipType = userInfo.VipType
 	} else {
@@ -140,6 +362,11 @@ func (d *Downloader) ResumeDownload(ctx context.Context, snapshot file.DownloadS
 		downloader.SetCoroutineNum(5)    //分片下载并发数，普通用户不支持并发分片下载
 	}
 
+	if d.PartConcurrency > 0 && d.PartConcurrency < downloader.PartCoroutineNum {
+		downloader.SetCoroutineNum(d.PartConcurrency)
+	}
+	downloader.SetPartLimiter(d.PartLimiter)
+
 	supportRange, err := downloader.TryPrepare(ctx)
 	if err != nil {
 		log.Printf("resumeDownload downloader.TryPrepare failed err: %v savePath: %s", err, d.LocalFilePath)
@@ -158,17 +385,20 @@ func (d *Downloader) ResumeDownload(ctx context.Context, snapshot file.DownloadS
 		d.RemovePartFiles(delFiles)
 	}()
 
-	if !supportRange || downloader.FileSize <= downloader.PartSize {
+	// 分片范围只取自快照，单分片或服务端不支持Range时直接下载整个文件
+	if !supportRange || retSnapshot.TotalPart == 1 {
 		retSnapshot.VipType = vipType
 		retSnapshot.FileMd5 = fileMd5
 		retSnapshot.Recoverable = false
 		retSnapshot.DoneSize = 0
+		result.BytesWritten = 0
 		retSnapshot.TotalSize = downloader.FileSize
 		retSnapshot.PartSize = downloader.FileSize
 		retSnapshot.TotalPart = 1
 		filesFromSnapshot(retSnapshot.DoneParts)
 		retSnapshot.DoneParts = nil
 		err := downloader.DownloadWhole(ctx, downloader.FileSize, progressHandler)
+		retSnapshot.Diagnostics = downloader.Diagnostics()
 		if err == nil {
 			retSnapshot.DoneSize = downloader.FileSize
 		} else {
@@ -177,12 +407,13 @@ func (d *Downloader) ResumeDownload(ctx context.Context, snapshot file.DownloadS
 		return retSnapshot, err
 	}
 
-	if vipType != retSnapshot.VipType || fileMd5 != retSnapshot.FileMd5 {
-		log.Printf("resumeDownload vip type or file md5 mismatch, revert to download savePath: %s", d.LocalFilePath)
+	if fileMd5 != retSnapshot.FileMd5 || retSnapshot.TotalSize != downloader.FileSize || retSnapshot.ValidateParts() != nil {
+		log.Printf("resumeDownload file changed or snapshot parts invalid, revert to download savePath: %s", d.LocalFilePath)
 		retSnapshot.VipType = vipType
 		retSnapshot.FileMd5 = fileMd5
 		retSnapshot.Recoverable = false
 		retSnapshot.DoneSize = 0
+		result.BytesWritten = 0
 		retSnapshot.TotalSize = downloader.FileSize
 		retSnapshot.PartSize = 0
 		retSnapshot.TotalPart = 0
@@ -195,6 +426,7 @@ func (d *Downloader) ResumeDownload(ctx context.Context, snapshot file.DownloadS
 			return retSnapshot, err
 		}
 	} else {
+		retSnapshot.VipType = vipType
 		files, err := downloader.ResumeDownload(ctx, tempDir, &retSnapshot, progressHandler)
 		delFiles = append(delFiles, files...)
 		if err != nil {
@@ -206,6 +438,20 @@ func (d *Downloader) ResumeDownload(ctx context.Context, snapshot file.DownloadS
 	return retSnapshot, nil
 }
 
+// 临时目录或保存路径在两次下载之间被移动后，重新定位快照，返回的快照可直接用于ResumeDownload
+func (d *Downloader) RelocateSnapshot(snapshot file.DownloadSnapshot, tempDir string) (file.DownloadSnapshot, error) {
+	retSnapshot := snapshot
+	retSnapshot.DoneParts = make([]file.DownloadPartSnapshot, len(snapshot.DoneParts))
+	copy(retSnapshot.DoneParts, snapshot.DoneParts)
+
+	if err := retSnapshot.Relocate(tempDir, d.LocalFilePath); err != nil {
+		log.Printf("relocateSnapshot snapshot.Relocate failed err: %v tempDir: %s", err, tempDir)
+		return snapshot, err
+	}
+
+	return retSnapshot, nil
+}
+
 // 删除临时文件
 func (d *Downloader) RemovePartFiles(files []string) {
 	var wg sync.WaitGroup
@@ -214,9 +460,7 @@ func (d *Downloader) RemovePartFiles(files []string) {
 			wg.Add(1)
 			go func(filePath string) {
 				defer wg.Done()
-				if err := os.Remove(filePath); err != nil {
-					log.Println(filePath, "remove failed, err:", err)
-				}
+				file.RemoveTempFile(filePath)
 			}(f)
 		}
 	}
@@ -0,0 +1,158 @@
+package file_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jsyzchen/pan/file"
+	fileUtil "github.com/jsyzchen/pan/utils/file"
+	"github.com/jsyzchen/pan/utils/httpclient"
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+// 记录下载请求的Range
+type rangeRecorder struct {
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (r *rangeRecorder) record(req *http.Request) bool {
+	if strings.HasPrefix(req.URL.Path, "/file/") && req.Header.Get("Range") != "" {
+		r.mu.Lock()
+		r.ranges = append(r.ranges, req.Header.Get("Range"))
+		r.mu.Unlock()
+	}
+	return false
+}
+
+func (r *rangeRecorder) has(rng string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range r.ranges {
+		if v == rng {
+			return true
+		}
+	}
+	return false
+}
+
+// 准备模拟服务上的文件和临时目录，返回文件内容、fs_id和md5
+func resumeFixture(t *testing.T, mock *mockpan.Server, size int) ([]byte, uint64, string, string) {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(3)).Read(data)
+	fsID := mock.PutFile("/apps/test/resume.bin", data)
+	metas, err := file.NewFileClient("test").Metas([]uint64{fsID})
+	if err != nil || len(metas.List) != 1 {
+		t.Fatalf("Metas failed: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "panresume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, fsID, metas.List[0].Md5, dir
+}
+
+// 旧版本按3000字节分片生成的快照，在当前版本(超级会员分片50M)续传时沿用快照中的分片范围，已下载的分片不再请求
+func TestResumeDownloadChangedPartSize(t *testing.T) {
+	mock := mockpan.NewServer()
+	mock.VipType = 2
+	recorder := &rangeRecorder{}
+	httpclient.SetTransport(&failingTransport{server: mock, fail: recorder.record})
+	defer httpclient.SetTransport(nil)
+	data, fsID, md5, dir := resumeFixture(t, mock, 10000)
+	defer os.RemoveAll(dir)
+
+	donePath := filepath.Join(dir, "resume.bin.part1")
+	if err := ioutil.WriteFile(donePath, data[3000:6000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := fileUtil.DownloadSnapshot{
+		FsID:        fsID,
+		FileMd5:     md5,
+		Recoverable: true,
+		VipType:     2,
+		DoneSize:    3000,
+		TotalSize:   10000,
+		PartSize:    3000,
+		TotalPart:   4,
+		DoneParts: []fileUtil.DownloadPartSnapshot{
+			{From: 0, To: 2999},
+			{From: 3000, To: 5999, FilePath: donePath},
+			{From: 6000, To: 8999},
+			{From: 9000, To: 9999},
+		},
+	}
+	savePath := filepath.Join(dir, "resume.bin")
+	downloader := file.NewDownloaderWithFsID("test", fsID, savePath)
+	ret, err := downloader.ResumeDownload(context.Background(), snapshot, dir, nil)
+	if err != nil {
+		t.Fatalf("ResumeDownload failed: %v", err)
+	}
+	got, err := ioutil.ReadFile(savePath)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("downloaded content mismatch, err: %v", err)
+	}
+	if ret.TotalPart != 4 || ret.PartSize != 3000 || ret.DoneSize != 10000 {
+		t.Fatalf("snapshot parts changed: totalPart %d partSize %d doneSize %d", ret.TotalPart, ret.PartSize, ret.DoneSize)
+	}
+	for _, rng := range []string{"bytes=0-2999", "bytes=6000-8999", "bytes=9000-9999"} {
+		if !recorder.has(rng) {
+			t.Errorf("range %s not requested, got %v", rng, recorder.ranges)
+		}
+	}
+	if recorder.has("bytes=3000-5999") {
+		t.Errorf("done part downloaded again")
+	}
+}
+
+// 快照分片不连续时不按快照续传，重新下载整个文件
+func TestResumeDownloadInvalidParts(t *testing.T) {
+	mock := mockpan.NewServer()
+	mock.VipType = 2
+	recorder := &rangeRecorder{}
+	httpclient.SetTransport(&failingTransport{server: mock, fail: recorder.record})
+	defer httpclient.SetTransport(nil)
+	data, fsID, md5, dir := resumeFixture(t, mock, 10000)
+	defer os.RemoveAll(dir)
+
+	snapshot := fileUtil.DownloadSnapshot{
+		FsID:      fsID,
+		FileMd5:   md5,
+		VipType:   2,
+		TotalSize: 10000,
+		PartSize:  3000,
+		TotalPart: 3,
+		DoneParts: []fileUtil.DownloadPartSnapshot{
+			{From: 0, To: 2999},
+			{From: 4000, To: 6999}, //缺少3000-3999
+			{From: 7000, To: 9999},
+		},
+	}
+	if err := snapshot.ValidateParts(); err == nil {
+		t.Fatal("ValidateParts accepted gapped parts")
+	}
+	savePath := filepath.Join(dir, "resume.bin")
+	downloader := file.NewDownloaderWithFsID("test", fsID, savePath)
+	ret, err := downloader.ResumeDownload(context.Background(), snapshot, dir, nil)
+	if err != nil {
+		t.Fatalf("ResumeDownload failed: %v", err)
+	}
+	got, err := ioutil.ReadFile(savePath)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("downloaded content mismatch, err: %v", err)
+	}
+	if err := ret.ValidateParts(); err != nil {
+		t.Fatalf("returned snapshot invalid: %v", err)
+	}
+	if recorder.has("bytes=4000-6999") {
+		t.Errorf("gapped snapshot range used: %v", recorder.ranges)
+	}
+}
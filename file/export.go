@@ -0,0 +1,162 @@
+package file
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+)
+
+const (
+	ExportFormatCSV   = "csv"
+	ExportFormatJSONL = "jsonl"
+)
+
+// 导出的列
+const (
+	ColumnPath        = "path"
+	ColumnFsID        = "fs_id"
+	ColumnName        = "name"
+	ColumnSize        = "size"
+	ColumnIsDir       = "is_dir"
+	ColumnCategory    = "category"
+	ColumnMd5         = "md5"
+	ColumnServerCtime = "server_ctime"
+	ColumnServerMtime = "server_mtime"
+	ColumnLocalCtime  = "local_ctime"
+	ColumnLocalMtime  = "local_mtime"
+)
+
+// 默认导出的列
+var DefaultExportColumns = []string{ColumnPath, ColumnFsID, ColumnSize, ColumnMd5, ColumnServerMtime}
+
+// Exporter 递归导出目录下的文件信息为CSV或JSON Lines，按页获取文件列表边获取边写入
+type Exporter struct {
+	AccessToken string
+	Format      string
+	Columns     []string
+	IncludeDirs bool // 是否导出目录
+}
+
+func NewExporter(accessToken, format string) *Exporter {
+	return &Exporter{
+		AccessToken: accessToken,
+		Format:      format,
+		Columns:     DefaultExportColumns,
+	}
+}
+
+func (e *Exporter) SetColumns(columns []string) {
+	e.Columns = columns
+}
+
+func (e *Exporter) SetIncludeDirs(includeDirs bool) {
+	e.IncludeDirs = includeDirs
+}
+
+// 导出dir目录下的全部文件，返回导出的条数
+// CSV格式第一行为列名，JSON Lines格式每行一个对象，数值列输出为数字
+func (e *Exporter) Export(dir string, w io.Writer) (int, error) {
+	for _, column := range e.Columns {
+		if _, err := exportColumn(FsItem{}, column); err != nil {
+			return 0, err
+		}
+	}
+
+	var writeItem func(FsItem) error
+	var flush func() error
+	switch e.Format {
+	case ExportFormatCSV:
+		csvWriter := csv.NewWriter(w)
+		if err := csvWriter.Write(e.Columns); err != nil {
+			return 0, err
+		}
+		writeItem = func(item FsItem) error {
+			record := make([]string, len(e.Columns))
+			for i, column := range e.Columns {
+				v, _ := exportColumn(item, column)
+				record[i] = fmt.Sprint(v)
+			}
+			return csvWriter.Write(record)
+		}
+		flush = func() error {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+	case ExportFormatJSONL:
+		bufWriter := bufio.NewWriter(w)
+		writeItem = func(item FsItem) error {
+			// 使用有序的键值拼接，保证输出的列顺序与Columns一致
+			bufWriter.WriteByte('{')
+			for i, column := range e.Columns {
+				v, _ := exportColumn(item, column)
+				key, _ := json.Marshal(column)
+				value, err := json.Marshal(v)
+				if err != nil {
+					return err
+				}
+				if i > 0 {
+					bufWriter.WriteByte(',')
+				}
+				bufWriter.Write(key)
+				bufWriter.WriteByte(':')
+				bufWriter.Write(value)
+			}
+			_, err := bufWriter.WriteString("}\n")
+			return err
+		}
+		flush = bufWriter.Flush
+	default:
+		return 0, errors.New(fmt.Sprintf("unsupported export format: %s", e.Format))
+	}
+
+	count := 0
+	it := NewFileClient(e.AccessToken).ListAll(dir)
+	for it.Next() {
+		item := it.Item()
+		if item.IsDir == 1 && !e.IncludeDirs {
+			continue
+		}
+		if err := writeItem(item); err != nil {
+			log.Printf("export write failed dir: %s err: %v", dir, err)
+			return count, err
+		}
+		count++
+	}
+	if err := flush(); err != nil {
+		return count, err
+	}
+	return count, it.Err()
+}
+
+func exportColumn(item FsItem, column string) (interface{}, error) {
+	switch column {
+	case ColumnPath:
+		return item.Path, nil
+	case ColumnFsID:
+		return strconv.FormatUint(item.FsID, 10), nil // fs_id超出JSON数值精度，输出为字符串
+	case ColumnName:
+		return item.ServerFileName, nil
+	case ColumnSize:
+		return item.Size, nil
+	case ColumnIsDir:
+		return item.IsDir, nil
+	case ColumnCategory:
+		return int(item.Category), nil
+	case ColumnMd5:
+		return item.Md5, nil
+	case ColumnServerCtime:
+		return item.ServerCtime, nil
+	case ColumnServerMtime:
+		return item.ServerMtime, nil
+	case ColumnLocalCtime:
+		return item.LocalCtime, nil
+	case ColumnLocalMtime:
+		return item.LocalMtime, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unsupported export column: %s", column))
+}
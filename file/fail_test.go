@@ -0,0 +1,101 @@
+package file_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jsyzchen/pan/file"
+	"github.com/jsyzchen/pan/utils/httpclient"
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+// 指定的请求失败，其余请求转发给模拟服务
+type failingTransport struct {
+	server *mockpan.Server
+	fail   func(req *http.Request) bool
+}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.fail(req) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewReader([]byte("forbidden"))), Request: req}, nil
+	}
+	return t.server.RoundTrip(req)
+}
+
+// 一个分片失败时其余分片停止，返回失败分片的错误，多次运行以便-race发现共享状态的竞争
+func TestUploadSliceFailure(t *testing.T) {
+	mock := mockpan.NewServer()
+	httpclient.SetTransport(&failingTransport{server: mock, fail: func(req *http.Request) bool {
+		query := req.URL.Query()
+		return query.Get("method") == "upload" && query.Get("partseq") == "2"
+	}})
+	defer httpclient.SetTransport(nil)
+
+	dir, err := ioutil.TempDir("", "pantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "a.bin")
+	if err := ioutil.WriteFile(localPath, bytes.Repeat([]byte("0123456789abcdef"), 1<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		uploader := file.NewUploader("token", "/apps/test/a.bin", localPath)
+		uploader.SliceSize = 4 << 20
+		uploader.SetConcurrency(4)
+		uploader.SetRetryPolicy(file.RetryPolicy{MaxAttempts: 1})
+		_, _, err := uploader.Upload(context.Background(), nil)
+		if err == nil {
+			t.Fatal("Upload succeeded with a failing slice")
+		}
+		if errors.Is(err, context.Canceled) {
+			t.Fatalf("Upload returned the cancellation instead of the slice error: %v", err)
+		}
+		if mock.Exists("/apps/test/a.bin") {
+			t.Fatal("file created although a slice failed")
+		}
+	}
+}
+
+func TestDownloadPartFailure(t *testing.T) {
+	mock := mockpan.NewServer()
+	mock.VipType = 2
+	fsID := mock.PutFile("/apps/test/a.bin", bytes.Repeat([]byte("0123456789abcdef"), 4<<20))
+	httpclient.SetTransport(&failingTransport{server: mock, fail: func(req *http.Request) bool {
+		rangeHeader := req.Header.Get("Range")
+		return strings.HasPrefix(req.URL.Path, "/file/") && rangeHeader != "" && !strings.HasPrefix(rangeHeader, "bytes=0-")
+	}})
+	defer httpclient.SetTransport(nil)
+
+	dir, err := ioutil.TempDir("", "pantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 5; i++ {
+		savePath := filepath.Join(dir, "a.bin")
+		_, err := file.NewDownloaderWithFsID("token", fsID, savePath).Download(context.Background(), dir, nil)
+		if err == nil {
+			t.Fatal("Download succeeded with a failing part")
+		}
+		if errors.Is(err, context.Canceled) {
+			t.Fatalf("Download returned the cancellation instead of the part error: %v", err)
+		}
+		if _, statErr := os.Stat(savePath); statErr == nil {
+			t.Fatal("file saved although a part failed")
+		}
+	}
+}
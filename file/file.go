@@ -1,40 +1,55 @@
 package file
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/url"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/jsyzchen/pan/conf"
+	"github.com/jsyzchen/pan/errno"
+	"github.com/jsyzchen/pan/utils/audit"
+	fileUtil "github.com/jsyzchen/pan/utils/file"
 	"github.com/jsyzchen/pan/utils/httpclient"
 )
 
 const (
 	ListUri          = "/rest/2.0/xpan/file?method=list"
 	ListRecursiveUri = "/rest/2.0/xpan/multimedia?method=listall"
+	CategoryListUri  = "/rest/2.0/xpan/multimedia?method=categorylist"
 	SearchUri        = "/rest/2.0/xpan/file?method=search"
 	MetasUri         = "/rest/2.0/xpan/multimedia?method=filemetas"
 	StreamingUri     = "/rest/2.0/xpan/file?method=streaming"
 	ManagerUri       = "/rest/2.0/xpan/file?method=filemanager"
 )
 
+const (
+	MetasMaxFsIDs    = 100  // 单次获取文件信息的fs_id数量上限
+	metasConcurrency = 4    // 分批获取文件信息时的并发请求数
+	listPageSize     = 1000 // 分页列出目录时每页的文件数
+)
+
 type FsItem struct {
-	FsID           uint64            `json:"fs_id"`
-	Path           string            `json:"path"`
-	ServerFileName string            `json:"server_filename"`
-	Size           uint64            `json:"size"`
-	IsDir          int               `json:"isdir"`
-	Category       int               `json:"category"`
-	Md5            string            `json:"md5"`
-	DirEmpty       int               `json:"dir_empty"`
-	Thumbs         map[string]string `json:"thumbs"`
-	LocalCtime     int64             `json:"local_ctime"`
-	LocalMtime     int64             `json:"local_mtime"`
-	ServerCtime    int64             `json:"server_ctime"`
-	ServerMtime    int64             `json:"server_mtime"`
+	FsID           uint64   `json:"fs_id"`
+	Path           string   `json:"path"`
+	ServerFileName string   `json:"server_filename"`
+	Size           uint64   `json:"size"`
+	IsDir          int      `json:"isdir"`
+	Category       Category `json:"category"`
+	Md5            string   `json:"md5"`
+	DirEmpty       int      `json:"dir_empty"`
+	Thumbs         Thumbs   `json:"thumbs"`
+	LocalCtime     int64    `json:"local_ctime"`
+	LocalMtime     int64    `json:"local_mtime"`
+	ServerCtime    int64    `json:"server_ctime"`
+	ServerMtime    int64    `json:"server_mtime"`
 }
 
 type ListResponse struct {
@@ -56,27 +71,32 @@ type SearchResponse struct {
 	List    []FsItem
 }
 
+type MetasItem struct {
+	FsID        uint64   `json:"fs_id"`
+	Path        string   `json:"path"`
+	Category    Category `json:"category"`
+	FileName    string   `json:"filename"`
+	IsDir       int      `json:"isdir"`
+	Size        int64    `json:"size"`
+	Md5         string   `json:"md5"`
+	DLink       string   `json:"dlink"`
+	Thumbs      Thumbs   `json:"thumbs"`
+	ServerCtime int64    `json:"server_ctime"`
+	ServerMtime int64    `json:"server_mtime"`
+	DateTaken   int      `json:"date_taken"`
+	Width       int      `json:"width"`
+	Height      int      `json:"height"`
+	Duration    MediaInt `json:"duration"`    // 音视频时长，单位秒
+	Orientation MediaInt `json:"orientation"` // 图片的EXIF方向
+	Resolution  string   `json:"resolution"`  // 视频分辨率，格式如"width:1920,height:1080"
+}
+
 type MetasResponse struct {
 	ErrorCode    int    `json:"errno"`
 	ErrorMsg     string `json:"errmsg"`
 	RequestID    int
 	RequestIDStr string `json:"request_id"`
-	List         []struct {
-		FsID        uint64            `json:"fs_id"`
-		Path        string            `json:"path"`
-		Category    int               `json:"category"`
-		FileName    string            `json:"filename"`
-		IsDir       int               `json:"isdir"`
-		Size        int64             `json:"size"`
-		Md5         string            `json:"md5"`
-		DLink       string            `json:"dlink"`
-		Thumbs      map[string]string `json:"thumbs"`
-		ServerCtime int64             `json:"server_ctime"`
-		ServerMtime int64             `json:"server_mtime"`
-		DateTaken   int               `json:"date_taken"`
-		Width       int               `json:"width"`
-		Height      int               `json:"height"`
-	}
+	List         []MetasItem
 }
 
 type ManagerResponse struct {
@@ -89,15 +109,16 @@ type ManagerResponse struct {
 }
 
 type CreateDirResponse struct {
-	ErrorNo  int    `json:"errno"`
-	FsId     uint64 `json:"fs_id"`
-	Path     string `json:"path"`
-	Category int    `json:"category"`
-	IsDir    int    `json:"isdir"`
+	ErrorNo  int      `json:"errno"`
+	FsId     uint64   `json:"fs_id"`
+	Path     string   `json:"path"`
+	Category Category `json:"category"`
+	IsDir    int      `json:"isdir"`
 }
 
 type File struct {
 	AccessToken string
+	Audit       *audit.Writer // 审计日志，记录本客户端的接口请求，为nil时不记录
 }
 
 func NewFileClient(accessToken string) *File {
@@ -106,6 +127,16 @@ func NewFileClient(accessToken string) *File {
 	}
 }
 
+// 设置审计日志，只记录本客户端发起的接口请求
+func (f *File) SetAudit(auditWriter *audit.Writer) {
+	f.Audit = auditWriter
+}
+
+// 发起接口请求使用的ctx，设置了审计日志时请求记录到f.Audit
+func (f *File) context() context.Context {
+	return f.Audit.Context(context.Background())
+}
+
 // 获取文件列表
 func (f *File) List(dir string, start, limit int) (ListResponse, error) {
 	ret := ListResponse{}
@@ -118,7 +149,7 @@ func (f *File) List(dir string, start, limit int) (ListResponse, error) {
 	query := v.Encode()
 
 	requestUrl := conf.OpenApiDomain + ListUri + "&" + query
-	resp, err := httpclient.Get(nil, requestUrl, map[string]string{})
+	resp, err := httpclient.Get(f.context(), requestUrl, map[string]string{})
 	if err != nil {
 		log.Println("httpclient.Get failed, err:", err)
 		return ret, err
@@ -141,39 +172,16 @@ func (f *File) List(dir string, start, limit int) (ListResponse, error) {
 
 // 递归获取文件列表
 func (f *File) ListRecursive(dir string) ([]FsItem, error) {
+	return f.ListRecursiveByCategory(dir, nil)
+}
+
+// 递归获取指定分类的文件列表，categories为文件分类，如CategoryVideo、CategoryDoc，为空时不过滤
+func (f *File) ListRecursiveByCategory(dir string, categories []Category) ([]FsItem, error) {
 	items := []FsItem{}
-	listPageFunc := func(start int) (ListRecursiveResponse, error) {
-		ret := ListRecursiveResponse{}
-		v := url.Values{}
-		v.Add("access_token", f.AccessToken)
-		v.Add("path", dir)
-		v.Add("order", "name")
-		v.Add("start", strconv.Itoa(start))
-		v.Add("recursion", "1")
-		query := v.Encode()
-		requestUrl := conf.OpenApiDomain + ListRecursiveUri + "&" + query
-		resp, err := httpclient.Get(nil, requestUrl, map[string]string{})
-		if err != nil {
-			log.Printf("listPageFunc httpclient.Get failed start: %d err: %v", start, err)
-			return ret, err
-		}
-		if resp.StatusCode != 200 {
-			errStr := fmt.Sprintf("listPageFunc http code error start: %d code: %d", start, resp.StatusCode)
-			log.Println(errStr)
-			return ret, errors.New(errStr)
-		}
-		if err := json.Unmarshal(resp.Body, &ret); err != nil {
-			return ret, err
-		}
-		if ret.ErrorCode != 0 { //错误码不为0
-			return ret, errors.New(fmt.Sprintf("listPageFunc error_code: %d, error_msg: %s", ret.ErrorCode, ret.ErrorMsg))
-		}
-		return ret, nil
-	}
 
 	start := 0
 	for {
-		pageRet, err := listPageFunc(start)
+		pageRet, err := f.listRecursivePage(dir, start, 0, categories)
 		if err != nil {
 			return items, err
 		}
@@ -188,6 +196,57 @@ func (f *File) ListRecursive(dir string) ([]FsItem, error) {
 	return items, nil
 }
 
+// 递归获取一页文件列表，start为上一页返回的Cursor，首页为0，limit为0时使用接口默认值
+// HasMore为1时还有下一页，调用方可保存Cursor用于增量索引和断点续取
+func (f *File) ListRecursivePage(dir string, start, limit int) (ListRecursiveResponse, error) {
+	return f.listRecursivePage(dir, start, limit, nil)
+}
+
+// 递归获取一页文件列表，指定分类时使用categorylist接口由服务端过滤
+func (f *File) listRecursivePage(dir string, start, limit int, categories []Category) (ListRecursiveResponse, error) {
+	ret := ListRecursiveResponse{}
+	v := url.Values{}
+	v.Add("access_token", f.AccessToken)
+	v.Add("order", "name")
+	v.Add("start", strconv.Itoa(start))
+	if limit > 0 {
+		v.Add("limit", strconv.Itoa(limit))
+	}
+	v.Add("recursion", "1")
+	uri := ListRecursiveUri
+	if len(categories) > 0 {
+		categoryStrs := make([]string, len(categories))
+		for i, category := range categories {
+			categoryStrs[i] = strconv.Itoa(int(category))
+		}
+		v.Add("parent_path", dir)
+		v.Add("category", strings.Join(categoryStrs, ","))
+		v.Add("show_dir", "0")
+		uri = CategoryListUri
+	} else {
+		v.Add("path", dir)
+	}
+	query := v.Encode()
+	requestUrl := conf.OpenApiDomain + uri + "&" + query
+	resp, err := httpclient.Get(f.context(), requestUrl, map[string]string{})
+	if err != nil {
+		log.Printf("listPageFunc httpclient.Get failed start: %d err: %v", start, err)
+		return ret, err
+	}
+	if resp.StatusCode != 200 {
+		errStr := fmt.Sprintf("listPageFunc http code error start: %d code: %d", start, resp.StatusCode)
+		log.Println(errStr)
+		return ret, errors.New(errStr)
+	}
+	if err := json.Unmarshal(resp.Body, &ret); err != nil {
+		return ret, err
+	}
+	if ret.ErrorCode != 0 { //错误码不为0
+		return ret, errors.New(fmt.Sprintf("listPageFunc error_code: %d, error_msg: %s", ret.ErrorCode, ret.ErrorMsg))
+	}
+	return ret, nil
+}
+
 // 搜索文件
 func (f *File) Search(keyword, dir string, page int) (SearchResponse, error) {
 	ret := SearchResponse{}
@@ -201,7 +260,7 @@ func (f *File) Search(keyword, dir string, page int) (SearchResponse, error) {
 	query := v.Encode()
 
 	requestUrl := conf.OpenApiDomain + SearchUri + "&" + query
-	resp, err := httpclient.Get(nil, requestUrl, map[string]string{})
+	resp, err := httpclient.Get(f.context(), requestUrl, map[string]string{})
 	if err != nil {
 		log.Println("httpclient.Get failed, err:", err)
 		return ret, err
@@ -222,8 +281,112 @@ func (f *File) Search(keyword, dir string, page int) (SearchResponse, error) {
 	return ret, nil
 }
 
+// 搜索文件，获取全部分页的结果，按FsID去重
+func (f *File) SearchAll(keyword, dir string) ([]FsItem, error) {
+	items := []FsItem{}
+	err := f.SearchEach(keyword, dir, func(item FsItem) error {
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// 搜索文件，逐页获取结果并按FsID去重后依次交给fn处理，fn返回错误时停止搜索并返回该错误
+func (f *File) SearchEach(keyword, dir string, fn func(FsItem) error) error {
+	seen := make(map[uint64]bool)
+	for page := 1; ; page++ {
+		pageRet, err := f.Search(keyword, dir, page)
+		if err != nil {
+			log.Printf("searchEach failed keyword: %s dir: %s page: %d err: %v", keyword, dir, page, err)
+			return err
+		}
+		newCount := 0
+		for _, item := range pageRet.List {
+			if seen[item.FsID] {
+				continue
+			}
+			seen[item.FsID] = true
+			newCount++
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		// 接口翻页时可能返回重复结果，没有新结果时停止，避免死循环
+		if pageRet.HasMore != 1 || newCount == 0 {
+			break
+		}
+	}
+	return nil
+}
+
 // 通过FsID获取文件信息
+// 超过MetasMaxFsIDs个fs_id时自动分批并发请求，合并后的结果按fsIDs的顺序排列
 func (f *File) Metas(fsIDs []uint64) (MetasResponse, error) {
+	if len(fsIDs) <= MetasMaxFsIDs {
+		ret, err := f.metas(fsIDs)
+		if err == nil {
+			sortMetasItems(ret.List, fsIDs)
+		}
+		return ret, err
+	}
+
+	batchCount := (len(fsIDs) + MetasMaxFsIDs - 1) / MetasMaxFsIDs
+	rets := make([]MetasResponse, batchCount)
+	errs := make([]error, batchCount)
+	sem := make(chan struct{}, metasConcurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < batchCount; i++ {
+		start := i * MetasMaxFsIDs
+		end := start + MetasMaxFsIDs
+		if end > len(fsIDs) {
+			end = len(fsIDs)
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, batch []uint64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			rets[i], errs[i] = f.metas(batch)
+		}(i, fsIDs[start:end])
+	}
+	wg.Wait()
+
+	ret := MetasResponse{}
+	for i := range rets {
+		if errs[i] != nil {
+			log.Printf("File.Metas batch %d/%d failed, err: %v", i+1, batchCount, errs[i])
+			ret.ErrorCode = rets[i].ErrorCode
+			ret.ErrorMsg = rets[i].ErrorMsg
+			ret.RequestID = rets[i].RequestID
+			ret.RequestIDStr = rets[i].RequestIDStr
+			return ret, errs[i]
+		}
+		if i == 0 {
+			ret.RequestID = rets[i].RequestID
+			ret.RequestIDStr = rets[i].RequestIDStr
+		}
+		ret.List = append(ret.List, rets[i].List...)
+	}
+	sortMetasItems(ret.List, fsIDs)
+	return ret, nil
+}
+
+// 按fs_id在fsIDs中首次出现的位置排序
+func sortMetasItems(items []MetasItem, fsIDs []uint64) {
+	index := make(map[uint64]int, len(fsIDs))
+	for i, fsID := range fsIDs {
+		if _, ok := index[fsID]; !ok {
+			index[fsID] = i
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return index[items[i].FsID] < index[items[j].FsID]
+	})
+}
+
+func (f *File) metas(fsIDs []uint64) (MetasResponse, error) {
 	ret := MetasResponse{}
 
 	fsIDsByte, err := json.Marshal(fsIDs)
@@ -240,7 +403,7 @@ func (f *File) Metas(fsIDs []uint64) (MetasResponse, error) {
 	query := v.Encode()
 
 	requestUrl := conf.OpenApiDomain + MetasUri + "&" + query
-	resp, err := httpclient.Get(nil, requestUrl, map[string]string{})
+	resp, err := httpclient.Get(f.context(), requestUrl, map[string]string{})
 	if err != nil {
 		log.Println("httpclient.Get failed, err:", err)
 		return ret, err
@@ -274,7 +437,7 @@ func (f *File) Streaming(path string, transcodingType string) (string, error) {
 	query := v.Encode()
 
 	requestUrl := conf.OpenApiDomain + StreamingUri + "&" + query
-	resp, err := httpclient.Get(nil, requestUrl, map[string]string{})
+	resp, err := httpclient.Get(f.context(), requestUrl, map[string]string{})
 	if err != nil {
 		log.Println("httpclient.Get failed, err:", err)
 		return ret, err
@@ -287,8 +450,47 @@ func (f *File) Streaming(path string, transcodingType string) (string, error) {
 	return string(resp.Body), nil
 }
 
+// 重命名文件或目录，newName为新文件名，不能包含路径，返回重命名后的路径
+// 新文件名已存在时返回错误，不会自动重命名
+func (f *File) Rename(filePath, newName string) (string, error) {
+	if err := fileUtil.ValidateRemoteName(newName); err != nil {
+		log.Printf("File.Rename invalid newName: %s err: %v", newName, err)
+		return "", err
+	}
+	filePath = strings.TrimRight(filePath, "/")
+	if !strings.HasPrefix(filePath, "/") { //已有文件的名字不一定满足新文件名的规则，只要求是绝对路径
+		log.Printf("File.Rename invalid path: %s", filePath)
+		return "", errors.New(fmt.Sprintf("File.Rename path is not absolute, path: %s", filePath))
+	}
+	newPath := path.Join(path.Dir(filePath), newName)
+	if newPath == filePath {
+		return newPath, nil
+	}
+
+	tasks, err := json.Marshal([]map[string]string{{"path": filePath, "newname": newName}})
+	if err != nil {
+		return "", err
+	}
+	ret, err := f.manage("rename", string(tasks), "0", "fail")
+	if err != nil {
+		log.Printf("File.Rename failed path: %s newName: %s err: %v", filePath, newName, err)
+		return "", err
+	}
+	for _, info := range ret.Info {
+		if info.Errno != 0 {
+			return "", errors.New(fmt.Sprintf("File.Rename failed path: %s, errno: %d", info.Path, info.Errno))
+		}
+	}
+	return newPath, nil
+}
+
 // 文件管理
 func (f *File) Manage(opera, tasks string) (ManagerResponse, error) {
+	return f.manage(opera, tasks, "1", "newcopy")
+}
+
+// async为0时同步执行，ondup为路径冲突时的处理方式：fail、newcopy、overwrite、skip
+func (f *File) manage(opera, tasks, async, ondup string) (ManagerResponse, error) {
 	ret := ManagerResponse{}
 
 	v := url.Values{}
@@ -298,10 +500,10 @@ func (f *File) Manage(opera, tasks string) (ManagerResponse, error) {
 
 	requestUrl := conf.OpenApiDomain + ManagerUri + "&" + query
 	body := url.Values{}
-	body.Add("async", "1")
+	body.Add("async", async)
 	body.Add("filelist", tasks)
-	body.Add("ondup", "newcopy")
-	resp, err := httpclient.Post(nil, requestUrl, map[string]string{}, body.Encode())
+	body.Add("ondup", ondup)
+	resp, err := httpclient.Post(f.context(), requestUrl, map[string]string{}, body.Encode())
 	if err != nil {
 		log.Println("httpclient.Get failed, err:", err)
 		return ret, err
@@ -324,6 +526,20 @@ func (f *File) Manage(opera, tasks string) (ManagerResponse, error) {
 
 // 新建文件夹
 func (f *File) CreateDir(path string) (CreateDirResponse, error) {
+	return f.createDir(path, "")
+}
+
+// 新建文件夹及不存在的上级目录，文件夹已存在时不报错
+func (f *File) MkdirAll(path string) error {
+	ret, err := f.createDir(path, "0") // rtype为0时路径冲突直接返回错误，不会重命名
+	if err != nil && ret.ErrorNo != int(errno.CodeFileExists) {
+		log.Printf("File.MkdirAll failed path: %s err: %v", path, err)
+		return err
+	}
+	return nil
+}
+
+func (f *File) createDir(path, rtype string) (CreateDirResponse, error) {
 	ret := CreateDirResponse{}
 
 	v := url.Values{}
@@ -335,7 +551,10 @@ func (f *File) CreateDir(path string) (CreateDirResponse, error) {
 	body.Add("path", path)
 	body.Add("isdir", "1")
 	body.Add("mode", "1")
-	resp, err := httpclient.Post(nil, requestUrl, map[string]string{}, body.Encode())
+	if rtype != "" {
+		body.Add("rtype", rtype)
+	}
+	resp, err := httpclient.Post(f.context(), requestUrl, map[string]string{}, body.Encode())
 	if err != nil {
 		log.Println("File.CreateDir httpclient.Get failed, err:", err)
 		return ret, err
@@ -0,0 +1,53 @@
+//go:build go1.18
+// +build go1.18
+
+// testing.F需要Go 1.18及以上，go.mod声明的是1.13，低版本编译时跳过本文件
+
+package file
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// 服务端返回任意内容时预创建结果的解析不能panic
+func FuzzDecodePreCreateResponse(f *testing.F) {
+	f.Add([]byte(`{"return_type":2,"errno":0,"info":{"size":16877488,"fs_id":714504460793248,"request_id":1.821160071156e+17,"path":"\/apps\/a.pptx","isdir":0,"md5":"44090321ds594263c8818d7c398e5017"},"request_id":182116007115598010}`))
+	f.Add([]byte(`{"return_type":1,"errno":0,"uploadid":"N1-MTAuMTQ1","block_list":[0,1],"request_id":9223372036854775807}`))
+	f.Add([]byte(`{"info":{"request_id":"abc"}}`))
+	f.Add([]byte(`{"info":[1,2,3]}`))
+	f.Add([]byte(`{"info":{"request_id":-1e400}}`))
+	f.Add([]byte(`null`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decodePreCreateResponse(data)
+	})
+}
+
+// 媒体信息的整数字段可能是数字或字符串
+func FuzzMediaInt(f *testing.F) {
+	for _, seed := range []string{`1080`, `"1080"`, `""`, `null`, `1.5e3`, `"abc"`, `-9223372036854775808`, `1e400`} {
+		f.Add([]byte(`{"width":` + seed + `}`))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v struct {
+			Width MediaInt `json:"width"`
+		}
+		json.Unmarshal(data, &v)
+	})
+}
+
+// 处理后的文件名不再包含特殊字符
+func FuzzHandleSpecialChar(f *testing.F) {
+	for _, seed := range []string{"/apps/a.txt", `/apps/a\\b?c|d"e>f<g:h*i`, "a\tb\nc\rd", `a\0b\x0Bc`, `\\\\0`, "中文/名字.txt"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		handled := handleSpecialChar(name)
+		for _, c := range []string{"?", "|", "\"", ">", "<", ":", "*", "\t", "\n", "\r"} {
+			if strings.Contains(handled, c) {
+				t.Fatalf("handleSpecialChar(%q) = %q still contains %q", name, handled, c)
+			}
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package file
+
+import (
+	"errors"
+	"log"
+)
+
+// ListIterator 递归文件列表迭代器，按页获取文件列表，缩略图、下载地址等信息只在调用Metas时获取
+type ListIterator struct {
+	file    *File
+	dir     string
+	cursor  int
+	hasMore bool
+	items   []FsItem
+	index   int
+	err     error
+}
+
+// 获取递归文件列表迭代器
+func (f *File) ListAll(dir string) *ListIterator {
+	return &ListIterator{
+		file:    f,
+		dir:     dir,
+		hasMore: true,
+		index:   -1,
+	}
+}
+
+// 移动到下一个文件，没有更多文件或出错时返回false，出错原因通过Err获取
+func (it *ListIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.index++
+	for it.index >= len(it.items) {
+		if !it.hasMore {
+			return false
+		}
+		pageRet, err := it.file.listRecursivePage(it.dir, it.cursor, 0, nil)
+		if err != nil {
+			log.Printf("listIterator listRecursivePage failed dir: %s cursor: %d err: %v", it.dir, it.cursor, err)
+			it.err = err
+			return false
+		}
+		it.items = pageRet.List
+		it.index = 0
+		it.hasMore = pageRet.HasMore == 1
+		it.cursor = pageRet.Cursor
+	}
+	return true
+}
+
+// 当前文件
+func (it *ListIterator) Item() FsItem {
+	if it.index < 0 || it.index >= len(it.items) {
+		return FsItem{}
+	}
+	return it.items[it.index]
+}
+
+// 迭代过程中的错误
+func (it *ListIterator) Err() error {
+	return it.err
+}
+
+// 获取当前文件的详细信息，包括缩略图和下载地址
+func (it *ListIterator) Metas() (MetasItem, error) {
+	item := it.Item()
+	if item.FsID == 0 {
+		return MetasItem{}, errors.New("listIterator no current item")
+	}
+	metas, err := it.file.Metas([]uint64{item.FsID})
+	if err != nil {
+		return MetasItem{}, err
+	}
+	if len(metas.List) == 0 {
+		return MetasItem{}, errors.New("listIterator file doesn't exist")
+	}
+	return metas.List[0], nil
+}
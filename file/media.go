@@ -0,0 +1,49 @@
+package file
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// 媒体信息中的整数字段，接口对不同类型的文件可能返回数字或字符串
+type MediaInt int64
+
+func (m *MediaInt) UnmarshalJSON(data []byte) error {
+	data = bytes.Trim(data, `"`)
+	if len(data) == 0 || string(data) == "null" {
+		*m = 0
+		return nil
+	}
+	v, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return err
+	}
+	*m = MediaInt(v)
+	return nil
+}
+
+// 获取视频的宽高，优先解析resolution字段，没有时使用width、height字段
+func (m MetasItem) VideoSize() (int, int) {
+	width, height := 0, 0
+	for _, part := range strings.Split(m.Resolution, ",") {
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "width":
+			width = v
+		case "height":
+			height = v
+		}
+	}
+	if width == 0 || height == 0 {
+		return m.Width, m.Height
+	}
+	return width, height
+}
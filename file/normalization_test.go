@@ -0,0 +1,64 @@
+package file_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsyzchen/pan/file"
+	"github.com/jsyzchen/pan/utils/mockpan"
+	"github.com/jsyzchen/pan/utils/norm"
+)
+
+const (
+	nfdName = "cafe\u0301"
+	nfcName = "caf\u00E9"
+)
+
+// NFD形式的文件名上传后保存为NFC形式
+func TestUploadNormalization(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+
+	dir, err := ioutil.TempDir("", "pantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, nfdName+".txt")
+	if err := ioutil.WriteFile(localPath, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uploader := file.NewUploader("token", "/apps/test/a.txt", localPath)
+	uploader.SetNormalization(norm.NFC)
+	uploader.Path = "/apps/test/" + nfdName + ".txt" //设置规范化后修改的路径同样生效
+	ret, _, err := uploader.Upload(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Path != "/apps/test/"+nfcName+".txt" || ret.RenamedTo != "" {
+		t.Fatalf("Upload returned path %+q renamedTo %+q", ret.Path, ret.RenamedTo)
+	}
+
+	dirUploader := file.NewDirUploader("token", dir, "/apps/sync")
+	dirUploader.SetNormalization(norm.NFC)
+	if _, err := dirUploader.Upload(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !mock.Exists("/apps/sync/"+nfcName+".txt") || mock.Exists("/apps/sync/"+nfdName+".txt") {
+		t.Fatal("DirUploader didn't normalize the remote path")
+	}
+}
+
+// 路径只有规范化形式不同时视为同一个文件
+func TestDiffDirsNormalization(t *testing.T) {
+	prev := []file.FsItem{{FsID: 1, Path: "/apps/" + nfdName, Md5: "m", Size: 1}}
+	curr := []file.FsItem{{FsID: 2, Path: "/apps/" + nfcName, Md5: "m", Size: 1}}
+	diff := file.DiffDirs(prev, curr)
+	if !diff.Empty() {
+		t.Fatalf("DiffDirs = %+v, want no changes", diff)
+	}
+}
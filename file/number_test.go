@@ -0,0 +1,79 @@
+package file
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/bitly/go-simplejson"
+	"github.com/jsyzchen/pan/utils"
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+func TestNumberToUint64(t *testing.T) {
+	cases := map[string]uint64{
+		`1234567890123456789`:     1234567890123456789, //19位，超过float64精度
+		`18446744073709551615`:    math.MaxUint64,
+		`1.821160071156e+17`:      182116007115600000,
+		`1.234567890123456789e18`: 1234567890123456789,
+		`1e400`:                   math.MaxUint64,
+		`-1`:                      0,
+		`"abc"`:                   0,
+		`null`:                    0,
+	}
+	for raw, want := range cases {
+		js, err := simplejson.NewJson([]byte(`{"v":` + raw + `}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := numberToUint64(js.Get("v")); got != want {
+			t.Errorf("numberToUint64(%s) = %d, want %d", raw, got, want)
+		}
+	}
+}
+
+func TestDecodePreCreateResponse19DigitIDs(t *testing.T) {
+	ret, err := decodePreCreateResponse([]byte(`{"return_type":2,"errno":0,"info":{"size":1,"fs_id":1234567890123456789,"request_id":1.234567890123456789e18,"path":"/apps/a","md5":"m"},"request_id":9876543210987654321}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Info.FsID != 1234567890123456789 || ret.Info.RequestID != 1234567890123456789 || ret.RequestID != 9876543210987654321 {
+		t.Fatalf("decoded ids fs_id: %d info.request_id: %d request_id: %d", ret.Info.FsID, ret.Info.RequestID, ret.RequestID)
+	}
+}
+
+// 模拟服务的fs_id为19位，列表和元信息接口需原样返回
+func TestListAndMetas19DigitIDs(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+	fsID := mock.PutFile("/apps/test/a.txt", []byte("a"))
+	if fsID < 1e18 {
+		t.Fatalf("mock fs_id %d has fewer than 19 digits", fsID)
+	}
+
+	f := NewFileClient("token")
+	list, err := f.List("/apps/test", 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.List) != 1 || list.List[0].FsID != fsID {
+		t.Fatalf("List returned %+v, want fs_id %d", list.List, fsID)
+	}
+	metas, err := f.Metas([]uint64{fsID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas.List) != 1 || metas.List[0].FsID != fsID {
+		t.Fatalf("Metas returned %+v, want fs_id %d", metas.List, fsID)
+	}
+
+	query, err := utils.StructToUrlQuery(struct {
+		FsID uint64 `json:"fs_id"`
+	}{fsID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "fs_id=" + strconv.FormatUint(fsID, 10); query != want {
+		t.Fatalf("StructToUrlQuery = %s, want %s", query, want)
+	}
+}
@@ -0,0 +1,82 @@
+package file
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/jsyzchen/pan/utils"
+)
+
+// 文件列表输出格式
+type fsItemOutput struct {
+	FsID        uint64 `json:"fs_id"`
+	Path        string `json:"path"`
+	Name        string `json:"name"`
+	Size        uint64 `json:"size"`
+	IsDir       int    `json:"is_dir"`
+	Md5         string `json:"md5"`
+	ServerMtime int64  `json:"server_mtime"`
+}
+
+// 文件信息输出格式
+type metasItemOutput struct {
+	FsID        uint64 `json:"fs_id"`
+	Path        string `json:"path"`
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	IsDir       int    `json:"is_dir"`
+	Category    int    `json:"category"`
+	Md5         string `json:"md5"`
+	ServerMtime int64  `json:"server_mtime"`
+}
+
+// 输出JSON数组，字段依次为fs_id、path、name、size、is_dir、md5、server_mtime
+func (r ListResponse) ToJSON() ([]byte, error) {
+	items := make([]fsItemOutput, len(r.List))
+	for i, item := range r.List {
+		items[i] = fsItemOutput{item.FsID, item.Path, item.ServerFileName, item.Size, item.IsDir, item.Md5, item.ServerMtime}
+	}
+	return json.Marshal(items)
+}
+
+// 输出文本表格，列依次为FS_ID、PATH、SIZE、IS_DIR、MD5、SERVER_MTIME
+func (r ListResponse) ToTable() string {
+	rows := make([][]string, len(r.List))
+	for i, item := range r.List {
+		rows[i] = []string{
+			strconv.FormatUint(item.FsID, 10),
+			item.Path,
+			strconv.FormatUint(item.Size, 10),
+			strconv.Itoa(item.IsDir),
+			item.Md5,
+			strconv.FormatInt(item.ServerMtime, 10),
+		}
+	}
+	return utils.FormatTable([]string{"FS_ID", "PATH", "SIZE", "IS_DIR", "MD5", "SERVER_MTIME"}, rows)
+}
+
+// 输出JSON数组，字段依次为fs_id、path、name、size、is_dir、category、md5、server_mtime，不包含下载地址
+func (r MetasResponse) ToJSON() ([]byte, error) {
+	items := make([]metasItemOutput, len(r.List))
+	for i, item := range r.List {
+		items[i] = metasItemOutput{item.FsID, item.Path, item.FileName, item.Size, item.IsDir, int(item.Category), item.Md5, item.ServerMtime}
+	}
+	return json.Marshal(items)
+}
+
+// 输出文本表格，列依次为FS_ID、PATH、SIZE、IS_DIR、CATEGORY、MD5、SERVER_MTIME
+func (r MetasResponse) ToTable() string {
+	rows := make([][]string, len(r.List))
+	for i, item := range r.List {
+		rows[i] = []string{
+			strconv.FormatUint(item.FsID, 10),
+			item.Path,
+			strconv.FormatInt(item.Size, 10),
+			strconv.Itoa(item.IsDir),
+			strconv.Itoa(int(item.Category)),
+			item.Md5,
+			strconv.FormatInt(item.ServerMtime, 10),
+		}
+	}
+	return utils.FormatTable([]string{"FS_ID", "PATH", "SIZE", "IS_DIR", "CATEGORY", "MD5", "SERVER_MTIME"}, rows)
+}
@@ -0,0 +1,37 @@
+package file
+
+// 设置默认进度回调，调用Upload等方法时传入的进度回调为nil时使用，非交互场景可不传入回调
+func (u *Uploader) SetProgressHandler(progressHandler UploadProgressHandler) {
+	u.ProgressHandler = progressHandler
+}
+
+func (r *ReaderUploader) SetProgressHandler(progressHandler UploadProgressHandler) {
+	r.ProgressHandler = progressHandler
+}
+
+func (s *StreamUploader) SetProgressHandler(progressHandler UploadProgressHandler) {
+	s.ProgressHandler = progressHandler
+}
+
+func (d *Downloader) SetProgressHandler(progressHandler DownloadProgressHandler) {
+	d.ProgressHandler = progressHandler
+}
+
+func (d *DirUploader) SetProgressHandler(progressHandler DirUploadProgressHandler) {
+	d.ProgressHandler = progressHandler
+}
+
+func (u *Uploader) progressHandler(progressHandler UploadProgressHandler) UploadProgressHandler {
+	return progressHandlerOr(progressHandler, u.ProgressHandler)
+}
+
+// 依次使用传入的回调、默认回调，均为nil时返回空回调，调用方无需判断nil
+func progressHandlerOr(progressHandler, defaultHandler func(int, int64, int64)) func(int, int64, int64) {
+	if progressHandler != nil {
+		return progressHandler
+	}
+	if defaultHandler != nil {
+		return defaultHandler
+	}
+	return func(int, int64, int64) {}
+}
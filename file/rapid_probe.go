@@ -0,0 +1,78 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+)
+
+// 秒传探测结果
+type RapidUploadProbe struct {
+	Eligible   bool           // 是否满足秒传条件，文件不大于256KB时不发起预创建
+	Exists     bool           // 云端已存在相同文件，秒传成功
+	ReturnType int            // 预创建返回的return_type，2为秒传成功
+	Info       UploadResponse // 秒传成功时保存的文件信息
+}
+
+// 只调用预创建接口探测是否可以秒传，不上传分片
+// 云端已存在相同内容时预创建即完成秒传，文件已保存到Path，无需再调用Upload
+// 不存在时预创建返回的uploadid不会被使用，稍后由服务端过期清理
+// 设置了HashCache时，未修改的文件直接使用缓存的md5，无需读取文件内容
+func (u *Uploader) ProbeRapidUpload(ctx context.Context) (RapidUploadProbe, error) {
+	ret := RapidUploadProbe{}
+	fileInfo, err := u.GetFileInfo(false)
+	if err != nil {
+		log.Println("probeRapidUpload GetFileInfo failed, err:", err)
+		return ret, err
+	}
+	if fileInfo.Size <= rapidUploadMinSize {
+		return ret, nil
+	}
+	sliceMd5, err := u.getSliceMd5()
+	if err != nil {
+		log.Println("probeRapidUpload getSliceMd5 failed, err:", err)
+		return ret, err
+	}
+	return u.probeRapidUpload(ctx, fileInfo.Size, fileInfo.Md5, sliceMd5)
+}
+
+// 使用已知的文件大小、md5和前256KB的md5探测是否可以秒传，不读取本地文件，适用于备份工具已记录文件指纹的场景
+func (u *Uploader) ProbeRapidUploadHash(ctx context.Context, fileSize int64, fileMd5, sliceMd5 string) (RapidUploadProbe, error) {
+	if fileMd5 == "" || sliceMd5 == "" {
+		return RapidUploadProbe{}, errors.New(fmt.Sprintf("probeRapidUpload md5 required, fileMd5: %s sliceMd5: %s", fileMd5, sliceMd5))
+	}
+	if fileSize <= rapidUploadMinSize {
+		return RapidUploadProbe{}, nil
+	}
+	return u.probeRapidUpload(ctx, fileSize, fileMd5, sliceMd5)
+}
+
+// 秒传只比较文件md5和前256KB的md5，分片md5使用占位值，无需计算
+func (u *Uploader) probeRapidUpload(ctx context.Context, fileSize int64, fileMd5, sliceMd5 string) (RapidUploadProbe, error) {
+	ret := RapidUploadProbe{Eligible: true}
+	sliceSize, err := u.getSliceSize(ctx, fileSize)
+	if err != nil {
+		return ret, err
+	}
+	placeholders := make([]string, int(math.Ceil(float64(fileSize)/float64(sliceSize))))
+	for i := range placeholders {
+		placeholders[i] = placeholderSliceMd5
+	}
+	preCreateRes, err := u.preCreate(ctx, fileSize, fileMd5, sliceMd5, placeholders)
+	if err != nil {
+		log.Printf("probeRapidUpload preCreate failed path: %s err: %v", u.Path, err)
+		return ret, err
+	}
+	ret.ReturnType = preCreateRes.ReturnType
+	if preCreateRes.ReturnType == 2 {
+		preCreateRes.Info.ErrorCode = preCreateRes.ErrorCode
+		preCreateRes.Info.ErrorMsg = preCreateRes.ErrorMsg
+		preCreateRes.Info.RequestID = preCreateRes.RequestID
+		u.checkRenamed(&preCreateRes.Info)
+		ret.Exists = true
+		ret.Info = preCreateRes.Info
+	}
+	return ret, nil
+}
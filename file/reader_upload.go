@@ -0,0 +1,187 @@
+package file
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	fileUtil "github.com/jsyzchen/pan/utils/file"
+)
+
+// 预创建时占位的分片md5，数据读取前无法计算真实的分片md5
+const placeholderSliceMd5 = "d41d8cd98f00b204e9800998ecf8427e"
+
+// ReaderUploader 上传长度已知的数据流(如另一个HTTP请求的body)，边读取边上传分片，不写入本地临时文件
+// 预创建时分片md5未知，不会触发秒传，也不支持断点续传，长度未知时使用StreamUploader
+type ReaderUploader struct {
+	AccessToken     string
+	Path            string
+	Reader          io.Reader
+	Size            int64                 // 数据长度，只读取Size字节，数据提前结束时上传失败
+	RateLimiter     *fileUtil.RateLimiter // 限速器，为nil时不限速
+	Concurrency     int                   // 同时上传的分片数，每个分片占用一个分片大小的内存，小于等于0时使用默认值2
+	ProgressHandler UploadProgressHandler // 调用时传入的进度回调为nil时使用，均为nil时不回调
+}
+
+func NewReaderUploader(accessToken, path string, r io.Reader, size int64) *ReaderUploader {
+	return &ReaderUploader{
+		AccessToken: accessToken,
+		Path:        handleSpecialChar(path),
+		Reader:      r,
+		Size:        size,
+	}
+}
+
+func (r *ReaderUploader) SetRateLimiter(rateLimiter *fileUtil.RateLimiter) {
+	r.RateLimiter = rateLimiter
+}
+
+// 设置同时上传的分片数
+func (r *ReaderUploader) SetConcurrency(concurrency int) {
+	r.Concurrency = concurrency
+}
+
+// 设置上传限速，单位字节/秒，为0时不限速
+func (r *ReaderUploader) SetMaxUploadRate(bytesPerSecond int64) {
+	r.RateLimiter = fileUtil.NewRateLimiter(bytesPerSecond)
+}
+
+// 上传数据流到网盘，分片按读取顺序上传，同时最多缓存Concurrency个分片
+func (r *ReaderUploader) Upload(ctx context.Context, progressHandler UploadProgressHandler) (UploadResponse, error) {
+	var ret UploadResponse
+	dispatcher := fileUtil.NewProgressDispatcher(progressHandlerOr(progressHandler, r.ProgressHandler))
+	defer dispatcher.Close()
+	progressHandler = dispatcher.Handle
+
+	if r.Size < 0 {
+		return ret, errors.New(fmt.Sprintf("readerUpload invalid size: %d", r.Size))
+	}
+
+	u := NewUploader(r.AccessToken, r.Path, "")
+	u.SetRateLimiter(r.RateLimiter)
+	u.SetConcurrency(r.Concurrency)
+	sliceSize, err := u.getSliceSize(ctx, r.Size)
+	if err != nil {
+		return ret, err
+	}
+	sliceNum := 1
+	if sliceSize > 0 {
+		sliceNum = int(math.Ceil(float64(r.Size) / float64(sliceSize)))
+	}
+	placeholders := make([]string, sliceNum)
+	for i := range placeholders {
+		placeholders[i] = placeholderSliceMd5
+	}
+	preCreateRes, err := u.preCreate(ctx, r.Size, "", "", placeholders)
+	if err != nil {
+		log.Printf("readerUpload preCreate failed path: %s err: %v", r.Path, err)
+		return ret, err
+	}
+	if preCreateRes.UploadID == "" {
+		return ret, errors.New(fmt.Sprintf("readerUpload preCreate returned no uploadid, path: %s returnType: %d", r.Path, preCreateRes.ReturnType))
+	}
+	uploadID := preCreateRes.UploadID
+
+	var doneSize int64
+	var progressLock sync.Mutex
+	progressTick := time.Now()
+	internalProgressHandler := func(size int64) {
+		progressLock.Lock()
+		defer progressLock.Unlock()
+		doneSize += size
+		if newTick := time.Now(); newTick.Sub(progressTick).Milliseconds() >= 500 || doneSize == r.Size {
+			progressHandler(2, doneSize, r.Size)
+			progressTick = newTick
+		}
+	}
+
+	fileHash := md5.New()
+	blockList := make([]string, sliceNum)
+	uploadRespChan := make(chan UploadPartResponse, sliceNum)
+	sem := make(chan int, u.concurrency()) //限制并发数，以防大文件上传导致占用服务器大量内存
+	//任一分片失败时取消其余分片，不再读取新的分片
+	group, sliceCtx := fileUtil.NewFailGroup(ctx)
+	defer group.Cancel()
+	uploadSliceNum := 0
+	for i := 0; i < sliceNum; i++ {
+		if sliceCtx.Err() != nil {
+			break
+		}
+		sem <- 1 //当通道已满的时候将被阻塞
+		buffer, n, err := r.readSlice(fileHash, sliceSize, int64(i)*sliceSize)
+		if err != nil {
+			<-sem
+			log.Printf("readerUpload read slice failed seq: %d path: %s err: %v", i, r.Path, err)
+			group.Fail(err)
+			break
+		}
+		go func(partSeq int, buffer *[]byte, section *io.SectionReader) {
+			uploadResp, err := u.trySuperFile2Upload(sliceCtx, uploadID, partSeq, section, internalProgressHandler)
+			if err != nil {
+				log.Printf("readerUpload TrySuperFile2Upload failed seq: %d path: %s err: %v", partSeq, r.Path, err)
+				group.Fail(err)
+			}
+			putSliceBuffer(buffer)
+			uploadRespChan <- UploadPartResponse{uploadResp, section.Size(), err}
+			<-sem
+		}(i, buffer, bytesSection((*buffer)[:n]))
+		uploadSliceNum++
+	}
+
+	for i := 0; i < uploadSliceNum; i++ {
+		partResp := <-uploadRespChan
+		if partResp.Error != nil {
+			continue
+		}
+		partSeq, err := strconv.Atoi(partResp.Response.PartSeq)
+		if err != nil {
+			group.Fail(err)
+			continue
+		}
+		blockList[partSeq] = partResp.Response.Md5
+	}
+	if err := group.Err(); err != nil {
+		return ret, err
+	}
+	if err := ctx.Err(); err != nil {
+		return ret, err
+	}
+
+	u.FileInfo = LocalFileInfo{
+		Md5:     hex.EncodeToString(fileHash.Sum(nil)),
+		Size:    r.Size,
+		ModTime: time.Now().Unix(),
+	}
+	ret, err = u.commit(ctx, uploadID, blockList)
+	if err != nil {
+		log.Printf("readerUpload SuperFile2Commit failed path: %s err: %v", r.Path, err)
+		return ret, err
+	}
+	u.checkRenamed(&ret)
+	return ret, nil
+}
+
+// 按顺序读取一个分片，同时计算整个文件的md5
+func (r *ReaderUploader) readSlice(fileHash hash.Hash, sliceSize, offset int64) (*[]byte, int, error) {
+	size := sliceSize
+	if remaining := r.Size - offset; remaining < size {
+		size = remaining
+	}
+	buffer := getSliceBuffer(sliceSize)
+	n, err := io.ReadFull(r.Reader, (*buffer)[:size])
+	if err != nil {
+		putSliceBuffer(buffer)
+		return nil, 0, errors.New(fmt.Sprintf("read stream failed, offset: %d readSize: %d expectedSize: %d err: %v", offset, n, size, err))
+	}
+	fileHash.Write((*buffer)[:n])
+	return buffer, n, nil
+}
@@ -0,0 +1,62 @@
+package file_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jsyzchen/pan/file"
+	"github.com/jsyzchen/pan/utils/httpclient"
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+// 统计同时进行的分片上传请求数
+type inflightTransport struct {
+	server *mockpan.Server
+
+	lock     sync.Mutex
+	inflight int
+	max      int
+}
+
+func (t *inflightTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Query().Get("method") != "upload" {
+		return t.server.RoundTrip(req)
+	}
+	t.lock.Lock()
+	t.inflight++
+	if t.inflight > t.max {
+		t.max = t.inflight
+	}
+	t.lock.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	resp, err := t.server.RoundTrip(req)
+	t.lock.Lock()
+	t.inflight--
+	t.lock.Unlock()
+	return resp, err
+}
+
+// 同时上传的分片数按Concurrency设置，不再固定为2
+func TestReaderUploadConcurrency(t *testing.T) {
+	mock := mockpan.NewServer()
+	transport := &inflightTransport{server: mock}
+	httpclient.SetTransport(transport)
+	defer httpclient.SetTransport(nil)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<20) // 16M，普通用户4个分片
+	uploader := file.NewReaderUploader("token", "/apps/test/reader.bin", bytes.NewReader(data), int64(len(data)))
+	uploader.SetConcurrency(4)
+	if _, err := uploader.Upload(context.Background(), nil); err != nil {
+		t.Fatalf("ReaderUploader.Upload: %v", err)
+	}
+	if !mock.Exists("/apps/test/reader.bin") {
+		t.Fatal("reader uploaded file not found")
+	}
+	if transport.max <= 2 {
+		t.Fatalf("max concurrent slice uploads: %d, want more than 2", transport.max)
+	}
+}
@@ -0,0 +1,71 @@
+package file
+
+import (
+	"path"
+	"sync"
+)
+
+// RemoteDirCache 记录已确认存在的网盘目录，可在一批上传任务间共享
+type RemoteDirCache struct {
+	mu   sync.Mutex
+	dirs map[string]bool
+}
+
+func NewRemoteDirCache() *RemoteDirCache {
+	return &RemoteDirCache{
+		dirs: map[string]bool{},
+	}
+}
+
+func (c *RemoteDirCache) key(accessToken, dir string) string {
+	return accessToken + "\x00" + dir
+}
+
+// 目录是否已确认存在
+func (c *RemoteDirCache) Has(accessToken, dir string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dirs[c.key(accessToken, dir)]
+}
+
+// 记录目录及其上级目录已存在
+func (c *RemoteDirCache) Add(accessToken, dir string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for dir != "/" && dir != "." && dir != "" {
+		c.dirs[c.key(accessToken, dir)] = true
+		dir = path.Dir(dir)
+	}
+}
+
+// 清空缓存，网盘目录被删除后调用
+func (c *RemoteDirCache) Reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirs = map[string]bool{}
+}
+
+// 创建上传路径的上级目录，已创建过的目录不再重复请求
+func (u *Uploader) ensureRemoteDir() error {
+	if !u.EnsureDir {
+		return nil
+	}
+	dir := path.Dir(u.remotePath())
+	if dir == "/" || dir == "." || u.DirCache.Has(u.AccessToken, dir) {
+		return nil
+	}
+	if err := u.fileClient().MkdirAll(dir); err != nil {
+		return err
+	}
+	u.DirCache.Add(u.AccessToken, dir)
+	return nil
+}
@@ -0,0 +1,297 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jsyzchen/pan/errno"
+	fileUtil "github.com/jsyzchen/pan/utils/file"
+)
+
+// 网盘目录锁标记名的前缀，完整的标记名为".lock.<持有者>.<过期时间>"
+const RemoteLockName = ".lock"
+
+// 锁标记的默认过期时间
+const DefaultRemoteLockTTL = 30 * time.Minute
+
+// 查找锁标记时每页获取的文件数
+const remoteLockListLimit = 1000
+
+// 持有的锁标记已被删除或续期失败，锁可能已被其他写入方获取
+var ErrRemoteLockLost = errors.New("remote lock lost")
+
+// RemoteLock 基于网盘标记的目录锁，多台机器使用同一账号写入同一目录时互斥
+// 加锁时在目录下创建名为".lock.<持有者>.<过期时间>"的空目录作为标记，再列出目录下的全部标记，创建时间最早(相同时fs_id最小)的未过期标记获得锁，其余加锁方删除自己的标记
+// 标记过期视为持有者已异常退出，删除前再次确认标记的fs_id和名字未变，持有者需在过期前调用Refresh续期
+// 过期时间由持有者的本机时间计算，机器间时间偏差较大时需相应增大TTL
+// 实现了fileUtil.PathLocker，锁定的是目录，用于DirUploader.SetRemoteLock，不能用于Uploader.SetPathLocker
+type RemoteLock struct {
+	AccessToken string
+	TTL         time.Duration
+	Owner       string // 持有者标识，出现在标记名中，默认随机生成，不同实例不能相同
+
+	mu   sync.Mutex
+	held map[string]remoteLockMarker
+}
+
+// 锁标记
+type remoteLockMarker struct {
+	owner  string
+	expire int64 // 过期时间，unix秒级时间戳
+	fsID   uint64
+	ctime  int64
+}
+
+func NewRemoteLock(accessToken string) *RemoteLock {
+	return &RemoteLock{
+		AccessToken: accessToken,
+		TTL:         DefaultRemoteLockTTL,
+		Owner:       fileUtil.NewJobID(),
+		held:        map[string]remoteLockMarker{},
+	}
+}
+
+func (l *RemoteLock) SetTTL(ttl time.Duration) {
+	l.TTL = ttl
+}
+
+func (m remoteLockMarker) name() string {
+	return fmt.Sprintf("%s.%s.%d", RemoteLockName, m.owner, m.expire)
+}
+
+// 创建时间早的标记优先获得锁
+func (m remoteLockMarker) before(other remoteLockMarker) bool {
+	if m.ctime != other.ctime {
+		return m.ctime < other.ctime
+	}
+	return m.fsID < other.fsID
+}
+
+// 从文件列表项解析锁标记，不是锁标记时返回false
+func parseRemoteLockMarker(item FsItem) (remoteLockMarker, bool) {
+	if item.IsDir != 1 || !strings.HasPrefix(item.ServerFileName, RemoteLockName+".") {
+		return remoteLockMarker{}, false
+	}
+	rest := strings.TrimPrefix(item.ServerFileName, RemoteLockName+".")
+	i := strings.LastIndex(rest, ".")
+	if i <= 0 {
+		return remoteLockMarker{}, false
+	}
+	expire, err := strconv.ParseInt(rest[i+1:], 10, 64)
+	if err != nil {
+		return remoteLockMarker{}, false
+	}
+	return remoteLockMarker{owner: rest[:i], expire: expire, fsID: item.FsID, ctime: item.ServerCtime}, true
+}
+
+func (l *RemoteLock) ttl() time.Duration {
+	if l.TTL <= 0 {
+		return DefaultRemoteLockTTL
+	}
+	return l.TTL
+}
+
+func (l *RemoteLock) owner() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.Owner == "" {
+		l.Owner = fileUtil.NewJobID()
+	}
+	return l.Owner
+}
+
+// 获取目录锁，其他写入方持有未过期的标记时返回false
+func (l *RemoteLock) TryLock(dir string) (bool, error) {
+	dir = strings.TrimRight(dir, "/")
+	f := NewFileClient(l.AccessToken)
+	mine := remoteLockMarker{owner: l.owner(), expire: time.Now().Add(l.ttl()).Unix()}
+	ret, err := f.createDir(path.Join(dir, mine.name()), "0") // rtype为0时路径已存在直接返回错误
+	if err != nil {
+		log.Printf("RemoteLock.TryLock createDir failed dir: %s err: %v", dir, err)
+		return false, err
+	}
+	mine.fsID = ret.FsId
+
+	markers, err := l.listMarkers(f, dir)
+	if err != nil {
+		l.remove(f, dir, mine)
+		return false, err
+	}
+	now := time.Now().Unix()
+	var winner *remoteLockMarker
+	for i := range markers {
+		m := markers[i]
+		if m.fsID != mine.fsID && m.expire < now { //持有者已异常退出，删除过期的标记
+			l.removeStale(f, dir, m)
+			continue
+		}
+		if winner == nil || m.before(*winner) {
+			winner = &markers[i]
+		}
+	}
+	if winner == nil || winner.fsID != mine.fsID {
+		if err := l.remove(f, dir, mine); err != nil {
+			log.Printf("RemoteLock.TryLock remove own marker failed dir: %s err: %v", dir, err)
+		}
+		return false, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == nil {
+		l.held = map[string]remoteLockMarker{}
+	}
+	l.held[dir] = mine
+	return true, nil
+}
+
+// 延长持有的锁的过期时间，持有期间需在TTL内定期调用，标记已被删除时返回ErrRemoteLockLost
+func (l *RemoteLock) Refresh(dir string) error {
+	dir = strings.TrimRight(dir, "/")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	mine, ok := l.held[dir]
+	if !ok {
+		return ErrRemoteLockLost
+	}
+	refreshed := mine
+	refreshed.expire = time.Now().Add(l.ttl()).Unix()
+	if refreshed.expire == mine.expire {
+		return nil
+	}
+	tasks, err := json.Marshal([]map[string]string{{"path": path.Join(dir, mine.name()), "newname": refreshed.name()}})
+	if err != nil {
+		return err
+	}
+	ret, err := NewFileClient(l.AccessToken).manage("rename", string(tasks), "0", "fail")
+	for _, info := range ret.Info { //部分失败时接口同时返回错误码和每个任务的结果
+		if info.Errno == int(errno.CodeFileNotFound) || info.Errno == int(errno.CodeDirNotFound) {
+			delete(l.held, dir)
+			return ErrRemoteLockLost
+		}
+		if info.Errno != 0 {
+			return errors.New(fmt.Sprintf("RemoteLock.Refresh failed path: %s, errno: %d", info.Path, info.Errno))
+		}
+	}
+	if err != nil {
+		log.Printf("RemoteLock.Refresh rename failed dir: %s err: %v", dir, err)
+		return err
+	}
+	l.held[dir] = refreshed
+	return nil
+}
+
+// 持有锁期间每隔TTL/3续期一次，返回停止续期的函数
+func (l *RemoteLock) keepAlive(dir string) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(l.ttl() / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := l.Refresh(dir); err != nil {
+					log.Printf("RemoteLock.Refresh failed dir: %s err: %v", dir, err)
+					if errors.Is(err, ErrRemoteLockLost) {
+						return
+					}
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// 释放目录锁，只删除本实例创建且fs_id未变的标记
+func (l *RemoteLock) Unlock(dir string) error {
+	dir = strings.TrimRight(dir, "/")
+	l.mu.Lock()
+	mine, ok := l.held[dir]
+	delete(l.held, dir)
+	l.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	f := NewFileClient(l.AccessToken)
+	markers, err := l.listMarkers(f, dir)
+	if err != nil {
+		return err
+	}
+	for _, m := range markers {
+		if m.fsID == mine.fsID && m.owner == mine.owner {
+			return l.remove(f, dir, m)
+		}
+	}
+	log.Printf("RemoteLock.Unlock marker already removed dir: %s name: %s", dir, mine.name())
+	return nil
+}
+
+// 列出目录下的全部锁标记
+func (l *RemoteLock) listMarkers(f *File, dir string) ([]remoteLockMarker, error) {
+	markers := []remoteLockMarker{}
+	for start := 0; ; start += remoteLockListLimit {
+		res, err := f.List(dir, start, remoteLockListLimit)
+		if err != nil {
+			log.Printf("RemoteLock.listMarkers List failed dir: %s err: %v", dir, err)
+			return nil, err
+		}
+		for _, item := range res.List {
+			if m, ok := parseRemoteLockMarker(item); ok {
+				markers = append(markers, m)
+			}
+		}
+		if len(res.List) < remoteLockListLimit {
+			return markers, nil
+		}
+	}
+}
+
+// 删除过期的标记，删除前确认标记仍存在、fs_id和名字未变，持有者已续期时不删除
+func (l *RemoteLock) removeStale(f *File, dir string, stale remoteLockMarker) {
+	markers, err := l.listMarkers(f, dir)
+	if err != nil {
+		return
+	}
+	for _, m := range markers {
+		if m.fsID == stale.fsID && m.owner == stale.owner && m.expire == stale.expire {
+			log.Printf("RemoteLock remove stale lock dir: %s name: %s", dir, m.name())
+			if err := l.remove(f, dir, m); err != nil {
+				log.Printf("RemoteLock remove stale lock failed dir: %s err: %v", dir, err)
+			}
+			return
+		}
+	}
+}
+
+func (l *RemoteLock) remove(f *File, dir string, m remoteLockMarker) error {
+	lockPath := path.Join(dir, m.name())
+	tasks, err := json.Marshal([]string{lockPath})
+	if err != nil {
+		return err
+	}
+	ret, err := f.manage("delete", string(tasks), "0", "fail")
+	for _, info := range ret.Info { //标记已不存在时视为删除成功
+		if info.Errno != 0 && info.Errno != int(errno.CodeFileNotFound) && info.Errno != int(errno.CodeDirNotFound) {
+			return errors.New(fmt.Sprintf("RemoteLock.remove failed path: %s, errno: %d", info.Path, info.Errno))
+		}
+	}
+	if err != nil && len(ret.Info) == 0 {
+		log.Printf("RemoteLock.remove failed path: %s err: %v", lockPath, err)
+		return err
+	}
+	return nil
+}
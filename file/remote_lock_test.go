@@ -0,0 +1,143 @@
+package file_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jsyzchen/pan/file"
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+const lockDir = "/apps/sync"
+
+// 目录下的锁标记名
+func lockMarkers(t *testing.T) []string {
+	res, err := file.NewFileClient("token").List(lockDir, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, item := range res.List {
+		if strings.HasPrefix(item.ServerFileName, file.RemoteLockName+".") {
+			names = append(names, item.ServerFileName)
+		}
+	}
+	return names
+}
+
+func TestRemoteLockExclusive(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+
+	a, b := file.NewRemoteLock("token"), file.NewRemoteLock("token")
+	if ok, err := a.TryLock(lockDir); !ok || err != nil {
+		t.Fatalf("a.TryLock = %v, %v", ok, err)
+	}
+	if ok, err := b.TryLock(lockDir); ok || err != nil {
+		t.Fatalf("b.TryLock = %v, %v, want false", ok, err)
+	}
+	markers := lockMarkers(t)
+	if len(markers) != 1 || !strings.Contains(markers[0], a.Owner) {
+		t.Fatalf("markers after losing TryLock: %v", markers)
+	}
+
+	if err := b.Unlock(lockDir); err != nil { //未持有锁时不删除其他写入方的标记
+		t.Fatal(err)
+	}
+	if len(lockMarkers(t)) != 1 {
+		t.Fatal("Unlock removed a marker it doesn't own")
+	}
+
+	if err := a.Unlock(lockDir); err != nil {
+		t.Fatal(err)
+	}
+	if markers := lockMarkers(t); len(markers) != 0 {
+		t.Fatalf("markers after Unlock: %v", markers)
+	}
+	if ok, err := b.TryLock(lockDir); !ok || err != nil {
+		t.Fatalf("b.TryLock after Unlock = %v, %v", ok, err)
+	}
+}
+
+func TestRemoteLockConcurrent(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	winners := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := file.NewRemoteLock("token").TryLock(lockDir)
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				mu.Lock()
+				winners++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if winners != 1 {
+		t.Fatalf("%d writers got the lock, want 1", winners)
+	}
+	if markers := lockMarkers(t); len(markers) != 1 {
+		t.Fatalf("markers: %v", markers)
+	}
+}
+
+func TestRemoteLockStale(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+
+	stale := lockDir + "/" + file.RemoteLockName + ".crashed." + "1600000000"
+	if _, err := file.NewFileClient("token").CreateDir(stale); err != nil {
+		t.Fatal(err)
+	}
+	l := file.NewRemoteLock("token")
+	if ok, err := l.TryLock(lockDir); !ok || err != nil {
+		t.Fatalf("TryLock with stale marker = %v, %v", ok, err)
+	}
+	if mock.Exists(stale) {
+		t.Fatal("stale marker not removed")
+	}
+}
+
+func TestRemoteLockRefresh(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+
+	l := file.NewRemoteLock("token")
+	l.SetTTL(time.Minute)
+	if ok, err := l.TryLock(lockDir); !ok || err != nil {
+		t.Fatalf("TryLock = %v, %v", ok, err)
+	}
+	before := lockMarkers(t)
+	l.SetTTL(time.Hour)
+	if err := l.Refresh(lockDir); err != nil {
+		t.Fatal(err)
+	}
+	after := lockMarkers(t)
+	if len(after) != 1 || after[0] == before[0] {
+		t.Fatalf("Refresh didn't extend the marker: %v -> %v", before, after)
+	}
+
+	if ok, err := file.NewRemoteLock("token").TryLock(lockDir); ok || err != nil {
+		t.Fatalf("TryLock after Refresh = %v, %v, want false", ok, err)
+	}
+
+	if _, err := file.NewFileClient("token").Manage("delete", `["`+lockDir+"/"+after[0]+`"]`); err != nil {
+		t.Fatal(err)
+	}
+	l.SetTTL(2 * time.Hour)
+	if err := l.Refresh(lockDir); !errors.Is(err, file.ErrRemoteLockLost) {
+		t.Fatalf("Refresh after marker removed = %v, want ErrRemoteLockLost", err)
+	}
+}
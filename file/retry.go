@@ -0,0 +1,80 @@
+package file
+
+import (
+	"time"
+
+	"github.com/jsyzchen/pan/errno"
+)
+
+// 分片上传的重试策略
+type RetryPolicy struct {
+	MaxAttempts    int           // 最多尝试次数，包括第一次，小于1时按1处理
+	InitialDelay   time.Duration // 第一次重试前的等待时间
+	Multiplier     float64       // 每次重试的等待时间为上一次的倍数，小于1时按1处理，即固定间隔
+	MaxDelay       time.Duration // 最长等待时间，为0时不限制
+	RetryableCodes []errno.Code  // 重试的接口错误码，为空时按errno.IsRetryable判断，不为空时只重试列表中的错误码，网络等其他错误仍按errno.IsRetryable判断
+}
+
+// 默认重试策略，最多尝试10次，固定间隔6秒
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  10,
+	InitialDelay: 6 * time.Second,
+	Multiplier:   1,
+}
+
+// 第attempt次重试前的等待时间，attempt从1开始
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		return 0
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.InitialDelay)
+	for i := 1; i < attempt; i++ {
+		delay *= multiplier
+		if p.MaxDelay > 0 && delay >= float64(p.MaxDelay) {
+			return p.MaxDelay
+		}
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+// 判断错误是否重试
+func (p RetryPolicy) Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if code, ok := errno.CodeOf(err); ok && len(p.RetryableCodes) > 0 {
+		for _, c := range p.RetryableCodes {
+			if c == code {
+				return true
+			}
+		}
+		return false
+	}
+	return errno.IsRetryable(err)
+}
+
+func (p RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// 设置分片上传的重试策略，长时间运行的任务可增加重试次数并使用指数退避
+func (u *Uploader) SetRetryPolicy(policy RetryPolicy) {
+	u.RetryPolicy = &policy
+}
+
+func (u *Uploader) retryPolicy() RetryPolicy {
+	if u.RetryPolicy == nil {
+		return DefaultRetryPolicy
+	}
+	return *u.RetryPolicy
+}
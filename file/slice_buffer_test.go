@@ -0,0 +1,109 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+func TestSliceBufferPool(t *testing.T) {
+	buffer := getSliceBuffer(4096)
+	if len(*buffer) != 4096 {
+		t.Fatalf("buffer len %d, want 4096", len(*buffer))
+	}
+	*buffer = (*buffer)[:100] //最后一个分片只使用部分缓冲区
+	putSliceBuffer(buffer)
+	buffer = getSliceBuffer(4096)
+	if len(*buffer) != 4096 {
+		t.Fatalf("reused buffer len %d, want 4096", len(*buffer))
+	}
+	putSliceBuffer(buffer)
+
+	other := getSliceBuffer(8192) //不同分片大小使用不同的池
+	if len(*other) != 8192 {
+		t.Fatalf("buffer len %d, want 8192", len(*other))
+	}
+	putSliceBuffer(other)
+
+	putSliceBuffer(nil)
+	unknown := make([]byte, 12345)
+	putSliceBuffer(&unknown) //不是从池中获取的大小，直接丢弃
+	if _, ok := sliceBufferPools.Load(int64(12345)); ok {
+		t.Fatal("pool created for unknown size")
+	}
+}
+
+func TestSliceBufferPoolConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				buffer := getSliceBuffer(1024)
+				for k := range *buffer {
+					(*buffer)[k] = byte(i)
+				}
+				for _, b := range *buffer {
+					if b != byte(i) {
+						t.Errorf("buffer shared between goroutines")
+						return
+					}
+				}
+				putSliceBuffer(buffer)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// 多个分片并发上传时复用缓冲区，上传的内容不能被后续分片覆盖
+func TestUploadReusesSliceBuffers(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+	dir, err := ioutil.TempDir("", "pantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := make([]byte, 10*4096+100)
+	rand.New(rand.NewSource(4)).Read(data)
+	localPath := filepath.Join(dir, "a.bin")
+	if err := ioutil.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, zeroCopy := range []bool{false, true} {
+		remotePath := "/apps/test/buffer.bin"
+		if zeroCopy {
+			remotePath = "/apps/test/zerocopy.bin"
+		}
+		uploader := NewUploader("token", remotePath, localPath)
+		uploader.SliceSize = 4096
+		uploader.SetConcurrency(4)
+		uploader.SetZeroCopy(zeroCopy)
+		if _, _, err := uploader.Upload(context.Background(), nil); err != nil {
+			t.Fatalf("Upload zeroCopy: %v err: %v", zeroCopy, err)
+		}
+		got, ok := mock.ReadFile(remotePath)
+		if !ok || !bytes.Equal(got, data) {
+			t.Fatalf("uploaded content mismatch zeroCopy: %v", zeroCopy)
+		}
+	}
+}
+
+// 每个分片都从池中获取缓冲区，复用后每次上传分配的内存远小于文件大小
+func BenchmarkSliceBuffer(b *testing.B) {
+	const sliceSize = 4 * 1024 * 1024
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		putSliceBuffer(getSliceBuffer(sliceSize))
+	}
+}
@@ -0,0 +1,127 @@
+package file
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	fileUtil "github.com/jsyzchen/pan/utils/file"
+)
+
+// StreamUploader 上传长度未知的数据流(如标准输入、管道)
+// 数据流先写入临时文件，读取结束后按实际大小和分片md5预创建，支持秒传，临时文件最多占用MaxSpoolSize的磁盘空间
+type StreamUploader struct {
+	AccessToken     string
+	Path            string
+	Reader          io.Reader
+	TempDir         string                // 临时文件目录，为空时使用系统临时目录
+	MaxSpoolSize    int64                 // 数据流大小上限，超出时上传失败
+	ProgressHandler UploadProgressHandler // 调用时传入的进度回调为nil时使用，均为nil时不回调
+}
+
+const defaultMaxSpoolSize = 21474836480 // 20G，超级会员单文件总大小上限
+
+// 数据流超出大小上限
+var ErrSpoolTooLarge = errors.New("stream size exceeds max spool size")
+
+func NewStreamUploader(accessToken, path string, reader io.Reader) *StreamUploader {
+	return &StreamUploader{
+		AccessToken:  accessToken,
+		Path:         path,
+		Reader:       reader,
+		MaxSpoolSize: defaultMaxSpoolSize,
+	}
+}
+
+func (s *StreamUploader) SetTempDir(tempDir string) {
+	s.TempDir = tempDir
+}
+
+func (s *StreamUploader) SetMaxSpoolSize(maxSpoolSize int64) {
+	s.MaxSpoolSize = maxSpoolSize
+}
+
+// 上传数据流到网盘，读取数据流时上传进度回调的totalSize为0，读取结束后按临时文件上传
+func (s *StreamUploader) Upload(ctx context.Context, progressHandler UploadProgressHandler) (UploadResponse, error) {
+	var ret UploadResponse
+	dispatcher := fileUtil.NewProgressDispatcher(progressHandlerOr(progressHandler, s.ProgressHandler))
+	defer dispatcher.Close()
+	progressHandler = dispatcher.Handle
+
+	maxSpoolSize := s.MaxSpoolSize
+	if maxSpoolSize <= 0 {
+		maxSpoolSize = defaultMaxSpoolSize
+	}
+	spoolFile, err := fileUtil.TempFile(s.TempDir, "pan_stream_", maxSpoolSize)
+	if err != nil {
+		log.Println("streamUpload fileUtil.TempFile failed, err: ", err)
+		return ret, err
+	}
+	spoolPath := spoolFile.Name()
+	defer fileUtil.RemoveTempFile(spoolPath)
+
+	u := NewUploader(s.AccessToken, s.Path, spoolPath)
+	// 文件大小未知，先按会员身份获取分片大小，边写临时文件边计算分片md5
+	sliceSize, err := u.getSliceSize(ctx, math.MaxInt64)
+	if err != nil {
+		spoolFile.Close()
+		return ret, err
+	}
+	u.SliceSize = sliceSize
+	fileInfo, blockList, err := s.spool(ctx, spoolFile, sliceSize, maxSpoolSize, progressHandler)
+	closeErr := spoolFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("streamUpload spool failed path: %s err: %v", s.Path, err)
+		return ret, err
+	}
+	u.FileInfo = fileInfo
+	u.blockList = blockList
+
+	ret, _, err = u.Upload(ctx, progressHandler)
+	return ret, err
+}
+
+// 读取数据流写入临时文件，返回文件信息和分片md5列表
+func (s *StreamUploader) spool(ctx context.Context, spoolFile *os.File, sliceSize, maxSpoolSize int64, progressHandler UploadProgressHandler) (LocalFileInfo, []string, error) {
+	info := LocalFileInfo{}
+	blockList := []string{}
+	fileHash := md5.New()
+	for {
+		if err := ctx.Err(); err != nil {
+			return info, blockList, err
+		}
+		sliceHash := md5.New()
+		n, err := io.CopyN(io.MultiWriter(spoolFile, fileHash, sliceHash), s.Reader, sliceSize)
+		if err != nil && err != io.EOF {
+			return info, blockList, errors.New(fmt.Sprintf("read stream failed, err: %v", err))
+		}
+		if n > 0 {
+			info.Size += n
+			if info.Size > maxSpoolSize {
+				return info, blockList, ErrSpoolTooLarge
+			}
+			blockList = append(blockList, hex.EncodeToString(sliceHash.Sum(nil)))
+			progressHandler(1, info.Size, 0)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	info.Md5 = hex.EncodeToString(fileHash.Sum(nil))
+	info.ModTime = time.Now().Unix()
+	if len(blockList) <= 1 { //只有一个分片时分片md5即文件md5，空数据流也按一个分片预创建
+		blockList = []string{info.Md5}
+	}
+	return info, blockList, nil
+}
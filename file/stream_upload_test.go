@@ -0,0 +1,144 @@
+package file_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/jsyzchen/pan/file"
+	"github.com/jsyzchen/pan/utils/httpclient"
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+const streamSliceSize = 4194304 //普通用户的分片大小
+
+// 记录预创建请求的参数
+type preCreateRecorder struct {
+	server *mockpan.Server
+
+	lock  sync.Mutex
+	forms []url.Values
+}
+
+func (r *preCreateRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Query().Get("method") == "precreate" {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		r.lock.Lock()
+		r.forms = append(r.forms, form)
+		r.lock.Unlock()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return r.server.RoundTrip(req)
+}
+
+// 读取结束后按实际大小和分片md5预创建，不超出账号的单文件大小上限
+func TestStreamUploadPreCreateSize(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "panstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, size := range []int{3*streamSliceSize + 123, 2 * streamSliceSize, 100, 0} {
+		mock := mockpan.NewServer()
+		recorder := &preCreateRecorder{server: mock}
+		httpclient.SetTransport(recorder)
+
+		data := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(data)
+		uploader := file.NewStreamUploader("token", "/apps/test/stream.bin", bytes.NewReader(data))
+		uploader.SetTempDir(tempDir)
+		if _, err := uploader.Upload(context.Background(), nil); err != nil {
+			httpclient.SetTransport(nil)
+			t.Fatalf("Upload size: %d err: %v", size, err)
+		}
+		httpclient.SetTransport(nil)
+		got, ok := mock.ReadFile("/apps/test/stream.bin")
+		if !ok || !bytes.Equal(got, data) {
+			t.Fatalf("uploaded content mismatch, size: %d", size)
+		}
+		if len(recorder.forms) != 1 {
+			t.Fatalf("precreate called %d times, size: %d", len(recorder.forms), size)
+		}
+		form := recorder.forms[0]
+		if form.Get("size") != strconv.Itoa(size) {
+			t.Fatalf("precreate size: %s, want %d", form.Get("size"), size)
+		}
+		var blockList []string
+		if err := json.Unmarshal([]byte(form.Get("block_list")), &blockList); err != nil {
+			t.Fatal(err)
+		}
+		wantSlices := (size + streamSliceSize - 1) / streamSliceSize
+		if wantSlices == 0 {
+			wantSlices = 1
+		}
+		if len(blockList) != wantSlices {
+			t.Fatalf("precreate block_list length: %d, want %d, size: %d", len(blockList), wantSlices, size)
+		}
+		if entries, _ := ioutil.ReadDir(tempDir); len(entries) != 0 {
+			t.Fatalf("spool temp file not removed: %d", len(entries))
+		}
+	}
+}
+
+// 网盘已有相同内容的文件时秒传，不上传分片
+func TestStreamUploadRapid(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+
+	data := make([]byte, 2*streamSliceSize+10)
+	rand.New(rand.NewSource(1)).Read(data)
+	if _, err := file.NewStreamUploader("token", "/apps/test/a.bin", bytes.NewReader(data)).Upload(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	uploads := mock.Calls("upload")
+	if _, err := file.NewStreamUploader("token", "/apps/test/b.bin", bytes.NewReader(data)).Upload(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if mock.Calls("upload") != uploads {
+		t.Fatal("slices uploaded although the content exists in the cloud")
+	}
+	if got, ok := mock.ReadFile("/apps/test/b.bin"); !ok || !bytes.Equal(got, data) {
+		t.Fatal("rapid uploaded content mismatch")
+	}
+}
+
+func TestStreamUploadTooLarge(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+	tempDir, err := ioutil.TempDir("", "panstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	uploader := file.NewStreamUploader("token", "/apps/test/large.bin", bytes.NewReader(make([]byte, streamSliceSize+1)))
+	uploader.SetTempDir(tempDir)
+	uploader.SetMaxSpoolSize(streamSliceSize)
+	if _, err := uploader.Upload(context.Background(), nil); !errors.Is(err, file.ErrSpoolTooLarge) {
+		t.Fatalf("Upload err: %v, want ErrSpoolTooLarge", err)
+	}
+	if mock.Exists("/apps/test/large.bin") {
+		t.Fatal("oversized stream uploaded")
+	}
+	if entries, _ := ioutil.ReadDir(tempDir); len(entries) != 0 {
+		t.Fatalf("slice temp files not removed: %d", len(entries))
+	}
+}
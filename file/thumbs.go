@@ -0,0 +1,65 @@
+package file
+
+// 缩略图尺寸对应的字段名
+const (
+	ThumbKeyIcon   = "icon" // 图标，60x60
+	ThumbKeySmall  = "url1" // 小图，140x90
+	ThumbKeyMedium = "url2" // 中图，360x270
+	ThumbKeyLarge  = "url3" // 大图，850x580
+)
+
+// Thumbs 缩略图地址，只有图片、视频等文件才有缩略图
+type Thumbs map[string]string
+
+// 图标，没有时返回空字符串
+func (t Thumbs) Icon() string {
+	return t[ThumbKeyIcon]
+}
+
+// 小图，没有时依次使用中图、大图、图标
+func (t Thumbs) Small() string {
+	return t.first(ThumbKeySmall, ThumbKeyMedium, ThumbKeyLarge, ThumbKeyIcon)
+}
+
+// 中图，没有时依次使用大图、小图、图标
+func (t Thumbs) Medium() string {
+	return t.first(ThumbKeyMedium, ThumbKeyLarge, ThumbKeySmall, ThumbKeyIcon)
+}
+
+// 大图，没有时依次使用中图、小图、图标
+func (t Thumbs) Large() string {
+	return t.first(ThumbKeyLarge, ThumbKeyMedium, ThumbKeySmall, ThumbKeyIcon)
+}
+
+func (t Thumbs) first(keys ...string) string {
+	for _, key := range keys {
+		if url := t[key]; url != "" {
+			return url
+		}
+	}
+	return ""
+}
+
+func (f FsItem) ThumbSmall() string {
+	return f.Thumbs.Small()
+}
+
+func (f FsItem) ThumbMedium() string {
+	return f.Thumbs.Medium()
+}
+
+func (f FsItem) ThumbLarge() string {
+	return f.Thumbs.Large()
+}
+
+func (m MetasItem) ThumbSmall() string {
+	return m.Thumbs.Small()
+}
+
+func (m MetasItem) ThumbMedium() string {
+	return m.Thumbs.Medium()
+}
+
+func (m MetasItem) ThumbLarge() string {
+	return m.Thumbs.Large()
+}
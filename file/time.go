@@ -0,0 +1,78 @@
+package file
+
+import (
+	"time"
+
+	"github.com/jsyzchen/pan/utils"
+)
+
+// 文件列表项的时间字段为time.Time的视图，JSON序列化为RFC3339格式
+type FsItemView struct {
+	FsItem
+	LocalCtime  time.Time `json:"local_ctime"`
+	LocalMtime  time.Time `json:"local_mtime"`
+	ServerCtime time.Time `json:"server_ctime"`
+	ServerMtime time.Time `json:"server_mtime"`
+}
+
+// 文件信息的时间字段为time.Time的视图，JSON序列化为RFC3339格式
+type MetasItemView struct {
+	MetasItem
+	ServerCtime time.Time `json:"server_ctime"`
+	ServerMtime time.Time `json:"server_mtime"`
+	DateTaken   time.Time `json:"date_taken"`
+}
+
+// 本地创建时间
+func (item FsItem) LocalCreated() time.Time {
+	return utils.UnixTime(item.LocalCtime)
+}
+
+// 本地修改时间
+func (item FsItem) LocalModified() time.Time {
+	return utils.UnixTime(item.LocalMtime)
+}
+
+// 服务端创建时间
+func (item FsItem) ServerCreated() time.Time {
+	return utils.UnixTime(item.ServerCtime)
+}
+
+// 服务端修改时间
+func (item FsItem) ServerModified() time.Time {
+	return utils.UnixTime(item.ServerMtime)
+}
+
+func (item FsItem) View() FsItemView {
+	return FsItemView{
+		FsItem:      item,
+		LocalCtime:  item.LocalCreated(),
+		LocalMtime:  item.LocalModified(),
+		ServerCtime: item.ServerCreated(),
+		ServerMtime: item.ServerModified(),
+	}
+}
+
+// 服务端创建时间
+func (item MetasItem) ServerCreated() time.Time {
+	return utils.UnixTime(item.ServerCtime)
+}
+
+// 服务端修改时间
+func (item MetasItem) ServerModified() time.Time {
+	return utils.UnixTime(item.ServerMtime)
+}
+
+// 图片拍摄时间
+func (item MetasItem) Taken() time.Time {
+	return utils.UnixTime(int64(item.DateTaken))
+}
+
+func (item MetasItem) View() MetasItemView {
+	return MetasItemView{
+		MetasItem:   item,
+		ServerCtime: item.ServerCreated(),
+		ServerMtime: item.ServerModified(),
+		DateTaken:   item.Taken(),
+	}
+}
@@ -11,8 +11,13 @@ import (
 	"io"
 	"log"
 	"math"
+	"math/big"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,20 +26,39 @@ import (
 	"github.com/bitly/go-simplejson"
 	"github.com/jsyzchen/pan/account"
 	"github.com/jsyzchen/pan/conf"
+	"github.com/jsyzchen/pan/errno"
+	"github.com/jsyzchen/pan/utils/audit"
 	fileUtil "github.com/jsyzchen/pan/utils/file"
 	"github.com/jsyzchen/pan/utils/httpclient"
+	"github.com/jsyzchen/pan/utils/norm"
 )
 
 type UploadProgressHandler = func(int, int64, int64)
 
 type UploadResponse struct {
 	conf.CloudDiskResponseBase
-	Path  string `json:"path"`
-	Name  string `json:"server_filename"`
-	Size  int64  `json:"size"`
-	Md5   string `json:"md5"`
-	FsID  uint64 `json:"fs_id"`
-	IsDir int    `json:"isdir"`
+	Path      string `json:"path"`
+	Name      string `json:"server_filename"`
+	Size      int64  `json:"size"`
+	Md5       string `json:"md5"`
+	FsID      uint64 `json:"fs_id"`
+	IsDir     int    `json:"isdir"`
+	RenamedTo string `json:"-"` // 服务端因重名将文件保存为其他路径时(如"file(1).txt")，记录实际保存的路径
+}
+
+// 上传结果，包括各阶段耗时和重试统计
+type UploadResult struct {
+	UploadResponse
+	HashDuration     time.Duration // 计算md5和预创建耗时，续传时为0
+	TransferDuration time.Duration // 分片上传耗时
+	CommitDuration   time.Duration // 创建文件耗时
+	Duration         time.Duration // 总耗时
+	BytesRetried     int64         // 分片失败后重新上传的字节数
+	SlicesUploaded   int           // 本次上传成功的分片数
+	SlicesPerSecond  float64       // 分片上传阶段平均每秒上传的分片数
+	RapidUpload      bool          // 是否秒传，秒传时没有实际上传数据
+	BytesTransferred int64         // 实际上传的字节数，包括重新上传的部分，秒传时为0
+	SlicesReused     int           // 差异上传时未修改、无需重新上传的分片数
 }
 
 type PreCreateResponse struct {
@@ -66,20 +90,100 @@ type LocalFileInfo struct {
 }
 
 type Uploader struct {
-	AccessToken   string
-	Path          string
-	LocalFilePath string
-	FileInfo      LocalFileInfo
-	SliceSize     int64
+	AccessToken     string
+	Path            string
+	LocalFilePath   string
+	FileInfo        LocalFileInfo
+	SliceSize       int64
+	ZeroCopy        bool                         // 分片直接从文件流式上传，不读入内存缓冲区
+	Mmap            bool                         // 通过内存映射读取分片，不分配分片缓冲区，系统不支持时使用普通读取
+	RenameHandler   func(string, string)         // 服务端重命名文件时回调，参数依次为请求的路径、实际保存的路径
+	RateLimiter     *fileUtil.RateLimiter        // 限速器，为nil时不限速
+	UploadType      string                       // superfile2分片上传的type参数，为空时使用tmpfile
+	Fallback        bool                         // xpan创建文件失败时使用旧版createsuperfile接口创建文件
+	Normalization   norm.Form                    // 网盘路径的Unicode规范化形式，为norm.None时不处理
+	SliceMd5Check   bool                         // 分片请求携带Content-MD5，并校验服务端返回的分片md5
+	PathLocker      fileUtil.PathLocker          // 网盘路径锁，为nil时不加锁
+	Audit           *audit.Writer                // 审计日志，记录传输结果和本次传输发起的接口请求，为nil时不记录
+	EnsureDir       bool                         // 预创建前创建网盘上级目录
+	DirCache        *RemoteDirCache              // 已创建的网盘目录，批量上传时共享以避免重复创建
+	HashCache       *fileUtil.HashCache          // 本地文件hash缓存，为nil时每次重新计算
+	WarnHandler     func(error)                  // 上传降级等不导致失败的问题回调，如获取用户信息失败时分片大小降为4M
+	SliceTimeouts   fileUtil.UploadTimeouts      // 分片上传请求各阶段的超时，为0的阶段不限制
+	Concurrency     int                          // 同时上传的分片数，为0时使用2，每个上传中的分片占用一个分片大小的内存
+	Conflict        ConflictPolicy               // 网盘路径已存在同名文件时的处理方式，默认覆盖
+	ReadAhead       int                          // 同时占用缓冲区的分片数上限，包括已读取待上传和正在上传的分片，为0时与Concurrency相同
+	SliceLimiter    *fileUtil.ConcurrencyLimiter // 可在上传中调整的分片数上限，与Concurrency和ReadAhead同时生效，为nil时不限制
+	RetryPolicy     *RetryPolicy                 // 分片上传的重试策略，为nil时使用DefaultRetryPolicy
+	BlockIndex      *fileUtil.BlockIndex         // 已上传文件的分片md5索引，上传成功后记录，为nil时不记录
+	Differential    bool                         // 差异上传，只上传与BlockIndex记录相比已修改或新增的分片
+	ProgressHandler UploadProgressHandler        // 调用时传入的进度回调为nil时使用，均为nil时不回调
+	Checkpoint      fileUtil.SnapshotStore       // 快照存储，每完成一个分片保存一次快照，为nil时不保存
+	blockList       []string                     // 预先计算好的分片md5，为空时在预创建时计算
+	preCreateList   []string                     // 最近一次预创建使用的分片md5
+	sliceBasis      string                       // 分片大小的计算依据，指定了SliceSize时为空
+	mu              sync.Mutex                   // 同一个Uploader的上传串行执行，不同Uploader之间互不影响
 }
 
 const (
 	PreCreateUri        = "/rest/2.0/xpan/file?method=precreate"
 	CreateUri           = "/rest/2.0/xpan/file?method=create"
 	Superfile2UploadUri = "/rest/2.0/pcs/superfile2?method=upload"
+	CreateSuperFileUri  = "/rest/2.0/pcs/file?method=createsuperfile"
+)
+
+const defaultUploadType = "tmpfile"
+
+// 默认同时上传的分片数
+const defaultUploadConcurrency = 2
+
+const (
+	createMaxRetries    = 3               // 创建文件请求结果未知时的最大重试次数
+	createRetryInterval = 3 * time.Second // 创建文件重试间隔，留出服务端处理时间
 )
 
-var UploadLock sync.Mutex
+// 获取用户信息失败，分片大小降为普通用户的4M
+var ErrSliceSizeDowngraded = errors.New("user info unavailable, slice size downgraded to 4MB")
+
+// 续传时uploadid已过期，已上传的分片失效，按当前分片大小重新上传
+var ErrUploadIDExpired = errors.New("upload id expired, upload restarted")
+
+// 旧版createsuperfile接口返回结果
+type CreateSuperFileResponse struct {
+	conf.PcsResponseBase
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Md5   string `json:"md5"`
+	FsID  uint64 `json:"fs_id"`
+	Ctime int64  `json:"ctime"`
+	Mtime int64  `json:"mtime"`
+}
+
+// 分片缓冲区池，按分片大小区分，避免长时间上传时每个分片都重新分配最大32M的内存
+var sliceBufferPools sync.Map
+
+func getSliceBuffer(size int64) *[]byte {
+	pool, ok := sliceBufferPools.Load(size)
+	if !ok {
+		pool, _ = sliceBufferPools.LoadOrStore(size, &sync.Pool{
+			New: func() interface{} {
+				buffer := make([]byte, size)
+				return &buffer
+			},
+		})
+	}
+	return pool.(*sync.Pool).Get().(*[]byte)
+}
+
+func putSliceBuffer(buffer *[]byte) {
+	if buffer == nil {
+		return
+	}
+	if pool, ok := sliceBufferPools.Load(int64(cap(*buffer))); ok {
+		*buffer = (*buffer)[:cap(*buffer)]
+		pool.(*sync.Pool).Put(buffer)
+	}
+}
 
 func NewUploader(accessToken, path, localFilePath string) *Uploader {
 	return &Uploader{
@@ -89,15 +193,258 @@ func NewUploader(accessToken, path, localFilePath string) *Uploader {
 	}
 }
 
-// 上传文件到网盘，包括预创建、分片上传、创建3个步骤
+// 切换为其他用户的access_token，分片大小与会员身份相关，切换后重新计算
+// 不能与正在进行的上传并发调用
+func (u *Uploader) Bind(accessToken string) {
+	u.AccessToken = accessToken
+	u.SliceSize = 0
+	u.sliceBasis = ""
+	u.blockList = nil
+}
+
+// 设置同时上传的分片数，网络较好时可增大到8~16，内存或带宽受限时可设为1
+func (u *Uploader) SetConcurrency(concurrency int) {
+	u.Concurrency = concurrency
+}
+
+// 设置同时占用缓冲区的分片数上限，读取速度超过上传速度时暂停读取，内存占用不超过readAhead个分片大小
+// 大于Concurrency时可提前读取分片排队等待上传，小于Concurrency时同时上传的分片数也不超过readAhead，ZeroCopy或Mmap模式下分片不占用缓冲区
+func (u *Uploader) SetReadAhead(readAhead int) {
+	u.ReadAhead = readAhead
+}
+
+// 设置可在上传中调整的分片数上限，读取分片前获取名额，分片上传结束后释放，传输管理通过它调整正在运行的任务
+func (u *Uploader) SetSliceLimiter(sliceLimiter *fileUtil.ConcurrencyLimiter) {
+	u.SliceLimiter = sliceLimiter
+}
+
+func (u *Uploader) readAhead() int {
+	if u.ReadAhead <= 0 {
+		return u.concurrency()
+	}
+	return u.ReadAhead
+}
+
+func (u *Uploader) concurrency() int {
+	if u.Concurrency <= 0 {
+		return defaultUploadConcurrency
+	}
+	return u.Concurrency
+}
+
+// 设置分片是否直接从文件流式上传，开启后不再为每个分片分配内存缓冲区
+func (u *Uploader) SetZeroCopy(zeroCopy bool) {
+	u.ZeroCopy = zeroCopy
+}
+
+// 设置是否通过内存映射读取分片，适合超大文件，减少一次内存拷贝
+func (u *Uploader) SetMmap(mmap bool) {
+	u.Mmap = mmap
+}
+
+// 设置服务端重命名文件时的回调
+func (u *Uploader) SetRenameHandler(renameHandler func(string, string)) {
+	u.RenameHandler = renameHandler
+}
+
+// 设置限速器，多个上传任务可共享同一个限速器
+func (u *Uploader) SetRateLimiter(rateLimiter *fileUtil.RateLimiter) {
+	u.RateLimiter = rateLimiter
+}
+
+// 设置上传限速，单位字节/秒，为0时不限速，使用本Uploader独占的限速器，多个任务共同限速时使用SetRateLimiter
+func (u *Uploader) SetMaxUploadRate(bytesPerSecond int64) {
+	u.RateLimiter = fileUtil.NewRateLimiter(bytesPerSecond)
+}
+
+// 设置superfile2分片上传的type参数
+func (u *Uploader) SetUploadType(uploadType string) {
+	u.UploadType = uploadType
+}
+
+// 设置网盘路径的Unicode规范化形式，macOS下的文件名为NFD形式，一般设置为norm.NFC与服务端保持一致
+func (u *Uploader) SetNormalization(form norm.Form) {
+	u.Normalization = form
+}
+
+// 请求中使用的网盘路径，按Normalization规范化
+func (u *Uploader) remotePath() string {
+	return u.Normalization.String(u.Path)
+}
+
+// 设置是否校验分片md5，开启后分片在传输中损坏时立即重试，而不是等到创建文件时才失败
+// ZeroCopy模式下计算md5需要额外读取一次分片
+func (u *Uploader) SetSliceMd5Check(sliceMd5Check bool) {
+	u.SliceMd5Check = sliceMd5Check
+}
+
+// 设置网盘路径锁，多个Uploader共享同一个锁时，同一网盘路径同时只能有一个任务上传，其余任务返回RemotePathLockedError，可通过errors.Is(err, ErrRemotePathLocked)判断
+func (u *Uploader) SetPathLocker(pathLocker fileUtil.PathLocker) {
+	u.PathLocker = pathLocker
+}
+
+// 设置预创建前是否创建网盘上级目录，dirCache不为nil时同一批次的上传只创建一次
+func (u *Uploader) SetEnsureRemoteDir(enable bool, dirCache *RemoteDirCache) {
+	u.EnsureDir = enable
+	u.DirCache = dirCache
+}
+
+// 设置本地文件hash缓存，未修改的文件直接使用缓存的md5和分片md5
+func (u *Uploader) SetHashCache(hashCache *fileUtil.HashCache) {
+	u.HashCache = hashCache
+}
+
+// 设置差异上传，网盘文件上次上传的分片md5记录在blockIndex中，大文件少量修改(如追加日志)时只上传修改和新增的分片
+// 服务端不接受未在本次uploadid上传的分片时，创建文件前补传这些分片
+func (u *Uploader) SetDifferential(enable bool, blockIndex *fileUtil.BlockIndex) {
+	u.Differential = enable
+	u.BlockIndex = blockIndex
+}
+
+// 设置审计日志，记录上传结果
+func (u *Uploader) SetAudit(auditWriter *audit.Writer) {
+	u.Audit = auditWriter
+}
+
+// 设置分片上传请求各阶段的超时，如连接10秒、写入10分钟、等待响应30秒，阶段超时的分片会重试
+func (u *Uploader) SetSliceTimeouts(timeouts fileUtil.UploadTimeouts) {
+	u.SliceTimeouts = timeouts
+}
+
+// 设置警告回调，上传可以继续但结果可能不符合预期时调用，如获取用户信息失败导致分片大小降为4M
+func (u *Uploader) SetWarnHandler(warnHandler func(error)) {
+	u.WarnHandler = warnHandler
+}
+
+func (u *Uploader) warn(err error) {
+	log.Println("upload warning:", err)
+	if u.WarnHandler != nil {
+		u.WarnHandler(err)
+	}
+}
+
+// 设置xpan创建文件失败时是否使用旧版createsuperfile接口创建文件，兼容较早申请的应用
+func (u *Uploader) SetFallback(fallback bool) {
+	u.Fallback = fallback
+}
+
+// 获取网盘路径锁，返回释放锁的函数
+func (u *Uploader) lockPath() (func(), error) {
+	if u.PathLocker == nil {
+		return func() {}, nil
+	}
+	remotePath := u.remotePath()
+	locked, err := u.PathLocker.TryLock(remotePath)
+	if err != nil {
+		log.Printf("upload pathLocker.TryLock failed path: %s err: %v", remotePath, err)
+		return nil, err
+	}
+	if !locked {
+		log.Printf("upload remote path locked by another job path: %s", remotePath)
+		return nil, &fileUtil.RemotePathLockedError{Path: remotePath}
+	}
+	return func() {
+		if err := u.PathLocker.Unlock(remotePath); err != nil {
+			log.Printf("upload pathLocker.Unlock failed path: %s err: %v", remotePath, err)
+		}
+	}, nil
+}
+
+// 检查服务端保存的路径是否与请求的路径一致
+func (u *Uploader) checkRenamed(ret *UploadResponse) {
+	if ret.Path == "" || ret.Path == u.remotePath() {
+		return
+	}
+	log.Printf("upload file renamed by server path: %s renamedTo: %s", u.Path, ret.Path)
+	ret.RenamedTo = ret.Path
+	if u.RenameHandler != nil {
+		u.RenameHandler(u.Path, ret.Path)
+	}
+}
+
+// 上传文件到网盘，包括预创建、分片上传、创建3个步骤，返回的快照中记录传输结束的原因
 func (u *Uploader) Upload(ctx context.Context, progressHandler UploadProgressHandler) (UploadResponse, fileUtil.UploadSnapshot, error) {
+	result, snapshot, err := u.UploadWithResult(ctx, progressHandler)
+	return result.UploadResponse, snapshot, err
+}
+
+// 上传文件到网盘，同时返回各阶段耗时和重试统计
+// 进度回调在同一goroutine中按上报顺序依次调用，返回前全部回调已执行完
+func (u *Uploader) UploadWithResult(ctx context.Context, progressHandler UploadProgressHandler) (UploadResult, fileUtil.UploadSnapshot, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	ctx = u.Audit.Context(ctx)
+	startTime := time.Now()
+	dispatcher := fileUtil.NewProgressDispatcher(u.progressHandler(progressHandler))
+	defer dispatcher.Close()
+	progressHandler = dispatcher.Handle
+	result := UploadResult{}
+	unlock, err := u.lockPath()
+	if err != nil {
+		snapshot := fileUtil.UploadSnapshot{Path: u.Path, LocalPath: u.LocalFilePath, Status: fileUtil.ClassifyStatus(err)}
+		u.audit(startTime, UploadResponse{}, snapshot, err)
+		return result, snapshot, err
+	}
+	defer unlock()
+
+	ret, snapshot, err := u.upload(ctx, progressHandler, &result)
+	snapshot.Status = fileUtil.ClassifyStatus(err)
+	snapshot.UpdatedAt = time.Now().Unix()
+	u.audit(startTime, ret, snapshot, err)
+	u.finishCheckpoint(snapshot)
+	result.UploadResponse = ret
+	result.finish(startTime)
+	return result, snapshot, err
+}
+
+// 计算总耗时和分片上传速度
+func (r *UploadResult) finish(startTime time.Time) {
+	r.Duration = time.Since(startTime)
+	if r.TransferDuration > 0 {
+		r.SlicesPerSecond = float64(r.SlicesUploaded) / r.TransferDuration.Seconds()
+	}
+}
+
+// 上传过程中使用的文件客户端，接口请求同样记录到审计日志
+func (u *Uploader) fileClient() *File {
+	f := NewFileClient(u.AccessToken)
+	f.SetAudit(u.Audit)
+	return f
+}
+
+// 记录上传结果到审计日志
+func (u *Uploader) audit(startTime time.Time, ret UploadResponse, snapshot fileUtil.UploadSnapshot, err error) {
+	bytes := snapshot.DoneSize
+	if err == nil {
+		bytes = ret.Size
+	}
+	record := audit.Record{
+		Type:      audit.TypeUpload,
+		Path:      u.Path,
+		LocalPath: u.LocalFilePath,
+		FsID:      ret.FsID,
+		Status:    string(snapshot.Status),
+		Bytes:     bytes,
+	}
+	if auditErr := u.Audit.WriteTransfer(record, startTime, err); auditErr != nil {
+		log.Println("audit WriteTransfer failed, err:", auditErr)
+	}
+}
+
+func (u *Uploader) upload(ctx context.Context, progressHandler UploadProgressHandler, result *UploadResult) (UploadResponse, fileUtil.UploadSnapshot, error) {
 	var ret UploadResponse
 	retSnapshot := fileUtil.UploadSnapshot{}
 	retSnapshot.Path = u.Path
 	retSnapshot.LocalPath = u.LocalFilePath
 
+	if err := u.ensureRemoteDir(); err != nil {
+		return ret, retSnapshot, err
+	}
+
 	//1. file precreate
+	phaseStart := time.Now()
 	preCreateRes, err := u.PreCreate(ctx, progressHandler)
+	result.HashDuration = time.Since(phaseStart)
 	if err != nil {
 		log.Println("PreCreate failed, err: ", err)
 		ret.ErrorCode = preCreateRes.ErrorCode
@@ -108,26 +455,28 @@ func (u *Uploader) Upload(ctx context.Context, progressHandler UploadProgressHan
 	retSnapshot.FileMd5 = u.FileInfo.Md5
 	retSnapshot.FileModTime = u.FileInfo.ModTime
 	retSnapshot.UploadId = preCreateRes.UploadID
+	retSnapshot.CreatedAt = time.Now().Unix()
 
 	if preCreateRes.ReturnType == 2 { //云端已存在相同文件，直接上传成功，无需请求后面的分片上传和创建文件接口
 		preCreateRes.Info.ErrorCode = preCreateRes.ErrorCode
 		preCreateRes.Info.ErrorMsg = preCreateRes.ErrorMsg
 		preCreateRes.Info.RequestID = preCreateRes.RequestID
 		progressHandler(2, preCreateRes.Info.Size, preCreateRes.Info.Size)
+		result.RapidUpload = true
 		retSnapshot.DoneSize = preCreateRes.Info.Size
 		retSnapshot.TotalSize = preCreateRes.Info.Size
+		u.recordBlocks(u.SliceSize, u.preCreateList)
+		u.checkRenamed(&preCreateRes.Info)
 		return preCreateRes.Info, retSnapshot, nil
 	}
 	uploadID := preCreateRes.UploadID
 
-	UploadLock.Lock()
-	defer UploadLock.Unlock()
-
 	//2. superfile2 upload
+	phaseStart = time.Now()
 	fileInfo, _ := u.GetFileInfo(false)
 	retSnapshot.TotalSize = fileInfo.Size
 	fileSize := fileInfo.Size
-	sliceSize, err := u.GetSliceSize(fileSize)
+	sliceSize, err := u.getSliceSize(ctx, fileSize)
 	if err != nil {
 		log.Println("GetSliceSize failed, err: ", err)
 		return ret, retSnapshot, err
@@ -135,13 +484,27 @@ func (u *Uploader) Upload(ctx context.Context, progressHandler UploadProgressHan
 
 	sliceNum := int(math.Ceil(float64(fileSize) / float64(sliceSize)))
 	retSnapshot.SliceSize = sliceSize
+	retSnapshot.SliceBasis = u.sliceBasis
+	if retSnapshot.SliceBasis == "" {
+		retSnapshot.SliceBasis = fileUtil.SliceBasisCustom
+	}
 	retSnapshot.SliceNum = sliceNum
+	reused := u.reusableBlocks(preCreateRes.BlockList, sliceSize, sliceNum)
+	result.SlicesReused = len(reused)
 	var doneSize int64 = 0
+	for i := range reused {
+		doneSize += sliceLen(i, sliceSize, fileSize)
+	}
 	var progressLock sync.Mutex
 	progressTick := time.Now()
 	internalProgressHandler := func(size int64) {
 		progressLock.Lock()
 		defer progressLock.Unlock()
+		if size < 0 { //分片失败，已上传的部分需要重新上传
+			result.BytesRetried -= size
+		} else {
+			result.BytesTransferred += size
+		}
 		doneSize += size
 		if doneSize > fileSize {
 			doneSize = fileSize
@@ -159,48 +522,78 @@ func (u *Uploader) Upload(ctx context.Context, progressHandler UploadProgressHan
 		return ret, retSnapshot, err
 	}
 	defer localFile.Close()
+	mappedFile := u.mapLocalFile(localFile)
+	if mappedFile != nil {
+		defer mappedFile.Close()
+	}
+	if len(reused) > 0 {
+		log.Printf("upload differential reuse slices: %d sliceNum: %d path: %s", len(reused), sliceNum, u.Path)
+		progressHandler(2, doneSize, fileSize)
+	}
+	checkpoint := u.newCheckpoint(retSnapshot)
+	blockList := make([]string, sliceNum)
 	uploadRespChan := make(chan UploadPartResponse, sliceNum)
-	sem := make(chan int, 2) //限制并发数，以防大文件上传导致占用服务器大量内存
-	hasFailed := false
+	sem := make(chan int, u.concurrency())   //限制并发数，以防大文件上传导致占用服务器大量内存
+	buffers := make(chan int, u.readAhead()) //已读取未上传完的分片数，上传结束后释放，读取速度超过上传速度时阻塞读取
+	//任一分片失败时取消其余分片，不再开始新的分片
+	group, sliceCtx := fileUtil.NewFailGroup(ctx)
+	defer group.Cancel()
 	uploadSliceNum := 0
 	var uploadErr error
 	for i := 0; i < sliceNum; i++ {
-		if hasFailed {
-			break
-		}
-		select {
-		case <-ctx.Done():
+		if sliceCtx.Err() != nil {
 			uploadErr = ctx.Err()
-		default:
 			break
 		}
-		if uploadErr != nil {
+		if blockMd5, ok := reused[i]; ok {
+			blockList[i] = blockMd5
+			continue
+		}
+		buffers <- 1
+		if err := u.SliceLimiter.Acquire(sliceCtx); err != nil {
+			<-buffers
+			uploadErr = ctx.Err()
 			break
 		}
-		buffer := make([]byte, sliceSize)
-		n, err := localFile.Read(buffer[:])
-		if err != nil && err != io.EOF {
-			log.Printf("upload file.Read failed seq: %d localPath: %s err: %v", i, u.LocalFilePath, err)
-			uploadErr = err
+		buffer, section, err := u.readSlice(localFile, mappedFile, int64(i)*sliceSize, sliceSize, fileSize)
+		if err != nil {
+			u.SliceLimiter.Release()
+			<-buffers
+			log.Printf("upload readSlice failed seq: %d localPath: %s err: %v", i, u.LocalFilePath, err)
+			group.Fail(err)
 			break
 		}
-		if n == 0 { //文件已读取结束
+		if section.Size() == 0 { //文件已读取结束
+			putSliceBuffer(buffer)
+			u.SliceLimiter.Release()
+			<-buffers
 			break
 		}
-		sem <- 1 //当通道已满的时候将被阻塞
-		go func(partSeq int, partByte []byte) {
-			uploadResp, err := u.TrySuperFile2Upload(ctx, uploadID, partSeq, partByte, internalProgressHandler)
+		//读取完成后即交给上传协程排队，读取只受buffers限制，上传数由sem单独限制
+		go func(partSeq int, buffer *[]byte, section *io.SectionReader) {
+			var uploadResp SuperFile2UploadResponse
+			var err error
+			select {
+			case sem <- 1: //当通道已满的时候将被阻塞
+				uploadResp, err = u.trySuperFile2Upload(sliceCtx, uploadID, partSeq, section, internalProgressHandler)
+				<-sem
+			case <-sliceCtx.Done():
+				err = sliceCtx.Err()
+			}
 			if err != nil {
 				log.Printf("upload TrySuperFile2Upload failed seq: %d path: %s err: %v", partSeq, u.Path, err)
-				hasFailed = true
+				group.Fail(err)
+			} else {
+				checkpoint.done(partSeq, uploadResp.Md5, section.Size())
 			}
-			uploadRespChan <- UploadPartResponse{uploadResp, int64(len(partByte)), err}
-			<-sem
-		}(i, buffer[0:n])
+			putSliceBuffer(buffer)
+			uploadRespChan <- UploadPartResponse{uploadResp, section.Size(), err}
+			u.SliceLimiter.Release()
+			<-buffers
+		}(i, buffer, section)
 		uploadSliceNum++
 	}
 
-	blockList := make([]string, sliceNum)
 	retSnapshot.Recoverable = true
 	retSnapshot.DoneSlices = make([]string, sliceNum)
 	for i := 0; i < uploadSliceNum; i++ {
@@ -223,28 +616,99 @@ func (u *Uploader) Upload(ctx context.Context, progressHandler UploadProgressHan
 		}
 		blockList[partSeq] = partResp.Response.Md5
 		retSnapshot.DoneSlices[partSeq] = partResp.Response.Md5
+		result.SlicesUploaded++
 		retSnapshot.DoneSize += partResp.Size
 		log.Printf("upload done seq: %d partSize: %d doneSize: %d totalSize: %d path: %s", partSeq, partResp.Size, retSnapshot.DoneSize, retSnapshot.TotalSize, u.Path)
 	}
+	result.TransferDuration = time.Since(phaseStart)
+	if err := group.Err(); err != nil { //其余分片因取消而失败，返回第一个失败分片的错误
+		uploadErr = err
+	}
+	if err := ctx.Err(); err != nil { //分片因ctx结束而失败时返回ctx的错误，暂停时为ErrPaused
+		uploadErr = err
+	}
 	if uploadErr != nil {
 		return ret, retSnapshot, uploadErr
 	}
 
 	//3. file create
-	superFile2CommitRes, err := u.Create(ctx, uploadID, blockList)
+	phaseStart = time.Now()
+	superFile2CommitRes, err := u.commit(ctx, uploadID, blockList)
+	if err != nil && len(reused) > 0 && ctx.Err() == nil { //服务端不接受复用的分片，补传后重新创建文件
+		log.Printf("upload differential commit failed, upload reused slices path: %s err: %v", u.Path, err)
+		if err = u.uploadReused(ctx, localFile, mappedFile, uploadID, reused, sliceSize, fileSize, internalProgressHandler, &retSnapshot, result); err == nil {
+			superFile2CommitRes, err = u.commit(ctx, uploadID, blockList)
+		}
+	}
+	result.CommitDuration = time.Since(phaseStart)
 	if err != nil {
 		log.Printf("upload SuperFile2Commit failed path: %s err: %v", u.Path, err)
 		return superFile2CommitRes, retSnapshot, err
 	}
 
 	retSnapshot.Recoverable = false
+	u.recordBlocks(sliceSize, blockList)
+	u.checkRenamed(&superFile2CommitRes)
 	return superFile2CommitRes, retSnapshot, nil
 }
 
 // 从断点继续上传文件到网盘
 func (u *Uploader) ResumeUpload(ctx context.Context, snapshot fileUtil.UploadSnapshot, progressHandler UploadProgressHandler) (UploadResponse, fileUtil.UploadSnapshot, error) {
-	UploadLock.Lock()
-	defer UploadLock.Unlock()
+	result, retSnapshot, err := u.ResumeUploadWithResult(ctx, snapshot, progressHandler)
+	return result.UploadResponse, retSnapshot, err
+}
+
+// 从断点继续上传文件到网盘，同时返回各阶段耗时和重试统计
+func (u *Uploader) ResumeUploadWithResult(ctx context.Context, snapshot fileUtil.UploadSnapshot, progressHandler UploadProgressHandler) (UploadResult, fileUtil.UploadSnapshot, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	ctx = u.Audit.Context(ctx)
+	startTime := time.Now()
+	dispatcher := fileUtil.NewProgressDispatcher(u.progressHandler(progressHandler))
+	defer dispatcher.Close()
+	progressHandler = dispatcher.Handle
+	result := UploadResult{}
+	unlock, err := u.lockPath()
+	if err != nil {
+		snapshot.Status = fileUtil.ClassifyStatus(err)
+		snapshot.UpdatedAt = time.Now().Unix()
+		u.audit(startTime, UploadResponse{}, snapshot, err)
+		return result, snapshot, err
+	}
+	defer unlock()
+
+	ret, retSnapshot, err := u.resumeUpload(ctx, snapshot, progressHandler, &result)
+	if uploadIDExpired(err) && ctx.Err() == nil { //uploadid过期后只能重新预创建，按当前会员身份重新计算分片大小
+		log.Printf("resumeUpload upload id expired, restart upload path: %s err: %v", u.Path, err)
+		u.warn(fmt.Errorf("%w: %v", ErrUploadIDExpired, err))
+		u.SliceSize = 0
+		u.sliceBasis = ""
+		u.blockList = nil
+		ret, retSnapshot, err = u.upload(ctx, progressHandler, &result)
+	}
+	retSnapshot.Status = fileUtil.ClassifyStatus(err)
+	retSnapshot.UpdatedAt = time.Now().Unix()
+	u.audit(startTime, ret, retSnapshot, err)
+	u.finishCheckpoint(retSnapshot)
+	result.UploadResponse = ret
+	result.finish(startTime)
+	return result, retSnapshot, err
+}
+
+func (u *Uploader) resumeUpload(ctx context.Context, snapshot fileUtil.UploadSnapshot, progressHandler UploadProgressHandler, result *UploadResult) (UploadResponse, fileUtil.UploadSnapshot, error) {
+	if err := snapshot.ValidateSlices(); err != nil {
+		log.Printf("resumeUpload invalid snapshot path: %s err: %v", u.Path, err)
+		return UploadResponse{}, snapshot, err
+	}
+	//会员身份变化后分片大小可能不同，已上传的分片按快照的分片大小划分，续传时沿用快照的分片大小
+	if u.SliceSize > 0 && u.SliceSize != snapshot.SliceSize {
+		log.Printf("resumeUpload slice size changed, use snapshot slice size: %d current: %d basis: %s", snapshot.SliceSize, u.SliceSize, snapshot.SliceBasis)
+	}
+	u.SliceSize = snapshot.SliceSize
+	u.sliceBasis = snapshot.SliceBasis
+	u.blockList = nil
+
+	phaseStart := time.Now()
 
 	var ret UploadResponse
 	retSnapshot := snapshot
@@ -256,6 +720,11 @@ func (u *Uploader) ResumeUpload(ctx context.Context, snapshot fileUtil.UploadSna
 	internalProgressHandler := func(size int64) {
 		progressLock.Lock()
 		defer progressLock.Unlock()
+		if size < 0 { //分片失败，已上传的部分需要重新上传
+			result.BytesRetried -= size
+		} else {
+			result.BytesTransferred += size
+		}
 		doneSize += size
 		if doneSize > retSnapshot.TotalSize {
 			doneSize = retSnapshot.TotalSize
@@ -273,52 +742,73 @@ func (u *Uploader) ResumeUpload(ctx context.Context, snapshot fileUtil.UploadSna
 		return ret, retSnapshot, err
 	}
 	defer localFile.Close()
+	mappedFile := u.mapLocalFile(localFile)
+	if mappedFile != nil {
+		defer mappedFile.Close()
+	}
 	sliceNum := retSnapshot.SliceNum
+	checkpoint := u.newCheckpoint(retSnapshot)
 	uploadRespChan := make(chan UploadPartResponse, sliceNum)
-	sem := make(chan int, 2) //限制并发数，以防大文件上传导致占用服务器大量内存
-	hasFailed := false
+	sem := make(chan int, u.concurrency())   //限制并发数，以防大文件上传导致占用服务器大量内存
+	buffers := make(chan int, u.readAhead()) //已读取未上传完的分片数，上传结束后释放，读取速度超过上传速度时阻塞读取
+	//任一分片失败时取消其余分片，不再开始新的分片
+	group, sliceCtx := fileUtil.NewFailGroup(ctx)
+	defer group.Cancel()
 	uploadSliceNum := 0
 	var offset int64 = 0
 	var uploadErr error
 	for i := 0; i < sliceNum; i++ {
-		if hasFailed {
-			break
-		}
-		select {
-		case <-ctx.Done():
+		if sliceCtx.Err() != nil {
 			uploadErr = ctx.Err()
-		default:
-			break
-		}
-		if uploadErr != nil {
 			break
 		}
 		if retSnapshot.DoneSlices[i] != "" {
 			offset += retSnapshot.SliceSize
 			continue
 		}
-		localFile.Seek(offset, 0)
-		buffer := make([]byte, snapshot.SliceSize)
-		n, err := localFile.Read(buffer[:])
-		offset += int64(n)
-		if err != nil && err != io.EOF {
-			log.Printf("resumeUpload file.Read failed seq: %d localPath: %s err: %v", i, u.LocalFilePath, err)
-			uploadErr = err
+		buffers <- 1
+		if err := u.SliceLimiter.Acquire(sliceCtx); err != nil {
+			<-buffers
+			uploadErr = ctx.Err()
+			break
+		}
+		buffer, section, err := u.readSlice(localFile, mappedFile, offset, snapshot.SliceSize, retSnapshot.TotalSize)
+		if err != nil {
+			u.SliceLimiter.Release()
+			<-buffers
+			log.Printf("resumeUpload readSlice failed seq: %d localPath: %s err: %v", i, u.LocalFilePath, err)
+			group.Fail(err)
 			break
 		}
-		if n == 0 { //文件已读取结束
+		offset += section.Size()
+		if section.Size() == 0 { //文件已读取结束
+			putSliceBuffer(buffer)
+			u.SliceLimiter.Release()
+			<-buffers
 			break
 		}
-		sem <- 1 //当通道已满的时候将被阻塞
-		go func(partSeq int, partByte []byte) {
-			uploadResp, err := u.TrySuperFile2Upload(ctx, retSnapshot.UploadId, partSeq, partByte, internalProgressHandler)
+		//读取完成后即交给上传协程排队，读取只受buffers限制，上传数由sem单独限制
+		go func(partSeq int, buffer *[]byte, section *io.SectionReader) {
+			var uploadResp SuperFile2UploadResponse
+			var err error
+			select {
+			case sem <- 1: //当通道已满的时候将被阻塞
+				uploadResp, err = u.trySuperFile2Upload(sliceCtx, retSnapshot.UploadId, partSeq, section, internalProgressHandler)
+				<-sem
+			case <-sliceCtx.Done():
+				err = sliceCtx.Err()
+			}
 			if err != nil {
 				log.Printf("resumeUpload TrySuperFile2UploadFailed seq: %d path: %s err: %v", partSeq, u.Path, err)
-				hasFailed = true
+				group.Fail(err)
+			} else {
+				checkpoint.done(partSeq, uploadResp.Md5, section.Size())
 			}
-			uploadRespChan <- UploadPartResponse{uploadResp, int64(len(partByte)), err}
-			<-sem
-		}(i, buffer[0:n])
+			putSliceBuffer(buffer)
+			uploadRespChan <- UploadPartResponse{uploadResp, section.Size(), err}
+			u.SliceLimiter.Release()
+			<-buffers
+		}(i, buffer, section)
 		uploadSliceNum++
 	}
 
@@ -342,27 +832,155 @@ func (u *Uploader) ResumeUpload(ctx context.Context, snapshot fileUtil.UploadSna
 		}
 		retSnapshot.DoneSlices[partSeq] = partResp.Response.Md5
 		retSnapshot.DoneSize += partResp.Size
+		result.SlicesUploaded++
 		log.Printf("resumeUpload done seq: %d partSize: %d doneSize: %d totalSize: %d path: %s", partSeq, partResp.Size, retSnapshot.DoneSize, retSnapshot.TotalSize, u.Path)
 	}
+	result.TransferDuration = time.Since(phaseStart)
+	if err := group.Err(); err != nil { //其余分片因取消而失败，返回第一个失败分片的错误
+		uploadErr = err
+	}
+	if err := ctx.Err(); err != nil { //分片因ctx结束而失败时返回ctx的错误，暂停时为ErrPaused
+		uploadErr = err
+	}
 	if uploadErr != nil {
 		return ret, retSnapshot, uploadErr
 	}
 
 	blockList := make([]string, sliceNum)
 	copy(blockList, retSnapshot.DoneSlices)
-	superFile2CommitRes, err := u.Create(ctx, retSnapshot.UploadId, blockList)
+	phaseStart = time.Now()
+	superFile2CommitRes, err := u.commit(ctx, retSnapshot.UploadId, blockList)
+	result.CommitDuration = time.Since(phaseStart)
 	if err != nil {
 		log.Printf("resumeUpload SuperFile2Commit failed path: %s err: %v", u.Path, err)
 		return superFile2CommitRes, retSnapshot, err
 	}
 
 	retSnapshot.Recoverable = false
+	u.recordBlocks(retSnapshot.SliceSize, blockList)
+	u.checkRenamed(&superFile2CommitRes)
 	return superFile2CommitRes, retSnapshot, nil
 }
 
+// 差异上传时可复用的分片，包括与BlockIndex记录相同的分片和预创建返回的block_list中不需要上传的分片
+func (u *Uploader) reusableBlocks(needed []int, sliceSize int64, sliceNum int) map[int]string {
+	reused := map[int]string{}
+	if !u.Differential || len(u.preCreateList) != sliceNum {
+		return reused
+	}
+	if entry, ok := u.BlockIndex.Get(u.Path); ok {
+		reused = entry.Unchanged(sliceSize, u.preCreateList)
+	}
+	if len(needed) > 0 && len(needed) < sliceNum {
+		neededSet := make(map[int]bool, len(needed))
+		for _, i := range needed {
+			neededSet[i] = true
+		}
+		for i, blockMd5 := range u.preCreateList {
+			if !neededSet[i] {
+				reused[i] = blockMd5
+			}
+		}
+	}
+	return reused
+}
+
+// 补传差异上传时复用的分片，按顺序逐个上传
+func (u *Uploader) uploadReused(ctx context.Context, localFile *os.File, mappedFile *fileUtil.MappedFile, uploadID string, reused map[int]string, sliceSize, fileSize int64, progressHandler func(int64), snapshot *fileUtil.UploadSnapshot, result *UploadResult) error {
+	seqs := make([]int, 0, len(reused))
+	for i := range reused {
+		seqs = append(seqs, i)
+	}
+	sort.Ints(seqs)
+	for _, i := range seqs {
+		buffer, section, err := u.readSlice(localFile, mappedFile, int64(i)*sliceSize, sliceSize, fileSize)
+		if err != nil {
+			log.Printf("uploadReused readSlice failed seq: %d localPath: %s err: %v", i, u.LocalFilePath, err)
+			return err
+		}
+		uploadResp, err := u.trySuperFile2Upload(ctx, uploadID, i, section, progressHandler)
+		putSliceBuffer(buffer)
+		if err != nil {
+			log.Printf("uploadReused TrySuperFile2Upload failed seq: %d path: %s err: %v", i, u.Path, err)
+			return err
+		}
+		snapshot.DoneSlices[i] = uploadResp.Md5
+		snapshot.DoneSize += section.Size()
+		result.SlicesUploaded++
+		result.SlicesReused--
+	}
+	return nil
+}
+
+// 上传成功后记录网盘文件的分片md5
+func (u *Uploader) recordBlocks(sliceSize int64, blockList []string) {
+	if u.BlockIndex == nil || sliceSize <= 0 || len(blockList) == 0 {
+		return
+	}
+	list := make([]string, len(blockList))
+	copy(list, blockList)
+	u.BlockIndex.Put(u.Path, fileUtil.BlockIndexEntry{
+		Md5:       u.FileInfo.Md5,
+		Size:      u.FileInfo.Size,
+		SliceSize: sliceSize,
+		BlockList: list,
+	})
+}
+
+// 第i个分片的大小
+func sliceLen(i int, sliceSize, fileSize int64) int64 {
+	size := fileSize - int64(i)*sliceSize
+	if size > sliceSize {
+		size = sliceSize
+	}
+	if size < 0 {
+		size = 0
+	}
+	return size
+}
+
+// 开启Mmap时映射本地文件，映射失败时返回nil，使用普通读取
+func (u *Uploader) mapLocalFile(localFile *os.File) *fileUtil.MappedFile {
+	if !u.Mmap {
+		return nil
+	}
+	mappedFile, err := fileUtil.MapFile(localFile)
+	if err != nil {
+		log.Printf("upload fileUtil.MapFile failed, fallback to read, localPath: %s err: %v", u.LocalFilePath, err)
+		return nil
+	}
+	return mappedFile
+}
+
+// 读取offset开始的一个分片，ZeroCopy模式下直接返回文件的section，mappedFile不为nil时返回映射内存的section，都不占用缓冲区
+func (u *Uploader) readSlice(localFile *os.File, mappedFile *fileUtil.MappedFile, offset, sliceSize, fileSize int64) (*[]byte, *io.SectionReader, error) {
+	size := fileSize - offset
+	if size > sliceSize {
+		size = sliceSize
+	}
+	if size < 0 {
+		size = 0
+	}
+	if mappedFile != nil {
+		return nil, io.NewSectionReader(mappedFile, offset, size), nil
+	}
+	if u.ZeroCopy {
+		return nil, io.NewSectionReader(localFile, offset, size), nil
+	}
+
+	buffer := getSliceBuffer(sliceSize)
+	n, err := localFile.ReadAt((*buffer)[:size], offset)
+	if err != nil && err != io.EOF {
+		putSliceBuffer(buffer)
+		return nil, nil, err
+	}
+	return buffer, bytesSection((*buffer)[:n]), nil
+}
+
 // preCreate
 func (u *Uploader) PreCreate(ctx context.Context, progressHandler UploadProgressHandler) (PreCreateResponse, error) {
 	ret := PreCreateResponse{}
+	progressHandler = u.progressHandler(progressHandler)
 
 	fileInfo, err := u.GetFileInfo(false)
 	if err != nil {
@@ -395,6 +1013,14 @@ func (u *Uploader) PreCreate(ctx context.Context, progressHandler UploadProgress
 		log.Println("getBlockList failed, err: ", err)
 		return ret, err
 	}
+	u.preCreateList = blockList
+	return u.preCreate(ctx, fileSize, fileMd5, sliceMd5, blockList)
+}
+
+// fileMd5和sliceMd5为空时不携带，不会触发秒传
+func (u *Uploader) preCreate(ctx context.Context, fileSize int64, fileMd5, sliceMd5 string, blockList []string) (PreCreateResponse, error) {
+	ret := PreCreateResponse{}
+
 	blockListByte, err := json.Marshal(blockList)
 	if err != nil {
 		return ret, err
@@ -403,14 +1029,18 @@ func (u *Uploader) PreCreate(ctx context.Context, progressHandler UploadProgress
 
 	// path urlencode
 	v := url.Values{}
-	v.Add("path", u.Path)
+	v.Add("path", u.remotePath())
 	v.Add("size", strconv.FormatInt(fileSize, 10))
 	v.Add("isdir", "0")
 	v.Add("autoinit", "1") // 固定值1
-	v.Add("rtype", "3")    // 3为覆盖
+	v.Add("rtype", u.Conflict.rtype())
 	v.Add("block_list", blockListStr)
-	v.Add("content-md5", fileMd5)
-	v.Add("slice-md5", sliceMd5)
+	if fileMd5 != "" {
+		v.Add("content-md5", fileMd5)
+	}
+	if sliceMd5 != "" {
+		v.Add("slice-md5", sliceMd5)
+	}
 	body := v.Encode()
 
 	requestUrl := conf.OpenApiDomain + PreCreateUri + "&access_token=" + u.AccessToken
@@ -421,11 +1051,24 @@ func (u *Uploader) PreCreate(ctx context.Context, progressHandler UploadProgress
 		return ret, err
 	}
 
-	respBody := resp.Body
+	if ret, err = decodePreCreateResponse(resp.Body); err != nil {
+		return ret, err
+	}
+
+	if ret.ErrorCode != 0 { //错误码不为0
+		return ret, u.conflictError(ret.ErrorCode, errors.New(fmt.Sprintf("error_code:%d, error_msg:%s", ret.ErrorCode, ret.ErrorMsg)))
+	}
+
+	return ret, nil
+}
+
+// 解析预创建接口的返回
+func decodePreCreateResponse(respBody []byte) (PreCreateResponse, error) {
+	ret := PreCreateResponse{}
 	if js, err := simplejson.NewJson(respBody); err == nil {
 		if info, isExist := js.CheckGet("info"); isExist { //秒传返回的request_id有可能是科学计数法，这里将它统一转成uint64
 			//{"return_type":2,"errno":0,"info":{"size":16877488,"category":4,"fs_id":714504460793248,"request_id":1.821160071156e+17,"path":"\/apps\/\u4e66\u68af\/easy_20210726_163824.pptx","isdir":0,"mtime":1627288705,"ctime":1627288705,"md5":"44090321ds594263c8818d7c398e5017"},"request_id":182116007115598010}
-			info.Set("request_id", uint64(info.Get("request_id").MustFloat64()))
+			info.Set("request_id", numberToUint64(info.Get("request_id")))
 			if respBody, err = js.Encode(); err != nil {
 				log.Println("simplejson Encode failed, err: ", err)
 				return ret, err
@@ -437,34 +1080,62 @@ func (u *Uploader) PreCreate(ctx context.Context, progressHandler UploadProgress
 		log.Println("json.Unmarshal failed, err: ", err)
 		return ret, err
 	}
+	return ret, nil
+}
 
-	if ret.ErrorCode != 0 { //错误码不为0
-		return ret, errors.New(fmt.Sprintf("error_code:%d, error_msg:%s", ret.ErrorCode, ret.ErrorMsg))
+// 将json数字转成uint64，整数直接解析，科学计数法按十进制高精度解析，不经过float64以免丢失低位
+// 不是数字或为负数时返回0，超出uint64范围时返回math.MaxUint64
+func numberToUint64(js *simplejson.Json) uint64 {
+	if v, err := js.Uint64(); err == nil {
+		return v
 	}
-
-	return ret, nil
+	number, ok := js.Interface().(json.Number)
+	if !ok {
+		return 0
+	}
+	f, _, err := big.ParseFloat(number.String(), 10, 128, big.ToZero)
+	if err != nil {
+		return 0
+	}
+	v, _ := f.Uint64()
+	return v
 }
 
 // 反复上传直到成功或超出重试次数
 func (u *Uploader) TrySuperFile2Upload(ctx context.Context, uploadID string, partSeq int, partByte []byte, progressHandler func(int64)) (SuperFile2UploadResponse, error) {
+	return u.trySuperFile2Upload(ctx, uploadID, partSeq, bytesSection(partByte), progressHandler)
+}
+
+func (u *Uploader) trySuperFile2Upload(ctx context.Context, uploadID string, partSeq int, section *io.SectionReader, progressHandler func(int64)) (SuperFile2UploadResponse, error) {
+	if progressHandler == nil {
+		progressHandler = func(int64) {}
+	}
 	var partDoneSize int64 = 0
 	internalProgressHandler := func(writtenSize int64) {
 		partDoneSize += writtenSize
 		progressHandler(writtenSize)
 	}
 	var resp SuperFile2UploadResponse
-	var err error
-	for i := 0; i < 10; i++ {
+	sliceMd5, err := u.sectionMd5(section)
+	if err != nil {
+		return resp, err
+	}
+	policy := u.retryPolicy()
+	for i := 0; i < policy.maxAttempts(); i++ {
 		if i > 0 {
-			time.Sleep(time.Second * 6)
+			select {
+			case <-ctx.Done():
+				return resp, err
+			case <-time.After(policy.Delay(i)):
+			}
 		}
-		resp, err = u.SuperFile2Upload(ctx, uploadID, partSeq, partByte, i, internalProgressHandler)
+		resp, err = u.superFile2Upload(ctx, uploadID, partSeq, section, sliceMd5, i, internalProgressHandler)
 		if err == nil {
 			break
 		}
 		progressHandler(-partDoneSize)
 		partDoneSize = 0
-		if ctx.Err() != nil {
+		if ctx.Err() != nil || !policy.Retryable(err) {
 			break
 		}
 	}
@@ -473,24 +1144,42 @@ func (u *Uploader) TrySuperFile2Upload(ctx context.Context, uploadID string, par
 
 // superfile2 upload
 func (u *Uploader) SuperFile2Upload(ctx context.Context, uploadID string, partSeq int, partByte []byte, tryIter int, progressHandler func(int64)) (SuperFile2UploadResponse, error) {
+	section := bytesSection(partByte)
+	sliceMd5, err := u.sectionMd5(section)
+	if err != nil {
+		return SuperFile2UploadResponse{}, err
+	}
+	return u.superFile2Upload(ctx, uploadID, partSeq, section, sliceMd5, tryIter, progressHandler)
+}
+
+// sliceMd5不为空时请求携带Content-MD5，并校验服务端返回的分片md5
+func (u *Uploader) superFile2Upload(ctx context.Context, uploadID string, partSeq int, section *io.SectionReader, sliceMd5 string, tryIter int, progressHandler func(int64)) (SuperFile2UploadResponse, error) {
 	ret := SuperFile2UploadResponse{}
 
-	path := u.Path
+	path := u.remotePath()
 	localFilePath := u.LocalFilePath
 
 	// path urlencode
 	v := url.Values{}
 	v.Add("access_token", u.AccessToken)
 	v.Add("path", path)
-	v.Add("type", "tmpfile")
+	uploadType := u.UploadType
+	if uploadType == "" {
+		uploadType = defaultUploadType
+	}
+	v.Add("type", uploadType)
 	v.Add("uploadid", uploadID)
 	v.Add("partseq", strconv.Itoa(partSeq))
 	queryParams := v.Encode()
 	uploadUrl := conf.PcsDataDomain + Superfile2UploadUri + "&" + queryParams
 	fileUploader := fileUtil.NewFileUploader(uploadUrl, localFilePath)
-	resp, err := fileUploader.UploadByByte(ctx, partByte, progressHandler)
+	fileUploader.SetRateLimiter(u.RateLimiter)
+	fileUploader.SetContentMd5(sliceMd5)
+	fileUploader.SetTimeouts(u.SliceTimeouts)
+	// 每次重试都从分片开头读取
+	resp, err := fileUploader.UploadBySection(ctx, io.NewSectionReader(section, 0, section.Size()), progressHandler)
 	if err != nil {
-		log.Printf("upload fileUploader.UploadByByte failed tryIter: %d seq: %d path: %s err: %v", tryIter, partSeq, path, err)
+		log.Printf("upload fileUploader.UploadBySection failed tryIter: %d seq: %d path: %s err: %v", tryIter, partSeq, path, err)
 		return ret, err
 	}
 
@@ -501,7 +1190,158 @@ func (u *Uploader) SuperFile2Upload(ctx context.Context, uploadID string, partSe
 
 	if ret.ErrorCode != 0 { //错误码不为0
 		log.Printf("upload failed tryIter: %d seq: %d path: %s response: %s", tryIter, partSeq, path, string(resp))
-		return ret, errors.New(fmt.Sprintf("error_code:%d, error_msg:%s", ret.ErrorCode, ret.ErrorMsg))
+		return ret, &errno.APIError{Code: ret.ErrorCode, Msg: ret.ErrorMsg, RequestID: ret.RequestID}
+	}
+
+	if sliceMd5 != "" && !strings.EqualFold(ret.Md5, sliceMd5) {
+		log.Printf("upload slice md5 mismatch tryIter: %d seq: %d path: %s expected: %s actual: %s", tryIter, partSeq, path, sliceMd5, ret.Md5)
+		return ret, errors.New(fmt.Sprintf("slice md5 mismatch, seq: %d expected: %s actual: %s", partSeq, sliceMd5, ret.Md5))
+	}
+
+	return ret, nil
+}
+
+// 计算分片md5，未开启SliceMd5Check时返回空
+func (u *Uploader) sectionMd5(section *io.SectionReader) (string, error) {
+	if !u.SliceMd5Check {
+		return "", nil
+	}
+	hash := md5.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(section, 0, section.Size())); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func bytesSection(partByte []byte) *io.SectionReader {
+	return io.NewSectionReader(bytes.NewReader(partByte), 0, int64(len(partByte)))
+}
+
+// 创建文件，开启Fallback时xpan创建失败后使用旧版createsuperfile接口
+func (u *Uploader) commit(ctx context.Context, uploadID string, blockList []string) (UploadResponse, error) {
+	ret, err := u.createWithRetry(ctx, uploadID, blockList)
+	if err == nil || !u.Fallback || ctx.Err() != nil || errors.Is(err, ErrRemoteFileExists) {
+		return ret, err
+	}
+	log.Printf("upload create failed, fallback to createsuperfile path: %s err: %v", u.Path, err)
+	superFileRes, fallbackErr := u.CreateSuperFile(ctx, blockList)
+	if errors.Is(fallbackErr, ErrRemoteFileExists) {
+		return ret, fallbackErr
+	}
+	if fallbackErr != nil {
+		return ret, errors.New(fmt.Sprintf("%v; createsuperfile failed: %v", err, fallbackErr))
+	}
+	return UploadResponse{
+		CloudDiskResponseBase: conf.CloudDiskResponseBase{RequestID: superFileRes.RequestID},
+		Path:                  superFileRes.Path,
+		Name:                  path.Base(superFileRes.Path),
+		Size:                  superFileRes.Size,
+		Md5:                   superFileRes.Md5,
+		FsID:                  superFileRes.FsID,
+	}, nil
+}
+
+// 创建文件请求超时等结果未知的错误时重试，重试前先检查文件是否已创建成功，避免重复创建出"file(1).txt"等副本
+func (u *Uploader) createWithRetry(ctx context.Context, uploadID string, blockList []string) (UploadResponse, error) {
+	for i := 0; ; i++ {
+		ret, err := u.Create(ctx, uploadID, blockList)
+		var apiErr *errno.APIError
+		if err == nil || errors.As(err, &apiErr) || !errno.IsRetryable(err) || i >= createMaxRetries {
+			return ret, err
+		}
+		log.Printf("upload create result unknown, check remote file before retry path: %s err: %v", u.Path, err)
+		select {
+		case <-ctx.Done():
+			return ret, err
+		case <-time.After(createRetryInterval):
+		}
+		if existing, ok := u.findCommitted(ctx); ok {
+			log.Printf("upload create already succeeded path: %s fsID: %d", existing.Path, existing.FsID)
+			return existing, nil
+		}
+	}
+}
+
+// 查找已创建成功的文件，大小和md5与本地文件一致
+// 重名时自动重命名的策略下，"file(1).txt"等重命名后的文件同样视为已创建
+func (u *Uploader) findCommitted(ctx context.Context) (UploadResponse, bool) {
+	fileInfo, err := u.GetFileInfo(false)
+	if err != nil {
+		return UploadResponse{}, false
+	}
+	dir, name := path.Split(u.remotePath())
+	dir = path.Clean(dir)
+	matchName := func(itemName string) bool { return norm.Equal(itemName, name) }
+	if u.Conflict == ConflictRename {
+		nfcName := norm.NFCString(name)
+		ext := path.Ext(nfcName)
+		renamed := regexp.MustCompile("^" + regexp.QuoteMeta(strings.TrimSuffix(nfcName, ext)) + `\(\d+\)` + regexp.QuoteMeta(ext) + "$")
+		matchName = func(itemName string) bool {
+			itemName = norm.NFCString(itemName)
+			return itemName == nfcName || renamed.MatchString(itemName)
+		}
+	}
+
+	fileClient := u.fileClient()
+	for start := 0; ; start += listPageSize {
+		if ctx.Err() != nil {
+			return UploadResponse{}, false
+		}
+		res, err := fileClient.List(dir, start, listPageSize)
+		if err != nil {
+			log.Printf("upload findCommitted List failed dir: %s err: %v", dir, err)
+			return UploadResponse{}, false
+		}
+		for _, item := range res.List {
+			if item.IsDir == 1 || !matchName(item.ServerFileName) || int64(item.Size) != fileInfo.Size || !strings.EqualFold(item.Md5, fileInfo.Md5) {
+				continue
+			}
+			return UploadResponse{
+				Path:  item.Path,
+				Name:  item.ServerFileName,
+				Size:  int64(item.Size),
+				Md5:   item.Md5,
+				FsID:  item.FsID,
+				IsDir: item.IsDir,
+			}, true
+		}
+		if len(res.List) < listPageSize {
+			return UploadResponse{}, false
+		}
+	}
+}
+
+// 使用旧版createsuperfile接口合并分片创建文件
+func (u *Uploader) CreateSuperFile(ctx context.Context, blockList []string) (CreateSuperFileResponse, error) {
+	ret := CreateSuperFileResponse{}
+
+	param, err := json.Marshal(map[string][]string{"block_list": blockList})
+	if err != nil {
+		return ret, err
+	}
+
+	v := url.Values{}
+	v.Add("access_token", u.AccessToken)
+	v.Add("path", u.remotePath())
+	v.Add("ondup", u.Conflict.ondup())
+	requestUrl := conf.PcsApiDomain + CreateSuperFileUri + "&" + v.Encode()
+
+	body := url.Values{}
+	body.Add("param", string(param))
+	resp, err := httpclient.Post(ctx, requestUrl, map[string]string{}, body.Encode())
+	if err != nil {
+		log.Println("httpclient.Post failed, err:", err)
+		return ret, err
+	}
+
+	if err := json.Unmarshal(resp.Body, &ret); err != nil {
+		log.Printf("json.Unmarshal failed, resp[%s], err[%v]", string(resp.Body), err)
+		return ret, err
+	}
+
+	if ret.ErrorCode != 0 { //错误码不为0
+		log.Println("createsuperfile failed, resp:", string(resp.Body))
+		return ret, u.conflictError(ret.ErrorCode, errors.New(fmt.Sprintf("error_code:%d, error_msg:%s", ret.ErrorCode, ret.ErrorMsg)))
 	}
 
 	return ret, nil
@@ -525,12 +1365,12 @@ func (u *Uploader) Create(ctx context.Context, uploadID string, blockList []stri
 
 	// path urlencode
 	v := url.Values{}
-	v.Add("path", u.Path)
+	v.Add("path", u.remotePath())
 	v.Add("uploadid", uploadID)
 	v.Add("block_list", blockListStr)
 	v.Add("size", strconv.FormatInt(fileInfo.Size, 10))
 	v.Add("isdir", "0")
-	v.Add("rtype", "3") // 3为覆盖
+	v.Add("rtype", u.Conflict.rtype())
 	body := v.Encode()
 
 	requestUrl := conf.OpenApiDomain + CreateUri + "&access_token=" + u.AccessToken
@@ -549,7 +1389,7 @@ func (u *Uploader) Create(ctx context.Context, uploadID string, blockList []stri
 
 	if ret.ErrorCode != 0 { //错误码不为0
 		log.Println("file create failed, resp:", string(resp.Body))
-		return ret, errors.New(fmt.Sprintf("error_code:%d, error_msg:%s", ret.ErrorCode, ret.ErrorMsg))
+		return ret, u.conflictError(ret.ErrorCode, &errno.APIError{Code: ret.ErrorCode, Msg: ret.ErrorMsg, RequestID: ret.RequestID})
 	}
 
 	return ret, nil
@@ -557,6 +1397,17 @@ func (u *Uploader) Create(ctx context.Context, uploadID string, blockList []stri
 
 // 获取分片的大小
 func (u *Uploader) GetSliceSize(fileSize int64) (int64, error) {
+	return u.getSliceSize(context.Background(), fileSize)
+}
+
+// 续传失败的原因是uploadid过期，服务端找不到之前上传的分片
+func uploadIDExpired(err error) bool {
+	code, ok := errno.CodeOf(err)
+	return ok && (code == errno.CodeSliceMissing || code == errno.CodeCreateNotFound)
+}
+
+// 获取用户信息失败时分片大小降为4M，通过WarnHandler回调ErrSliceSizeDowngraded
+func (u *Uploader) getSliceSize(ctx context.Context, fileSize int64) (int64, error) {
 	if u.SliceSize > 0 {
 		return u.SliceSize, nil
 	}
@@ -571,28 +1422,38 @@ func (u *Uploader) GetSliceSize(fileSize int64) (int64, error) {
 	*/
 	//切割文件，单个分片大小暂时先固定为4M，TODO 普通会员和超级会员单个分片可以更大，需判断用户的身份
 	sliceSize = 4194304 //4M
+	basis := fileUtil.SliceBasisDefault
 	accountClient := account.NewAccountClient(u.AccessToken)
-	userInfo, err := accountClient.UserInfo()
-	if err != nil { //获取失败直接用4M
+	userInfo, err := accountClient.UserInfoWithContext(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		//获取失败用4M，并记录到SliceSize，保证同一次上传的分片大小不变
 		log.Println("account.UserInfo failed, err:", err)
-		return sliceSize, nil
-	}
-	if userInfo.VipType == 1 { //普通会员
+		u.warn(fmt.Errorf("%w: %v", ErrSliceSizeDowngraded, err))
+	} else if userInfo.VipType == 1 { //普通会员
 		sliceSize = 16777216 //16M
+		basis = fileUtil.SliceBasisVip
 	} else if userInfo.VipType == 2 { //超级会员
 		sliceSize = 33554432 //32M
+		basis = fileUtil.SliceBasisSvip
 	}
 
 	if fileSize <= sliceSize { //无须切片
 		sliceSize = fileSize
 	}
 	u.SliceSize = sliceSize
+	u.sliceBasis = basis
 
 	return sliceSize, nil
 }
 
 // 获取block_list
 func (u *Uploader) getBlockList(ctx context.Context, progressHandler func(int64)) ([]string, error) {
+	if len(u.blockList) > 0 {
+		return u.blockList, nil
+	}
 	blockList := []string{}
 	filePath := u.LocalFilePath
 	fileInfo, err := u.GetFileInfo(false)
@@ -603,7 +1464,7 @@ func (u *Uploader) getBlockList(ctx context.Context, progressHandler func(int64)
 	fileSize := fileInfo.Size
 	fileMd5 := fileInfo.Md5
 
-	sliceSize, err := u.GetSliceSize(fileSize)
+	sliceSize, err := u.getSliceSize(ctx, fileSize)
 	if err != nil {
 		log.Println("GetSliceSize failed, err:", err)
 		return blockList, err
@@ -620,6 +1481,15 @@ func (u *Uploader) getBlockList(ctx context.Context, progressHandler func(int64)
 		return blockList, err
 	}
 	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return blockList, err
+	}
+	cacheKey, modTime := u.hashCacheKey(stat)
+	if entry, ok := u.HashCache.Get(cacheKey, stat.Size(), modTime); ok && entry.SliceSize == sliceSize && len(entry.BlockList) > 0 {
+		progressHandler(fileSize)
+		return entry.BlockList, nil
+	}
 
 	for {
 		select {
@@ -643,6 +1513,11 @@ func (u *Uploader) getBlockList(ctx context.Context, progressHandler func(int64)
 		progressHandler(int64(n))
 	}
 
+	u.HashCache.Update(cacheKey, stat.Size(), modTime, func(entry *fileUtil.HashEntry) {
+		entry.Md5 = fileMd5
+		entry.SliceSize = sliceSize
+		entry.BlockList = blockList
+	})
 	return blockList, nil
 }
 
@@ -663,6 +1538,12 @@ func (u *Uploader) GetFileInfo(simpleMode bool) (LocalFileInfo, error) {
 	}
 	info.Size = fileInfo.Size()
 	info.ModTime = fileInfo.ModTime().Unix()
+	cacheKey, modTime := u.hashCacheKey(fileInfo)
+	if entry, ok := u.HashCache.Get(cacheKey, info.Size, modTime); ok && !simpleMode {
+		info.Md5 = entry.Md5
+		u.FileInfo = info
+		return info, nil
+	}
 	if !simpleMode {
 		hash, fileBuf := md5.New(), make([]byte, 1<<20)
 		for {
@@ -681,25 +1562,26 @@ func (u *Uploader) GetFileInfo(simpleMode bool) (LocalFileInfo, error) {
 		}
 		fileMd5 := hex.EncodeToString(hash.Sum(nil))
 		info.Md5 = fileMd5
+		u.HashCache.Update(cacheKey, info.Size, modTime, func(entry *fileUtil.HashEntry) {
+			entry.Md5 = fileMd5
+		})
 	}
 	u.FileInfo = info
 	return info, nil
 }
 
-// 特殊字符处理，文件名里有特殊字符时无法上传到网盘，特殊字符有'\\', '?', '|', '"', '>', '<', ':', '*',"\t","\n","\r","\0","\x0B"
+// 特殊字符处理，见fileUtil.HandleSpecialChar
 func handleSpecialChar(char string) string {
-	specialChars := []string{"\\\\", "?", "|", "\"", ">", "<", ":", "*", "\t", "\n", "\r", "\\0", "\\x0B"}
-
-	newChar := char
-	for _, specialChar := range specialChars {
-		newChar = strings.Replace(newChar, specialChar, "", -1)
-	}
+	return fileUtil.HandleSpecialChar(char)
+}
 
-	if newChar != char {
-		fmt.Printf("char has handle, origin[%s] handled[%s]", char, newChar)
+// hash缓存的key为本地文件的绝对路径，修改时间精确到纳秒
+func (u *Uploader) hashCacheKey(fileInfo os.FileInfo) (string, int64) {
+	cacheKey, err := filepath.Abs(u.LocalFilePath)
+	if err != nil {
+		cacheKey = u.LocalFilePath
 	}
-
-	return newChar
+	return cacheKey, fileInfo.ModTime().UnixNano()
 }
 
 // 获取分片的md5值
@@ -726,12 +1608,24 @@ func (u *Uploader) getSliceMd5() (string, error) {
 			return sliceMd5, err
 		}
 		defer file.Close()
+		stat, err := file.Stat()
+		if err != nil {
+			return sliceMd5, err
+		}
+		cacheKey, modTime := u.hashCacheKey(stat)
+		if entry, ok := u.HashCache.Get(cacheKey, stat.Size(), modTime); ok && entry.SliceMd5 != "" {
+			return entry.SliceMd5, nil
+		}
 
 		partBuffer := make([]byte, sliceSize)
 		if _, err := file.Read(partBuffer); err == nil {
 			hash := md5.New()
 			hash.Write(partBuffer)
 			sliceMd5 = hex.EncodeToString(hash.Sum(nil))
+			u.HashCache.Update(cacheKey, stat.Size(), modTime, func(entry *fileUtil.HashEntry) {
+				entry.Md5 = fileMd5
+				entry.SliceMd5 = sliceMd5
+			})
 		}
 	}
 
@@ -0,0 +1,324 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/jsyzchen/pan/account"
+	fileUtil "github.com/jsyzchen/pan/utils/file"
+	"github.com/jsyzchen/pan/utils/norm"
+)
+
+// 目录上传时符号链接的处理方式
+type SymlinkPolicy int
+
+const (
+	SymlinkSkip   SymlinkPolicy = iota // 跳过符号链接，记录在上传报告中
+	SymlinkFollow                      // 上传链接指向的文件或目录，指向已遍历过的目录时跳过，避免循环
+	SymlinkError                       // 遇到符号链接时上传失败
+)
+
+// 目录中存在符号链接且处理方式为SymlinkError
+var ErrSymlink = errors.New("symlink found in upload dir")
+
+// 网盘目录已被其他写入方锁定
+var ErrRemoteDirLocked = errors.New("remote dir is locked by another writer")
+
+// 跳过的符号链接
+type SkippedLink struct {
+	LocalPath string
+	Target    string
+	Reason    string // skip或cycle
+}
+
+// 上传失败的文件
+type DirUploadFailure struct {
+	LocalPath string
+	Path      string
+	Err       error
+}
+
+// 因网盘剩余空间不足跳过的文件
+type QuotaSkippedFile struct {
+	LocalPath string
+	Path      string
+	Size      int64
+}
+
+// 目录上传报告
+type DirUploadReport struct {
+	Uploaded     []UploadResponse
+	Failed       []DirUploadFailure
+	SkippedLinks []SkippedLink
+	SkippedQuota []QuotaSkippedFile // 开启QuotaAware时，剩余空间不足而跳过的文件
+	RequiredSize int64              // 需要上传的文件总大小
+	FreeSize     int64              // 上传前网盘的剩余空间，开启QuotaAware时有效
+}
+
+// 待上传的文件
+type dirUploadTask struct {
+	localPath  string
+	remotePath string
+	size       int64
+}
+
+type DirUploadProgressHandler = func(localPath string, status int, doneSize, totalSize int64)
+
+// DirUploader 上传本地目录到网盘，按目录结构逐个上传文件
+type DirUploader struct {
+	AccessToken     string
+	LocalDir        string
+	RemoteDir       string
+	SymlinkPolicy   SymlinkPolicy
+	Ignore          *fileUtil.IgnoreMatcher  // 排除规则，为nil时读取本地目录下的.panignore
+	RemoteLock      fileUtil.PathLocker      // 上传前锁定网盘目录，多台机器同步到同一目录时使用RemoteLock，上传期间自动续期，为nil时不加锁
+	QuotaAware      bool                     // 上传前获取网盘剩余空间，跳过剩余空间已放不下的文件
+	SmallestFirst   bool                     // 按文件大小从小到大上传，空间不足时尽量多上传文件
+	RateLimiter     *fileUtil.RateLimiter    // 全部文件共享的限速器，为nil时不限速
+	ProgressHandler DirUploadProgressHandler // 调用时传入的进度回调为nil时使用，均为nil时不回调
+	Normalization   norm.Form                // 网盘路径的Unicode规范化形式，为norm.None时不处理
+	dirCache        *RemoteDirCache
+}
+
+func NewDirUploader(accessToken, localDir, remoteDir string) *DirUploader {
+	return &DirUploader{
+		AccessToken:   accessToken,
+		LocalDir:      localDir,
+		RemoteDir:     remoteDir,
+		SymlinkPolicy: SymlinkSkip,
+		dirCache:      NewRemoteDirCache(),
+	}
+}
+
+// 设置符号链接的处理方式
+func (d *DirUploader) SetSymlinkPolicy(policy SymlinkPolicy) {
+	d.SymlinkPolicy = policy
+}
+
+// 设置排除规则，设置后不再读取本地目录下的.panignore
+func (d *DirUploader) SetIgnore(ignore *fileUtil.IgnoreMatcher) {
+	d.Ignore = ignore
+}
+
+// 设置是否按网盘剩余空间跳过放不下的文件，smallestFirst为true时从小到大上传
+// 剩余空间按上传前获取的值计算，网盘中同名文件被覆盖释放的空间不计入
+func (d *DirUploader) SetQuotaAware(enable, smallestFirst bool) {
+	d.QuotaAware = enable
+	d.SmallestFirst = smallestFirst
+}
+
+// 设置限速器，目录中的全部文件共享
+func (d *DirUploader) SetRateLimiter(rateLimiter *fileUtil.RateLimiter) {
+	d.RateLimiter = rateLimiter
+}
+
+// 设置上传限速，单位字节/秒，为0时不限速，后台备份时避免占满上行带宽
+func (d *DirUploader) SetMaxUploadRate(bytesPerSecond int64) {
+	d.RateLimiter = fileUtil.NewRateLimiter(bytesPerSecond)
+}
+
+// 设置网盘目录锁
+func (d *DirUploader) SetRemoteLock(remoteLock fileUtil.PathLocker) {
+	d.RemoteLock = remoteLock
+}
+
+// 设置网盘路径的Unicode规范化形式，同Uploader.SetNormalization
+func (d *DirUploader) SetNormalization(form norm.Form) {
+	d.Normalization = form
+}
+
+// 上传目录，单个文件上传失败时继续上传其余文件，失败的文件记录在报告中
+// ctx结束或遇到SymlinkError策略下的符号链接时停止上传并返回错误
+func (d *DirUploader) Upload(ctx context.Context, progressHandler DirUploadProgressHandler) (DirUploadReport, error) {
+	report := DirUploadReport{}
+	if progressHandler == nil {
+		progressHandler = d.ProgressHandler
+	}
+	if progressHandler == nil {
+		progressHandler = func(string, int, int64, int64) {}
+	}
+	rootDir, err := filepath.EvalSymlinks(d.LocalDir)
+	if err != nil {
+		log.Printf("dirUpload filepath.EvalSymlinks failed localDir: %s err: %v", d.LocalDir, err)
+		return report, err
+	}
+	if d.Ignore == nil {
+		ignore, err := fileUtil.LoadIgnoreFile(filepath.Join(d.LocalDir, fileUtil.IgnoreFileName))
+		if err != nil {
+			log.Printf("dirUpload LoadIgnoreFile failed localDir: %s err: %v", d.LocalDir, err)
+			return report, err
+		}
+		d.Ignore = ignore
+	}
+	if d.RemoteLock != nil {
+		locked, err := d.RemoteLock.TryLock(d.RemoteDir)
+		if err != nil {
+			log.Printf("dirUpload RemoteLock.TryLock failed remoteDir: %s err: %v", d.RemoteDir, err)
+			return report, err
+		}
+		if !locked {
+			return report, ErrRemoteDirLocked
+		}
+		defer func() {
+			if err := d.RemoteLock.Unlock(d.RemoteDir); err != nil {
+				log.Printf("dirUpload RemoteLock.Unlock failed remoteDir: %s err: %v", d.RemoteDir, err)
+			}
+		}()
+		if remoteLock, ok := d.RemoteLock.(*RemoteLock); ok { //上传期间定期续期，避免耗时超过TTL后被其他写入方视为过期
+			defer remoteLock.keepAlive(d.RemoteDir)()
+		}
+	}
+	tasks := []dirUploadTask{}
+	if err := d.walk(ctx, d.LocalDir, d.RemoteDir, []string{rootDir}, &report, &tasks); err != nil {
+		return report, err
+	}
+	for _, task := range tasks {
+		report.RequiredSize += task.size
+	}
+	if d.SmallestFirst {
+		sort.SliceStable(tasks, func(i, j int) bool {
+			return tasks[i].size < tasks[j].size
+		})
+	}
+
+	var free int64 = -1 //为-1时不检查剩余空间
+	if d.QuotaAware {
+		quota, err := account.NewAccountClient(d.AccessToken).QuotaWithContext(ctx)
+		if err != nil {
+			log.Printf("dirUpload QuotaWithContext failed remoteDir: %s err: %v", d.RemoteDir, err)
+			return report, err
+		}
+		free = quota.Free
+		report.FreeSize = quota.Free
+		if report.RequiredSize > free {
+			log.Printf("dirUpload insufficient quota, required: %d free: %d", report.RequiredSize, free)
+		}
+	}
+
+	for _, task := range tasks {
+		if free >= 0 && task.size > free {
+			report.SkippedQuota = append(report.SkippedQuota, QuotaSkippedFile{task.localPath, task.remotePath, task.size})
+			continue
+		}
+		ret, err := d.upload(ctx, task, progressHandler)
+		if err != nil {
+			if ctx.Err() != nil {
+				return report, err
+			}
+			log.Printf("dirUpload uploader.Upload failed localPath: %s err: %v", task.localPath, err)
+			report.Failed = append(report.Failed, DirUploadFailure{task.localPath, task.remotePath, err})
+			continue
+		}
+		if free >= 0 {
+			free -= task.size
+		}
+		report.Uploaded = append(report.Uploaded, ret)
+	}
+	return report, nil
+}
+
+func (d *DirUploader) upload(ctx context.Context, task dirUploadTask, progressHandler DirUploadProgressHandler) (UploadResponse, error) {
+	uploader := NewUploader(d.AccessToken, task.remotePath, task.localPath)
+	uploader.SetEnsureRemoteDir(true, d.dirCache)
+	uploader.SetRateLimiter(d.RateLimiter)
+	uploader.SetNormalization(d.Normalization)
+	ret, _, err := uploader.Upload(ctx, func(status int, doneSize, totalSize int64) {
+		progressHandler(task.localPath, status, doneSize, totalSize)
+	})
+	return ret, err
+}
+
+// 遍历本地目录，收集待上传的文件
+// ancestors为从根目录到localDir每一级目录解析链接后的真实路径，链接指向其中之一时为循环
+func (d *DirUploader) walk(ctx context.Context, localDir, remoteDir string, ancestors []string, report *DirUploadReport, tasks *[]dirUploadTask) error {
+	entries, err := ioutil.ReadDir(localDir)
+	if err != nil {
+		log.Printf("dirUpload ioutil.ReadDir failed localDir: %s err: %v", localDir, err)
+		return err
+	}
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		localPath := filepath.Join(localDir, entry.Name())
+		remotePath := path.Join(remoteDir, d.Normalization.String(entry.Name()))
+		info := entry
+		realPath := filepath.Join(ancestors[len(ancestors)-1], entry.Name())
+		isLink := entry.Mode()&os.ModeSymlink != 0
+
+		// 先按排除规则过滤，被排除的链接不再按链接策略处理，根目录的.panignore本身不上传
+		if relPath, err := filepath.Rel(d.LocalDir, localPath); err == nil {
+			relPath = filepath.ToSlash(relPath)
+			if relPath == fileUtil.IgnoreFileName {
+				continue
+			}
+			isDir := entry.IsDir()
+			if isLink {
+				if targetInfo, err := os.Stat(localPath); err == nil {
+					isDir = targetInfo.IsDir()
+				}
+			}
+			if d.Ignore.Match(relPath, isDir) {
+				continue
+			}
+		}
+
+		if isLink {
+			target, _ := os.Readlink(localPath)
+			switch d.SymlinkPolicy {
+			case SymlinkError:
+				return fmt.Errorf("%w, path: %s", ErrSymlink, localPath)
+			case SymlinkFollow:
+				info, err = os.Stat(localPath)
+				if err != nil { //链接指向的文件不存在
+					report.Failed = append(report.Failed, DirUploadFailure{localPath, remotePath, err})
+					continue
+				}
+			default:
+				report.SkippedLinks = append(report.SkippedLinks, SkippedLink{localPath, target, "skip"})
+				continue
+			}
+			if info.IsDir() {
+				realPath, err = filepath.EvalSymlinks(localPath)
+				if err != nil {
+					report.Failed = append(report.Failed, DirUploadFailure{localPath, remotePath, err})
+					continue
+				}
+				if containsPath(ancestors, realPath) {
+					log.Printf("dirUpload symlink cycle skipped path: %s target: %s", localPath, target)
+					report.SkippedLinks = append(report.SkippedLinks, SkippedLink{localPath, target, "cycle"})
+					continue
+				}
+			}
+		}
+
+		if info.IsDir() {
+			if err := d.walk(ctx, localPath, remotePath, append(ancestors, realPath), report, tasks); err != nil {
+				return err
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		*tasks = append(*tasks, dirUploadTask{localPath, remotePath, info.Size()})
+	}
+	return nil
+}
+
+func containsPath(paths []string, p string) bool {
+	for _, v := range paths {
+		if v == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package file_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsyzchen/pan/file"
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+// 创建目录和文件，失败时结束测试
+func mustWriteFile(t *testing.T, filePath, content string) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustSymlink(t *testing.T, target, link string) {
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlink unsupported: %v", err)
+	}
+}
+
+// 两个链接指向同一目录时都上传，指向祖先目录的链接作为循环跳过
+func TestDirUploadSymlinkCycle(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+	dir, err := ioutil.TempDir("", "pandir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	shared := filepath.Join(dir, "shared")
+	mustWriteFile(t, filepath.Join(root, "a", "f.txt"), "a")
+	mustWriteFile(t, filepath.Join(shared, "s.txt"), "s")
+	mustSymlink(t, shared, filepath.Join(root, "link1"))
+	mustSymlink(t, shared, filepath.Join(root, "a", "link2"))
+	mustSymlink(t, root, filepath.Join(root, "a", "back"))
+	mustSymlink(t, filepath.Join(root, "a"), filepath.Join(shared, "up")) //经链接进入shared后再指回a
+
+	dirUploader := file.NewDirUploader("token", root, "/apps/dir")
+	dirUploader.SetSymlinkPolicy(file.SymlinkFollow)
+	report, err := dirUploader.Upload(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("DirUploader.Upload: %v", err)
+	}
+	for _, p := range []string{"/apps/dir/a/f.txt", "/apps/dir/link1/s.txt", "/apps/dir/a/link2/s.txt", "/apps/dir/link1/up/f.txt"} {
+		if !mock.Exists(p) {
+			t.Errorf("%s not uploaded", p)
+		}
+	}
+	cycles := map[string]bool{}
+	for _, link := range report.SkippedLinks {
+		if link.Reason == "cycle" {
+			rel, _ := filepath.Rel(root, link.LocalPath)
+			cycles[filepath.ToSlash(rel)] = true
+		}
+	}
+	for _, p := range []string{"a/back", "a/link2/up", "link1/up/back"} {
+		if !cycles[p] {
+			t.Errorf("cycle %s not detected, got %v", p, cycles)
+		}
+	}
+}
+
+// 排除规则先于链接策略生效，.panignore本身不上传
+func TestDirUploadIgnoreBeforeSymlinkPolicy(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+	dir, err := ioutil.TempDir("", "pandir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	mustWriteFile(t, filepath.Join(root, "kept.txt"), "k")
+	mustWriteFile(t, filepath.Join(root, ".panignore"), "broken\nlinked/\n")
+	mustWriteFile(t, filepath.Join(dir, "other", "o.txt"), "o")
+	mustSymlink(t, filepath.Join(dir, "missing"), filepath.Join(root, "broken"))
+	mustSymlink(t, filepath.Join(dir, "other"), filepath.Join(root, "linked"))
+
+	dirUploader := file.NewDirUploader("token", root, "/apps/dir")
+	dirUploader.SetSymlinkPolicy(file.SymlinkError) //未被排除的链接会导致上传失败
+	if _, err := dirUploader.Upload(context.Background(), nil); err != nil {
+		t.Fatalf("DirUploader.Upload: %v", err)
+	}
+	if !mock.Exists("/apps/dir/kept.txt") {
+		t.Error("kept.txt not uploaded")
+	}
+	for _, p := range []string{"/apps/dir/.panignore", "/apps/dir/linked/o.txt"} {
+		if mock.Exists(p) {
+			t.Errorf("%s uploaded", p)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	fileUtil "github.com/jsyzchen/pan/utils/file"
+)
+
+// 后台上传的状态
+type UploadState string
+
+const (
+	UploadRunning  UploadState = "running"  // 上传中
+	UploadPaused   UploadState = "paused"   // 已暂停，可调用Resume继续
+	UploadFinished UploadState = "finished" // 已结束，通过Wait获取结果
+)
+
+// UploadHandle 后台上传的控制句柄，可暂停、继续和查询状态
+// 暂停时正在上传的分片被取消，已完成的分片保留在快照中，继续时从快照续传，不需要重新计算md5和预创建
+type UploadHandle struct {
+	mu       sync.Mutex
+	state    UploadState
+	pause    func() // 当前一轮上传的暂停函数，未在上传时为nil
+	resume   chan struct{}
+	done     chan struct{}
+	result   UploadResult
+	snapshot fileUtil.UploadSnapshot
+	err      error
+}
+
+// 在后台开始上传，返回控制句柄，ctx结束时上传结束，暂停中的上传同样结束
+func (u *Uploader) Start(ctx context.Context, progressHandler UploadProgressHandler) *UploadHandle {
+	h := &UploadHandle{
+		state:  UploadRunning,
+		resume: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go h.run(ctx, u, progressHandler)
+	return h
+}
+
+func (h *UploadHandle) run(ctx context.Context, u *Uploader, progressHandler UploadProgressHandler) {
+	defer close(h.done)
+	var snapshot fileUtil.UploadSnapshot
+	for {
+		h.mu.Lock()
+		if h.state == UploadPaused { //开始前已被暂停
+			h.mu.Unlock()
+			if !h.waitResume(ctx) {
+				return
+			}
+			continue
+		}
+		select {
+		case <-h.resume: //暂停后立即继续，丢弃多余的信号
+		default:
+		}
+		runCtx, pause := fileUtil.WithPause(ctx)
+		h.pause = pause
+		h.mu.Unlock()
+
+		var result UploadResult
+		var err error
+		if snapshot.Recoverable {
+			result, snapshot, err = u.ResumeUploadWithResult(runCtx, snapshot, progressHandler)
+		} else { //暂停时还未预创建，重新开始上传
+			result, snapshot, err = u.UploadWithResult(runCtx, progressHandler)
+		}
+
+		h.mu.Lock()
+		h.pause = nil
+		pause() //释放本轮的ctx
+		h.result, h.snapshot, h.err = result, snapshot, err
+		if !errors.Is(err, fileUtil.ErrPaused) || ctx.Err() != nil {
+			h.state = UploadFinished
+			h.mu.Unlock()
+			return
+		}
+		h.mu.Unlock()
+	}
+}
+
+// 等待继续，ctx结束时返回false，上传以ctx的错误结束
+func (h *UploadHandle) waitResume(ctx context.Context) bool {
+	select {
+	case <-h.resume:
+		return true
+	case <-ctx.Done():
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.err = ctx.Err()
+		h.snapshot.Status = fileUtil.ClassifyStatus(h.err)
+		h.state = UploadFinished
+		return false
+	}
+}
+
+// 暂停上传，上传中时返回true
+func (h *UploadHandle) Pause() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.state != UploadRunning {
+		return false
+	}
+	h.state = UploadPaused
+	if h.pause != nil {
+		h.pause()
+	}
+	return true
+}
+
+// 继续已暂停的上传，暂停中时返回true
+func (h *UploadHandle) Resume() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.state != UploadPaused {
+		return false
+	}
+	h.state = UploadRunning
+	select {
+	case h.resume <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// 当前状态
+func (h *UploadHandle) Status() UploadState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state
+}
+
+// 最近一次暂停或结束时的快照，暂停中可保存以便进程退出后续传
+func (h *UploadHandle) Snapshot() fileUtil.UploadSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.snapshot
+}
+
+// 上传结束时关闭
+func (h *UploadHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// 等待上传结束，返回值同UploadWithResult
+func (h *UploadHandle) Wait() (UploadResult, fileUtil.UploadSnapshot, error) {
+	<-h.done
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.result, h.snapshot, h.err
+}
@@ -0,0 +1,61 @@
+package file
+
+import (
+	"context"
+	"log"
+	"math"
+)
+
+// 秒传要求文件大于256KB
+const rapidUploadMinSize = 262144
+
+// 上传计划
+type UploadPlan struct {
+	FileSize        int64
+	FileMd5         string
+	SliceMd5        string // 文件前256KB的md5
+	SliceSize       int64
+	SliceNum        int
+	BlockList       []string // 各分片的md5
+	RapidUploadable bool     // 是否满足秒传条件，是否秒传成功由服务端是否已存在相同文件决定
+}
+
+// 计算上传计划，只读取本地文件和用户信息，不调用预创建等写入接口
+// 计算结果会被之后的Upload复用，无需再次计算分片md5
+func (u *Uploader) Plan(ctx context.Context, progressHandler func(int64)) (UploadPlan, error) {
+	plan := UploadPlan{}
+
+	fileInfo, err := u.GetFileInfo(false)
+	if err != nil {
+		log.Println("plan GetFileInfo failed, err:", err)
+		return plan, err
+	}
+	sliceSize, err := u.getSliceSize(ctx, fileInfo.Size)
+	if err != nil {
+		return plan, err
+	}
+	sliceMd5, err := u.getSliceMd5()
+	if err != nil {
+		return plan, err
+	}
+	if progressHandler == nil {
+		progressHandler = func(int64) {}
+	}
+	blockList, err := u.getBlockList(ctx, progressHandler)
+	if err != nil {
+		log.Println("plan getBlockList failed, err:", err)
+		return plan, err
+	}
+	u.blockList = blockList
+
+	plan.FileSize = fileInfo.Size
+	plan.FileMd5 = fileInfo.Md5
+	plan.SliceMd5 = sliceMd5
+	plan.SliceSize = sliceSize
+	if sliceSize > 0 {
+		plan.SliceNum = int(math.Ceil(float64(fileInfo.Size) / float64(sliceSize)))
+	}
+	plan.BlockList = blockList
+	plan.RapidUploadable = fileInfo.Size > rapidUploadMinSize
+	return plan, nil
+}
@@ -0,0 +1,265 @@
+package file_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jsyzchen/pan/file"
+	fileUtil "github.com/jsyzchen/pan/utils/file"
+	"github.com/jsyzchen/pan/utils/httpclient"
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+// 上传路径只做特殊字符处理，首尾有空格的文件名可以上传和重命名
+func TestUploadKeepsLegacyNames(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+
+	dir, err := ioutil.TempDir("", "pantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(localPath, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := file.NewUploader("token", "/apps/test/ lead.txt", localPath).Upload(context.Background(), nil); err != nil {
+		t.Fatalf("Uploader.Upload: %v", err)
+	}
+	if !mock.Exists("/apps/test/ lead.txt") {
+		t.Fatal("uploaded file not found")
+	}
+
+	data := []byte("reader")
+	if _, err := file.NewReaderUploader("token", "/apps/test/trail.txt ", bytes.NewReader(data), int64(len(data))).Upload(context.Background(), nil); err != nil {
+		t.Fatalf("ReaderUploader.Upload: %v", err)
+	}
+	if !mock.Exists("/apps/test/trail.txt ") {
+		t.Fatal("reader uploaded file not found")
+	}
+
+	newPath, err := file.NewFileClient("token").Rename("/apps/test/ lead.txt", "lead.txt")
+	if err != nil {
+		t.Fatalf("Rename existing legacy name: %v", err)
+	}
+	if newPath != "/apps/test/lead.txt" || !mock.Exists(newPath) {
+		t.Fatalf("Rename returned %s", newPath)
+	}
+	if _, err := file.NewFileClient("token").Rename("/apps/test/lead.txt", "b?ad"); err == nil {
+		t.Fatal("Rename accepted invalid new name")
+	}
+}
+
+// 上传和重命名使用同一组特殊字符，上传时保持不变的文件名重命名时也能通过校验，反之亦然
+func TestUploadAndRenameShareNameRules(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+
+	dir, err := ioutil.TempDir("", "pantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(localPath, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := file.NewFileClient("token")
+	for i, name := range []string{" lead.txt", "trail.txt ", `a\b.txt`, "a?b.txt", "a\tb.txt", `a\0b.txt`, "a\x0Bb.txt", "中文 名字.txt", "a\x7fb.txt"} {
+		uploadPath := "/apps/test/" + name
+		ret, _, err := file.NewUploader("token", uploadPath, localPath).Upload(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("Upload %q: %v", name, err)
+		}
+		uploadKeeps := ret.Path == uploadPath
+
+		src := "/apps/rename/src" + strconv.Itoa(i) + ".txt" //重命名到另一个目录，不与上传的文件冲突
+		mock.PutFile(src, []byte("hello"))
+		_, renameErr := client.Rename(src, name)
+		if uploadKeeps != (renameErr == nil) {
+			t.Fatalf("name %q: upload kept name %v, rename err %v", name, uploadKeeps, renameErr)
+		}
+	}
+}
+
+// 直接调用分片上传时进度回调可以为nil
+func TestTrySuperFile2UploadNilHandler(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+
+	dir, err := ioutil.TempDir("", "pantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := bytes.Repeat([]byte("x"), 1024)
+	localPath := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uploader := file.NewUploader("token", "/apps/test/a.txt", localPath)
+	preCreateRes, err := uploader.PreCreate(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uploader.TrySuperFile2Upload(context.Background(), preCreateRes.UploadID, 0, data, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 第一个分片上传前阻塞，直到放行
+type gatedUploadTransport struct {
+	server  *mockpan.Server
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (t *gatedUploadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Query().Get("method") == "upload" && req.URL.Query().Get("partseq") == "0" {
+		t.once.Do(func() {
+			close(t.started)
+			<-t.release
+		})
+	}
+	return t.server.RoundTrip(req)
+}
+
+// ReadAhead大于Concurrency时，上传名额被占满后仍提前读取后续分片
+func TestUploadReadAheadBeyondConcurrency(t *testing.T) {
+	mock := mockpan.NewServer()
+	transport := &gatedUploadTransport{server: mock, started: make(chan struct{}), release: make(chan struct{})}
+	httpclient.SetTransport(transport)
+	defer httpclient.SetTransport(nil)
+
+	dir, err := ioutil.TempDir("", "pantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "a.bin")
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<20)
+	if err := ioutil.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uploader := file.NewUploader("token", "/apps/test/a.bin", localPath)
+	uploader.SliceSize = 4 << 20
+	uploader.SetConcurrency(1)
+	uploader.SetReadAhead(4)
+	uploader.SetRetryPolicy(file.RetryPolicy{MaxAttempts: 1})
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := uploader.Upload(context.Background(), nil)
+		done <- err
+	}()
+
+	<-transport.started
+	time.Sleep(300 * time.Millisecond) //等待后续分片读入缓冲区
+	//第一个分片上传期间改写文件，已提前读取的分片不受影响
+	f, err := os.OpenFile(localPath, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(bytes.Repeat([]byte("x"), 12<<20), 4<<20); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	close(transport.release)
+
+	if err := <-done; err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if uploaded, ok := mock.ReadFile("/apps/test/a.bin"); !ok || !bytes.Equal(uploaded, data) {
+		t.Fatal("uploaded content changed, slices were not read ahead of the upload slot")
+	}
+}
+
+// 上传中调大SliceLimiter的上限，剩余分片按新的上限并发上传
+func TestUploadSliceLimiterRaisedMidUpload(t *testing.T) {
+	mock := mockpan.NewServer()
+	transport := &inflightTransport{server: mock}
+	httpclient.SetTransport(transport)
+	defer httpclient.SetTransport(nil)
+
+	dir, err := ioutil.TempDir("", "pantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "limited.bin")
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<20)
+	if err := ioutil.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	limiter := fileUtil.NewConcurrencyLimiter(1)
+	uploader := file.NewUploader("token", "/apps/test/limited.bin", localPath)
+	uploader.SliceSize = 4 << 20
+	uploader.SetConcurrency(4)
+	uploader.SetSliceLimiter(limiter)
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := uploader.Upload(context.Background(), nil)
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond) //第一个分片上传中
+	limiter.SetLimit(4)
+	if err := <-done; err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if transport.max <= 1 {
+		t.Fatalf("max concurrent slice uploads: %d, want more than 1 after raising the limit", transport.max)
+	}
+}
+
+// 网盘路径被其他任务锁定时返回RemotePathLockedError，结束原因为冲突而不是网络错误
+func TestUploadRemotePathLocked(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+
+	dir, err := ioutil.TempDir("", "pantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(localPath, []byte("locked"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	locker := fileUtil.NewMemoryPathLocker()
+	if ok, err := locker.TryLock("/apps/test/a.txt"); !ok || err != nil {
+		t.Fatalf("TryLock = %v, %v", ok, err)
+	}
+	uploader := file.NewUploader("token", "/apps/test/a.txt", localPath)
+	uploader.SetPathLocker(locker)
+	_, _, err = uploader.Upload(context.Background(), nil)
+	if !errors.Is(err, fileUtil.ErrRemotePathLocked) {
+		t.Fatalf("Upload err: %v, want ErrRemotePathLocked", err)
+	}
+	var lockedErr *fileUtil.RemotePathLockedError
+	if !errors.As(err, &lockedErr) || lockedErr.Path != "/apps/test/a.txt" {
+		t.Fatalf("Upload err: %#v, want RemotePathLockedError for /apps/test/a.txt", err)
+	}
+	if status := fileUtil.ClassifyStatus(err); status != fileUtil.StatusConflict {
+		t.Fatalf("ClassifyStatus = %s, want %s", status, fileUtil.StatusConflict)
+	}
+	if mock.Exists("/apps/test/a.txt") {
+		t.Fatal("file uploaded while the remote path was locked")
+	}
+}
@@ -0,0 +1,72 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	fileUtil "github.com/jsyzchen/pan/utils/file"
+)
+
+// 上传后校验网盘文件失败
+var ErrVerifyFailed = errors.New("upload verify failed")
+
+// 上传文件并校验，创建文件后重新获取网盘文件信息，大小和md5与本地文件一致时才回调onVerified(如删除本地文件)
+// 上传期间本地文件被修改时同样视为校验失败，onVerified为nil时只做校验
+func (u *Uploader) UploadAndVerify(ctx context.Context, progressHandler UploadProgressHandler, onVerified func(UploadResponse) error) (UploadResponse, fileUtil.UploadSnapshot, error) {
+	ret, snapshot, err := u.Upload(ctx, progressHandler)
+	if err != nil {
+		return ret, snapshot, err
+	}
+
+	if err := u.Verify(ret); err != nil {
+		log.Printf("uploadAndVerify failed path: %s err: %v", u.Path, err)
+		return ret, snapshot, err
+	}
+
+	if onVerified != nil {
+		if err := onVerified(ret); err != nil {
+			log.Printf("uploadAndVerify onVerified failed path: %s err: %v", u.Path, err)
+			return ret, snapshot, err
+		}
+	}
+	return ret, snapshot, nil
+}
+
+// 校验已上传的网盘文件与本地文件是否一致
+func (u *Uploader) Verify(ret UploadResponse) error {
+	fileInfo, err := u.GetFileInfo(false)
+	if err != nil {
+		return err
+	}
+
+	// 本地文件在计算md5之后被修改
+	localFileInfo, err := os.Stat(u.LocalFilePath)
+	if err != nil {
+		return err
+	}
+	if localFileInfo.Size() != fileInfo.Size || localFileInfo.ModTime().Unix() != fileInfo.ModTime {
+		return errors.New(fmt.Sprintf("%v, local file changed during upload, localPath: %s", ErrVerifyFailed, u.LocalFilePath))
+	}
+
+	fileClient := u.fileClient()
+	metas, err := fileClient.Metas([]uint64{ret.FsID})
+	if err != nil {
+		return err
+	}
+	if len(metas.List) == 0 {
+		return errors.New(fmt.Sprintf("%v, remote file not found, fs_id: %d", ErrVerifyFailed, ret.FsID))
+	}
+
+	item := metas.List[0]
+	if item.Size != fileInfo.Size {
+		return errors.New(fmt.Sprintf("%v, size mismatch, local: %d remote: %d", ErrVerifyFailed, fileInfo.Size, item.Size))
+	}
+	if !strings.EqualFold(item.Md5, fileInfo.Md5) {
+		return errors.New(fmt.Sprintf("%v, md5 mismatch, local: %s remote: %s", ErrVerifyFailed, fileInfo.Md5, item.Md5))
+	}
+	return nil
+}
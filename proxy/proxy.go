@@ -0,0 +1,201 @@
+// 通过HTTP提供网盘文件，请求路径映射为RootDir下的网盘路径，由服务端获取下载地址并转发文件内容
+// 客户端不会拿到下载地址和access_token
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jsyzchen/pan/file"
+	"github.com/jsyzchen/pan/utils/httpclient"
+)
+
+const (
+	listPageSize        = 1000
+	defaultMetaCacheTTL = 5 * time.Minute
+)
+
+// 文件不存在
+var ErrNotFound = errors.New("file not found")
+
+type cacheEntry struct {
+	item     file.FsItem
+	expireAt time.Time
+}
+
+type Handler struct {
+	AccessToken  string
+	RootDir      string          // 网盘中的根目录
+	MetaCacheTTL time.Duration   // 路径到文件信息的缓存时间
+	CacheControl string          // 返回给客户端的Cache-Control，为空时不设置
+	DlinkPool    *file.DlinkPool // 下载地址池
+
+	mu    sync.Mutex // 保护AccessToken和cache，Handler可并发使用
+	cache map[string]cacheEntry
+}
+
+func NewHandler(accessToken, rootDir string) *Handler {
+	return &Handler{
+		AccessToken:  accessToken,
+		RootDir:      path.Clean("/" + rootDir),
+		MetaCacheTTL: defaultMetaCacheTTL,
+		DlinkPool:    file.NewDlinkPool(accessToken),
+		cache:        map[string]cacheEntry{},
+	}
+}
+
+// 切换为其他用户的access_token，并清空文件信息和下载地址缓存
+func (h *Handler) Bind(accessToken string) {
+	h.mu.Lock()
+	h.AccessToken = accessToken
+	h.cache = map[string]cacheEntry{}
+	h.mu.Unlock()
+	h.DlinkPool.Bind(accessToken)
+}
+
+func (h *Handler) accessToken() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.AccessToken
+}
+
+func (h *Handler) SetMetaCacheTTL(ttl time.Duration) {
+	h.MetaCacheTTL = ttl
+}
+
+func (h *Handler) SetCacheControl(cacheControl string) {
+	h.CacheControl = cacheControl
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	remotePath := path.Join(h.RootDir, path.Clean("/"+r.URL.Path))
+	item, err := h.lookup(remotePath)
+	if err == ErrNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Printf("proxy lookup failed path: %s err: %v", remotePath, err)
+		http.Error(w, "lookup file failed", http.StatusBadGateway)
+		return
+	}
+
+	etag := strconv.Quote(item.Md5)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", time.Unix(item.ServerMtime, 0).UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if h.CacheControl != "" {
+		w.Header().Set("Cache-Control", h.CacheControl)
+	}
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.FormatUint(item.Size, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	dlink, err := h.DlinkPool.Get(item.FsID)
+	if err != nil {
+		log.Printf("proxy get dlink failed path: %s err: %v", remotePath, err)
+		http.Error(w, "get download link failed", http.StatusBadGateway)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, dlink+"&access_token="+h.accessToken(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("User-Agent", "pan.baidu.com")
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	resp, err := httpclient.GetClient().Do(req)
+	if err != nil {
+		log.Printf("proxy request dlink failed path: %s err: %v", remotePath, err)
+		http.Error(w, "request download link failed", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable { //Range超出文件范围，原样返回给客户端，下载地址仍然有效
+		if v := resp.Header.Get("Content-Range"); v != "" {
+			w.Header().Set("Content-Range", v)
+		}
+		w.WriteHeader(resp.StatusCode)
+		return
+	}
+	if resp.StatusCode > 299 {
+		if dlinkExpired(resp.StatusCode) { // 下载地址已失效，下次请求重新获取
+			h.DlinkPool.Remove(item.FsID)
+		}
+		log.Printf("proxy dlink response error path: %s statusCode: %d", remotePath, resp.StatusCode)
+		http.Error(w, "download failed", http.StatusBadGateway)
+		return
+	}
+
+	for _, header := range []string{"Content-Length", "Content-Range"} {
+		if v := resp.Header.Get(header); v != "" {
+			w.Header().Set(header, v)
+		}
+	}
+	contentType := "application/octet-stream"
+	if t := mime.TypeByExtension(path.Ext(item.ServerFileName)); t != "" {
+		contentType = t
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Printf("proxy io.Copy failed path: %s err: %v", remotePath, err)
+	}
+}
+
+// 下载地址过期或无效时返回的状态码
+func dlinkExpired(statusCode int) bool {
+	return statusCode == http.StatusForbidden || statusCode == http.StatusNotFound || statusCode == http.StatusGone
+}
+
+// 查找网盘文件，结果缓存MetaCacheTTL
+func (h *Handler) lookup(remotePath string) (file.FsItem, error) {
+	h.mu.Lock()
+	entry, ok := h.cache[remotePath]
+	h.mu.Unlock()
+	if ok && time.Now().Before(entry.expireAt) {
+		return entry.item, nil
+	}
+
+	dir, name := path.Split(remotePath)
+	fileClient := file.NewFileClient(h.accessToken())
+	for start := 0; ; start += listPageSize {
+		ret, err := fileClient.List(path.Clean(dir), start, listPageSize)
+		if err != nil {
+			return file.FsItem{}, err
+		}
+		for _, item := range ret.List {
+			if item.ServerFileName == name && item.IsDir == 0 {
+				h.mu.Lock()
+				h.cache[remotePath] = cacheEntry{item, time.Now().Add(h.MetaCacheTTL)}
+				h.mu.Unlock()
+				return item, nil
+			}
+		}
+		if len(ret.List) < listPageSize {
+			break
+		}
+	}
+	return file.FsItem{}, ErrNotFound
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jsyzchen/pan/utils/httpclient"
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+// 下载地址返回指定的状态码，其余请求转发给模拟服务
+type dlinkStatusTransport struct {
+	server     *mockpan.Server
+	statusCode int
+}
+
+func (t *dlinkStatusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasPrefix(req.URL.Path, "/file/") {
+		return t.server.RoundTrip(req)
+	}
+	header := http.Header{}
+	if t.statusCode == http.StatusRequestedRangeNotSatisfiable {
+		header.Set("Content-Range", "bytes */5")
+	}
+	return &http.Response{StatusCode: t.statusCode, Header: header, Body: ioutil.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func TestServeHTTPDlinkStatus(t *testing.T) {
+	mock := mockpan.NewServer()
+	mock.PutFile("/apps/proxy/a.txt", []byte("hello"))
+	transport := &dlinkStatusTransport{server: mock}
+	httpclient.SetTransport(transport)
+	defer httpclient.SetTransport(nil)
+	h := NewHandler("token", "/apps/proxy")
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+		req.Header.Set("Range", "bytes=10-")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Range超出文件范围时原样返回416，下载地址继续使用
+	transport.statusCode = http.StatusRequestedRangeNotSatisfiable
+	for i := 0; i < 2; i++ {
+		rec := get()
+		if rec.Code != http.StatusRequestedRangeNotSatisfiable || rec.Header().Get("Content-Range") != "bytes */5" {
+			t.Fatalf("status = %d Content-Range = %q, want 416 bytes */5", rec.Code, rec.Header().Get("Content-Range"))
+		}
+	}
+	if calls := mock.Calls("filemetas"); calls != 1 {
+		t.Fatalf("dlink fetched %d times after 416, want 1", calls)
+	}
+
+	// 下载地址失效时返回502，下次请求重新获取下载地址
+	transport.statusCode = http.StatusForbidden
+	if rec := get(); rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", rec.Code)
+	}
+	get()
+	if calls := mock.Calls("filemetas"); calls != 2 {
+		t.Fatalf("dlink fetched %d times after 403, want 2", calls)
+	}
+}
@@ -0,0 +1,411 @@
+// 实验性的S3兼容网关，将最小的S3 API(PUT/GET/HEAD/LIST/DELETE Object)映射为网盘文件操作
+// bucket对应RootDir下的一级目录，object key对应bucket目录下的相对路径
+// 注：网关不校验S3签名，需要由调用方自行在外层做鉴权
+package s3gateway
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jsyzchen/pan/file"
+	"github.com/jsyzchen/pan/utils/httpclient"
+)
+
+const listPageSize = 1000
+
+// ListObjects每页最多返回的对象数
+const maxListKeys = 1000
+
+// 对象不存在
+var ErrNoSuchKey = errors.New("no such key")
+
+// bucket或key解析后的路径不在RootDir下，如包含..
+var ErrInvalidPath = errors.New("invalid bucket or key")
+
+type Gateway struct {
+	AccessToken string
+	RootDir     string // 网盘中的根目录
+	TempDir     string // 上传时数据流临时文件目录
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Marker                string         `xml:"Marker,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	Contents              []objectResult `xml:"Contents"`
+}
+
+type objectResult struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         uint64 `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type errorResult struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+func NewGateway(accessToken, rootDir string) *Gateway {
+	return &Gateway{
+		AccessToken: accessToken,
+		RootDir:     path.Clean("/" + rootDir),
+	}
+}
+
+func (g *Gateway) SetTempDir(tempDir string) {
+	g.TempDir = tempDir
+}
+
+// 处理S3请求，路径格式为/bucket/key
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key := splitBucketKey(r.URL.Path)
+	if bucket == "" {
+		g.writeError(w, http.StatusBadRequest, "InvalidBucketName", "bucket is empty", r.URL.Path)
+		return
+	}
+	if _, err := g.remotePath(bucket, key); err != nil {
+		g.writeError(w, http.StatusBadRequest, "InvalidArgument", err.Error(), r.URL.Path)
+		return
+	}
+
+	if key == "" {
+		switch r.Method {
+		case http.MethodGet:
+			g.listObjects(w, r, bucket)
+		case http.MethodPut:
+			g.createBucket(w, r, bucket)
+		case http.MethodHead:
+			g.headBucket(w, r, bucket)
+		default:
+			g.writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed", r.URL.Path)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		g.putObject(w, r, bucket, key)
+	case http.MethodGet, http.MethodHead:
+		g.getObject(w, r, bucket, key)
+	case http.MethodDelete:
+		g.deleteObject(w, r, bucket, key)
+	default:
+		g.writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed", r.URL.Path)
+	}
+}
+
+// 上传对象，请求携带Content-Length时边读取边上传，分块传输的请求先写入临时文件
+func (g *Gateway) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	var ret file.UploadResponse
+	var err error
+	if r.ContentLength >= 0 {
+		uploader := file.NewReaderUploader(g.AccessToken, g.objectPath(bucket, key), r.Body, r.ContentLength)
+		ret, err = uploader.Upload(r.Context(), func(int, int64, int64) {})
+	} else {
+		uploader := file.NewStreamUploader(g.AccessToken, g.objectPath(bucket, key), r.Body)
+		uploader.SetTempDir(g.TempDir)
+		ret, err = uploader.Upload(r.Context(), func(int, int64, int64) {})
+	}
+	if err != nil {
+		log.Printf("s3gateway putObject failed bucket: %s key: %s err: %v", bucket, key, err)
+		g.writeError(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path)
+		return
+	}
+	w.Header().Set("ETag", strconv.Quote(ret.Md5))
+	w.WriteHeader(http.StatusOK)
+}
+
+// 下载对象，Range请求头透传到下载地址
+func (g *Gateway) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	item, err := g.findObject(g.objectPath(bucket, key))
+	if err == ErrNoSuchKey {
+		g.writeError(w, http.StatusNotFound, "NoSuchKey", "the specified key does not exist", r.URL.Path)
+		return
+	} else if err != nil {
+		g.writeError(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path)
+		return
+	}
+
+	w.Header().Set("ETag", strconv.Quote(item.Md5))
+	w.Header().Set("Last-Modified", time.Unix(item.ServerMtime, 0).UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.FormatUint(item.Size, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	fileClient := file.NewFileClient(g.AccessToken)
+	metas, err := fileClient.Metas([]uint64{item.FsID})
+	if err != nil || len(metas.List) == 0 {
+		log.Printf("s3gateway getObject fileClient.Metas failed bucket: %s key: %s err: %v", bucket, key, err)
+		g.writeError(w, http.StatusInternalServerError, "InternalError", "get download link failed", r.URL.Path)
+		return
+	}
+
+	downloadLink := metas.List[0].DLink + "&access_token=" + g.AccessToken
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, downloadLink, nil)
+	if err != nil {
+		g.writeError(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path)
+		return
+	}
+	req.Header.Set("User-Agent", "pan.baidu.com")
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	resp, err := httpclient.GetClient().Do(req)
+	if err != nil {
+		g.writeError(w, http.StatusBadGateway, "InternalError", err.Error(), r.URL.Path)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range []string{"Content-Length", "Content-Range", "Accept-Ranges"} {
+		if v := resp.Header.Get(header); v != "" {
+			w.Header().Set(header, v)
+		}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Printf("s3gateway getObject io.Copy failed bucket: %s key: %s err: %v", bucket, key, err)
+	}
+}
+
+// 删除对象
+func (g *Gateway) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	fileList, err := json.Marshal([]string{g.objectPath(bucket, key)})
+	if err != nil {
+		g.writeError(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path)
+		return
+	}
+	fileClient := file.NewFileClient(g.AccessToken)
+	if _, err := fileClient.Manage("delete", string(fileList)); err != nil {
+		log.Printf("s3gateway deleteObject failed bucket: %s key: %s err: %v", bucket, key, err)
+		g.writeError(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 列出对象，支持prefix、max-keys参数，按key排序分页，下一页通过continuation-token(V2)或marker(V1)获取
+func (g *Gateway) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	maxKeys := maxListKeys
+	if v, err := strconv.Atoi(query.Get("max-keys")); err == nil && v >= 0 && v < maxKeys {
+		maxKeys = v
+	}
+	ret := listBucketResult{
+		Name:       bucket,
+		Prefix:     prefix,
+		MaxKeys:    maxKeys,
+		Marker:     query.Get("marker"),
+		StartAfter: query.Get("start-after"),
+	}
+	startAfter := ret.Marker
+	if ret.StartAfter != "" {
+		startAfter = ret.StartAfter
+	}
+	if token := query.Get("continuation-token"); token != "" {
+		ret.ContinuationToken = token
+		key, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			g.writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid continuation token", r.URL.Path)
+			return
+		}
+		startAfter = string(key)
+	}
+
+	exists, err := g.bucketExists(bucket)
+	if err != nil {
+		log.Printf("s3gateway listObjects bucketExists failed bucket: %s err: %v", bucket, err)
+		g.writeError(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path)
+		return
+	}
+	if !exists {
+		g.writeError(w, http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist", r.URL.Path)
+		return
+	}
+
+	bucketDir := g.objectPath(bucket, "")
+	fileClient := file.NewFileClient(g.AccessToken)
+	items, err := fileClient.ListRecursive(bucketDir)
+	if err != nil {
+		log.Printf("s3gateway listObjects failed bucket: %s err: %v", bucket, err)
+		g.writeError(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path)
+		return
+	}
+
+	objects := []objectResult{}
+	for _, item := range items {
+		if item.IsDir == 1 {
+			continue
+		}
+		key := strings.TrimPrefix(item.Path, bucketDir+"/")
+		if !strings.HasPrefix(key, prefix) || key <= startAfter {
+			continue
+		}
+		objects = append(objects, objectResult{
+			Key:          key,
+			LastModified: time.Unix(item.ServerMtime, 0).UTC().Format(time.RFC3339),
+			ETag:         strconv.Quote(item.Md5),
+			Size:         item.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	if len(objects) > maxKeys {
+		objects = objects[:maxKeys]
+		ret.IsTruncated = true
+		if maxKeys > 0 {
+			lastKey := objects[maxKeys-1].Key
+			ret.NextMarker = lastKey
+			ret.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(lastKey))
+		}
+	}
+	ret.Contents = objects
+	ret.KeyCount = len(ret.Contents)
+	g.writeXML(w, http.StatusOK, ret)
+}
+
+// 判断bucket是否存在
+func (g *Gateway) headBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	exists, err := g.bucketExists(bucket)
+	if err != nil {
+		g.writeError(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// 在RootDir中分页查找bucket目录
+func (g *Gateway) bucketExists(bucket string) (bool, error) {
+	fileClient := file.NewFileClient(g.AccessToken)
+	for start := 0; ; start += listPageSize {
+		ret, err := fileClient.List(g.RootDir, start, listPageSize)
+		if err != nil {
+			return false, err
+		}
+		for _, item := range ret.List {
+			if item.ServerFileName == bucket && item.IsDir == 1 {
+				return true, nil
+			}
+		}
+		if len(ret.List) < listPageSize {
+			return false, nil
+		}
+	}
+}
+
+// 创建bucket，即在RootDir下新建目录
+func (g *Gateway) createBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	fileClient := file.NewFileClient(g.AccessToken)
+	if _, err := fileClient.CreateDir(g.objectPath(bucket, "")); err != nil {
+		log.Printf("s3gateway createBucket failed bucket: %s err: %v", bucket, err)
+		g.writeError(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// 在父目录中分页查找文件
+func (g *Gateway) findObject(remotePath string) (file.FsItem, error) {
+	dir, name := path.Split(remotePath)
+	fileClient := file.NewFileClient(g.AccessToken)
+	for start := 0; ; start += listPageSize {
+		ret, err := fileClient.List(path.Clean(dir), start, listPageSize)
+		if err != nil {
+			return file.FsItem{}, err
+		}
+		for _, item := range ret.List {
+			if item.ServerFileName == name && item.IsDir == 0 {
+				return item, nil
+			}
+		}
+		if len(ret.List) < listPageSize {
+			break
+		}
+	}
+	return file.FsItem{}, ErrNoSuchKey
+}
+
+// 对象在网盘中的路径，清理后不在bucket目录下时返回ErrInvalidPath，防止通过..访问RootDir以外的文件
+func (g *Gateway) remotePath(bucket, key string) (string, error) {
+	bucketDir := path.Join(g.RootDir, bucket)
+	if strings.Contains(bucket, "/") || path.Dir(bucketDir) != g.RootDir {
+		return "", ErrInvalidPath
+	}
+	if key == "" {
+		return bucketDir, nil
+	}
+	remotePath := path.Join(bucketDir, key)
+	if !strings.HasPrefix(remotePath, bucketDir+"/") {
+		return "", ErrInvalidPath
+	}
+	return remotePath, nil
+}
+
+// 已在ServeHTTP中校验过的对象路径
+func (g *Gateway) objectPath(bucket, key string) string {
+	remotePath, _ := g.remotePath(bucket, key)
+	return remotePath
+}
+
+func (g *Gateway) writeXML(w http.ResponseWriter, statusCode int, v interface{}) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+func (g *Gateway) writeError(w http.ResponseWriter, statusCode int, code, message, resource string) {
+	g.writeXML(w, statusCode, errorResult{
+		Code:     code,
+		Message:  message,
+		Resource: resource,
+	})
+}
+
+// 拆分请求路径为bucket和key
+func splitBucketKey(urlPath string) (string, string) {
+	urlPath = strings.TrimPrefix(urlPath, "/")
+	parts := strings.SplitN(urlPath, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
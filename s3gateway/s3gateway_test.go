@@ -0,0 +1,147 @@
+package s3gateway
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jsyzchen/pan/utils/httpclient"
+	"github.com/jsyzchen/pan/utils/mockpan"
+)
+
+func TestRemotePath(t *testing.T) {
+	g := NewGateway("token", "/apps/s3")
+	cases := []struct {
+		bucket, key string
+		want        string
+		wantErr     bool
+	}{
+		{"b", "", "/apps/s3/b", false},
+		{"b", "a/c.txt", "/apps/s3/b/a/c.txt", false},
+		{"b", "a/../c.txt", "/apps/s3/b/c.txt", false},
+		{"b", "../../x", "", true},
+		{"b", "a/../../x", "", true},
+		{"b", "..", "", true},
+		{"..", "", "", true},
+		{".", "x", "", true},
+	}
+	for _, c := range cases {
+		got, err := g.remotePath(c.bucket, c.key)
+		if (err != nil) != c.wantErr || got != c.want {
+			t.Errorf("remotePath(%q, %q) = %q, %v, want %q, err %v", c.bucket, c.key, got, err, c.want, c.wantErr)
+		}
+	}
+}
+
+type fakeTransport struct{}
+
+func (fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := `{"errno":0,"list":[]}`
+	query := req.URL.Query()
+	switch {
+	case query.Get("method") == "list" && query.Get("dir") == "/apps/s3":
+		body = `{"errno":0,"list":[{"server_filename":"b","path":"/apps/s3/b","isdir":1}]}`
+	case query.Get("method") == "listall":
+		body = `{"errno":0,"has_more":0,"list":[` +
+			`{"path":"/apps/s3/b/c","size":3,"isdir":0},` +
+			`{"path":"/apps/s3/b/a","size":1,"isdir":0},` +
+			`{"path":"/apps/s3/b/d","isdir":1},` +
+			`{"path":"/apps/s3/b/b","size":2,"isdir":0}]}`
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func listKeys(t *testing.T, g *Gateway, target string) listBucketResult {
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	ret := listBucketResult{}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s status = %d body = %s", target, rec.Code, rec.Body.String())
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &ret); err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestListObjectsPagination(t *testing.T) {
+	httpclient.SetTransport(fakeTransport{})
+	defer httpclient.SetTransport(nil)
+	g := NewGateway("token", "/apps/s3")
+
+	keys := []string{}
+	target := "/b?list-type=2&max-keys=2"
+	for i := 0; i < 3; i++ {
+		ret := listKeys(t, g, target)
+		for _, object := range ret.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !ret.IsTruncated {
+			break
+		}
+		target = "/b?list-type=2&max-keys=2&continuation-token=" + url.QueryEscape(ret.NextContinuationToken)
+	}
+	if got := strings.Join(keys, ","); got != "a,b,c" {
+		t.Errorf("keys = %s, want a,b,c", got)
+	}
+}
+
+func TestListObjectsNoSuchBucket(t *testing.T) {
+	httpclient.SetTransport(fakeTransport{})
+	defer httpclient.SetTransport(nil)
+	g := NewGateway("token", "/apps/s3")
+
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "NoSuchBucket") {
+		t.Errorf("status = %d body = %s, want 404 NoSuchBucket", rec.Code, rec.Body.String())
+	}
+}
+
+// 携带Content-Length的PUT边读取边上传，不写入临时文件，分块传输的PUT写入临时文件后上传
+func TestPutObject(t *testing.T) {
+	mock := mockpan.NewServer()
+	defer mock.Install()()
+	tempDir, err := ioutil.TempDir("", "pans3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	g := NewGateway("token", "/apps/s3")
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<19) // 8M，普通用户2个分片
+
+	g.TempDir = filepath.Join(tempDir, "missing") //临时目录不存在，写入临时文件时失败
+	req := httptest.NewRequest(http.MethodPut, "/b/sized.bin", bytes.NewReader(data))
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT with Content-Length status = %d body = %s", rec.Code, rec.Body.String())
+	}
+	if got, ok := mock.ReadFile("/apps/s3/b/sized.bin"); !ok || !bytes.Equal(got, data) {
+		t.Fatal("sized object content mismatch")
+	}
+
+	g.TempDir = tempDir
+	req = httptest.NewRequest(http.MethodPut, "/b/chunked.bin", ioutil.NopCloser(bytes.NewReader(data)))
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("chunked PUT status = %d body = %s", rec.Code, rec.Body.String())
+	}
+	if got, ok := mock.ReadFile("/apps/s3/b/chunked.bin"); !ok || !bytes.Equal(got, data) {
+		t.Fatal("chunked object content mismatch")
+	}
+}
@@ -0,0 +1,88 @@
+package share
+
+import (
+	"context"
+	"crypto/rand"
+	"log"
+	"sync"
+)
+
+// 批量创建分享链接的默认并发数
+const defaultShareLinkConcurrency = 4
+
+// 随机提取码使用的字符
+const pwdChars = "abcdefghijkmnpqrstuvwxyz23456789"
+
+// 批量创建分享链接的参数，全部链接共用
+type ShareLinkOptions struct {
+	Period      int    // 有效期天数
+	Pwd         string // 提取码，RandomPwd为true时忽略
+	RandomPwd   bool   // 为每个链接生成不同的4位随机提取码
+	Remark      string // 备注
+	Concurrency int    // 同时创建的链接数，为0时使用4
+}
+
+// 单个分享链接的创建结果
+type ShareLinkResult struct {
+	Index    int      // 在batches中的序号
+	FsIDs    []uint64 // 分享的文件
+	Response ShareLinkCreationResponse
+	Err      error
+}
+
+// 批量创建分享链接，batches中每组文件创建一个链接，返回的结果与batches顺序一致，单个链接失败不影响其余链接
+func (client *ShareClient) CreateShareLinks(batches [][]uint64, opts ShareLinkOptions) []ShareLinkResult {
+	return client.CreateShareLinksWithContext(context.Background(), batches, opts)
+}
+
+// 同CreateShareLinks，ctx结束时不再创建新的链接，未创建的链接返回ctx的错误
+func (client *ShareClient) CreateShareLinksWithContext(ctx context.Context, batches [][]uint64, opts ShareLinkOptions) []ShareLinkResult {
+	results := make([]ShareLinkResult, len(batches))
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultShareLinkConcurrency
+	}
+	sem := make(chan int, concurrency)
+	var wg sync.WaitGroup
+	for i, fsIDs := range batches {
+		results[i] = ShareLinkResult{Index: i, FsIDs: fsIDs}
+		select {
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		case sem <- 1:
+		}
+		pwd := opts.Pwd
+		if opts.RandomPwd {
+			var err error
+			if pwd, err = randomPwd(); err != nil {
+				results[i].Err = err
+				<-sem
+				continue
+			}
+		}
+		wg.Add(1)
+		go func(ret *ShareLinkResult, pwd string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ret.Response, ret.Err = client.CreateShareLinkWithContext(ctx, ret.FsIDs, opts.Period, pwd, opts.Remark)
+			if ret.Err != nil {
+				log.Printf("ShareClient.CreateShareLinks failed index = %d err = %v", ret.Index, ret.Err)
+			}
+		}(&results[i], pwd)
+	}
+	wg.Wait()
+	return results
+}
+
+// 生成4位随机提取码
+func randomPwd() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = pwdChars[int(b[i])%len(pwdChars)]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,130 @@
+package share
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// 默认检查间隔
+const defaultExpiryCheckInterval = 12 * time.Hour
+
+// 即将过期的分享
+type ExpiryWarning struct {
+	ShortUrl  string
+	LinkInfo  ShareLinkInfo
+	ExpiresAt time.Time
+	Remaining time.Duration
+}
+
+// ExpiryWatcher 定期检查长期维护的分享链接，剩余有效期不超过Within时回调告警
+// 每次检查都会回调全部即将过期的分享，需要只提醒一次时由调用方去重或在回调后Remove
+type ExpiryWatcher struct {
+	Client       *ShareClient
+	Within       time.Duration                    // 剩余有效期不超过该值时告警
+	Interval     time.Duration                    // Run的检查间隔
+	Handler      func(ExpiryWarning)              // 告警回调
+	ErrorHandler func(shortUrl string, err error) // 获取分享信息失败时回调，为nil时只记录日志
+
+	mu    sync.Mutex
+	links map[string]string // shortUrl => pwd
+}
+
+// withinDays为提前告警的天数
+func NewExpiryWatcher(client *ShareClient, withinDays int, handler func(ExpiryWarning)) *ExpiryWatcher {
+	return &ExpiryWatcher{
+		Client:   client,
+		Within:   time.Duration(withinDays) * 24 * time.Hour,
+		Interval: defaultExpiryCheckInterval,
+		Handler:  handler,
+		links:    map[string]string{},
+	}
+}
+
+func (w *ExpiryWatcher) SetInterval(interval time.Duration) {
+	w.Interval = interval
+}
+
+func (w *ExpiryWatcher) SetErrorHandler(errorHandler func(shortUrl string, err error)) {
+	w.ErrorHandler = errorHandler
+}
+
+// 添加需要检查的分享链接
+func (w *ExpiryWatcher) Add(shortUrl, pwd string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.links == nil {
+		w.links = map[string]string{}
+	}
+	w.links[shortUrl] = pwd
+}
+
+// 移除分享链接
+func (w *ExpiryWatcher) Remove(shortUrl string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.links, shortUrl)
+}
+
+// 检查一次全部分享链接，返回即将过期的分享，永久有效的分享不告警
+func (w *ExpiryWatcher) Check(ctx context.Context) []ExpiryWarning {
+	w.mu.Lock()
+	links := make(map[string]string, len(w.links))
+	for shortUrl, pwd := range w.links {
+		links[shortUrl] = pwd
+	}
+	w.mu.Unlock()
+
+	warnings := []ExpiryWarning{}
+	for shortUrl, pwd := range links {
+		if ctx.Err() != nil {
+			break
+		}
+		res, err := w.Client.GetShareInfoWithContext(ctx, shortUrl, pwd)
+		if err != nil {
+			log.Printf("ExpiryWatcher.Check GetShareInfo failed shortUrl: %s err: %v", shortUrl, err)
+			if w.ErrorHandler != nil {
+				w.ErrorHandler(shortUrl, err)
+			}
+			continue
+		}
+		linkInfo := res.Data.LinkInfo
+		if linkInfo.Permanent() {
+			continue
+		}
+		remaining := linkInfo.TimeRemaining()
+		if remaining > w.Within {
+			continue
+		}
+		warning := ExpiryWarning{
+			ShortUrl:  shortUrl,
+			LinkInfo:  linkInfo,
+			ExpiresAt: linkInfo.ExpiresAt(),
+			Remaining: remaining,
+		}
+		warnings = append(warnings, warning)
+		if w.Handler != nil {
+			w.Handler(warning)
+		}
+	}
+	return warnings
+}
+
+// 按Interval定期检查，直到ctx结束
+func (w *ExpiryWatcher) Run(ctx context.Context) {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = defaultExpiryCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		w.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
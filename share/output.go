@@ -0,0 +1,44 @@
+package share
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/jsyzchen/pan/utils"
+)
+
+// 分享文件列表输出格式
+type shareFileOutput struct {
+	FsID        string `json:"fs_id"`
+	Path        string `json:"path"`
+	Name        string `json:"name"`
+	Size        uint64 `json:"size"`
+	IsDir       int    `json:"is_dir"`
+	Md5         string `json:"md5"`
+	ServerMtime int64  `json:"server_mtime"`
+}
+
+// 输出JSON数组，字段依次为fs_id、path、name、size、is_dir、md5、server_mtime
+func (r ShareFilesResponse) ToJSON() ([]byte, error) {
+	items := make([]shareFileOutput, len(r.Data.List))
+	for i, item := range r.Data.List {
+		items[i] = shareFileOutput{item.FsId, item.Path, item.Name, item.Size, item.IsDir, item.Md5, item.ModifyTime}
+	}
+	return json.Marshal(items)
+}
+
+// 输出文本表格，列依次为FS_ID、PATH、SIZE、IS_DIR、MD5、SERVER_MTIME
+func (r ShareFilesResponse) ToTable() string {
+	rows := make([][]string, len(r.Data.List))
+	for i, item := range r.Data.List {
+		rows[i] = []string{
+			item.FsId,
+			item.Path,
+			strconv.FormatUint(item.Size, 10),
+			strconv.Itoa(item.IsDir),
+			item.Md5,
+			strconv.FormatInt(item.ModifyTime, 10),
+		}
+	}
+	return utils.FormatTable([]string{"FS_ID", "PATH", "SIZE", "IS_DIR", "MD5", "SERVER_MTIME"}, rows)
+}
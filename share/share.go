@@ -2,15 +2,23 @@
 package share
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/url"
+	"path"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/jsyzchen/pan/conf"
+	"github.com/jsyzchen/pan/file"
+	fileUtil "github.com/jsyzchen/pan/utils/file"
 	"github.com/jsyzchen/pan/utils/httpclient"
 )
 
@@ -20,6 +28,10 @@ const ListUri = "/apaas/1.0/share/list?product=netdisk"
 const InfoUri = "/apaas/1.0/share/info?product=netdisk"
 const TransferUri = "/apaas/1.0/share/transfer?product=netdisk"
 
+const errnoFileExist = -8 //文件或目录已存在
+const maxNewDirTries = 100
+const shareListPageSize = 100 //获取分享文件列表时每页的文件数
+
 type safeMap struct {
 	sync.RWMutex
 	m map[string]string
@@ -40,6 +52,16 @@ func (m *safeMap) set(key, value string) {
 	m.m[key] = value
 }
 
+func (m *safeMap) deletePrefix(prefix string) {
+	m.Lock()
+	defer m.Unlock()
+	for key := range m.m {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.m, key)
+		}
+	}
+}
+
 var spwdCache = &safeMap{
 	m: make(map[string]string),
 }
@@ -47,6 +69,9 @@ var spwdCache = &safeMap{
 type ShareClient struct {
 	AppId       string
 	AccessToken string
+	Timeout     time.Duration // 单次调用的超时时间，为0时不限制
+	// 验证提取码需要输入验证码时回调，完成后重新验证，为nil时返回VerificationError
+	VerificationHandler VerificationHandler
 }
 
 func NewShareClient(appId, accessToken string) *ShareClient {
@@ -86,15 +111,15 @@ type SharePwdVerificationResponse struct {
 }
 
 type ShareFileInfo struct {
-	FsId       string `json:"fsid"`
-	Category   int    `json:"category"`
-	IsDir      int    `json:"isdir"`
-	Name       string `json:"server_filename"`
-	Path       string `json:"path"`
-	Size       uint64 `json:"size"`
-	CreateTime int64  `json:"server_ctime"`
-	ModifyTime int64  `json:"server_mtime"`
-	Md5        string `json:"md5"`
+	FsId       string        `json:"fsid"`
+	Category   file.Category `json:"category"`
+	IsDir      int           `json:"isdir"`
+	Name       string        `json:"server_filename"`
+	Path       string        `json:"path"`
+	Size       uint64        `json:"size"`
+	CreateTime int64         `json:"server_ctime"`
+	ModifyTime int64         `json:"server_mtime"`
+	Md5        string        `json:"md5"`
 }
 
 type ShareFilesData struct {
@@ -139,6 +164,14 @@ type ShareInfoResponse struct {
 
 // 创建分享链接
 func (client *ShareClient) CreateShareLink(fsidList []uint64, period int, pwd, remark string) (ShareLinkCreationResponse, error) {
+	return client.CreateShareLinkWithContext(context.Background(), fsidList, period, pwd, remark)
+}
+
+// 同CreateShareLink，ctx结束时取消请求
+func (client *ShareClient) CreateShareLinkWithContext(ctx context.Context, fsidList []uint64, period int, pwd, remark string) (ShareLinkCreationResponse, error) {
+	ctx, cancel := client.withTimeout(ctx)
+	defer cancel()
+
 	ret := ShareLinkCreationResponse{}
 
 	v := url.Values{}
@@ -163,7 +196,7 @@ func (client *ShareClient) CreateShareLink(fsidList []uint64, period int, pwd, r
 	body := v.Encode()
 
 	requestUrl := conf.OpenApiDomain + SetUri + "&" + query
-	resp, err := httpclient.Post(nil, requestUrl, map[string]string{}, body)
+	resp, err := httpclient.Post(ctx, requestUrl, map[string]string{}, body)
 	if err != nil {
 		log.Println("ShareClient.CreateShareLink httpclient.Post failed, err = ", err)
 		return ret, err
@@ -181,17 +214,87 @@ func (client *ShareClient) CreateShareLink(fsidList []uint64, period int, pwd, r
 	return ret, nil
 }
 
+// 设置单次调用的超时时间，包括获取加密提取码等内部请求
+func (client *ShareClient) SetTimeout(timeout time.Duration) {
+	client.Timeout = timeout
+}
+
+func (client *ShareClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if client.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, client.Timeout)
+}
+
+// 加密提取码缓存key的前缀，按AppId和AccessToken的hash隔离，避免不同应用、不同用户共用缓存
+func (client *ShareClient) spwdCachePrefix(shortUrl string) string {
+	return client.tokenCachePrefix() + shortUrl + "|"
+}
+
+func (client *ShareClient) tokenCachePrefix() string {
+	tokenHash := sha256.Sum256([]byte(client.AccessToken))
+	return client.AppId + "|" + hex.EncodeToString(tokenHash[:8]) + "|"
+}
+
+// 切换为其他用户的access_token，并清除原用户的加密提取码缓存
+// 不能与该client上正在进行的请求并发调用
+func (client *ShareClient) Bind(accessToken string) {
+	if accessToken == client.AccessToken {
+		return
+	}
+	spwdCache.deletePrefix(client.tokenCachePrefix())
+	client.AccessToken = accessToken
+}
+
+// 清除分享链接的加密提取码缓存，服务端提取码验证过期时使用
+func (client *ShareClient) InvalidateSpwd(shortUrl string) {
+	spwdCache.deletePrefix(client.spwdCachePrefix(shortUrl))
+}
+
 // 获取加密提取码
 func (client *ShareClient) GetSpwd(shortUrl, pwd string) (string, error) {
+	return client.GetSpwdWithContext(context.Background(), shortUrl, pwd)
+}
+
+// 同GetSpwd，ctx结束时取消请求
+func (client *ShareClient) GetSpwdWithContext(ctx context.Context, shortUrl, pwd string) (string, error) {
+	ctx, cancel := client.withTimeout(ctx)
+	defer cancel()
+
 	if pwd == "" {
 		return "", nil
 	}
 
-	spwd := spwdCache.get(shortUrl + pwd)
+	cacheKey := client.spwdCachePrefix(shortUrl) + pwd
+	spwd := spwdCache.get(cacheKey)
 	if spwd != "" {
 		return spwd, nil
 	}
 
+	var answer *VerificationAnswer
+	for i := 0; ; i++ {
+		vfresp, err := client.verify(ctx, shortUrl, pwd, answer)
+		var verificationErr *VerificationError
+		if !errors.As(err, &verificationErr) || client.VerificationHandler == nil || i >= maxVerificationTries {
+			if err != nil {
+				return "", err
+			}
+			spwdCache.set(cacheKey, vfresp.Data.Spwd)
+			return vfresp.Data.Spwd, nil
+		}
+		log.Printf("ShareClient.GetSpwd need verification, shortUrl: %s tryIter: %d", shortUrl, i)
+		ret, err := client.VerificationHandler(ctx, verificationErr)
+		if err != nil {
+			return "", err
+		}
+		answer = &ret
+	}
+}
+
+// 验证提取码，answer不为nil时一并提交验证码
+func (client *ShareClient) verify(ctx context.Context, shortUrl, pwd string, answer *VerificationAnswer) (SharePwdVerificationResponse, error) {
+	vfresp := SharePwdVerificationResponse{}
+
 	v := url.Values{}
 	v.Add("appid", client.AppId)
 	v.Add("access_token", client.AccessToken)
@@ -199,35 +302,48 @@ func (client *ShareClient) GetSpwd(shortUrl, pwd string) (string, error) {
 	query := v.Encode()
 	v = url.Values{}
 	v.Add("pwd", pwd)
+	if answer != nil {
+		v.Add("vcode", answer.Vcode)
+		v.Add("vcode_str", answer.VcodeStr)
+	}
 	body := v.Encode()
 
 	requestUrl := conf.OpenApiDomain + VerifyUri + "&" + query
-	resp, err := httpclient.Post(nil, requestUrl, map[string]string{}, body)
+	resp, err := httpclient.Post(ctx, requestUrl, map[string]string{}, body)
 	if err != nil {
 		log.Println("ShareClient.GetSpwd httpclient.Post failed, err = ", err)
-		return "", err
+		return vfresp, err
 	}
 	if resp.StatusCode != 200 {
-		return "", errors.New(fmt.Sprintf("ShareClient.GetSpwd HttpStatusCode is not equal to 200, httpStatusCode[%d], respBody[%s]", resp.StatusCode, string(resp.Body)))
+		return vfresp, errors.New(fmt.Sprintf("ShareClient.GetSpwd HttpStatusCode is not equal to 200, httpStatusCode[%d], respBody[%s]", resp.StatusCode, string(resp.Body)))
 	}
 
-	vfresp := SharePwdVerificationResponse{}
 	if err := json.Unmarshal(resp.Body, &vfresp); err != nil {
-		return "", err
+		return vfresp, err
+	}
+	if verificationErr := newVerificationError(shortUrl, resp.Body, vfresp); verificationErr != nil {
+		return vfresp, verificationErr
 	}
 	if vfresp.ErrorNo != 0 {
-		return "", errors.New(fmt.Sprintf("ShareClient.GetSpwd errorNo = %d msg = %s", vfresp.ErrorNo, vfresp.Msg))
+		return vfresp, errors.New(fmt.Sprintf("ShareClient.GetSpwd errorNo = %d msg = %s", vfresp.ErrorNo, vfresp.Msg))
 	}
 
-	spwdCache.set(shortUrl+pwd, vfresp.Data.Spwd)
-	return vfresp.Data.Spwd, nil
+	return vfresp, nil
 }
 
 // 获取文件列表
 func (client *ShareClient) ListFiles(shortUrl, pwd, dir string, page, pageSize int) (ShareFilesResponse, error) {
+	return client.ListFilesWithContext(context.Background(), shortUrl, pwd, dir, page, pageSize)
+}
+
+// 同ListFiles，ctx结束时取消请求
+func (client *ShareClient) ListFilesWithContext(ctx context.Context, shortUrl, pwd, dir string, page, pageSize int) (ShareFilesResponse, error) {
+	ctx, cancel := client.withTimeout(ctx)
+	defer cancel()
+
 	ret := ShareFilesResponse{}
 
-	spwd, err := client.GetSpwd(shortUrl, pwd)
+	spwd, err := client.GetSpwdWithContext(ctx, shortUrl, pwd)
 	if err != nil {
 		return ret, err
 	}
@@ -244,13 +360,15 @@ func (client *ShareClient) ListFiles(shortUrl, pwd, dir string, page, pageSize i
 	}
 	if dir != "" {
 		v.Add("dir", dir)
+	}
+	if page > 0 {
 		v.Add("page", strconv.Itoa(page))
 		v.Add("page_size", strconv.Itoa(pageSize))
 	}
 	body := v.Encode()
 
 	requestUrl := conf.OpenApiDomain + ListUri + "&" + query
-	resp, err := httpclient.Post(nil, requestUrl, map[string]string{}, body)
+	resp, err := httpclient.Post(ctx, requestUrl, map[string]string{}, body)
 	if err != nil {
 		log.Println("ShareClient.ListFiles httpclient.Post failed, err = ", err)
 		return ret, err
@@ -270,9 +388,17 @@ func (client *ShareClient) ListFiles(shortUrl, pwd, dir string, page, pageSize i
 
 // 分享信息
 func (client *ShareClient) GetShareInfo(shortUrl, pwd string) (ShareInfoResponse, error) {
+	return client.GetShareInfoWithContext(context.Background(), shortUrl, pwd)
+}
+
+// 同GetShareInfo，ctx结束时取消请求
+func (client *ShareClient) GetShareInfoWithContext(ctx context.Context, shortUrl, pwd string) (ShareInfoResponse, error) {
+	ctx, cancel := client.withTimeout(ctx)
+	defer cancel()
+
 	ret := ShareInfoResponse{}
 
-	spwd, err := client.GetSpwd(shortUrl, pwd)
+	spwd, err := client.GetSpwdWithContext(ctx, shortUrl, pwd)
 	if err != nil {
 		return ret, err
 	}
@@ -290,7 +416,7 @@ func (client *ShareClient) GetShareInfo(shortUrl, pwd string) (ShareInfoResponse
 	body := v.Encode()
 
 	requestUrl := conf.OpenApiDomain + InfoUri + "&" + query
-	resp, err := httpclient.Post(nil, requestUrl, map[string]string{}, body)
+	resp, err := httpclient.Post(ctx, requestUrl, map[string]string{}, body)
 	if err != nil {
 		log.Println("ShareClient.GetShareInfo httpclient.Post failed, err = ", err)
 		return ret, err
@@ -310,9 +436,17 @@ func (client *ShareClient) GetShareInfo(shortUrl, pwd string) (ShareInfoResponse
 
 // 文件转存
 func (client *ShareClient) TransferFiles(shortUrl, pwd, path string, fsidList []uint64) (BaseShareResponse, error) {
+	return client.TransferFilesWithContext(context.Background(), shortUrl, pwd, path, fsidList)
+}
+
+// 同TransferFiles，ctx结束时取消请求
+func (client *ShareClient) TransferFilesWithContext(ctx context.Context, shortUrl, pwd, path string, fsidList []uint64) (BaseShareResponse, error) {
+	ctx, cancel := client.withTimeout(ctx)
+	defer cancel()
+
 	ret := BaseShareResponse{}
 
-	spwd, err := client.GetSpwd(shortUrl, pwd)
+	spwd, err := client.GetSpwdWithContext(ctx, shortUrl, pwd)
 	if err != nil {
 		return ret, err
 	}
@@ -341,7 +475,7 @@ func (client *ShareClient) TransferFiles(shortUrl, pwd, path string, fsidList []
 	body := v.Encode()
 
 	requestUrl := conf.OpenApiDomain + TransferUri + "&" + query
-	resp, err := httpclient.Post(nil, requestUrl, map[string]string{}, body)
+	resp, err := httpclient.Post(ctx, requestUrl, map[string]string{}, body)
 	if err != nil {
 		log.Println("ShareClient.TransferFiles httpclient.Post failed, err = ", err)
 		return ret, err
@@ -358,3 +492,91 @@ func (client *ShareClient) TransferFiles(shortUrl, pwd, path string, fsidList []
 
 	return ret, nil
 }
+
+// 转存到新建的目录中，目录名由nameTemplate生成，支持{title}(分享备注，为空时取第一个文件名)和{date}(当前日期)占位符
+// 目录已存在时在目录名后追加序号，fsidList为空时转存分享的全部文件，返回新建的目录信息
+func (client *ShareClient) TransferToNewDir(shortUrl, pwd, parentDir, nameTemplate string, fsidList []uint64) (file.FsItem, error) {
+	return client.TransferToNewDirWithContext(context.Background(), shortUrl, pwd, parentDir, nameTemplate, fsidList)
+}
+
+// 同TransferToNewDir，ctx结束时取消请求
+func (client *ShareClient) TransferToNewDirWithContext(ctx context.Context, shortUrl, pwd, parentDir, nameTemplate string, fsidList []uint64) (file.FsItem, error) {
+	ctx, cancel := client.withTimeout(ctx)
+	defer cancel()
+
+	ret := file.FsItem{}
+
+	files, err := client.listAllFiles(ctx, shortUrl, pwd)
+	if err != nil {
+		return ret, err
+	}
+	if len(fsidList) == 0 {
+		for _, f := range files {
+			fsid, err := strconv.ParseUint(f.FsId, 10, 64)
+			if err != nil {
+				return ret, err
+			}
+			fsidList = append(fsidList, fsid)
+		}
+	}
+	if len(fsidList) == 0 {
+		return ret, errors.New("ShareClient.TransferToNewDir share has no files")
+	}
+
+	infoRet, err := client.GetShareInfoWithContext(ctx, shortUrl, pwd)
+	if err != nil {
+		return ret, err
+	}
+	title := infoRet.Data.LinkInfo.Remark
+	if title == "" && len(files) > 0 {
+		title = files[0].Name
+	}
+	title = strings.Replace(fileUtil.HandleSpecialChar(title), "/", "", -1) //分享备注可能包含网盘文件名不支持的字符
+	if title == "" {
+		title = shortUrl
+	}
+	if nameTemplate == "" {
+		nameTemplate = "{title}_{date}"
+	}
+	dirName := strings.NewReplacer("{title}", title, "{date}", time.Now().Format("20060102")).Replace(nameTemplate)
+
+	fileClient := file.NewFileClient(client.AccessToken)
+	dirPath := path.Join(parentDir, dirName)
+	for i := 1; ; i++ {
+		createRet, err := fileClient.CreateDir(dirPath)
+		if err == nil {
+			ret.FsID = createRet.FsId
+			ret.Path = createRet.Path
+			ret.ServerFileName = path.Base(createRet.Path)
+			ret.IsDir = 1
+			ret.Category = createRet.Category
+			break
+		}
+		if createRet.ErrorNo != errnoFileExist || i >= maxNewDirTries {
+			log.Printf("ShareClient.TransferToNewDir CreateDir failed path = %s err = %v", dirPath, err)
+			return ret, err
+		}
+		dirPath = path.Join(parentDir, fmt.Sprintf("%s(%d)", dirName, i))
+	}
+
+	if _, err := client.TransferFilesWithContext(ctx, shortUrl, pwd, ret.Path, fsidList); err != nil {
+		return ret, err
+	}
+
+	return ret, nil
+}
+
+// 分页获取分享根目录下的全部文件
+func (client *ShareClient) listAllFiles(ctx context.Context, shortUrl, pwd string) ([]ShareFileInfo, error) {
+	files := []ShareFileInfo{}
+	for page := 1; ; page++ {
+		ret, err := client.ListFilesWithContext(ctx, shortUrl, pwd, "", page, shareListPageSize)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, ret.Data.List...)
+		if len(ret.Data.List) < shareListPageSize || (ret.Data.Count > 0 && len(files) >= ret.Data.Count) {
+			return files, nil
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package share
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/jsyzchen/pan/utils/httpclient"
+)
+
+// 模拟分享相关接口，根目录下有fileCount个文件，每页最多返回pageSize个
+type fakeShareServer struct {
+	fileCount int
+	remark    string
+
+	mu          sync.Mutex
+	listPages   []string
+	createdDirs []string
+	transferred []string
+	transferTo  string
+}
+
+func (s *fakeShareServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, _ := ioutil.ReadAll(req.Body)
+	form, _ := url.ParseQuery(string(body))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ret interface{}
+	switch req.URL.Path {
+	case "/apaas/1.0/share/verify":
+		ret = map[string]interface{}{"errno": 0, "data": map[string]string{"spwd": "spwd"}}
+	case "/apaas/1.0/share/list":
+		s.listPages = append(s.listPages, form.Get("page"))
+		page, _ := strconv.Atoi(form.Get("page"))
+		pageSize, _ := strconv.Atoi(form.Get("page_size"))
+		if page < 1 || pageSize < 1 { //不分页时只返回第一页
+			page, pageSize = 1, 100
+		}
+		list := []map[string]interface{}{}
+		for i := (page - 1) * pageSize; i < page*pageSize && i < s.fileCount; i++ {
+			list = append(list, map[string]interface{}{"fsid": strconv.Itoa(i + 1), "server_filename": fmt.Sprintf("f%d", i), "isdir": 0})
+		}
+		ret = map[string]interface{}{"errno": 0, "data": map[string]interface{}{"count": s.fileCount, "list": list}}
+	case "/apaas/1.0/share/info":
+		ret = map[string]interface{}{"errno": 0, "data": map[string]interface{}{"link_info": map[string]string{"remark": s.remark}}}
+	case "/rest/2.0/xpan/file":
+		dirPath := form.Get("path")
+		s.createdDirs = append(s.createdDirs, dirPath)
+		ret = map[string]interface{}{"errno": 0, "fs_id": 1, "path": dirPath, "isdir": 1}
+	case "/apaas/1.0/share/transfer":
+		json.Unmarshal([]byte(form.Get("fsid_list")), &s.transferred)
+		s.transferTo = form.Get("to_path")
+		ret = map[string]interface{}{"errno": 0}
+	default:
+		return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(bytes.NewReader(nil)), Request: req}, nil
+	}
+	data, _ := json.Marshal(ret)
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewReader(data)), Request: req}, nil
+}
+
+func TestTransferToNewDirAllPages(t *testing.T) {
+	server := &fakeShareServer{fileCount: 250, remark: `a/b:c?*"备份"`}
+	httpclient.SetTransport(server)
+	defer httpclient.SetTransport(nil)
+
+	client := NewShareClient("appid", "token")
+	item, err := client.TransferToNewDir("surl-pages", "pwd", "/apps/share", "{title}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(server.transferred) != 250 {
+		t.Fatalf("transferred %d files, want 250, pages: %v", len(server.transferred), server.listPages)
+	}
+	if item.Path != "/apps/share/abc备份" || server.transferTo != item.Path {
+		t.Fatalf("created dir %s, transferred to %s, want /apps/share/abc备份", item.Path, server.transferTo)
+	}
+}
@@ -0,0 +1,64 @@
+package share
+
+import (
+	"math"
+	"time"
+
+	"github.com/jsyzchen/pan/utils"
+)
+
+// 文件创建时间
+func (info ShareFileInfo) Created() time.Time {
+	return utils.UnixTime(info.CreateTime)
+}
+
+// 文件修改时间
+func (info ShareFileInfo) Modified() time.Time {
+	return utils.UnixTime(info.ModifyTime)
+}
+
+// 分享创建时间
+func (info ShareLinkInfo) Created() time.Time {
+	return utils.UnixTime(info.CreateTime)
+}
+
+// 分享修改时间
+func (info ShareLinkInfo) Modified() time.Time {
+	return utils.UnixTime(info.ModifyTime)
+}
+
+// 是否永久有效，Period为0时永久有效
+func (info ShareLinkInfo) Permanent() bool {
+	return info.Period <= 0
+}
+
+// 分享过期时间，按创建时间加有效天数计算，永久有效时返回零值
+func (info ShareLinkInfo) ExpiresAt() time.Time {
+	if info.Permanent() || info.CreateTime == 0 {
+		return time.Time{}
+	}
+	return utils.UnixTime(info.CreateTime).AddDate(0, 0, info.Period)
+}
+
+// 剩余有效时间，已过期时返回0，永久有效时返回math.MaxInt64
+func (info ShareLinkInfo) TimeRemaining() time.Duration {
+	expiresAt := info.ExpiresAt()
+	if expiresAt.IsZero() {
+		return math.MaxInt64
+	}
+	remaining := time.Until(expiresAt)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// 分享过期时间，同ShareLinkInfo.ExpiresAt
+func (data ShareInfoData) ExpiresAt() time.Time {
+	return data.LinkInfo.ExpiresAt()
+}
+
+// 剩余有效时间，同ShareLinkInfo.TimeRemaining
+func (data ShareInfoData) TimeRemaining() time.Duration {
+	return data.LinkInfo.TimeRemaining()
+}
@@ -0,0 +1,78 @@
+package share
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// 验证提取码时需要输入验证码的错误码
+const errnoNeedVcode = -62
+
+// 验证提取码时最多回调VerificationHandler的次数
+const maxVerificationTries = 3
+
+// 验证分享提取码时需要完成验证码或风控校验，可通过errors.Is判断，通过errors.As获取VerificationError
+var ErrNeedVerification = errors.New("share verification required")
+
+// 验证码等校验信息
+type VerificationChallenge struct {
+	VcodeStr string          `json:"vcode_str"` // 验证码标识，提交验证码时原样带回
+	VcodeImg string          `json:"img"`       // 验证码图片地址
+	Raw      json.RawMessage `json:"-"`         // 服务端返回的原始数据，包含其他校验方式需要的信息
+}
+
+// 验证码等校验的结果
+type VerificationAnswer struct {
+	VcodeStr string // 验证码标识
+	Vcode    string // 用户输入的验证码
+}
+
+// 验证分享提取码时需要完成校验
+type VerificationError struct {
+	ShortUrl  string
+	ErrorNo   int
+	Msg       string
+	Challenge VerificationChallenge
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("share verification required, shortUrl: %s errorNo: %d msg: %s", e.ShortUrl, e.ErrorNo, e.Msg)
+}
+
+func (e *VerificationError) Is(target error) bool {
+	return target == ErrNeedVerification
+}
+
+// 验证回调，交互式应用展示验证码并返回用户的输入，返回error时放弃验证
+type VerificationHandler func(ctx context.Context, challenge *VerificationError) (VerificationAnswer, error)
+
+// 设置验证回调，为nil时需要验证直接返回VerificationError，等待回调的时间计入Timeout
+func (client *ShareClient) SetVerificationHandler(handler VerificationHandler) {
+	client.VerificationHandler = handler
+}
+
+// 解析需要验证的响应，不需要验证时返回nil
+func newVerificationError(shortUrl string, body []byte, resp SharePwdVerificationResponse) *VerificationError {
+	if resp.ErrorNo != errnoNeedVcode {
+		return nil
+	}
+	verificationErr := &VerificationError{
+		ShortUrl: shortUrl,
+		ErrorNo:  resp.ErrorNo,
+		Msg:      resp.Msg,
+	}
+	data := struct {
+		VerificationChallenge
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err := json.Unmarshal(body, &data); err == nil {
+		verificationErr.Challenge = data.VerificationChallenge
+		verificationErr.Challenge.Raw = data.Data
+		if verificationErr.Challenge.VcodeStr == "" && len(data.Data) > 0 { //验证码信息在data中返回
+			json.Unmarshal(data.Data, &verificationErr.Challenge)
+		}
+	}
+	return verificationErr
+}
@@ -0,0 +1,167 @@
+package transfer
+
+import (
+	"sync"
+	"time"
+)
+
+// 汇总进度的默认回调间隔
+const defaultAggregateInterval = 500 * time.Millisecond
+
+// 速度平滑系数，越大越接近瞬时速度
+const speedSmoothing = 0.3
+
+// 全部任务的汇总进度
+type OverallProgress struct {
+	ActiveJobs   int           // 正在传输的任务数
+	FinishedJobs int           // 已结束的任务数，包括失败的任务
+	DoneBytes    int64         // 已完成的字节数，已结束的任务按结束时的进度计算
+	TotalBytes   int64         // 总字节数，失败的任务按已完成的部分计算，不影响整体完成度
+	Speed        float64       // 平均速度，单位字节/秒
+	ETA          time.Duration // 预计剩余时间，速度为0时为-1
+}
+
+type jobProgress struct {
+	doneSize  int64
+	totalSize int64
+}
+
+// AggregateProgress 汇总多个任务的传输进度，按间隔串行回调一个整体进度，供仪表盘等界面使用
+// Update的参数与Manager.ProgressHandler一致，可直接通过Manager.SetAggregateProgress使用
+type AggregateProgress struct {
+	Handler  func(OverallProgress)
+	Interval time.Duration // 最小回调间隔，任务开始和结束时立即回调
+
+	mu            sync.Mutex
+	jobs          map[string]*jobProgress
+	finishedJobs  int
+	finishedBytes int64
+	lastEmit      time.Time
+	lastDone      int64
+	speed         float64
+	emitSeq       uint64
+
+	handlerMu  sync.Mutex // 串行调用Handler，调用时不持有mu，Handler中可以调用Progress
+	handledSeq uint64
+}
+
+func NewAggregateProgress(handler func(OverallProgress)) *AggregateProgress {
+	return &AggregateProgress{
+		Handler:  handler,
+		Interval: defaultAggregateInterval,
+		jobs:     map[string]*jobProgress{},
+	}
+}
+
+func (a *AggregateProgress) SetInterval(interval time.Duration) {
+	a.Interval = interval
+}
+
+// 更新任务进度，只统计传输阶段(status为2)的进度，a为nil时忽略
+func (a *AggregateProgress) Update(key string, status int, doneSize, totalSize int64) {
+	if a == nil || status != 2 {
+		return
+	}
+	a.mu.Lock()
+	if a.jobs == nil {
+		a.jobs = map[string]*jobProgress{}
+	}
+	job, ok := a.jobs[key]
+	if !ok {
+		job = &jobProgress{}
+		a.jobs[key] = job
+	}
+	job.doneSize = doneSize
+	job.totalSize = totalSize
+	p, seq, emit := a.emit(!ok)
+	a.mu.Unlock()
+	if emit {
+		a.deliver(p, seq)
+	}
+}
+
+// 任务结束，进度计入已完成部分，a为nil时忽略
+func (a *AggregateProgress) Finish(key string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	job, ok := a.jobs[key]
+	if !ok {
+		a.mu.Unlock()
+		return
+	}
+	delete(a.jobs, key)
+	a.finishedJobs++
+	a.finishedBytes += job.doneSize
+	p, seq, _ := a.emit(true)
+	a.mu.Unlock()
+	a.deliver(p, seq)
+}
+
+// 当前汇总进度
+func (a *AggregateProgress) Progress() OverallProgress {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.progress()
+}
+
+func (a *AggregateProgress) progress() OverallProgress {
+	p := OverallProgress{
+		ActiveJobs:   len(a.jobs),
+		FinishedJobs: a.finishedJobs,
+		DoneBytes:    a.finishedBytes,
+		TotalBytes:   a.finishedBytes,
+		Speed:        a.speed,
+		ETA:          -1,
+	}
+	for _, job := range a.jobs {
+		p.DoneBytes += job.doneSize
+		p.TotalBytes += job.totalSize
+	}
+	if a.speed > 0 {
+		p.ETA = time.Duration(float64(p.TotalBytes-p.DoneBytes) / a.speed * float64(time.Second))
+	}
+	return p
+}
+
+// 需要持有a.mu，返回需要回调的进度快照及其序号，force为true时忽略回调间隔
+func (a *AggregateProgress) emit(force bool) (OverallProgress, uint64, bool) {
+	now := time.Now()
+	elapsed := now.Sub(a.lastEmit)
+	if !force && elapsed < a.Interval {
+		return OverallProgress{}, 0, false
+	}
+	p := a.progress()
+	if !a.lastEmit.IsZero() && elapsed > 0 {
+		delta := p.DoneBytes - a.lastDone
+		if delta < 0 { // 分片重试导致进度回退
+			delta = 0
+		}
+		speed := float64(delta) / elapsed.Seconds()
+		if a.speed == 0 {
+			a.speed = speed
+		} else {
+			a.speed = speedSmoothing*speed + (1-speedSmoothing)*a.speed
+		}
+		p = a.progress()
+	}
+	a.lastEmit = now
+	a.lastDone = p.DoneBytes
+	a.emitSeq++
+	return p, a.emitSeq, true
+}
+
+// 在mu外串行回调，比已回调的快照旧的快照直接丢弃，保证回调的进度按时间顺序
+func (a *AggregateProgress) deliver(p OverallProgress, seq uint64) {
+	if a.Handler == nil {
+		return
+	}
+	a.handlerMu.Lock()
+	defer a.handlerMu.Unlock()
+	if seq <= a.handledSeq {
+		return
+	}
+	a.handledSeq = seq
+	a.Handler(p)
+}
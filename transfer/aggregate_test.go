@@ -0,0 +1,72 @@
+package transfer
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 回调中可以调用Progress，不会死锁
+func TestAggregateHandlerCallsProgress(t *testing.T) {
+	var a *AggregateProgress
+	calls := 0
+	a = NewAggregateProgress(func(p OverallProgress) {
+		calls++
+		if got := a.Progress(); got.FinishedJobs < p.FinishedJobs {
+			t.Errorf("Progress in handler = %+v, handler got %+v", got, p)
+		}
+	})
+	done := make(chan struct{})
+	go func() {
+		a.Update("a", 2, 10, 100)
+		a.Finish("a")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler calling Progress deadlocked")
+	}
+	if calls != 2 {
+		t.Fatalf("handler called %d times, want 2", calls)
+	}
+}
+
+// 并发更新时回调串行，进度不回退
+func TestAggregateSerialOrdered(t *testing.T) {
+	var running int32
+	var last int64 = -1
+	a := NewAggregateProgress(func(p OverallProgress) {
+		if !atomic.CompareAndSwapInt32(&running, 0, 1) {
+			t.Error("handler called concurrently")
+		}
+		if p.DoneBytes < last {
+			t.Errorf("DoneBytes went back from %d to %d", last, p.DoneBytes)
+		}
+		last = p.DoneBytes
+		time.Sleep(time.Microsecond)
+		atomic.StoreInt32(&running, 0)
+	})
+	a.SetInterval(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for done := int64(0); done <= 1000; done += 10 {
+				a.Update(key, 2, done, 1000)
+			}
+			a.Finish(key)
+		}(fmt.Sprintf("job%d", i))
+	}
+	wg.Wait()
+	if p := a.Progress(); p.FinishedJobs != 8 || p.DoneBytes != 8000 {
+		t.Fatalf("Progress = %+v", p)
+	}
+	if last != 8000 {
+		t.Fatalf("last delivered DoneBytes = %d, want 8000", last)
+	}
+}
@@ -0,0 +1,163 @@
+package transfer
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+// 任务历史记录
+type JobRecord struct {
+	Kind       string    `json:"kind"`
+	Path       string    `json:"path"`       // 网盘路径
+	LocalPath  string    `json:"local_path"` // 本地路径
+	Action     string    `json:"action"`
+	Status     string    `json:"status"`
+	Reason     string    `json:"reason,omitempty"` // 传输结束的原因，见file.TransferStatus
+	Bytes      int64     `json:"bytes"`
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	Duration   int64     `json:"duration"` // 单位毫秒
+	Error      string    `json:"error,omitempty"`
+	RequestIDs []uint64  `json:"request_ids,omitempty"`
+}
+
+// 历史记录查询条件，零值表示不限制
+type HistoryQuery struct {
+	Since  time.Time // 结束时间不早于Since
+	Until  time.Time // 结束时间早于Until
+	Status string
+	Kind   string
+	Limit  int // 最多返回的记录数，从最新的记录开始取
+}
+
+// 任务历史存储
+type HistoryStore interface {
+	Append(record JobRecord) error
+	Query(query HistoryQuery) ([]JobRecord, error)
+}
+
+// 是否满足查询条件
+func (q HistoryQuery) Match(record JobRecord) bool {
+	if !q.Since.IsZero() && record.EndTime.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !record.EndTime.Before(q.Until) {
+		return false
+	}
+	if q.Status != "" && record.Status != q.Status {
+		return false
+	}
+	if q.Kind != "" && record.Kind != q.Kind {
+		return false
+	}
+	return true
+}
+
+// 按时间顺序过滤记录，Limit生效时保留最新的记录
+func (q HistoryQuery) filter(records []JobRecord) []JobRecord {
+	ret := []JobRecord{}
+	for _, record := range records {
+		if q.Match(record) {
+			ret = append(ret, record)
+		}
+	}
+	if q.Limit > 0 && len(ret) > q.Limit {
+		ret = ret[len(ret)-q.Limit:]
+	}
+	return ret
+}
+
+// MemoryHistoryStore 内存中的历史存储，进程退出后丢失
+type MemoryHistoryStore struct {
+	mu      sync.Mutex
+	records []JobRecord
+}
+
+func NewMemoryHistoryStore() *MemoryHistoryStore {
+	return &MemoryHistoryStore{}
+}
+
+func (s *MemoryHistoryStore) Append(record JobRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *MemoryHistoryStore) Query(query HistoryQuery) ([]JobRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return query.filter(s.records), nil
+}
+
+// FileHistoryStore 以JSON Lines格式追加写入文件的历史存储
+type FileHistoryStore struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func NewFileHistoryStore(path string) *FileHistoryStore {
+	return &FileHistoryStore{
+		Path: path,
+	}
+}
+
+func (s *FileHistoryStore) Append(record JobRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Println("fileHistoryStore os.OpenFile failed, err:", err)
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// 逐行读取历史文件，无法解析的行跳过
+func (s *FileHistoryStore) Query(query HistoryQuery) ([]JobRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.Path)
+	if os.IsNotExist(err) {
+		return []JobRecord{}, nil
+	} else if err != nil {
+		log.Println("fileHistoryStore os.Open failed, err:", err)
+		return nil, err
+	}
+	defer f.Close()
+
+	records := []JobRecord{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		record := JobRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Println("fileHistoryStore json.Unmarshal failed, err:", err)
+			continue
+		}
+		if query.Match(record) {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return HistoryQuery{Limit: query.Limit}.filter(records), nil
+}
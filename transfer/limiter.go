@@ -0,0 +1,13 @@
+package transfer
+
+import (
+	fileUtil "github.com/jsyzchen/pan/utils/file"
+)
+
+// ConcurrencyLimiter 可在运行中调整上限的并发限制，见fileUtil.ConcurrencyLimiter
+type ConcurrencyLimiter = fileUtil.ConcurrencyLimiter
+
+// limit小于1时按1处理
+func NewConcurrencyLimiter(limit int) *ConcurrencyLimiter {
+	return fileUtil.NewConcurrencyLimiter(limit)
+}
@@ -0,0 +1,490 @@
+// 传输任务管理
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jsyzchen/pan/file"
+	fileUtil "github.com/jsyzchen/pan/utils/file"
+)
+
+const (
+	KindUpload   = "upload"
+	KindDownload = "download"
+)
+
+const (
+	ActionResumed      = "resumed"       // 从断点继续传输
+	ActionRestarted    = "restarted"     // 断点无效，重新开始传输
+	ActionDiscarded    = "discarded"     // 快照不可恢复或本地文件已不存在，直接删除快照
+	ActionFailed       = "failed"        // 传输失败，快照已更新保存
+	ActionPaused       = "paused"        // 调度时间窗口结束，传输暂停，快照已更新保存
+	ActionMergePending = "merge_pending" // 分片已下载完成，推迟合并，快照已更新保存
+)
+
+// 检查调度时间窗口的间隔
+const scheduleCheckInterval = time.Minute
+
+// 单个任务的恢复结果
+type ResumeResult struct {
+	Kind      string
+	Path      string // 上传为网盘路径，下载为本地保存路径
+	LocalPath string
+	Action    string
+	Error     error
+	Status    fileUtil.TransferStatus // 传输结束的原因，未开始传输时为空
+	Bytes     int64                   // 已完成的字节数，传输完成时为文件大小
+	RequestID uint64                  // 上传完成时创建文件接口返回的request_id
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// 恢复全部任务的结果报告
+type ResumeReport struct {
+	Results []ResumeResult
+}
+
+type Manager struct {
+	AccessToken     string
+	TempDir         string                          // 下载分片临时目录
+	ProgressHandler func(string, int, int64, int64) // 参数依次为快照key、传输阶段、已完成大小、总大小
+	Schedule        *Schedule                       // 传输调度，为nil时不限制运行时间
+	RateLimiter     *fileUtil.RateLimiter           // 全部任务共享的限速器，速率随调度时间窗口切换
+	History         HistoryStore                    // 任务历史存储，为nil时不记录
+	PathLocker      fileUtil.PathLocker             // 网盘路径锁，防止同时上传到同一路径
+	Uploads         *ConcurrencyLimiter             // 同时运行的上传任务数
+	Downloads       *ConcurrencyLimiter             // 同时运行的下载任务数
+	Aggregate       *AggregateProgress              // 全部任务的汇总进度，为nil时不汇总
+
+	mu                      sync.Mutex
+	downloadPartConcurrency int                          // 单个下载任务的分片并发数上限，为0时不限制
+	uploadSliceConcurrency  int                          // 单个上传任务同时上传的分片数，为0时使用Uploader的默认值
+	partLimiters            map[*ConcurrencyLimiter]bool // 运行中的下载任务各自的分片并发限制
+	sliceLimiters           map[*ConcurrencyLimiter]bool // 运行中的上传任务各自的分片并发限制
+}
+
+const (
+	defaultUploadSliceConcurrency = 2             // 与Uploader的默认值相同
+	maxUploadSliceConcurrency     = 16            // 单个上传任务的分片并发数最多可调整到16
+	unlimitedPartConcurrency      = math.MaxInt32 // 不限制时下载分片并发数由会员身份决定
+)
+
+func NewManager(accessToken string) *Manager {
+	return &Manager{
+		AccessToken: accessToken,
+		RateLimiter: fileUtil.NewRateLimiter(0),
+		PathLocker:  fileUtil.NewMemoryPathLocker(),
+		Uploads:     NewConcurrencyLimiter(1),
+		Downloads:   NewConcurrencyLimiter(1),
+	}
+}
+
+// 设置同时运行的上传任务数，可在ResumeAll运行中调整
+func (m *Manager) SetUploadConcurrency(n int) {
+	m.Uploads.SetLimit(n)
+}
+
+// 设置同时运行的下载任务数，可在ResumeAll运行中调整
+func (m *Manager) SetDownloadConcurrency(n int) {
+	m.Downloads.SetLimit(n)
+}
+
+// 设置单个下载任务的分片并发数上限，避免大文件下载占满带宽影响上传，正在运行的任务也会生效
+func (m *Manager) SetDownloadPartConcurrency(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.downloadPartConcurrency = n
+	for limiter := range m.partLimiters {
+		limiter.SetLimit(partLimit(n))
+	}
+}
+
+// 设置单个上传任务同时上传的分片数，最多16，正在运行的任务也会生效
+func (m *Manager) SetUploadSliceConcurrency(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploadSliceConcurrency = n
+	for limiter := range m.sliceLimiters {
+		limiter.SetLimit(sliceLimit(n))
+	}
+}
+
+func partLimit(n int) int {
+	if n <= 0 {
+		return unlimitedPartConcurrency
+	}
+	return n
+}
+
+func sliceLimit(n int) int {
+	if n <= 0 {
+		return defaultUploadSliceConcurrency
+	}
+	return n
+}
+
+// 创建下载任务的分片并发限制，任务运行中调整上限时同步调整，任务结束时调用返回的函数
+func (m *Manager) newPartLimiter() (*ConcurrencyLimiter, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limiter := NewConcurrencyLimiter(partLimit(m.downloadPartConcurrency))
+	if m.partLimiters == nil {
+		m.partLimiters = map[*ConcurrencyLimiter]bool{}
+	}
+	m.partLimiters[limiter] = true
+	return limiter, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.partLimiters, limiter)
+	}
+}
+
+// 创建上传任务的分片并发限制，任务运行中调整上限时同步调整，任务结束时调用返回的函数
+func (m *Manager) newSliceLimiter() (*ConcurrencyLimiter, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limiter := NewConcurrencyLimiter(sliceLimit(m.uploadSliceConcurrency))
+	if m.sliceLimiters == nil {
+		m.sliceLimiters = map[*ConcurrencyLimiter]bool{}
+	}
+	m.sliceLimiters[limiter] = true
+	return limiter, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.sliceLimiters, limiter)
+	}
+}
+
+// 切换为其他用户的access_token，之后恢复的任务使用新的access_token，不能与ResumeAll并发调用
+func (m *Manager) Bind(accessToken string) {
+	m.AccessToken = accessToken
+}
+
+func (m *Manager) SetTempDir(tempDir string) {
+	m.TempDir = tempDir
+}
+
+// 设置传输调度，如只在01:00~07:00运行
+func (m *Manager) SetSchedule(schedule *Schedule) {
+	m.Schedule = schedule
+}
+
+// 设置网盘路径锁，多进程部署时可使用分布式锁实现
+func (m *Manager) SetPathLocker(pathLocker fileUtil.PathLocker) {
+	m.PathLocker = pathLocker
+}
+
+// 设置任务历史存储，每个任务结束后追加一条记录
+func (m *Manager) SetHistory(history HistoryStore) {
+	m.History = history
+}
+
+func (m *Manager) SetProgressHandler(progressHandler func(string, int, int64, int64)) {
+	m.ProgressHandler = progressHandler
+}
+
+// 设置汇总进度，与ProgressHandler可同时使用
+func (m *Manager) SetAggregateProgress(aggregate *AggregateProgress) {
+	m.Aggregate = aggregate
+}
+
+// 加载存储中全部可恢复的快照，重新校验本地文件后继续或重新开始传输，例如机器重启后调用
+// 传输完成的任务从存储中删除，失败的任务保存最新的快照，每个任务的处理情况记录在返回的报告中
+// 设置了调度时，任务只在时间窗口内运行，窗口结束时暂停并保存快照，等待下一个窗口继续
+// 上传和下载任务分别按各自的并发数同时运行，互不占用名额
+func (m *Manager) ResumeAll(ctx context.Context, store fileUtil.SnapshotStore) (ResumeReport, error) {
+	report := ResumeReport{}
+
+	uploadSnapshots, err := store.LoadUploads()
+	if err != nil {
+		log.Println("resumeAll store.LoadUploads failed, err:", err)
+		return report, err
+	}
+	downloadSnapshots, err := store.LoadDownloads()
+	if err != nil {
+		log.Println("resumeAll store.LoadDownloads failed, err:", err)
+		return report, err
+	}
+
+	var downloadResults []ResumeResult
+	var downloadErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		downloadResults, downloadErr = m.runJobs(ctx, m.Downloads, len(downloadSnapshots), func(jobCtx context.Context, i int) ResumeResult {
+			return m.resumeDownload(jobCtx, store, downloadSnapshots[i])
+		})
+	}()
+	uploadResults, uploadErr := m.runJobs(ctx, m.Uploads, len(uploadSnapshots), func(jobCtx context.Context, i int) ResumeResult {
+		return m.resumeUpload(jobCtx, store, uploadSnapshots[i])
+	})
+	wg.Wait()
+
+	report.Results = append(uploadResults, downloadResults...)
+	if uploadErr != nil {
+		return report, uploadErr
+	}
+	return report, downloadErr
+}
+
+// 按并发限制运行一组任务，返回已运行任务的结果，顺序与任务顺序一致
+// ctx结束时不再开始新任务，等待已开始的任务结束后返回ctx的错误
+func (m *Manager) runJobs(ctx context.Context, limiter *ConcurrencyLimiter, n int, job func(context.Context, int) ResumeResult) ([]ResumeResult, error) {
+	results := make([]ResumeResult, n)
+	done := make([]bool, n)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		if err := limiter.Acquire(ctx); err != nil {
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer limiter.Release()
+			ret, err := m.runScheduled(ctx, func(jobCtx context.Context) ResumeResult {
+				return job(jobCtx, i)
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			results[i] = ret
+			done[i] = true
+		}(i)
+	}
+	wg.Wait()
+
+	ret := []ResumeResult{}
+	for i := range results {
+		if done[i] {
+			ret = append(ret, results[i])
+		}
+	}
+	return ret, firstErr
+}
+
+func (m *Manager) resumeUpload(ctx context.Context, store fileUtil.SnapshotStore, snapshot fileUtil.UploadSnapshot) ResumeResult {
+	ret := ResumeResult{Kind: KindUpload, Path: snapshot.Path, LocalPath: snapshot.LocalPath}
+
+	if !snapshot.Recoverable {
+		ret.Action = ActionDiscarded
+		ret.Error = store.DeleteUpload(snapshot)
+		return ret
+	}
+
+	fileInfo, err := os.Stat(snapshot.LocalPath)
+	if err != nil {
+		log.Printf("resumeAll upload local file invalid localPath: %s err: %v", snapshot.LocalPath, err)
+		ret.Action = ActionDiscarded
+		if delErr := store.DeleteUpload(snapshot); delErr != nil {
+			err = delErr
+		}
+		ret.Error = err
+		return ret
+	}
+
+	progressHandler := m.progressHandler(snapshot.Key())
+	defer m.Aggregate.Finish(snapshot.Key())
+	uploader := file.NewUploader(m.AccessToken, snapshot.Path, snapshot.LocalPath)
+	uploader.SetRateLimiter(m.RateLimiter)
+	uploader.SetPathLocker(m.PathLocker)
+	sliceLimiter, removeSliceLimiter := m.newSliceLimiter()
+	defer removeSliceLimiter()
+	uploader.SetConcurrency(maxUploadSliceConcurrency) //实际并发数由sliceLimiter限制，可在运行中调整
+	uploader.SetSliceLimiter(sliceLimiter)
+	var uploadRet file.UploadResponse
+	var newSnapshot fileUtil.UploadSnapshot
+	if fileInfo.Size() != snapshot.TotalSize || fileInfo.ModTime().Unix() != snapshot.FileModTime {
+		// 本地文件已修改，断点无效
+		log.Printf("resumeAll upload local file changed, restart localPath: %s", snapshot.LocalPath)
+		ret.Action = ActionRestarted
+		uploadRet, newSnapshot, err = uploader.Upload(ctx, progressHandler)
+	} else {
+		ret.Action = ActionResumed
+		uploadRet, newSnapshot, err = uploader.ResumeUpload(ctx, snapshot, progressHandler)
+		if err != nil && ctx.Err() == nil {
+			// uploadid可能已过期，重新上传
+			log.Printf("resumeAll resumeUpload failed, restart path: %s err: %v", snapshot.Path, err)
+			ret.Action = ActionRestarted
+			uploadRet, newSnapshot, err = uploader.Upload(ctx, progressHandler)
+		}
+	}
+
+	ret.RequestID = uploadRet.RequestID
+	ret.Bytes = newSnapshot.DoneSize
+	if err == nil {
+		ret.Bytes = fileInfo.Size()
+	}
+	return m.finish(ret, err, func() error {
+		if newSnapshot.Key() != snapshot.Key() {
+			if err := store.DeleteUpload(snapshot); err != nil {
+				return err
+			}
+		}
+		if newSnapshot.Recoverable {
+			return store.SaveUpload(newSnapshot)
+		}
+		return store.DeleteUpload(newSnapshot)
+	})
+}
+
+func (m *Manager) resumeDownload(ctx context.Context, store fileUtil.SnapshotStore, snapshot fileUtil.DownloadSnapshot) ResumeResult {
+	ret := ResumeResult{Kind: KindDownload, Path: snapshot.SavePath, LocalPath: snapshot.SavePath}
+
+	if !snapshot.Recoverable {
+		ret.Action = ActionDiscarded
+		ret.Error = store.DeleteDownload(snapshot)
+		return ret
+	}
+
+	// 分片文件和下载地址在ResumeDownload中重新校验，网盘文件md5变化时会重新下载
+	downloader := file.NewDownloaderWithFsID(m.AccessToken, snapshot.FsID, snapshot.SavePath)
+	downloader.SetRateLimiter(m.RateLimiter)
+	partLimiter, removePartLimiter := m.newPartLimiter()
+	defer removePartLimiter()
+	downloader.SetPartLimiter(partLimiter)
+	defer m.Aggregate.Finish(snapshot.Key())
+	newSnapshot, err := downloader.ResumeDownload(ctx, snapshot, m.TempDir, m.progressHandler(snapshot.Key()))
+	ret.Action = ActionResumed
+	if newSnapshot.FileMd5 != snapshot.FileMd5 || newSnapshot.TotalPart != snapshot.TotalPart {
+		ret.Action = ActionRestarted
+	}
+
+	ret.Bytes = newSnapshot.DoneSize
+	if err == nil {
+		ret.Bytes = newSnapshot.TotalSize
+	}
+	return m.finish(ret, err, func() error {
+		if newSnapshot.Recoverable {
+			return store.SaveDownload(newSnapshot)
+		}
+		return store.DeleteDownload(newSnapshot)
+	})
+}
+
+// 记录传输结果并更新快照存储
+func (m *Manager) finish(ret ResumeResult, err error, updateStore func() error) ResumeResult {
+	ret.Status = fileUtil.ClassifyStatus(err)
+	if err != nil {
+		log.Printf("resumeAll %s failed path: %s err: %v", ret.Kind, ret.Path, err)
+		ret.Action = ActionFailed
+		ret.Error = err
+		if ret.Status == fileUtil.StatusPaused {
+			ret.Action = ActionPaused
+		} else if ret.Status == fileUtil.StatusMergePending {
+			ret.Action = ActionMergePending
+			ret.Error = nil
+		}
+	}
+	if storeErr := updateStore(); storeErr != nil {
+		log.Printf("resumeAll %s update store failed path: %s err: %v", ret.Kind, ret.Path, storeErr)
+		if ret.Error == nil {
+			ret.Error = storeErr
+		} else {
+			ret.Error = errors.New(fmt.Sprintf("%v; update store failed: %v", ret.Error, storeErr))
+		}
+	}
+	return ret
+}
+
+func (m *Manager) progressHandler(key string) func(int, int64, int64) {
+	return func(status int, doneSize, totalSize int64) {
+		if m.ProgressHandler != nil {
+			m.ProgressHandler(key, status, doneSize, totalSize)
+		}
+		m.Aggregate.Update(key, status, doneSize, totalSize)
+	}
+}
+
+// 在调度时间窗口内运行任务，窗口结束时取消任务并标记为暂停
+// 任务运行期间定时检查窗口，切换到其他窗口时更新限速
+func (m *Manager) runScheduled(ctx context.Context, job func(context.Context) ResumeResult) (ResumeResult, error) {
+	window, err := m.Schedule.Wait(ctx)
+	if err != nil {
+		return ResumeResult{}, err
+	}
+	if m.Schedule == nil || len(m.Schedule.Windows) == 0 { //没有调度窗口时保留调用方设置的限速
+		return m.record(ctx, job), nil
+	}
+	m.RateLimiter.SetRate(window.RateLimit)
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pauseCtx, pause := fileUtil.WithPause(jobCtx)
+	go func() {
+		ticker := time.NewTicker(scheduleCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jobCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			window, ok := m.Schedule.Active(time.Now())
+			if !ok {
+				log.Println("transfer schedule window ended, pause job")
+				pause()
+				return
+			}
+			m.RateLimiter.SetRate(window.RateLimit)
+		}
+	}()
+
+	return m.record(pauseCtx, job), nil
+}
+
+// 运行任务并记录耗时，写入任务历史
+func (m *Manager) record(ctx context.Context, job func(context.Context) ResumeResult) ResumeResult {
+	startTime := time.Now()
+	ret := job(ctx)
+	ret.StartTime = startTime
+	ret.EndTime = time.Now()
+	if m.History == nil || ret.Action == ActionDiscarded {
+		return ret
+	}
+
+	record := JobRecord{
+		Kind:      ret.Kind,
+		LocalPath: ret.LocalPath,
+		Action:    ret.Action,
+		Status:    JobStatusSucceeded,
+		Reason:    string(ret.Status),
+		Bytes:     ret.Bytes,
+		StartTime: ret.StartTime,
+		EndTime:   ret.EndTime,
+		Duration:  ret.EndTime.Sub(ret.StartTime).Milliseconds(),
+	}
+	if ret.Kind == KindUpload {
+		record.Path = ret.Path
+	}
+	if ret.RequestID != 0 {
+		record.RequestIDs = []uint64{ret.RequestID}
+	}
+	if ret.Error != nil {
+		record.Status = JobStatusFailed
+		record.Error = ret.Error.Error()
+	}
+	if err := m.History.Append(record); err != nil {
+		log.Printf("transfer history append failed path: %s err: %v", ret.Path, err)
+	}
+	return ret
+}
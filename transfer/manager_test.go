@@ -0,0 +1,48 @@
+package transfer
+
+import (
+	"context"
+	"testing"
+)
+
+// 没有设置调度时不修改调用方设置的限速
+func TestRunScheduledKeepsRate(t *testing.T) {
+	m := NewManager("token")
+	m.RateLimiter.SetRate(1024)
+	if _, err := m.runScheduled(context.Background(), func(context.Context) ResumeResult { return ResumeResult{} }); err != nil {
+		t.Fatal(err)
+	}
+	if rate := m.RateLimiter.Rate(); rate != 1024 {
+		t.Fatalf("rate after unscheduled job: %d, want 1024", rate)
+	}
+}
+
+// 调整单个任务的分片并发数时，运行中的任务同步调整，已结束的任务不再调整
+func TestSliceConcurrencyAppliesToRunningJobs(t *testing.T) {
+	m := NewManager("token")
+	uploadLimiter, removeUpload := m.newSliceLimiter()
+	downloadLimiter, removeDownload := m.newPartLimiter()
+	if limit := uploadLimiter.Limit(); limit != defaultUploadSliceConcurrency {
+		t.Fatalf("default slice limit: %d, want %d", limit, defaultUploadSliceConcurrency)
+	}
+
+	m.SetUploadSliceConcurrency(6)
+	m.SetDownloadPartConcurrency(3)
+	if limit := uploadLimiter.Limit(); limit != 6 {
+		t.Fatalf("running upload slice limit: %d, want 6", limit)
+	}
+	if limit := downloadLimiter.Limit(); limit != 3 {
+		t.Fatalf("running download part limit: %d, want 3", limit)
+	}
+
+	removeUpload()
+	removeDownload()
+	m.SetUploadSliceConcurrency(1)
+	m.SetDownloadPartConcurrency(0)
+	if limit := uploadLimiter.Limit(); limit != 6 {
+		t.Fatalf("finished upload slice limit changed to %d", limit)
+	}
+	if limit := downloadLimiter.Limit(); limit != 3 {
+		t.Fatalf("finished download part limit changed to %d", limit)
+	}
+}
@@ -0,0 +1,123 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 调度时间窗口，Start、End为距零点的时长，End小于等于Start时表示跨零点
+type Window struct {
+	Start     time.Duration
+	End       time.Duration
+	RateLimit int64 // 窗口内的限速，单位字节/秒，为0时不限速
+}
+
+// 传输调度，只在时间窗口内运行传输任务，不同窗口可设置不同的限速
+type Schedule struct {
+	Windows  []Window
+	Location *time.Location // 时间窗口所在时区，为nil时使用本地时区
+}
+
+func NewSchedule() *Schedule {
+	return &Schedule{
+		Location: time.Local,
+	}
+}
+
+func (s *Schedule) SetLocation(location *time.Location) {
+	s.Location = location
+}
+
+// 添加时间窗口，start、end格式为15:04，如AddWindow("01:00", "07:00", 0)
+func (s *Schedule) AddWindow(start, end string, rateLimit int64) error {
+	startOffset, err := parseClock(start)
+	if err != nil {
+		return err
+	}
+	endOffset, err := parseClock(end)
+	if err != nil {
+		return err
+	}
+	s.Windows = append(s.Windows, Window{
+		Start:     startOffset,
+		End:       endOffset,
+		RateLimit: rateLimit,
+	})
+	return nil
+}
+
+// 获取t所在的时间窗口，没有设置窗口时不限制运行时间
+func (s *Schedule) Active(t time.Time) (Window, bool) {
+	if s == nil || len(s.Windows) == 0 {
+		return Window{}, true
+	}
+	t = t.In(s.location())
+	offset := t.Sub(midnight(t))
+	for _, window := range s.Windows {
+		if window.contains(offset) {
+			return window, true
+		}
+	}
+	return Window{}, false
+}
+
+// 获取t之后最近的窗口开始时间
+func (s *Schedule) NextStart(t time.Time) time.Time {
+	t = t.In(s.location())
+	var next time.Time
+	for _, window := range s.Windows {
+		start := midnight(t).Add(window.Start)
+		if !start.After(t) {
+			start = midnight(t.AddDate(0, 0, 1)).Add(window.Start)
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
+
+// 阻塞直到进入时间窗口，返回当前所在窗口
+func (s *Schedule) Wait(ctx context.Context) (Window, error) {
+	for {
+		now := time.Now()
+		if window, ok := s.Active(now); ok {
+			return window, nil
+		}
+		timer := time.NewTimer(s.NextStart(now).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return Window{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Schedule) location() *time.Location {
+	if s.Location == nil {
+		return time.Local
+	}
+	return s.Location
+}
+
+func (w Window) contains(offset time.Duration) bool {
+	if w.End > w.Start {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func parseClock(clock string) (time.Duration, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid clock %s, err: %v", clock, err))
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,175 @@
+// 审计日志，以JSON Lines格式记录接口请求和传输结果
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jsyzchen/pan/utils/httpclient"
+)
+
+const (
+	TypeAPI      = "api"
+	TypeUpload   = "upload"
+	TypeDownload = "download"
+)
+
+// 不记录值的参数
+var defaultSecretParams = []string{"access_token", "refresh_token", "client_secret", "code", "pwd", "sekey", "randsk", "spwd"}
+
+// 审计记录
+type Record struct {
+	Time      time.Time         `json:"time"`
+	Type      string            `json:"type"`
+	Method    string            `json:"method,omitempty"`
+	Endpoint  string            `json:"endpoint,omitempty"` // 不含参数的接口地址
+	Params    map[string]string `json:"params,omitempty"`   // 已去除敏感参数
+	Path      string            `json:"path,omitempty"`     // 传输的网盘路径
+	FsID      uint64            `json:"fs_id,omitempty"`
+	LocalPath string            `json:"local_path,omitempty"`
+	Status    string            `json:"status"` // 接口请求为HTTP状态码，传输为file.TransferStatus
+	Bytes     int64             `json:"bytes"`
+	Duration  int64             `json:"duration"` // 单位毫秒
+	Error     string            `json:"error,omitempty"`
+}
+
+// Writer 审计日志写入器，同时实现http.RoundTripper，通过httpclient.SetTransport开启全部接口请求的审计
+// 只审计单个客户端的请求时使用客户端的SetAudit或Context，不同客户端可以写入不同的Writer
+type Writer struct {
+	W            io.Writer
+	Transport    http.RoundTripper // 实际发起请求的Transport，为nil时使用httpclient.DefaultTransport()
+	SecretParams []string
+
+	mu sync.Mutex
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		W:            w,
+		SecretParams: defaultSecretParams,
+	}
+}
+
+func (a *Writer) SetTransport(transport http.RoundTripper) {
+	a.Transport = transport
+}
+
+// 写入一条审计记录，a为nil时不记录
+func (a *Writer) Write(record Record) error {
+	if a == nil {
+		return nil
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.W.Write(append(line, '\n'))
+	return err
+}
+
+// 记录传输结果，补充开始时间、耗时和错误信息
+func (a *Writer) WriteTransfer(record Record, startTime time.Time, err error) error {
+	record.Time = startTime
+	record.Duration = time.Since(startTime).Milliseconds()
+	if err != nil {
+		record.Error = err.Error()
+	}
+	return a.Write(record)
+}
+
+func (a *Writer) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := a.Transport
+	if transport == nil {
+		transport = httpclient.DefaultTransport()
+	}
+	return a.roundTrip(transport, req)
+}
+
+// 返回记录请求后转发给next的Transport
+func (a *Writer) Wrap(next http.RoundTripper) http.RoundTripper {
+	return &wrappedTransport{writer: a, next: next}
+}
+
+// 返回的ctx发起的接口请求记录到a，其他请求不受影响，a为nil时直接返回ctx
+func (a *Writer) Context(ctx context.Context) context.Context {
+	if a == nil {
+		return ctx
+	}
+	return httpclient.WithTransportWrapper(ctx, a.Wrap)
+}
+
+type wrappedTransport struct {
+	writer *Writer
+	next   http.RoundTripper
+}
+
+func (t *wrappedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.writer.roundTrip(t.next, req)
+}
+
+func (a *Writer) roundTrip(transport http.RoundTripper, req *http.Request) (*http.Response, error) {
+	record := Record{
+		Time:     time.Now(),
+		Type:     TypeAPI,
+		Method:   req.Method,
+		Endpoint: req.URL.Scheme + "://" + req.URL.Host + req.URL.Path,
+		Params:   a.params(req),
+		Bytes:    req.ContentLength,
+	}
+	resp, err := transport.RoundTrip(req)
+	record.Duration = time.Since(record.Time).Milliseconds()
+	if err != nil {
+		record.Error = err.Error()
+	} else {
+		record.Status = strings.TrimSpace(resp.Status)
+		if resp.ContentLength > 0 {
+			record.Bytes += resp.ContentLength
+		}
+	}
+	a.Write(record)
+	return resp, err
+}
+
+// 获取请求参数，包括表单请求体中的参数，敏感参数只记录参数名
+func (a *Writer) params(req *http.Request) map[string]string {
+	values := url.Values{}
+	for k, v := range req.URL.Query() {
+		values[k] = v
+	}
+	if req.Body != nil && strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err == nil {
+			if form, err := url.ParseQuery(string(body)); err == nil {
+				for k, v := range form {
+					values[k] = v
+				}
+			}
+		}
+	}
+
+	params := make(map[string]string, len(values))
+	for k := range values {
+		params[k] = values.Get(k)
+	}
+	for _, secret := range a.SecretParams {
+		if _, ok := params[secret]; ok {
+			params[secret] = "***"
+		}
+	}
+	return params
+}
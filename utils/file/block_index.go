@@ -0,0 +1,126 @@
+package file
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// 网盘文件上传时使用的分片md5
+type BlockIndexEntry struct {
+	Md5       string   `json:"md5"`
+	Size      int64    `json:"size"`
+	SliceSize int64    `json:"slice_size"`
+	BlockList []string `json:"block_list"`
+	UpdatedAt int64    `json:"updated_at"`
+}
+
+// BlockIndex 记录上传到网盘的文件的分片md5，以网盘路径为key，差异上传时与本地文件的分片比较
+// 调用Save持久化到文件
+type BlockIndex struct {
+	FilePath string // 持久化文件路径，为空时只保存在内存中
+
+	mu      sync.Mutex
+	entries map[string]*BlockIndexEntry
+}
+
+// 创建分片索引，filePath存在时加载已有的索引
+func NewBlockIndex(filePath string) (*BlockIndex, error) {
+	b := &BlockIndex{
+		FilePath: filePath,
+		entries:  map[string]*BlockIndexEntry{},
+	}
+	if filePath == "" {
+		return b, nil
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return b, nil
+		}
+		log.Println("blockIndex ioutil.ReadFile failed, err:", err)
+		return b, err
+	}
+	if err := json.Unmarshal(data, &b.entries); err != nil {
+		log.Println("blockIndex json.Unmarshal failed, err:", err)
+		b.entries = map[string]*BlockIndexEntry{}
+	}
+	return b, nil
+}
+
+// 获取网盘文件的分片md5
+func (b *BlockIndex) Get(path string) (BlockIndexEntry, bool) {
+	if b == nil {
+		return BlockIndexEntry{}, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.entries[path]
+	if !ok {
+		return BlockIndexEntry{}, false
+	}
+	return *entry, true
+}
+
+// 记录网盘文件的分片md5，覆盖原有记录
+func (b *BlockIndex) Put(path string, entry BlockIndexEntry) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.entries == nil {
+		b.entries = map[string]*BlockIndexEntry{}
+	}
+	entry.UpdatedAt = time.Now().Unix()
+	b.entries[path] = &entry
+}
+
+// 删除网盘文件的记录，网盘文件被删除或修改后调用
+func (b *BlockIndex) Remove(path string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.entries, path)
+}
+
+// 与记录的分片比较，返回序号相同且md5相同的分片，分片大小不同时全部视为已修改
+func (e BlockIndexEntry) Unchanged(sliceSize int64, blockList []string) map[int]string {
+	unchanged := map[int]string{}
+	if e.SliceSize != sliceSize {
+		return unchanged
+	}
+	for i, blockMd5 := range blockList {
+		if i >= len(e.BlockList) {
+			break
+		}
+		if blockMd5 != "" && blockMd5 == e.BlockList[i] {
+			unchanged[i] = blockMd5
+		}
+	}
+	return unchanged
+}
+
+// 保存到持久化文件，先写临时文件再重命名，避免写入中断导致索引文件损坏
+func (b *BlockIndex) Save() error {
+	if b == nil || b.FilePath == "" {
+		return nil
+	}
+	b.mu.Lock()
+	data, err := json.Marshal(b.entries)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmpPath := b.FilePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Println("blockIndex ioutil.WriteFile failed, err:", err)
+		return err
+	}
+	return os.Rename(tmpPath, b.FilePath)
+}
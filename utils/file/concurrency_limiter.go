@@ -0,0 +1,84 @@
+package file
+
+import (
+	"context"
+	"sync"
+)
+
+// ConcurrencyLimiter 可在运行中调整上限的并发限制，为nil时不限制
+type ConcurrencyLimiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+}
+
+// limit小于1时按1处理
+func NewConcurrencyLimiter(limit int) *ConcurrencyLimiter {
+	l := &ConcurrencyLimiter{limit: limit}
+	if l.limit < 1 {
+		l.limit = 1
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// 调整并发上限，调小时已运行的任务不受影响，结束后才释放名额
+func (l *ConcurrencyLimiter) SetLimit(limit int) {
+	if limit < 1 {
+		limit = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.cond.Broadcast()
+}
+
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// 获取一个名额，达到上限时阻塞直到有任务结束或ctx结束
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.mu.Lock()
+			l.cond.Broadcast()
+			l.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.active >= l.limit {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.cond.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.active++
+	return nil
+}
+
+// 释放名额
+func (l *ConcurrencyLimiter) Release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.cond.Broadcast()
+}
@@ -0,0 +1,125 @@
+package file
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/jsyzchen/pan/errno"
+)
+
+const (
+	maxDiagnosticRequestIDs = 20 // 诊断信息最多保留的请求ID数
+	maxDiagnosticErrors     = 50 // 诊断信息最多保留的分片错误数
+)
+
+// 响应头中的请求ID，排查下载问题时提供给服务端
+var requestIDHeaders = []string{"X-Bs-Request-Id", "X-Pcs-Request-Id", "X-Request-Id"}
+
+// 分片下载失败的记录
+type DownloadPartError struct {
+	Index      int    `json:"index"`
+	Attempt    int    `json:"attempt"` // 第几次尝试，从0开始
+	Error      string `json:"error"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Time       int64  `json:"time"`
+}
+
+// 下载诊断信息，开启Debug时记录在快照中，用于事后排查下载停滞等问题
+type DownloadDiagnostics struct {
+	DlinkHost  string              `json:"dlink_host,omitempty"`  // 下载地址的域名
+	CdnHost    string              `json:"cdn_host,omitempty"`    // 跳转后实际下载的CDN域名
+	RequestIDs []string            `json:"request_ids,omitempty"` // 最近的响应请求ID
+	PartErrors []DownloadPartError `json:"part_errors,omitempty"` // 最近的分片下载错误
+}
+
+type diagnosticsRecorder struct {
+	mu   sync.Mutex
+	diag *DownloadDiagnostics
+}
+
+// 设置是否记录诊断信息，开启后下载快照的Diagnostics字段记录下载域名、请求ID和分片错误
+func (d *Downloader) SetDebug(debug bool) {
+	d.Debug = debug
+}
+
+// 获取诊断信息，未开启Debug时返回nil
+func (d *Downloader) Diagnostics() *DownloadDiagnostics {
+	if !d.Debug {
+		return nil
+	}
+	d.recorder.mu.Lock()
+	defer d.recorder.mu.Unlock()
+	diag := d.initDiagnostics()
+	ret := *diag
+	ret.RequestIDs = append([]string{}, diag.RequestIDs...)
+	ret.PartErrors = append([]DownloadPartError{}, diag.PartErrors...)
+	return &ret
+}
+
+// 续传时沿用快照中已有的诊断信息
+func (d *Downloader) seedDiagnostics(diag *DownloadDiagnostics) {
+	if !d.Debug || diag == nil {
+		return
+	}
+	d.recorder.mu.Lock()
+	defer d.recorder.mu.Unlock()
+	seed := *diag
+	seed.RequestIDs = append([]string{}, diag.RequestIDs...)
+	seed.PartErrors = append([]DownloadPartError{}, diag.PartErrors...)
+	d.recorder.diag = &seed
+}
+
+// 调用方需持有锁
+func (d *Downloader) initDiagnostics() *DownloadDiagnostics {
+	if d.recorder.diag == nil {
+		d.recorder.diag = &DownloadDiagnostics{}
+	}
+	if u, err := url.Parse(d.Link); err == nil {
+		d.recorder.diag.DlinkHost = u.Host
+	}
+	return d.recorder.diag
+}
+
+// 记录响应的CDN域名和请求ID
+func (d *Downloader) recordResponse(resp *http.Response) {
+	if !d.Debug || resp == nil {
+		return
+	}
+	d.recorder.mu.Lock()
+	defer d.recorder.mu.Unlock()
+	diag := d.initDiagnostics()
+	if resp.Request != nil && resp.Request.URL != nil {
+		diag.CdnHost = resp.Request.URL.Host
+	}
+	for _, header := range requestIDHeaders {
+		if requestID := resp.Header.Get(header); requestID != "" {
+			diag.RequestIDs = append(diag.RequestIDs, requestID)
+			break
+		}
+	}
+	if len(diag.RequestIDs) > maxDiagnosticRequestIDs {
+		diag.RequestIDs = diag.RequestIDs[len(diag.RequestIDs)-maxDiagnosticRequestIDs:]
+	}
+}
+
+// 记录分片下载错误
+func (d *Downloader) recordPartError(index, attempt int, err error) {
+	if !d.Debug || err == nil {
+		return
+	}
+	d.recorder.mu.Lock()
+	defer d.recorder.mu.Unlock()
+	diag := d.initDiagnostics()
+	partErr := DownloadPartError{Index: index, Attempt: attempt, Error: err.Error(), Time: time.Now().Unix()}
+	var httpErr *errno.HTTPError
+	if errors.As(err, &httpErr) {
+		partErr.StatusCode = httpErr.StatusCode
+	}
+	diag.PartErrors = append(diag.PartErrors, partErr)
+	if len(diag.PartErrors) > maxDiagnosticErrors {
+		diag.PartErrors = diag.PartErrors[len(diag.PartErrors)-maxDiagnosticErrors:]
+	}
+}
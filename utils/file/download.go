@@ -13,7 +13,11 @@ import (
 	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/jsyzchen/pan/errno"
+	"github.com/jsyzchen/pan/utils/httpclient"
 )
 
 // downloadPartSnapshot 下载分片快照
@@ -35,6 +39,156 @@ type DownloadSnapshot struct {
 	PartSize    int64                  `json:"part_size"`
 	TotalPart   int                    `json:"total_part"`
 	DoneParts   []DownloadPartSnapshot `json:"done_parts"`
+	Status      TransferStatus         `json:"status,omitempty"`
+	JobID       string                 `json:"job_id,omitempty"`      // 下载任务ID，分片文件名包含该ID，续传时沿用
+	UpdatedAt   int64                  `json:"updated_at,omitempty"`  // 快照最后更新的时间
+	Diagnostics *DownloadDiagnostics   `json:"diagnostics,omitempty"` // 诊断信息，下载器开启Debug时记录
+}
+
+// 检查快照中的分片范围是否连续且覆盖整个文件，续传只使用快照记录的分片范围，与当前的默认分片大小无关
+func (s *DownloadSnapshot) ValidateParts() error {
+	if s.TotalPart <= 0 || len(s.DoneParts) != s.TotalPart {
+		return errors.New(fmt.Sprintf("snapshot part num mismatch, totalPart: %d parts: %d", s.TotalPart, len(s.DoneParts)))
+	}
+	var next int64 = 0
+	for i, part := range s.DoneParts {
+		if part.From != next || part.To < part.From {
+			return errors.New(fmt.Sprintf("snapshot part range invalid, index: %d from: %d to: %d", i, part.From, part.To))
+		}
+		next = part.To + 1
+	}
+	if next != s.TotalSize {
+		return errors.New(fmt.Sprintf("snapshot parts size mismatch, partsSize: %d totalSize: %d", next, s.TotalSize))
+	}
+	return nil
+}
+
+// 重新定位快照中的临时分片文件和保存路径，用于两次下载之间临时目录或保存路径被移动的场景
+// 分片文件大小与快照记录不一致的，视为未下载，需要重新下载
+func (s *DownloadSnapshot) Relocate(tempDir, savePath string) error {
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	if info, err := os.Stat(tempDir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return errors.New(fmt.Sprintf("relocate temp dir is not a directory, tempDir: %s", tempDir))
+	}
+	if savePath != "" {
+		s.SavePath = savePath
+	}
+	for i, part := range s.DoneParts {
+		if part.FilePath == "" {
+			continue
+		}
+		partSize := part.To - part.From + 1
+		newFilePath := filepath.Join(tempDir, filepath.Base(part.FilePath))
+		info, err := os.Stat(newFilePath)
+		if err == nil && !info.IsDir() && info.Size() == partSize {
+			s.DoneParts[i].FilePath = newFilePath
+			continue
+		}
+		log.Printf("relocate part file invalid path: %s expectedSize: %d err: %v", newFilePath, partSize, err)
+		s.DoneParts[i].FilePath = ""
+		s.DoneSize -= partSize
+	}
+	if s.DoneSize < 0 {
+		s.DoneSize = 0
+	}
+	return nil
+}
+
+// 分片已全部下载，按设置推迟合并
+var ErrMergeDeferred = errors.New("download merge deferred")
+
+// 合并后的文件大小与文件总大小不一致，错误信息随errno.SetLocale设置的语言变化
+var ErrFileIncomplete error = errno.NewLocalizedError(errno.Message{Default: "文件不完整", Zh: "文件不完整", En: "file incomplete"})
+
+// 日志和错误信息，随errno.SetLocale设置的语言变化
+var (
+	msgServerError      = errno.Message{Default: "服务器错误", Zh: "服务器错误", En: "server error"}
+	msgPartStart        = errno.Message{Default: "开始[%d]下载", Zh: "开始[%d]下载", En: "start downloading part [%d]"}
+	msgPartDone         = errno.Message{Default: "结束[%d]下载", Zh: "结束[%d]下载", En: "finished downloading part [%d]"}
+	msgPartSizeMismatch = errno.Message{Default: "下载文件分片长度错误", Zh: "下载文件分片长度错误", En: "downloaded part size mismatch"}
+	msgMergeStart       = errno.Message{Default: "开始合并文件", Zh: "开始合并文件", En: "start merging file parts"}
+)
+
+// 分片文件检查结果
+type PartsReport struct {
+	Ready        bool  // 全部分片文件存在且大小正确，可以合并
+	MissingParts []int // 未下载或分片文件不存在的分片序号
+	InvalidParts []int // 分片文件大小与分片范围不一致的分片序号
+	DoneSize     int64 // 有效分片的总大小
+}
+
+// 检查快照中的分片文件，不合并也不修改快照，用于判断任务是否可以合并
+func (s *DownloadSnapshot) VerifyParts() (PartsReport, error) {
+	report := PartsReport{}
+	if err := s.ValidateParts(); err != nil {
+		return report, err
+	}
+	for i, part := range s.DoneParts {
+		if part.FilePath == "" {
+			report.MissingParts = append(report.MissingParts, i)
+			continue
+		}
+		partSize := part.To - part.From + 1
+		info, err := os.Stat(part.FilePath)
+		if err != nil {
+			report.MissingParts = append(report.MissingParts, i)
+			continue
+		}
+		if info.IsDir() || info.Size() != partSize {
+			report.InvalidParts = append(report.InvalidParts, i)
+			continue
+		}
+		report.DoneSize += partSize
+	}
+	report.Ready = len(report.MissingParts) == 0 && len(report.InvalidParts) == 0 && report.DoneSize == s.TotalSize
+	return report, nil
+}
+
+// 合并快照中已下载完成的分片文件，savePath为空时使用快照的保存路径
+// 合并成功后快照标记为不可恢复，返回可以删除的分片文件
+func MergeSnapshot(ctx context.Context, snapshot *DownloadSnapshot, savePath string, progressHandler func(int, int64, int64)) ([]string, error) {
+	return MergeSnapshotValidated(ctx, snapshot, savePath, nil, progressHandler)
+}
+
+// 合并快照中的分片，合并后调用校验钩子，未通过校验时返回ValidationError，分片文件同样返回以便删除
+func MergeSnapshotValidated(ctx context.Context, snapshot *DownloadSnapshot, savePath string, validate ValidateHook, progressHandler func(int, int64, int64)) ([]string, error) {
+	report, err := snapshot.VerifyParts()
+	if err != nil {
+		return []string{}, err
+	}
+	if !report.Ready {
+		return []string{}, errors.New(fmt.Sprintf("mergeSnapshot parts not ready, missing: %v invalid: %v", report.MissingParts, report.InvalidParts))
+	}
+	if savePath == "" {
+		savePath = snapshot.SavePath
+	}
+
+	d := &Downloader{FilePath: savePath, FileSize: snapshot.TotalSize, Validate: validate}
+	parts := make([]Part, len(snapshot.DoneParts))
+	for i, p := range snapshot.DoneParts {
+		parts[i] = Part{Index: i, From: p.From, To: p.To, FilePath: p.FilePath}
+	}
+	var doneSize int64
+	err = d.mergeFileParts(ctx, parts, func(size int64) {
+		doneSize += size
+		if progressHandler != nil {
+			progressHandler(3, doneSize, snapshot.TotalSize)
+		}
+	})
+	if err != nil && !errors.Is(err, ErrValidationRejected) {
+		log.Printf("mergeSnapshot mergeFileParts failed savePath: %s err: %v", savePath, err)
+		return []string{}, err
+	}
+	snapshot.Recoverable = false
+	delFiles := make([]string, 0, len(parts))
+	for _, p := range parts {
+		delFiles = append(delFiles, p.FilePath)
+	}
+	return delFiles, err
 }
 
 // FileDownloader 文件下载器
@@ -44,7 +198,41 @@ type Downloader struct {
 	FilePath         string
 	TotalPart        int //下载线程
 	PartSize         int64
-	PartCoroutineNum int //分片下载协程数
+	PartCoroutineNum int                 //分片下载协程数
+	BufferSize       int64               //读写缓冲区大小，为0时使用1M
+	RateLimiter      *RateLimiter        //限速器，为nil时不限速
+	JobID            string              //下载任务ID，用于分片文件名，Download和ResumeDownload时从快照中获取
+	DeferMerge       bool                //分片全部下载完成后不合并，返回ErrMergeDeferred，之后通过MergeSnapshot合并
+	Validate         ValidateHook        //下载完成后的校验钩子，为nil时不校验
+	Debug            bool                //记录下载域名、请求ID和分片错误等诊断信息到快照中
+	PartLimiter      *ConcurrencyLimiter //分片并发数上限，可在下载中调整，实际并发数不超过PartCoroutineNum，为nil时不限制
+	retries          int64               //分片重试次数
+	recorder         diagnosticsRecorder
+}
+
+const defaultDownloadBufferSize = 1024 * 1024
+
+// 读写缓冲区池，按缓冲区大小区分
+var bufferPools sync.Map
+
+func getBuffer(size int64) *[]byte {
+	pool, ok := bufferPools.Load(size)
+	if !ok {
+		pool, _ = bufferPools.LoadOrStore(size, &sync.Pool{
+			New: func() interface{} {
+				buffer := make([]byte, size)
+				return &buffer
+			},
+		})
+	}
+	return pool.(*sync.Pool).Get().(*[]byte)
+}
+
+func putBuffer(buffer *[]byte) {
+	if pool, ok := bufferPools.Load(int64(cap(*buffer))); ok {
+		*buffer = (*buffer)[:cap(*buffer)]
+		pool.(*sync.Pool).Put(buffer)
+	}
 }
 
 // filePart 文件分片
@@ -83,6 +271,32 @@ func (d *Downloader) SetCoroutineNum(partCoroutineNum int) {
 	d.PartCoroutineNum = partCoroutineNum
 }
 
+// 设置读写缓冲区大小，磁盘或网络较慢时可适当调大
+func (d *Downloader) SetBufferSize(bufferSize int64) {
+	d.BufferSize = bufferSize
+}
+
+// 设置分片全部下载完成后是否推迟合并，如在磁盘空闲时段统一合并
+func (d *Downloader) SetDeferMerge(deferMerge bool) {
+	d.DeferMerge = deferMerge
+}
+
+// 设置分片并发数上限，多个任务可共用一个ConcurrencyLimiter，调整上限后正在下载的任务也会生效
+func (d *Downloader) SetPartLimiter(partLimiter *ConcurrencyLimiter) {
+	d.PartLimiter = partLimiter
+}
+
+func (d *Downloader) SetRateLimiter(rateLimiter *RateLimiter) {
+	d.RateLimiter = rateLimiter
+}
+
+func (d *Downloader) bufferSize(defaultSize int64) int64 {
+	if d.BufferSize > 0 {
+		return d.BufferSize
+	}
+	return defaultSize
+}
+
 func (d *Downloader) ensureDirExist(path string, isDir bool) error {
 	dir := ""
 	if isDir {
@@ -105,10 +319,23 @@ func (d *Downloader) ensureDirExist(path string, isDir bool) error {
 
 // Run 开始下载任务
 func (d *Downloader) Download(ctx context.Context, tempDir string, snapshot *DownloadSnapshot, progressHandler func(int, int64, int64)) ([]string, error) {
+	if progressHandler == nil {
+		progressHandler = func(int, int64, int64) {}
+	}
+	if d.Debug {
+		defer func() {
+			snapshot.Diagnostics = d.Diagnostics()
+		}()
+	}
 	if err := d.ensureDirExist(tempDir, true); err != nil {
 		return []string{}, err
 	}
 
+	if snapshot.JobID == "" {
+		snapshot.JobID = NewJobID()
+	}
+	d.JobID = snapshot.JobID
+
 	fileTotalSize := d.FileSize
 	if d.TotalPart == 0 || fileTotalSize/d.PartSize < int64(d.TotalPart) { //减少range请求次数
 		d.TotalPart = int(math.Ceil(float64(fileTotalSize) / float64(d.PartSize)))
@@ -164,30 +391,30 @@ func (d *Downloader) Download(ctx context.Context, tempDir string, snapshot *Dow
 			progressTick = newTick
 		}
 	}
-	hasFailed := false
+	//任一分片失败时取消其余分片，不再开始新的分片
+	group, partCtx := NewFailGroup(ctx)
+	defer group.Cancel()
 	var downloadErr error
 	downloadPartNum := 0
 	for _, job := range jobs {
-		if hasFailed {
-			break
-		}
-		select {
-		case <-ctx.Done():
+		if partCtx.Err() != nil {
 			downloadErr = ctx.Err()
-		default:
 			break
 		}
-		if downloadErr != nil {
+		sem <- 1 //当通道已满的时候将被阻塞
+		if err := d.PartLimiter.Acquire(partCtx); err != nil {
+			<-sem
+			downloadErr = ctx.Err()
 			break
 		}
-		sem <- 1 //当通道已满的时候将被阻塞
 		go func(job Part) {
-			part, err := d.tryDownloadPart(ctx, job, tempDir, internalProgressHandler)
+			part, err := d.tryDownloadPart(partCtx, job, tempDir, internalProgressHandler)
 			if err != nil {
 				log.Printf("download downloader.tryDownloadPart failed savePath: %s part: %v err: %v", d.FilePath, job, err)
-				hasFailed = true
+				group.Fail(err)
 			}
 			downloadRespChan <- DownloadPartResponse{part, err}
+			d.PartLimiter.Release()
 			<-sem
 		}(job)
 		downloadPartNum++
@@ -209,6 +436,9 @@ func (d *Downloader) Download(ctx context.Context, tempDir string, snapshot *Dow
 		snapshot.DoneParts[resp.Part.Index].FilePath = resp.Part.FilePath
 		snapshot.DoneSize += (resp.Part.To - resp.Part.From + 1)
 	}
+	if err := group.Err(); err != nil { //其余分片因取消而失败，返回第一个失败分片的错误
+		downloadErr = err
+	}
 	if downloadErr != nil {
 		return delFiles, downloadErr
 	} else if downloadPartNum != d.TotalPart {
@@ -217,6 +447,11 @@ func (d *Downloader) Download(ctx context.Context, tempDir string, snapshot *Dow
 		return delFiles, errors.New(errStr)
 	}
 
+	if d.DeferMerge {
+		log.Printf("download parts done, merge deferred savePath: %s", d.FilePath)
+		return delFiles, ErrMergeDeferred
+	}
+
 	doneSize = 0
 	mergeProgressHandler := func(partDoneSize int64) {
 		doneSize += partDoneSize
@@ -228,7 +463,7 @@ func (d *Downloader) Download(ctx context.Context, tempDir string, snapshot *Dow
 		}
 	}
 	downloadErr = d.mergeFileParts(ctx, doneParts, mergeProgressHandler)
-	if downloadErr == nil {
+	if downloadErr == nil || errors.Is(downloadErr, ErrValidationRejected) { //未通过校验时重新下载的内容相同，不再续传
 		for _, p := range doneParts {
 			delFiles = append(delFiles, p.FilePath)
 		}
@@ -240,10 +475,29 @@ func (d *Downloader) Download(ctx context.Context, tempDir string, snapshot *Dow
 
 // 从断点继续下载
 func (d *Downloader) ResumeDownload(ctx context.Context, tempDir string, snapshot *DownloadSnapshot, progressHandler func(int, int64, int64)) ([]string, error) {
+	if progressHandler == nil {
+		progressHandler = func(int, int64, int64) {}
+	}
+	if d.Debug {
+		d.seedDiagnostics(snapshot.Diagnostics)
+		defer func() {
+			snapshot.Diagnostics = d.Diagnostics()
+		}()
+	}
 	if err := d.ensureDirExist(tempDir, true); err != nil {
 		return []string{}, err
 	}
 
+	if err := snapshot.ValidateParts(); err != nil {
+		log.Printf("resumeDownload snapshot.ValidateParts failed savePath: %s err: %v", d.FilePath, err)
+		return []string{}, err
+	}
+
+	if snapshot.JobID == "" { //旧版本快照没有任务ID，之后下载的分片使用新ID
+		snapshot.JobID = NewJobID()
+	}
+	d.JobID = snapshot.JobID
+
 	fileTotalSize := snapshot.TotalSize
 	d.TotalPart = snapshot.TotalPart
 	log.Printf("resumeDownload totalPart: %d savePath: %s", d.TotalPart, d.FilePath)
@@ -270,7 +524,9 @@ func (d *Downloader) ResumeDownload(ctx context.Context, tempDir string, snapsho
 			progressTick = newTick
 		}
 	}
-	hasFailed := false
+	//任一分片失败时取消其余分片，不再开始新的分片
+	group, partCtx := NewFailGroup(ctx)
+	defer group.Cancel()
 	var downloadErr error
 	downloadPartNum := 0
 	donePartNum := 0
@@ -294,16 +550,8 @@ func (d *Downloader) ResumeDownload(ctx context.Context, tempDir string, snapsho
 	}
 	snapshot.DoneSize = doneSize
 	for i, part := range snapshot.DoneParts {
-		if hasFailed {
-			break
-		}
-		select {
-		case <-ctx.Done():
+		if partCtx.Err() != nil {
 			downloadErr = ctx.Err()
-		default:
-			break
-		}
-		if downloadErr != nil {
 			break
 		}
 		if part.FilePath != "" {
@@ -311,13 +559,19 @@ func (d *Downloader) ResumeDownload(ctx context.Context, tempDir string, snapsho
 			continue
 		}
 		sem <- 1 //当通道已满的时候将被阻塞
+		if err := d.PartLimiter.Acquire(partCtx); err != nil {
+			<-sem
+			downloadErr = ctx.Err()
+			break
+		}
 		go func(job Part) {
-			part, err := d.tryDownloadPart(ctx, job, tempDir, internalProgressHandler)
+			part, err := d.tryDownloadPart(partCtx, job, tempDir, internalProgressHandler)
 			if err != nil {
 				log.Printf("resumeDownload downloader.tryDownloadPart failed savePath: %s part: %v err: %v", d.FilePath, job, err)
-				hasFailed = true
+				group.Fail(err)
 			}
 			downloadRespChan <- DownloadPartResponse{part, err}
+			d.PartLimiter.Release()
 			<-sem
 		}(Part{Index: i, From: part.From, To: part.To})
 		downloadPartNum++
@@ -338,6 +592,9 @@ func (d *Downloader) ResumeDownload(ctx context.Context, tempDir string, snapsho
 		snapshot.DoneParts[resp.Part.Index].FilePath = resp.Part.FilePath
 		snapshot.DoneSize += (resp.Part.To - resp.Part.From + 1)
 	}
+	if err := group.Err(); err != nil { //其余分片因取消而失败，返回第一个失败分片的错误
+		downloadErr = err
+	}
 	if downloadErr != nil {
 		return delFiles, downloadErr
 	} else if donePartNum != d.TotalPart {
@@ -345,6 +602,11 @@ func (d *Downloader) ResumeDownload(ctx context.Context, tempDir string, snapsho
 		return delFiles, errors.New("done part num and total part mismatch")
 	}
 
+	if d.DeferMerge {
+		log.Printf("download parts done, merge deferred savePath: %s", d.FilePath)
+		return delFiles, ErrMergeDeferred
+	}
+
 	doneSize = 0
 	mergeProgressHandler := func(partDoneSize int64) {
 		doneSize += partDoneSize
@@ -360,7 +622,7 @@ func (d *Downloader) ResumeDownload(ctx context.Context, tempDir string, snapsho
 		doneParts[i] = Part{Index: i, From: p.From, To: p.To, FilePath: p.FilePath}
 	}
 	downloadErr = d.mergeFileParts(ctx, doneParts, mergeProgressHandler)
-	if downloadErr == nil {
+	if downloadErr == nil || errors.Is(downloadErr, ErrValidationRejected) { //未通过校验时重新下载的内容相同，不再续传
 		for _, p := range doneParts {
 			delFiles = append(delFiles, p.FilePath)
 		}
@@ -376,10 +638,14 @@ func (d *Downloader) TryPrepare(ctx context.Context) (bool, error) {
 	var err error
 	for i := 0; i < 5; i++ {
 		if i > 0 {
-			time.Sleep(time.Second)
+			select {
+			case <-ctx.Done():
+				return supportRange, err
+			case <-time.After(time.Second):
+			}
 		}
 		supportRange, err = d.Prepare(ctx)
-		if err == nil {
+		if err == nil || !errno.IsRetryable(err) {
 			break
 		}
 	}
@@ -393,12 +659,13 @@ func (d *Downloader) Prepare(ctx context.Context) (bool, error) {
 	if err != nil {
 		return isSupportRange, err
 	}
-	resp, err := http.DefaultClient.Do(r)
+	resp, err := httpclient.GetClientWithContext(r.Context()).Do(r)
 	if err != nil {
 		return isSupportRange, err
 	}
+	d.recordResponse(resp)
 	if resp.StatusCode > 299 {
-		return isSupportRange, errors.New(fmt.Sprintf("Can't process, response is %v", resp))
+		return isSupportRange, &errno.HTTPError{StatusCode: resp.StatusCode, Body: resp.Status}
 	}
 	//检查是否支持 断点续传
 	if resp.Header.Get("Accept-Ranges") == "bytes" {
@@ -426,24 +693,35 @@ func (d *Downloader) tryDownloadPart(ctx context.Context, part Part, tempDir str
 	var err error
 	for i := 0; i < 10; i++ {
 		if i > 0 {
-			time.Sleep(time.Second * 6)
+			select {
+			case <-ctx.Done():
+				return retPart, err
+			case <-time.After(time.Second * 6):
+			}
 		}
 		retPart, err = d.downloadPart(ctx, part, tempDir, i, internalProgressHandler)
 		if err == nil {
 			break
 		}
+		d.recordPartError(part.Index, i, err)
 		if retPart.FilePath != "" {
-			os.Remove(retPart.FilePath)
+			RemoveTempFile(retPart.FilePath)
 		}
 		progressHandler(-partDoneSize)
 		partDoneSize = 0
-		if ctx.Err() != nil {
+		if ctx.Err() != nil || !errno.IsRetryable(err) || errors.Is(err, ErrTempFileVetoed) {
 			break
 		}
+		atomic.AddInt64(&d.retries, 1)
 	}
 	return retPart, err
 }
 
+// 获取分片重试次数
+func (d *Downloader) Retries() int64 {
+	return atomic.LoadInt64(&d.retries)
+}
+
 // 下载分片
 func (d *Downloader) downloadPart(ctx context.Context, part Part, tempDir string, tryIter int, progressHandler func(int64)) (Part, error) {
 	retPart := part
@@ -451,30 +729,34 @@ func (d *Downloader) downloadPart(ctx context.Context, part Part, tempDir string
 	if err != nil {
 		return retPart, err
 	}
-	log.Printf("Downloader.downloadPart 开始[%d]下载 tryIter:%d from:%d to:%d\n", part.Index, tryIter, part.From, part.To)
+	log.Printf("Downloader.downloadPart "+msgPartStart.String()+" tryIter:%d from:%d to:%d\n", part.Index, tryIter, part.From, part.To)
 	r.Header.Set("Range", fmt.Sprintf("bytes=%v-%v", part.From, part.To))
-	resp, err := http.DefaultClient.Do(r)
+	resp, err := httpclient.GetClientWithContext(r.Context()).Do(r)
 	if err != nil {
 		return retPart, err
 	}
 	defer resp.Body.Close()
+	d.recordResponse(resp)
 
 	if resp.StatusCode > 299 {
 		buffer, _ := ioutil.ReadAll(resp.Body)
-		log.Println(fmt.Sprintf("Downloader.downloadPart 服务器错误 tryIter: %d statusCode: %v, msg:%s", tryIter, resp.StatusCode, string(buffer)))
-		return retPart, errors.New(fmt.Sprintf("服务器错误，状态码: %v, msg:%s", resp.StatusCode, string(buffer)))
+		log.Println(fmt.Sprintf("Downloader.downloadPart %s tryIter: %d statusCode: %v, msg:%s", msgServerError, tryIter, resp.StatusCode, string(buffer)))
+		return retPart, &errno.HTTPError{StatusCode: resp.StatusCode, Body: string(buffer)}
 	}
 
-	//分片文件写入到本地临时目录
+	//分片文件写入到本地临时目录，文件名包含任务ID，多个同名文件同时下载时不会冲突
 	fileName := filepath.Base(d.FilePath)
 	fileNamePrefix := fileName[0 : len(fileName)-len(filepath.Ext(d.FilePath))]
-	nowTime := time.Now().UnixNano() / 1e6
 	if tempDir == "" {
 		tempDir = os.TempDir()
 	}
-	partFilePath := filepath.Join(tempDir, fileNamePrefix+"_"+strconv.Itoa(part.Index)+"_"+strconv.FormatInt(nowTime, 10))
+	jobID := d.JobID
+	if jobID == "" {
+		jobID = strconv.FormatInt(time.Now().UnixNano()/1e6, 10)
+	}
+	partFilePath := filepath.Join(tempDir, fileNamePrefix+"_"+jobID+"_"+strconv.Itoa(part.Index))
 
-	f, err := os.Create(partFilePath)
+	f, err := CreateTempFile(partFilePath, part.To-part.From+1)
 	if err != nil {
 		log.Println("Downloader.downloadPart open file error :", err)
 		return retPart, err
@@ -482,42 +764,43 @@ func (d *Downloader) downloadPart(ctx context.Context, part Part, tempDir string
 	defer f.Close()
 	retPart.FilePath = partFilePath
 
-	buffer := make([]byte, 1024*1024)
-	doneSize, err := io.CopyBuffer(f, &ProgressByteReader{resp.Body, progressHandler}, buffer)
+	buffer := getBuffer(d.bufferSize(defaultDownloadBufferSize))
+	defer putBuffer(buffer)
+	doneSize, err := io.CopyBuffer(f, &ProgressByteReader{d.RateLimiter.Reader(ctx, resp.Body), progressHandler}, *buffer)
 	if err != nil && err != io.ErrUnexpectedEOF {
 		return retPart, err
 	}
 	expectedDoneSize := (part.To - part.From + 1)
 	if doneSize != expectedDoneSize {
-		return retPart, errors.New(fmt.Sprintf("Downloader.downloadPart 下载文件分片长度错误, doneSize:%d expectedDoneSize:%d", doneSize, expectedDoneSize))
+		return retPart, errors.New(fmt.Sprintf("Downloader.downloadPart %s, doneSize:%d expectedDoneSize:%d", msgPartSizeMismatch, doneSize, expectedDoneSize))
 	}
 
-	log.Printf("Downloader.downloadPart 结束[%d]下载 tryIter:%d from:%d to:%d\n", part.Index, tryIter, part.From, part.To)
+	log.Printf("Downloader.downloadPart "+msgPartDone.String()+" tryIter:%d from:%d to:%d\n", part.Index, tryIter, part.From, part.To)
 	return retPart, nil
 }
 
 // mergeFileParts 合并下载的文件
 func (d *Downloader) mergeFileParts(ctx context.Context, parts []Part, progressHandler func(int64)) error {
-	log.Println("开始合并文件")
+	log.Println(msgMergeStart)
 
 	if err := d.ensureDirExist(d.FilePath, false); err != nil {
 		return err
 	}
 
-	mergedFile, err := os.Create(d.FilePath)
+	mergedFile, err := os.Create(d.writePath())
 	if err != nil {
 		return err
 	}
 	defer mergedFile.Close()
 	var totalSize int64 = 0
-	buffer := make([]byte, 4*1024*1024)
 	copyFunc := func(filePath string) error {
 		partFile, err := os.Open(filePath)
 		if err != nil {
 			return err
 		}
 		defer partFile.Close()
-		nw, err := io.CopyBuffer(mergedFile, partFile, buffer)
+		// os.File.ReadFrom在支持的系统上使用copy_file_range/sendfile在内核中完成拷贝
+		nw, err := mergedFile.ReadFrom(partFile)
 		if err != nil {
 			return err
 		}
@@ -538,13 +821,19 @@ func (d *Downloader) mergeFileParts(ctx context.Context, parts []Part, progressH
 		}
 	}
 	if totalSize != d.FileSize {
-		return errors.New("文件不完整")
+		return ErrFileIncomplete
 	}
-	return nil
+	if err := mergedFile.Close(); err != nil {
+		return err
+	}
+	return d.finalize(ctx)
 }
 
 // 直接下载整个文件
 func (d *Downloader) DownloadWhole(ctx context.Context, totalSize int64, progressHandler func(int, int64, int64)) error {
+	if progressHandler == nil {
+		progressHandler = func(int, int64, int64) {}
+	}
 	log.Printf("downloadWhole savePath: %s", d.FilePath)
 
 	// Get the data
@@ -552,24 +841,27 @@ func (d *Downloader) DownloadWhole(ctx context.Context, totalSize int64, progres
 	if err != nil {
 		return err
 	}
-	resp, err := http.DefaultClient.Do(r)
+	resp, err := httpclient.GetClientWithContext(r.Context()).Do(r)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	d.recordResponse(resp)
 
 	if err := d.ensureDirExist(d.FilePath, false); err != nil {
 		return err
 	}
 
 	// 创建一个文件用于保存
-	f, err := os.Create(d.FilePath)
+	f, err := os.Create(d.writePath())
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	buffer := make([]byte, 1024*1024)
+	buffer := getBuffer(d.bufferSize(defaultDownloadBufferSize))
+	defer putBuffer(buffer)
+	body := d.RateLimiter.Reader(ctx, resp.Body)
 	var doneSize int64 = 0
 	progressTick := time.Now()
 	internalProgressHandler := func(status int, doneSize, totalSize int64) {
@@ -581,9 +873,9 @@ func (d *Downloader) DownloadWhole(ctx context.Context, totalSize int64, progres
 		}
 	}
 	for {
-		nr, err := resp.Body.Read(buffer)
+		nr, err := body.Read(*buffer)
 		if nr > 0 {
-			nw, err := f.Write(buffer[:nr])
+			nw, err := f.Write((*buffer)[:nr])
 			if err != nil {
 				return err
 			}
@@ -597,7 +889,10 @@ func (d *Downloader) DownloadWhole(ctx context.Context, totalSize int64, progres
 			break
 		}
 	}
-	return nil
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return d.finalize(ctx)
 }
 
 // getNewRequest 创建一个request
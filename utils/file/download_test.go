@@ -0,0 +1,36 @@
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 分片重试等待期间ctx结束时立即返回，不等到重试间隔结束
+func TestTryDownloadPartCanceledDuringRetryWait(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "pandownload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := &Downloader{Link: server.URL, FilePath: filepath.Join(dir, "a.bin")}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := d.tryDownloadPart(ctx, Part{Index: 0, From: 0, To: 9}, dir, func(int64) {}); err == nil {
+		t.Fatal("tryDownloadPart succeeded against a failing server")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("tryDownloadPart returned %v after ctx ended", elapsed)
+	}
+}
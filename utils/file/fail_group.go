@@ -0,0 +1,46 @@
+package file
+
+import (
+	"context"
+	"sync"
+)
+
+// FailGroup 一组并发分片任务的失败通知，类似errgroup
+// 第一个失败的任务记录错误并取消ctx，其余任务随之结束，不再开始新的分片
+type FailGroup struct {
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+// 返回的ctx在第一个任务失败或父ctx结束时取消，使用完需调用Cancel
+func NewFailGroup(ctx context.Context) (*FailGroup, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &FailGroup{cancel: cancel}, ctx
+}
+
+// 记录任务失败，只保留第一个错误，可在多个goroutine中调用
+func (g *FailGroup) Fail(err error) {
+	if err == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err == nil {
+		g.err = err
+		g.cancel()
+	}
+}
+
+// 第一个失败任务的错误
+func (g *FailGroup) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
+
+// 释放ctx
+func (g *FailGroup) Cancel() {
+	g.cancel()
+}
@@ -0,0 +1,78 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestFailGroupFirstErrorWins(t *testing.T) {
+	group, ctx := NewFailGroup(context.Background())
+	defer group.Cancel()
+
+	errs := make([]error, 32)
+	for i := range errs {
+		errs[i] = fmt.Errorf("slice %d failed", i)
+	}
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for _, err := range errs {
+		wg.Add(1)
+		go func(err error) {
+			defer wg.Done()
+			<-start
+			group.Fail(nil) //nil不算失败
+			group.Fail(err)
+			group.Err()
+		}(err)
+	}
+	close(start)
+	wg.Wait()
+
+	got := group.Err()
+	found := false
+	for _, err := range errs {
+		if got == err {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Err() = %v, want one of the reported errors", got)
+	}
+	select {
+	case <-ctx.Done():
+	default:
+		t.Fatal("ctx not canceled after Fail")
+	}
+	group.Fail(errors.New("later"))
+	if group.Err() != got {
+		t.Fatal("later failure replaced the first error")
+	}
+}
+
+func TestFailGroupNoFailure(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	group, ctx := NewFailGroup(parent)
+	defer group.Cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			group.Fail(nil)
+		}()
+	}
+	wg.Wait()
+	if group.Err() != nil || ctx.Err() != nil {
+		t.Fatalf("Err() = %v ctx.Err() = %v without failures", group.Err(), ctx.Err())
+	}
+
+	cancelParent()
+	<-ctx.Done()
+	if group.Err() != nil { //父ctx结束不记录为任务失败
+		t.Fatalf("Err() = %v after parent cancel", group.Err())
+	}
+}
@@ -0,0 +1,43 @@
+//go:build go1.18
+// +build go1.18
+
+// testing.F需要Go 1.18及以上，go.mod声明的是1.13，低版本编译时跳过本文件
+
+package file
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 校验通过的路径每一级都是合法的文件名
+func FuzzValidateRemotePath(f *testing.F) {
+	for _, seed := range []string{"/apps/a.txt", "/", "", "relative", "/a//b", "/a/../b", "/a/ b", "/a/b\x00c", "/" + strings.Repeat("x", 300), "/中文/名字.txt"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, path string) {
+		if err := ValidateRemotePath(path); err != nil {
+			return
+		}
+		for _, elem := range strings.Split(strings.Trim(path, "/"), "/") {
+			if elem == "" || elem == "." || elem == ".." || len(elem) > RemoteNameMaxLen || strings.ContainsAny(elem, remoteNameForbiddenChars) {
+				t.Fatalf("ValidateRemotePath(%q) accepted invalid name %q", path, elem)
+			}
+		}
+	})
+}
+
+// 保留文件名编码后可以原样还原
+func FuzzPathMapperRoundTrip(f *testing.F) {
+	for _, seed := range []string{"CON.txt", "dir/aux", "a%41b", "trailing. ", "com1 .tar.gz", "100%", "%zz", "...", "a/./b/../c"} {
+		f.Add(seed)
+	}
+	m := &PathMapper{ReservedName: true}
+	f.Fuzz(func(t *testing.T, path string) {
+		path = filepath.FromSlash(path)
+		if got := m.FromLocal(m.ToLocal(path)); got != path {
+			t.Fatalf("FromLocal(ToLocal(%q)) = %q", path, got)
+		}
+	})
+}
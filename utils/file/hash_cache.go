@@ -0,0 +1,146 @@
+package file
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultHashCacheMaxEntries = 100000
+
+// 缓存的文件hash，文件大小或修改时间变化后失效
+type HashEntry struct {
+	Size      int64    `json:"size"`
+	ModTime   int64    `json:"mtime"` // 单位纳秒
+	Md5       string   `json:"md5"`
+	SliceMd5  string   `json:"slice_md5,omitempty"`  // 文件前256KB的md5
+	SliceSize int64    `json:"slice_size,omitempty"` // BlockList对应的分片大小
+	BlockList []string `json:"block_list,omitempty"`
+	LastUsed  int64    `json:"last_used"`
+}
+
+// HashCache 本地文件hash缓存，以(路径, 大小, 修改时间)为key，避免重复计算未修改文件的md5
+// 条目超过MaxEntries时一次淘汰最久未使用的条目直到MaxEntries的90%，调用Save持久化到文件
+type HashCache struct {
+	FilePath   string // 持久化文件路径，为空时只缓存在内存中
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*HashEntry
+}
+
+// 创建hash缓存，filePath存在时加载已有的缓存
+func NewHashCache(filePath string) (*HashCache, error) {
+	c := &HashCache{
+		FilePath:   filePath,
+		MaxEntries: defaultHashCacheMaxEntries,
+		entries:    map[string]*HashEntry{},
+	}
+	if filePath == "" {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		log.Println("hashCache ioutil.ReadFile failed, err:", err)
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		log.Println("hashCache json.Unmarshal failed, err:", err)
+		c.entries = map[string]*HashEntry{}
+	}
+	return c, nil
+}
+
+func (c *HashCache) SetMaxEntries(maxEntries int) {
+	c.MaxEntries = maxEntries
+}
+
+// 获取文件的缓存，大小或修改时间不一致时删除缓存并返回false
+func (c *HashCache) Get(path string, size, modTime int64) (HashEntry, bool) {
+	if c == nil {
+		return HashEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[path]
+	if !ok {
+		return HashEntry{}, false
+	}
+	if entry.Size != size || entry.ModTime != modTime {
+		delete(c.entries, path)
+		return HashEntry{}, false
+	}
+	entry.LastUsed = time.Now().Unix()
+	return *entry, true
+}
+
+// 更新文件的缓存，大小或修改时间不一致时先清空原有缓存
+func (c *HashCache) Update(path string, size, modTime int64, update func(entry *HashEntry)) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[path]
+	if !ok || entry.Size != size || entry.ModTime != modTime {
+		entry = &HashEntry{Size: size, ModTime: modTime}
+		c.entries[path] = entry
+	}
+	update(entry)
+	entry.LastUsed = time.Now().Unix()
+	c.evict()
+}
+
+// 删除文件的缓存
+func (c *HashCache) Remove(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, path)
+}
+
+// 淘汰最久未使用的条目直到MaxEntries的90%，批量淘汰避免每次写入都排序全部条目，调用方需持有锁
+func (c *HashCache) evict() {
+	if c.MaxEntries <= 0 || len(c.entries) <= c.MaxEntries {
+		return
+	}
+	lowWater := c.MaxEntries - c.MaxEntries/10
+	paths := make([]string, 0, len(c.entries))
+	for path := range c.entries {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return c.entries[paths[i]].LastUsed < c.entries[paths[j]].LastUsed
+	})
+	for _, path := range paths[:len(paths)-lowWater] {
+		delete(c.entries, path)
+	}
+}
+
+// 保存到持久化文件，先写临时文件再重命名，避免写入中断导致缓存文件损坏
+func (c *HashCache) Save() error {
+	if c == nil || c.FilePath == "" {
+		return nil
+	}
+	c.mu.Lock()
+	data, err := json.Marshal(c.entries)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmpPath := c.FilePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Println("hashCache ioutil.WriteFile failed, err:", err)
+		return err
+	}
+	return os.Rename(tmpPath, c.FilePath)
+}
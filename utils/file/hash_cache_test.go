@@ -0,0 +1,46 @@
+package file
+
+import (
+	"strconv"
+	"testing"
+)
+
+// 超过MaxEntries时淘汰最久未使用的条目直到90%，之后的写入不再触发淘汰
+func TestHashCacheEvictLowWater(t *testing.T) {
+	c, err := NewHashCache("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetMaxEntries(10)
+	for i := 0; i < 10; i++ {
+		c.Update(strconv.Itoa(i), 1, 1, func(entry *HashEntry) { entry.Md5 = "md5" })
+		c.entries[strconv.Itoa(i)].LastUsed = int64(i)
+	}
+	c.Update("new", 1, 1, func(entry *HashEntry) {})
+	if len(c.entries) != 9 {
+		t.Fatalf("entries after evict: %d, want 9", len(c.entries))
+	}
+	for _, path := range []string{"0", "1"} {
+		if _, ok := c.Get(path, 1, 1); ok {
+			t.Errorf("least recently used entry %s not evicted", path)
+		}
+	}
+	for _, path := range []string{"2", "9", "new"} {
+		if _, ok := c.Get(path, 1, 1); !ok {
+			t.Errorf("entry %s evicted", path)
+		}
+	}
+	c.Update("next", 1, 1, func(entry *HashEntry) {})
+	if len(c.entries) != 10 {
+		t.Fatalf("entries below MaxEntries evicted: %d", len(c.entries))
+	}
+}
+
+func BenchmarkHashCacheUpdate(b *testing.B) {
+	c, _ := NewHashCache("")
+	c.SetMaxEntries(10000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Update(strconv.Itoa(i), 1, 1, func(entry *HashEntry) {})
+	}
+}
@@ -0,0 +1,144 @@
+package file
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// 忽略规则文件名，放在本地目录的根目录
+const IgnoreFileName = ".panignore"
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// IgnoreMatcher gitignore风格的排除规则，支持#注释、!取反、/结尾只匹配目录、/开头或包含/时相对根目录匹配、*、?、**通配符
+// 多条规则匹配时以最后一条为准，目录被排除时不再遍历其中的文件
+type IgnoreMatcher struct {
+	rules []ignoreRule
+}
+
+func NewIgnoreMatcher(patterns []string) *IgnoreMatcher {
+	m := &IgnoreMatcher{}
+	for _, pattern := range patterns {
+		m.Add(pattern)
+	}
+	return m
+}
+
+// 读取忽略规则文件，文件不存在时返回没有规则的IgnoreMatcher
+func LoadIgnoreFile(filePath string) (*IgnoreMatcher, error) {
+	m := &IgnoreMatcher{}
+	f, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return m, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m.Add(scanner.Text())
+	}
+	return m, scanner.Err()
+}
+
+// 添加一条规则
+func (m *IgnoreMatcher) Add(pattern string) {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return
+	}
+	rule := ignoreRule{}
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\`) { //转义开头的#和!
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return
+	}
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	expr := globToRegexp(pattern)
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "^(?:.*/)?" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return
+	}
+	rule.re = re
+	m.rules = append(m.rules, rule)
+}
+
+// 判断相对根目录的路径是否被排除，relPath使用/分隔
+func (m *IgnoreMatcher) Match(relPath string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	relPath = strings.Trim(relPath, "/")
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(relPath) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func globToRegexp(pattern string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			sb.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
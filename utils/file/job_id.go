@@ -0,0 +1,19 @@
+package file
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// 生成随机的任务ID(UUID v4格式)，用于区分共用临时目录的下载任务的分片文件
+func NewJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// 系统随机数不可用时退化为时间戳，仍可区分绝大多数任务
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
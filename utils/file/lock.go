@@ -0,0 +1,165 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 任务已被其他进程锁定
+var ErrJobLocked = errors.New("job is locked by another process")
+
+const lockFileSuffix = ".lock"
+
+// 锁文件超过该时间未更新时视为持有者已异常退出，持有期间每隔JobLockStaleAge/5更新一次锁文件的修改时间
+const JobLockStaleAge = 10 * time.Minute
+
+// JobLock 基于锁文件的建议锁，用于快照文件和下载目标文件，各平台行为一致
+// 锁文件内容为"<pid> <加锁时间> <主机名>"，持有者进程已退出(同一主机)或锁文件长时间未更新时，视为过期锁，可以直接接管
+type JobLock struct {
+	Path string // 锁文件路径
+
+	content  string
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// 锁定path，锁文件为path+".lock"，已被锁定时返回ErrJobLocked，force为true时强制接管已有的锁
+func LockJob(path string, force bool) (*JobLock, error) {
+	lockPath := path + lockFileSuffix
+	if err := os.MkdirAll(filepath.Dir(lockPath), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	content := fmt.Sprintf("%d %d %s\n", os.Getpid(), time.Now().UnixNano(), hostname)
+	for i := 0; i < 2; i++ {
+		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(content)
+			f.Close()
+			if err != nil {
+				os.Remove(lockPath)
+				return nil, err
+			}
+			l := &JobLock{Path: lockPath, content: content, stop: make(chan struct{})}
+			go l.keepAlive()
+			return l, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if i > 0 {
+			break
+		}
+		held, err := ioutil.ReadFile(lockPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil && !force && !lockStale(lockPath, string(held)) {
+			break
+		}
+		if force {
+			log.Printf("lockJob force takeover lockPath: %s", lockPath)
+		} else {
+			log.Printf("lockJob take over stale lock lockPath: %s holder: %s", lockPath, strings.TrimSpace(string(held)))
+		}
+		if err := removeLockFile(lockPath, string(held)); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, ErrJobLocked
+}
+
+// 判断锁文件是否过期：同一主机上持有锁的进程已不存在，或锁文件超过JobLockStaleAge未更新
+func lockStale(lockPath, content string) bool {
+	info, err := os.Stat(lockPath)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	if time.Since(info.ModTime()) > JobLockStaleAge {
+		return true
+	}
+	fields := strings.Fields(content)
+	if len(fields) < 3 {
+		return false
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil || pid <= 0 {
+		return false
+	}
+	hostname, _ := os.Hostname()
+	if fields[2] != hostname {
+		return false
+	}
+	return !processAlive(pid)
+}
+
+// 锁文件内容未变时删除，避免删除其他进程刚接管的锁
+func removeLockFile(lockPath, content string) error {
+	current, err := ioutil.ReadFile(lockPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if string(current) != content {
+		return ErrJobLocked
+	}
+	if err := os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 持有锁期间定期更新锁文件的修改时间，避免被其他进程视为过期锁
+func (l *JobLock) keepAlive() {
+	ticker := time.NewTicker(JobLockStaleAge / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			if err := os.Chtimes(l.Path, now, now); err != nil {
+				log.Printf("jobLock os.Chtimes failed lockPath: %s err: %v", l.Path, err)
+			}
+		}
+	}
+}
+
+// 释放锁，锁已被其他进程接管时不删除
+func (l *JobLock) Unlock() error {
+	if l == nil {
+		return nil
+	}
+	if l.stop != nil {
+		l.stopOnce.Do(func() {
+			close(l.stop)
+		})
+	}
+	if l.content == "" {
+		if err := os.Remove(l.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := removeLockFile(l.Path, l.content); err != nil {
+		if err == ErrJobLocked {
+			log.Printf("jobLock taken over by another process lockPath: %s", l.Path)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package file
+
+// 无法检测进程是否存在，视为存在，只按锁文件的修改时间判断是否过期
+func processAlive(pid int) bool {
+	return true
+}
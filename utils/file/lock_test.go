@@ -0,0 +1,130 @@
+package file
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockJob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "panlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "a.bin")
+
+	first, err := LockJob(target, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LockJob(target, false); err != ErrJobLocked {
+		t.Fatalf("second LockJob err: %v, want ErrJobLocked", err)
+	}
+	second, err := LockJob(target, true)
+	if err != nil {
+		t.Fatalf("force LockJob: %v", err)
+	}
+	if err := first.Unlock(); err != nil { //锁已被接管，不能删除新持有者的锁文件
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(second.Path); err != nil {
+		t.Fatalf("taken over lock removed by previous holder: %v", err)
+	}
+	if err := second.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(second.Path); !os.IsNotExist(err) {
+		t.Fatalf("lock file not removed: %v", err)
+	}
+}
+
+// 持有锁的进程已退出时直接接管
+func TestLockJobDeadProcess(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	pid := cmd.Process.Pid
+	if processAlive(pid) {
+		t.Skip("process liveness check unsupported")
+	}
+	dir, err := ioutil.TempDir("", "panlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "a.bin")
+	hostname, _ := os.Hostname()
+	if err := ioutil.WriteFile(target+lockFileSuffix, []byte(fmt.Sprintf("%d %d %s\n", pid, time.Now().UnixNano(), hostname)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := LockJob(target, false)
+	if err != nil {
+		t.Fatalf("LockJob with dead holder: %v", err)
+	}
+	lock.Unlock()
+}
+
+// 其他主机的锁只按修改时间判断是否过期
+func TestLockJobStaleMtime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "panlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "a.bin")
+	lockPath := target + lockFileSuffix
+	if err := ioutil.WriteFile(lockPath, []byte(fmt.Sprintf("1 %d other-host\n", time.Now().UnixNano())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LockJob(target, false); err != ErrJobLocked {
+		t.Fatalf("fresh lock of other host err: %v, want ErrJobLocked", err)
+	}
+	old := time.Now().Add(-2 * JobLockStaleAge)
+	if err := os.Chtimes(lockPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := LockJob(target, false)
+	if err != nil {
+		t.Fatalf("LockJob with stale lock: %v", err)
+	}
+	lock.Unlock()
+}
+
+// 快照文件被锁定时等待锁释放后写入
+func TestSnapshotStoreWaitsForLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "panlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewJSONSnapshotStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := DownloadSnapshot{FsID: 1, SavePath: "/tmp/a.bin", TotalSize: 10}
+	held, err := LockJob(store.path(downloadSnapshotPrefix, snapshot.Key()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		held.Unlock()
+	}()
+	start := time.Now()
+	if err := store.SaveDownload(snapshot); err != nil {
+		t.Fatalf("SaveDownload: %v", err)
+	}
+	if time.Since(start) < 150*time.Millisecond {
+		t.Fatal("SaveDownload did not wait for the snapshot lock")
+	}
+	snapshots, err := store.LoadDownloads()
+	if err != nil || len(snapshots) != 1 || snapshots[0].FsID != 1 {
+		t.Fatalf("LoadDownloads: %v %v", snapshots, err)
+	}
+}
@@ -0,0 +1,91 @@
+package file
+
+import (
+	"errors"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync"
+)
+
+// 当前系统不支持内存映射
+var ErrMmapUnsupported = errors.New("mmap is not supported on this platform")
+
+// 读取映射内存时出错，一般是文件在映射期间被截断
+var ErrMappedFileFault = errors.New("fault reading memory mapped file")
+
+// 映射已解除
+var ErrMappedFileClosed = errors.New("memory mapped file already closed")
+
+// MappedFile 只读内存映射的本地文件，实现io.ReaderAt，读取时直接从映射内存拷贝，不经过read系统调用
+// 可以并发读取，Close等待进行中的读取结束后再解除映射
+type MappedFile struct {
+	mu     sync.RWMutex
+	data   []byte
+	closed bool
+}
+
+// 将文件全部内容只读映射到内存，空文件不映射
+func MapFile(f *os.File) (*MappedFile, error) {
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo.Size() == 0 {
+		return &MappedFile{}, nil
+	}
+	if int64(int(fileInfo.Size())) != fileInfo.Size() {
+		return nil, errors.New("file too large to mmap")
+	}
+	data, err := mmap(f, int(fileInfo.Size()))
+	if err != nil {
+		return nil, err
+	}
+	return &MappedFile{data: data}, nil
+}
+
+func (m *MappedFile) Size() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return int64(len(m.data))
+}
+
+// 文件在映射期间被截断时返回ErrMappedFileFault，不会导致程序崩溃
+func (m *MappedFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("mappedFile.ReadAt: negative offset")
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return 0, ErrMappedFileClosed
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	old := debug.SetPanicOnFault(true)
+	defer debug.SetPanicOnFault(old)
+	defer func() {
+		if r := recover(); r != nil {
+			n, err = 0, ErrMappedFileFault
+		}
+	}()
+	n = copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// 解除映射，之后读取返回ErrMappedFileClosed，可重复调用
+func (m *MappedFile) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	if m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	return munmap(data)
+}
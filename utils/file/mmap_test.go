@@ -0,0 +1,115 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+// 创建内容为data的临时文件并映射，系统不支持时跳过
+func mapTempFile(tb testing.TB, data []byte) (*os.File, *MappedFile, func()) {
+	f, err := ioutil.TempFile("", "mmap")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if _, err := f.Write(data); err != nil {
+		cleanup()
+		tb.Fatal(err)
+	}
+	mapped, err := MapFile(f)
+	if err == ErrMmapUnsupported {
+		cleanup()
+		tb.Skip(err)
+	}
+	if err != nil {
+		cleanup()
+		tb.Fatal(err)
+	}
+	return f, mapped, cleanup
+}
+
+func TestMappedFileReadAt(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	_, mapped, cleanup := mapTempFile(t, data)
+	defer cleanup()
+	defer mapped.Close()
+
+	buf := make([]byte, 100)
+	n, err := mapped.ReadAt(buf, 9950)
+	if n != 50 || err != io.EOF || !bytes.Equal(buf[:n], data[9950:]) {
+		t.Fatalf("ReadAt at tail = %d, %v", n, err)
+	}
+	if n, err := mapped.ReadAt(buf, 10); n != 100 || err != nil || !bytes.Equal(buf, data[10:110]) {
+		t.Fatalf("ReadAt = %d, %v", n, err)
+	}
+}
+
+// Close与ReadAt并发时不会读取已解除映射的内存
+func TestMappedFileConcurrentClose(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 1<<20)
+	_, mapped, cleanup := mapTempFile(t, data)
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 64<<10)
+			for off := int64(0); ; off = (off + int64(len(buf))) % int64(len(data)) {
+				if _, err := mapped.ReadAt(buf, off); err == ErrMappedFileClosed {
+					return
+				} else if err != nil && err != io.EOF {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	if err := mapped.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if err := mapped.Close(); err != nil {
+		t.Fatalf("second Close = %v", err)
+	}
+	if _, err := mapped.ReadAt(make([]byte, 1), 0); err != ErrMappedFileClosed {
+		t.Fatalf("ReadAt after Close = %v", err)
+	}
+}
+
+// 对比映射内存与普通文件读取一个4MB分片的速度
+func BenchmarkSliceRead(b *testing.B) {
+	const sliceSize = 4 << 20
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4*sliceSize/16)
+	f, mapped, cleanup := mapTempFile(b, data)
+	defer cleanup()
+	defer mapped.Close()
+
+	readers := []struct {
+		name   string
+		reader io.ReaderAt
+	}{
+		{"mmap", mapped},
+		{"file", f},
+	}
+	for _, r := range readers {
+		b.Run(r.name, func(b *testing.B) {
+			buf := make([]byte, sliceSize)
+			b.SetBytes(sliceSize)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := r.reader.ReadAt(buf, int64(i%4)*sliceSize); err != nil && err != io.EOF {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
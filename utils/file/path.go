@@ -0,0 +1,136 @@
+package file
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const windowsMaxPath = 260
+
+// windows保留文件名，不区分大小写，带扩展名时同样不可用，如CON.txt
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// PathMapper 本地路径映射，处理windows下的长路径和保留文件名
+type PathMapper struct {
+	LongPath     bool // 路径超过MAX_PATH时添加\\?\前缀，仅windows下生效
+	ReservedName bool // 对保留文件名及结尾的点、空格做可逆的%XX编码，'%'本身编码为%25
+}
+
+// NewPathMapper 在windows下同时开启长路径和保留文件名处理，其他系统下不做任何处理
+func NewPathMapper() *PathMapper {
+	isWindows := runtime.GOOS == "windows"
+	return &PathMapper{
+		LongPath:     isWindows,
+		ReservedName: isWindows,
+	}
+}
+
+// 将逻辑路径映射为本地实际使用的路径
+func (m *PathMapper) ToLocal(path string) string {
+	if m == nil || path == "" {
+		return path
+	}
+	localPath := path
+	if m.ReservedName {
+		localPath = mapPathElems(localPath, encodePathElem)
+	}
+	if m.LongPath && runtime.GOOS == "windows" {
+		localPath = toLongPath(localPath)
+	}
+	return localPath
+}
+
+// 将本地实际路径还原为逻辑路径，ToLocal的逆操作
+func (m *PathMapper) FromLocal(localPath string) string {
+	if m == nil || localPath == "" {
+		return localPath
+	}
+	path := localPath
+	if strings.HasPrefix(path, `\\?\UNC\`) {
+		path = `\\` + path[len(`\\?\UNC\`):]
+	} else if strings.HasPrefix(path, `\\?\`) {
+		path = path[len(`\\?\`):]
+	}
+	if m.ReservedName {
+		path = mapPathElems(path, decodePathElem)
+	}
+	return path
+}
+
+// 对路径的每一级分别处理，卷名(如C:)保持不变
+func mapPathElems(path string, fn func(string) string) string {
+	volume := filepath.VolumeName(path)
+	elems := strings.Split(path[len(volume):], string(filepath.Separator))
+	for i, elem := range elems {
+		if elem == "" || elem == "." || elem == ".." {
+			continue
+		}
+		elems[i] = fn(elem)
+	}
+	return volume + strings.Join(elems, string(filepath.Separator))
+}
+
+func encodePathElem(elem string) string {
+	elem = strings.Replace(elem, "%", "%25", -1)
+
+	// 保留文件名，将主文件名的最后一个字符编码，如CON.txt => CO%4E.txt
+	stem := elem
+	if i := strings.Index(elem, "."); i >= 0 {
+		stem = elem[:i]
+	}
+	if windowsReservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		last := len(stem) - 1
+		elem = stem[:last] + fmt.Sprintf("%%%02X", stem[last]) + elem[len(stem):]
+	}
+
+	// 结尾的点和空格会被windows忽略
+	trimmed := strings.TrimRight(elem, ". ")
+	if trimmed != elem {
+		suffix := ""
+		for _, c := range elem[len(trimmed):] {
+			suffix += fmt.Sprintf("%%%02X", c)
+		}
+		elem = trimmed + suffix
+	}
+	return elem
+}
+
+func decodePathElem(elem string) string {
+	if !strings.Contains(elem, "%") {
+		return elem
+	}
+	var b strings.Builder
+	for i := 0; i < len(elem); i++ {
+		if elem[i] == '%' && i+2 < len(elem) {
+			if c, err := strconv.ParseUint(elem[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(elem[i])
+	}
+	return b.String()
+}
+
+// 超过MAX_PATH的绝对路径添加\\?\前缀，UNC路径转为\\?\UNC\server\share形式
+func toLongPath(path string) string {
+	if strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil || len(absPath) < windowsMaxPath {
+		return path
+	}
+	if strings.HasPrefix(absPath, `\\`) {
+		return `\\?\UNC\` + absPath[2:]
+	}
+	return `\\?\` + absPath
+}
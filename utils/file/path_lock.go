@@ -0,0 +1,59 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// 网盘路径已有其他任务在上传，可通过errors.Is判断，通过errors.As获取RemotePathLockedError
+var ErrRemotePathLocked = errors.New("remote path is being uploaded by another job")
+
+// 网盘路径已有其他任务在上传
+type RemotePathLockedError struct {
+	Path string // 网盘路径
+}
+
+func (e *RemotePathLockedError) Error() string {
+	return fmt.Sprintf("remote path is being uploaded by another job, path: %s", e.Path)
+}
+
+func (e *RemotePathLockedError) Is(target error) bool {
+	return target == ErrRemotePathLocked
+}
+
+// PathLocker 网盘路径锁，防止多个任务同时上传到同一路径，多进程或多机部署时可基于redis等实现
+type PathLocker interface {
+	// 获取锁，已被其他任务持有时返回false
+	TryLock(path string) (bool, error)
+	Unlock(path string) error
+}
+
+// MemoryPathLocker 进程内的网盘路径锁
+type MemoryPathLocker struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+func NewMemoryPathLocker() *MemoryPathLocker {
+	return &MemoryPathLocker{
+		paths: map[string]bool{},
+	}
+}
+
+func (l *MemoryPathLocker) TryLock(path string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.paths[path] {
+		return false, nil
+	}
+	l.paths[path] = true
+	return true, nil
+}
+
+func (l *MemoryPathLocker) Unlock(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.paths, path)
+	return nil
+}
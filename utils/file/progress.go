@@ -0,0 +1,61 @@
+package file
+
+import "sync"
+
+type progressEvent struct {
+	status    int
+	doneSize  int64
+	totalSize int64
+}
+
+// ProgressDispatcher 在单独的goroutine中依次调用进度回调
+// 传输中多个分片goroutine上报的进度按上报顺序串行送达，回调无需自行加锁
+type ProgressDispatcher struct {
+	handler func(int, int64, int64)
+	events  chan progressEvent
+	done    chan struct{}
+
+	mu     sync.RWMutex // Handle持有读锁发送，Close持有写锁关闭events
+	closed bool
+}
+
+// handler为nil时丢弃全部进度
+func NewProgressDispatcher(handler func(int, int64, int64)) *ProgressDispatcher {
+	p := &ProgressDispatcher{
+		handler: handler,
+		events:  make(chan progressEvent, 64),
+		done:    make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *ProgressDispatcher) run() {
+	defer close(p.done)
+	for event := range p.events {
+		if p.handler != nil {
+			p.handler(event.status, event.doneSize, event.totalSize)
+		}
+	}
+}
+
+// 上报进度，回调处理较慢时阻塞，不会丢弃或合并进度，Close之后上报的进度直接丢弃
+func (p *ProgressDispatcher) Handle(status int, doneSize, totalSize int64) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return
+	}
+	p.events <- progressEvent{status, doneSize, totalSize}
+}
+
+// 停止接收进度，等待已上报的进度全部送达后返回，可重复调用
+func (p *ProgressDispatcher) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.events)
+	}
+	p.mu.Unlock()
+	<-p.done
+}
@@ -0,0 +1,54 @@
+package file
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestProgressDispatcherOrder(t *testing.T) {
+	got := []int64{}
+	p := NewProgressDispatcher(func(status int, doneSize, totalSize int64) {
+		got = append(got, doneSize)
+	})
+	for i := int64(0); i < 1000; i++ {
+		p.Handle(2, i, 1000)
+	}
+	p.Close()
+	if len(got) != 1000 {
+		t.Fatalf("delivered %d events before Close returned, want 1000", len(got))
+	}
+	for i, doneSize := range got {
+		if doneSize != int64(i) {
+			t.Fatalf("event %d has doneSize %d, events out of order", i, doneSize)
+		}
+	}
+}
+
+// 上传结束后分片goroutine仍可能上报进度，不能panic
+func TestProgressDispatcherHandleAfterClose(t *testing.T) {
+	calls := 0
+	p := NewProgressDispatcher(func(int, int64, int64) { calls++ })
+	p.Handle(2, 1, 2)
+	p.Close()
+	p.Handle(2, 2, 2)
+	p.Close()
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+}
+
+func TestProgressDispatcherConcurrentClose(t *testing.T) {
+	p := NewProgressDispatcher(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				p.Handle(2, int64(j), 1000)
+			}
+		}()
+	}
+	p.Close()
+	wg.Wait()
+}
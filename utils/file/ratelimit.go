@@ -0,0 +1,108 @@
+package file
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter 传输限速器，可在多个上传、下载任务间共享，速率可在传输过程中修改
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64 // 每秒字节数，为0时不限速
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{
+		rate: bytesPerSecond,
+		last: time.Now(),
+	}
+}
+
+// 修改速率，为0时不限速
+func (l *RateLimiter) SetRate(bytesPerSecond int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = bytesPerSecond
+	if l.tokens > float64(bytesPerSecond) {
+		l.tokens = float64(bytesPerSecond)
+	}
+}
+
+// 获取当前速率
+func (l *RateLimiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// 等待n个字节的配额，最多允许1秒的突发流量
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.rate <= 0 {
+		l.last = now
+		l.mu.Unlock()
+		return nil
+	}
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// 限速读取，limiter为nil时直接返回reader
+func (l *RateLimiter) Reader(ctx context.Context, reader io.Reader) io.Reader {
+	if l == nil {
+		return reader
+	}
+	return &rateLimitedReader{ctx, reader, l}
+}
+
+type rateLimitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *RateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	// 单次读取不超过速率，避免一次读取等待过久
+	if rate := r.limiter.Rate(); rate > 0 && int64(len(p)) > rate {
+		p = p[:rate]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,65 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 网盘文件名最大字节数
+const RemoteNameMaxLen = 255
+
+// 网盘文件名中不允许出现的字符，另外还有'/'和控制字符，上传时从路径中去除，重命名时拒绝
+const remoteNameForbiddenChars = `\:*?"<>|`
+
+var ErrInvalidRemoteName = errors.New("invalid remote file name")
+
+// 是否是文件名中不允许出现的字符，不包括路径分隔符'/'
+func isSpecialChar(c rune) bool {
+	return strings.ContainsRune(remoteNameForbiddenChars, c) || c < 0x20 || c == 0x7f
+}
+
+// 校验网盘文件名，与上传时HandleSpecialChar使用同一组特殊字符，上传时保持不变的文件名重命名时也能通过校验
+// 不合法时返回包装了ErrInvalidRemoteName的错误
+func ValidateRemoteName(name string) error {
+	switch {
+	case name == "" || name == "." || name == "..":
+		return fmt.Errorf("%w: %q", ErrInvalidRemoteName, name)
+	case len(name) > RemoteNameMaxLen:
+		return fmt.Errorf("%w: name longer than %d bytes", ErrInvalidRemoteName, RemoteNameMaxLen)
+	case strings.Contains(name, "/"):
+		return fmt.Errorf("%w: %q contains path separator", ErrInvalidRemoteName, name)
+	case strings.IndexFunc(name, isSpecialChar) >= 0:
+		return fmt.Errorf("%w: %q contains forbidden characters %s or control characters", ErrInvalidRemoteName, name, remoteNameForbiddenChars)
+	}
+	return nil
+}
+
+// 校验网盘绝对路径的每一级文件名
+func ValidateRemotePath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("%w: path %q is not absolute", ErrInvalidRemoteName, path)
+	}
+	for _, elem := range strings.Split(strings.Trim(path, "/"), "/") {
+		if err := ValidateRemoteName(elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 特殊字符处理，文件名里有特殊字符时无法上传到网盘，特殊字符有'\\', '?', '|', '"', '>', '<', ':', '*'和控制字符，路径分隔符'/'保留
+func HandleSpecialChar(char string) string {
+	newChar := strings.Map(func(c rune) rune {
+		if isSpecialChar(c) {
+			return -1
+		}
+		return c
+	}, char)
+
+	if newChar != char {
+		fmt.Printf("char has handle, origin[%s] handled[%s]", char, newChar)
+	}
+
+	return newChar
+}
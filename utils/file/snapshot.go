@@ -0,0 +1,23 @@
+package file
+
+import "strconv"
+
+// SnapshotStore 传输快照存储，上传快照以网盘路径+文件md5区分，下载快照以保存路径+fs_id区分
+type SnapshotStore interface {
+	SaveUpload(snapshot UploadSnapshot) error
+	LoadUploads() ([]UploadSnapshot, error)
+	DeleteUpload(snapshot UploadSnapshot) error
+	SaveDownload(snapshot DownloadSnapshot) error
+	LoadDownloads() ([]DownloadSnapshot, error)
+	DeleteDownload(snapshot DownloadSnapshot) error
+}
+
+// 上传快照在存储中的key
+func (s UploadSnapshot) Key() string {
+	return s.Path + "|" + s.FileMd5
+}
+
+// 下载快照在存储中的key
+func (s DownloadSnapshot) Key() string {
+	return s.SavePath + "|" + strconv.FormatUint(s.FsID, 10)
+}
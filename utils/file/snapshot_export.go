@@ -0,0 +1,216 @@
+package file
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 便携快照格式版本，格式变化时递增，导入时拒绝比当前版本新的快照
+//
+// 格式为JSON对象：
+//
+//	version     格式版本
+//	kind        快照类型，upload或download
+//	exported_at 导出时间，unix时间戳
+//	upload      上传快照，与UploadSnapshot的JSON格式相同
+//	download    下载快照，与DownloadSnapshot的JSON格式相同，分片的file_path只保留文件名
+//	parts       下载快照已完成分片的指纹，包括分片序号、文件名、大小和md5
+const PortableSnapshotVersion = 1
+
+const (
+	PortableKindUpload   = "upload"
+	PortableKindDownload = "download"
+)
+
+// 快照格式版本不支持
+var ErrPortableSnapshotVersion = errors.New("unsupported portable snapshot version")
+
+// 本地文件与快照记录的指纹不一致
+var ErrSnapshotFingerprint = errors.New("snapshot fingerprint mismatch")
+
+// 已完成分片的指纹
+type PortablePart struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"` // 分片文件名，不含目录
+	Size  int64  `json:"size"`
+	Md5   string `json:"md5"`
+}
+
+// 便携快照，用于在共享临时目录的多台机器之间迁移任务
+type PortableSnapshot struct {
+	Version    int               `json:"version"`
+	Kind       string            `json:"kind"`
+	ExportedAt int64             `json:"exported_at"`
+	Upload     *UploadSnapshot   `json:"upload,omitempty"`
+	Download   *DownloadSnapshot `json:"download,omitempty"`
+	Parts      []PortablePart    `json:"parts,omitempty"`
+}
+
+// 导出上传快照
+func ExportUploadSnapshot(w io.Writer, snapshot UploadSnapshot) error {
+	return writePortableSnapshot(w, PortableSnapshot{
+		Kind:   PortableKindUpload,
+		Upload: &snapshot,
+	})
+}
+
+// 导入上传快照，localPath为本机的本地文件路径，为空时使用快照记录的路径
+// 本地文件的大小和md5需与快照一致，否则返回ErrSnapshotFingerprint
+func ImportUploadSnapshot(r io.Reader, localPath string) (UploadSnapshot, error) {
+	p, err := readPortableSnapshot(r, PortableKindUpload)
+	if err != nil {
+		return UploadSnapshot{}, err
+	}
+	snapshot := *p.Upload
+	if localPath != "" {
+		snapshot.LocalPath = localPath
+	}
+	info, err := os.Stat(snapshot.LocalPath)
+	if err != nil {
+		log.Printf("ImportUploadSnapshot os.Stat failed localPath: %s err: %v", snapshot.LocalPath, err)
+		return UploadSnapshot{}, err
+	}
+	if info.IsDir() || info.Size() != snapshot.TotalSize {
+		return UploadSnapshot{}, fmt.Errorf("%w, localPath: %s size: %d totalSize: %d", ErrSnapshotFingerprint, snapshot.LocalPath, info.Size(), snapshot.TotalSize)
+	}
+	if snapshot.FileMd5 != "" {
+		fileMd5, err := fileMd5(snapshot.LocalPath)
+		if err != nil {
+			return UploadSnapshot{}, err
+		}
+		if fileMd5 != snapshot.FileMd5 {
+			return UploadSnapshot{}, fmt.Errorf("%w, localPath: %s md5: %s fileMd5: %s", ErrSnapshotFingerprint, snapshot.LocalPath, fileMd5, snapshot.FileMd5)
+		}
+	}
+	snapshot.FileModTime = info.ModTime().Unix()
+	return snapshot, nil
+}
+
+// 导出下载快照，计算已完成分片文件的指纹，分片文件缺失或大小不一致的视为未下载
+func ExportDownloadSnapshot(w io.Writer, snapshot DownloadSnapshot) error {
+	if err := snapshot.ValidateParts(); err != nil {
+		return err
+	}
+	parts := make([]DownloadPartSnapshot, len(snapshot.DoneParts))
+	copy(parts, snapshot.DoneParts)
+	snapshot.DoneParts = parts
+	snapshot.DoneSize = 0
+	fingerprints := []PortablePart{}
+	for i, part := range parts {
+		if part.FilePath == "" {
+			continue
+		}
+		partSize := part.To - part.From + 1
+		info, err := os.Stat(part.FilePath)
+		if err != nil || info.IsDir() || info.Size() != partSize {
+			log.Printf("ExportDownloadSnapshot part file invalid path: %s expectedSize: %d err: %v", part.FilePath, partSize, err)
+			parts[i].FilePath = ""
+			continue
+		}
+		partMd5, err := fileMd5(part.FilePath)
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(part.FilePath)
+		parts[i].FilePath = name
+		snapshot.DoneSize += partSize
+		fingerprints = append(fingerprints, PortablePart{Index: i, Name: name, Size: partSize, Md5: partMd5})
+	}
+	return writePortableSnapshot(w, PortableSnapshot{
+		Kind:     PortableKindDownload,
+		Download: &snapshot,
+		Parts:    fingerprints,
+	})
+}
+
+// 导入下载快照，分片文件从tempDir中查找，tempDir为空时使用系统临时目录，savePath为空时使用快照记录的保存路径
+// 分片文件不存在或指纹不一致的视为未下载，续传时重新下载
+func ImportDownloadSnapshot(r io.Reader, tempDir, savePath string) (DownloadSnapshot, error) {
+	p, err := readPortableSnapshot(r, PortableKindDownload)
+	if err != nil {
+		return DownloadSnapshot{}, err
+	}
+	snapshot := *p.Download
+	if err := snapshot.ValidateParts(); err != nil {
+		return DownloadSnapshot{}, err
+	}
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	if savePath != "" {
+		snapshot.SavePath = savePath
+	}
+	fingerprints := make(map[int]PortablePart, len(p.Parts))
+	for _, part := range p.Parts {
+		fingerprints[part.Index] = part
+	}
+	snapshot.DoneSize = 0
+	for i, part := range snapshot.DoneParts {
+		snapshot.DoneParts[i].FilePath = ""
+		fingerprint, ok := fingerprints[i]
+		if !ok || fingerprint.Size != part.To-part.From+1 {
+			continue
+		}
+		partFilePath := filepath.Join(tempDir, filepath.Base(fingerprint.Name))
+		info, err := os.Stat(partFilePath)
+		if err != nil || info.IsDir() || info.Size() != fingerprint.Size {
+			log.Printf("ImportDownloadSnapshot part file invalid path: %s expectedSize: %d err: %v", partFilePath, fingerprint.Size, err)
+			continue
+		}
+		partMd5, err := fileMd5(partFilePath)
+		if err != nil {
+			return DownloadSnapshot{}, err
+		}
+		if partMd5 != fingerprint.Md5 {
+			log.Printf("ImportDownloadSnapshot part file md5 mismatch path: %s md5: %s expectedMd5: %s", partFilePath, partMd5, fingerprint.Md5)
+			continue
+		}
+		snapshot.DoneParts[i].FilePath = partFilePath
+		snapshot.DoneSize += fingerprint.Size
+	}
+	return snapshot, nil
+}
+
+func writePortableSnapshot(w io.Writer, p PortableSnapshot) error {
+	p.Version = PortableSnapshotVersion
+	p.ExportedAt = time.Now().Unix()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+func readPortableSnapshot(r io.Reader, kind string) (PortableSnapshot, error) {
+	p := PortableSnapshot{}
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		log.Println("readPortableSnapshot json.Decode failed, err:", err)
+		return p, err
+	}
+	if p.Version <= 0 || p.Version > PortableSnapshotVersion {
+		return p, fmt.Errorf("%w: %d", ErrPortableSnapshotVersion, p.Version)
+	}
+	if p.Kind != kind || (kind == PortableKindUpload && p.Upload == nil) || (kind == PortableKindDownload && p.Download == nil) {
+		return p, errors.New(fmt.Sprintf("portable snapshot kind mismatch, kind: %s expected: %s", p.Kind, kind))
+	}
+	return p, nil
+}
+
+func fileMd5(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
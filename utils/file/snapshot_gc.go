@@ -0,0 +1,102 @@
+package file
+
+import (
+	"log"
+	"time"
+)
+
+// uploadid的默认最长有效时间，超过后服务端不再接受该uploadid的分片，只能重新预创建
+const DefaultUploadIDMaxAge = 7 * 24 * time.Hour
+
+// 快照清理结果
+type SnapshotGCReport struct {
+	Uploads        []UploadSnapshot   // 已删除的上传快照
+	Downloads      []DownloadSnapshot // 已删除的下载快照
+	RemovedParts   int                // 已删除的下载分片文件数
+	RemovedBytes   int64              // 已删除的下载分片文件大小
+	FailedDeletion int                // 删除失败的快照数，下次清理时重试
+}
+
+// 删除最后更新时间早于olderThan的快照，下载快照的分片临时文件一同删除
+// 没有更新时间的旧快照不会被删除，长期运行的程序可定期调用，避免存储和临时目录无限增长
+func GCSnapshots(store SnapshotStore, olderThan time.Duration) (SnapshotGCReport, error) {
+	report := SnapshotGCReport{}
+	deadline := time.Now().Add(-olderThan).Unix()
+
+	uploads, err := store.LoadUploads()
+	if err != nil {
+		log.Println("GCSnapshots store.LoadUploads failed, err:", err)
+		return report, err
+	}
+	for _, snapshot := range uploads {
+		if snapshot.UpdatedAt <= 0 || snapshot.UpdatedAt >= deadline {
+			continue
+		}
+		deleteUploadSnapshot(store, snapshot, &report)
+	}
+
+	downloads, err := store.LoadDownloads()
+	if err != nil {
+		log.Println("GCSnapshots store.LoadDownloads failed, err:", err)
+		return report, err
+	}
+	for _, snapshot := range downloads {
+		if snapshot.UpdatedAt <= 0 || snapshot.UpdatedAt >= deadline {
+			continue
+		}
+		if err := store.DeleteDownload(snapshot); err != nil {
+			log.Printf("GCSnapshots DeleteDownload failed key: %s err: %v", snapshot.Key(), err)
+			report.FailedDeletion++
+			continue
+		}
+		for _, part := range snapshot.DoneParts {
+			if part.FilePath == "" {
+				continue
+			}
+			if err := RemoveTempFile(part.FilePath); err == nil {
+				report.RemovedParts++
+				report.RemovedBytes += part.To - part.From + 1
+			}
+		}
+		report.Downloads = append(report.Downloads, snapshot)
+	}
+	return report, nil
+}
+
+// 放弃uploadid创建时间早于maxAge的上传快照，maxAge<=0时使用DefaultUploadIDMaxAge
+// 服务端uploadid过期后已上传的分片无法续传，删除快照后需重新上传
+// 没有记录uploadid创建时间的旧快照按最后更新时间判断，两者都没有时不处理
+func AbandonExpiredUploads(store SnapshotStore, maxAge time.Duration) (SnapshotGCReport, error) {
+	report := SnapshotGCReport{}
+	if maxAge <= 0 {
+		maxAge = DefaultUploadIDMaxAge
+	}
+	deadline := time.Now().Add(-maxAge).Unix()
+
+	uploads, err := store.LoadUploads()
+	if err != nil {
+		log.Println("AbandonExpiredUploads store.LoadUploads failed, err:", err)
+		return report, err
+	}
+	for _, snapshot := range uploads {
+		createdAt := snapshot.CreatedAt
+		if createdAt <= 0 {
+			createdAt = snapshot.UpdatedAt
+		}
+		if snapshot.UploadId == "" || createdAt <= 0 || createdAt >= deadline {
+			continue
+		}
+		log.Printf("AbandonExpiredUploads upload id expired path: %s uploadId: %s createdAt: %d", snapshot.Path, snapshot.UploadId, createdAt)
+		deleteUploadSnapshot(store, snapshot, &report)
+	}
+	return report, nil
+}
+
+func deleteUploadSnapshot(store SnapshotStore, snapshot UploadSnapshot, report *SnapshotGCReport) {
+	if err := store.DeleteUpload(snapshot); err != nil {
+		log.Printf("deleteUploadSnapshot DeleteUpload failed key: %s err: %v", snapshot.Key(), err)
+		report.FailedDeletion++
+		return
+	}
+	report.Uploads = append(report.Uploads, snapshot)
+}
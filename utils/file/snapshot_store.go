@@ -0,0 +1,167 @@
+package file
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	uploadSnapshotPrefix   = "upload_"
+	downloadSnapshotPrefix = "download_"
+	snapshotFileExt        = ".json"
+)
+
+// 快照文件被其他进程锁定时等待的最长时间
+const snapshotLockWait = 5 * time.Second
+
+// JSONSnapshotStore 以JSON文件保存快照的SnapshotStore，每个快照一个文件，文件名为快照key的md5
+// 写入时先写临时文件再重命名，进程崩溃时不会留下不完整的快照
+// 写入和删除快照文件时通过LockJob锁定该文件，多个进程共用同一目录时不会互相覆盖
+type JSONSnapshotStore struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+// 创建快照存储，目录不存在时创建
+func NewJSONSnapshotStore(dir string) (*JSONSnapshotStore, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		log.Printf("NewJSONSnapshotStore os.MkdirAll failed dir: %s err: %v", dir, err)
+		return nil, err
+	}
+	return &JSONSnapshotStore{Dir: dir}, nil
+}
+
+func (s *JSONSnapshotStore) SaveUpload(snapshot UploadSnapshot) error {
+	return s.save(s.path(uploadSnapshotPrefix, snapshot.Key()), snapshot)
+}
+
+func (s *JSONSnapshotStore) LoadUploads() ([]UploadSnapshot, error) {
+	snapshots := []UploadSnapshot{}
+	err := s.load(uploadSnapshotPrefix, func(data []byte) error {
+		snapshot := UploadSnapshot{}
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return err
+		}
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+	return snapshots, err
+}
+
+func (s *JSONSnapshotStore) DeleteUpload(snapshot UploadSnapshot) error {
+	return s.remove(s.path(uploadSnapshotPrefix, snapshot.Key()))
+}
+
+func (s *JSONSnapshotStore) SaveDownload(snapshot DownloadSnapshot) error {
+	return s.save(s.path(downloadSnapshotPrefix, snapshot.Key()), snapshot)
+}
+
+func (s *JSONSnapshotStore) LoadDownloads() ([]DownloadSnapshot, error) {
+	snapshots := []DownloadSnapshot{}
+	err := s.load(downloadSnapshotPrefix, func(data []byte) error {
+		snapshot := DownloadSnapshot{}
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return err
+		}
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+	return snapshots, err
+}
+
+func (s *JSONSnapshotStore) DeleteDownload(snapshot DownloadSnapshot) error {
+	return s.remove(s.path(downloadSnapshotPrefix, snapshot.Key()))
+}
+
+// 删除最后更新时间早于olderThan的快照，见GCSnapshots
+func (s *JSONSnapshotStore) GC(olderThan time.Duration) (SnapshotGCReport, error) {
+	return GCSnapshots(s, olderThan)
+}
+
+func (s *JSONSnapshotStore) path(prefix, key string) string {
+	hash := md5.Sum([]byte(key))
+	return filepath.Join(s.Dir, prefix+hex.EncodeToString(hash[:])+snapshotFileExt)
+}
+
+func (s *JSONSnapshotStore) save(filePath string, snapshot interface{}) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, err := lockSnapshot(filePath)
+	if err != nil {
+		log.Printf("snapshotStore lockSnapshot failed file: %s err: %v", filePath, err)
+		return err
+	}
+	defer lock.Unlock()
+	tmpPath := filePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Println("snapshotStore ioutil.WriteFile failed, err:", err)
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+// 锁定快照文件，其他进程正在写入时等待，超过snapshotLockWait返回ErrJobLocked
+func lockSnapshot(filePath string) (*JobLock, error) {
+	deadline := time.Now().Add(snapshotLockWait)
+	for {
+		lock, err := LockJob(filePath, false)
+		if err != ErrJobLocked || time.Now().After(deadline) {
+			return lock, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// 读取前缀匹配的全部快照，无法解析的快照文件跳过
+func (s *JSONSnapshotStore) load(prefix string, decode func([]byte) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		log.Printf("snapshotStore ioutil.ReadDir failed dir: %s err: %v", s.Dir, err)
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, snapshotFileExt) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(s.Dir, name))
+		if err != nil {
+			return err
+		}
+		if err := decode(data); err != nil {
+			log.Printf("snapshotStore decode failed file: %s err: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *JSONSnapshotStore) remove(filePath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, err := lockSnapshot(filePath)
+	if err != nil {
+		log.Printf("snapshotStore lockSnapshot failed file: %s err: %v", filePath, err)
+		return err
+	}
+	defer lock.Unlock()
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		log.Println("snapshotStore os.Remove failed, err:", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/jsyzchen/pan/errno"
+)
+
+// TransferStatus 传输结束的原因，恢复逻辑和界面可据此决定下一步操作
+type TransferStatus string
+
+const (
+	StatusCompleted        TransferStatus = "completed"         // 传输完成
+	StatusCanceled         TransferStatus = "canceled"          // 用户取消
+	StatusDeadlineExceeded TransferStatus = "deadline_exceeded" // 超时
+	StatusPaused           TransferStatus = "paused"            // 暂停，可继续传输
+	StatusFatal            TransferStatus = "fatal"             // 永久性错误，如无权限、参数错误，重试无意义
+	StatusNetwork          TransferStatus = "network"           // 网络或服务端临时错误，重试次数用尽
+	StatusConflict         TransferStatus = "conflict"          // 网盘路径已有其他任务在上传，等待该任务结束后再试
+	StatusMergePending     TransferStatus = "merge_pending"     // 分片已全部下载，等待合并
+)
+
+// 传输被暂停
+var ErrPaused = errors.New("transfer paused")
+
+// 根据传输返回的错误判断结束原因
+func ClassifyStatus(err error) TransferStatus {
+	switch {
+	case err == nil:
+		return StatusCompleted
+	case errors.Is(err, ErrPaused):
+		return StatusPaused
+	case errors.Is(err, ErrMergeDeferred):
+		return StatusMergePending
+	case errors.Is(err, context.Canceled):
+		return StatusCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return StatusDeadlineExceeded
+	case errors.Is(err, ErrRemotePathLocked):
+		return StatusConflict
+	case errors.Is(err, ErrTempFileVetoed), errors.Is(err, ErrValidationRejected):
+		return StatusFatal
+	case errno.IsRetryable(err):
+		return StatusNetwork
+	}
+	return StatusFatal
+}
+
+type pauseContext struct {
+	context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	paused bool
+}
+
+func (c *pauseContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		return ErrPaused
+	}
+	return c.Context.Err()
+}
+
+// 返回可暂停的ctx，调用pause后ctx结束，Err返回ErrPaused，传输返回的快照状态为StatusPaused
+func WithPause(parent context.Context) (ctx context.Context, pause func()) {
+	cancelCtx, cancel := context.WithCancel(parent)
+	c := &pauseContext{Context: cancelCtx, cancel: cancel}
+	return c, func() {
+		c.mu.Lock()
+		if c.Context.Err() == nil {
+			c.paused = true
+		}
+		c.mu.Unlock()
+		cancel()
+	}
+}
@@ -0,0 +1,90 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+)
+
+// 临时文件被TempFileHook拒绝创建，不会重试
+var ErrTempFileVetoed = errors.New("temp file creation vetoed")
+
+// TempFileHook 临时文件生命周期钩子，可用于将分片等临时文件计入自定义的磁盘配额
+type TempFileHook interface {
+	// 创建临时文件前调用，size为预计写入的大小，未知时为-1，返回错误时不创建文件，传输失败
+	Create(path string, size int64) error
+	// 删除临时文件后调用，size为删除前的实际大小
+	Remove(path string, size int64)
+}
+
+var (
+	tempFileHookMu sync.RWMutex
+	tempFileHook   TempFileHook
+)
+
+// 设置全局的临时文件钩子，为nil时不处理
+func SetTempFileHook(hook TempFileHook) {
+	tempFileHookMu.Lock()
+	defer tempFileHookMu.Unlock()
+	tempFileHook = hook
+}
+
+func getTempFileHook() TempFileHook {
+	tempFileHookMu.RLock()
+	defer tempFileHookMu.RUnlock()
+	return tempFileHook
+}
+
+// 创建路径已知的临时文件
+func CreateTempFile(path string, size int64) (*os.File, error) {
+	if hook := getTempFileHook(); hook != nil {
+		if err := hook.Create(path, size); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrTempFileVetoed, err)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		notifyTempFileRemoved(path, 0)
+		return nil, err
+	}
+	return f, nil
+}
+
+// 在dir目录下创建随机文件名的临时文件，同ioutil.TempFile
+func TempFile(dir, pattern string, size int64) (*os.File, error) {
+	f, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if hook := getTempFileHook(); hook != nil {
+		if err := hook.Create(f.Name(), size); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, fmt.Errorf("%w: %v", ErrTempFileVetoed, err)
+		}
+	}
+	return f, nil
+}
+
+// 删除临时文件，删除成功后通知钩子
+func RemoveTempFile(path string) error {
+	var size int64 = 0
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+	if err := os.Remove(path); err != nil {
+		log.Println(path, "remove failed, err:", err)
+		return err
+	}
+	notifyTempFileRemoved(path, size)
+	return nil
+}
+
+func notifyTempFileRemoved(path string, size int64) {
+	if hook := getTempFileHook(); hook != nil {
+		hook.Remove(path, size)
+	}
+}
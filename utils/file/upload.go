@@ -3,44 +3,115 @@ package file
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
+	"github.com/jsyzchen/pan/errno"
 	"github.com/jsyzchen/pan/utils/httpclient"
 )
 
 type UploadSnapshot struct {
-	Path        string   `json:"path"`
-	LocalPath   string   `json:"local_path"`
-	UploadId    string   `json:"upload_id"`
-	FileMd5     string   `json:"file_md5"`
-	FileModTime int64    `json:"file_mtime"`
-	Recoverable bool     `json:"recoverable"`
-	DoneSize    int64    `json:"done_size"`
-	TotalSize   int64    `json:"total_size"`
-	SliceSize   int64    `json:"slice_size"`
-	SliceNum    int      `json:"slice_num"`
-	DoneSlices  []string `json:"done_slices"`
+	Path        string         `json:"path"`
+	LocalPath   string         `json:"local_path"`
+	UploadId    string         `json:"upload_id"`
+	FileMd5     string         `json:"file_md5"`
+	FileModTime int64          `json:"file_mtime"`
+	Recoverable bool           `json:"recoverable"`
+	DoneSize    int64          `json:"done_size"`
+	TotalSize   int64          `json:"total_size"`
+	SliceSize   int64          `json:"slice_size"`
+	SliceBasis  string         `json:"slice_basis,omitempty"` // 分片大小的计算依据，续传时沿用快照的分片大小，与当前会员身份无关
+	SliceNum    int            `json:"slice_num"`
+	DoneSlices  []string       `json:"done_slices"`
+	Status      TransferStatus `json:"status,omitempty"`
+	CreatedAt   int64          `json:"created_at,omitempty"` // uploadid的创建时间，用于判断uploadid是否已过期
+	UpdatedAt   int64          `json:"updated_at,omitempty"` // 快照最后更新的时间
+}
+
+// 分片大小的计算依据
+const (
+	SliceBasisCustom  = "custom"  // 指定了分片大小
+	SliceBasisDefault = "default" // 普通用户或获取用户信息失败，分片大小为4M
+	SliceBasisVip     = "vip"     // 普通会员，分片大小为16M
+	SliceBasisSvip    = "svip"    // 超级会员，分片大小为32M
+)
+
+// 检查快照的分片数与分片大小、文件大小是否一致，不一致时已上传的分片无法续传
+func (s *UploadSnapshot) ValidateSlices() error {
+	if s.SliceSize <= 0 || s.TotalSize <= 0 {
+		return errors.New(fmt.Sprintf("snapshot slice size invalid, sliceSize: %d totalSize: %d", s.SliceSize, s.TotalSize))
+	}
+	sliceNum := int(math.Ceil(float64(s.TotalSize) / float64(s.SliceSize)))
+	if s.SliceNum != sliceNum || len(s.DoneSlices) > sliceNum {
+		return errors.New(fmt.Sprintf("snapshot slice num mismatch, sliceNum: %d expected: %d doneSlices: %d sliceSize: %d", s.SliceNum, sliceNum, len(s.DoneSlices), s.SliceSize))
+	}
+	return nil
 }
 
 type Uploader struct {
-	Url      string
-	FilePath string
+	Url         string
+	FilePath    string
+	RateLimiter *RateLimiter      //限速器，为nil时不限速
+	ContentMd5  string            //文件内容的md5(16进制)，不为空时在文件部分的头中携带Content-MD5
+	FieldName   string            //文件部分的参数名，默认为file
+	FileName    string            //文件部分的文件名，为空时使用FilePath的文件名
+	FormFields  map[string]string //文件部分之前的其他表单参数，按参数名排序写入
+	Timeouts    UploadTimeouts    //UploadBySection各阶段的超时
 }
 
+const defaultFieldName = "file"
+
 // NewFileUploader
 func NewFileUploader(url, filePath string) *Uploader {
 	return &Uploader{
-		Url:      url,
-		FilePath: filePath,
+		Url:       url,
+		FilePath:  filePath,
+		FieldName: defaultFieldName,
+	}
+}
+
+func (u *Uploader) SetFieldName(fieldName string) {
+	u.FieldName = fieldName
+}
+
+func (u *Uploader) SetFileName(fileName string) {
+	u.FileName = fileName
+}
+
+// 设置上传请求各阶段的超时，分片上传一般需要较长的写入超时和较短的响应超时
+func (u *Uploader) SetTimeouts(timeouts UploadTimeouts) {
+	u.Timeouts = timeouts
+}
+
+// 添加表单参数，同名参数会被覆盖
+func (u *Uploader) AddFormField(name, value string) {
+	if u.FormFields == nil {
+		u.FormFields = map[string]string{}
 	}
+	u.FormFields[name] = value
+}
+
+func (u *Uploader) SetRateLimiter(rateLimiter *RateLimiter) {
+	u.RateLimiter = rateLimiter
+}
+
+func (u *Uploader) SetContentMd5(contentMd5 string) {
+	u.ContentMd5 = contentMd5
 }
 
 // 上传文件
@@ -49,8 +120,7 @@ func (u *Uploader) Upload() ([]byte, error) {
 
 	bodyBuf := &bytes.Buffer{}
 	bodyWriter := multipart.NewWriter(bodyBuf)
-	//"file" 为接收时定义的参数名
-	fileWriter, err := bodyWriter.CreateFormFile("file", filepath.Base(u.FilePath))
+	fileWriter, err := u.createFormFile(bodyWriter)
 	if err != nil {
 		log.Println("error writing to buffer, err:", err)
 		return ret, err
@@ -84,7 +154,7 @@ func (u *Uploader) Upload() ([]byte, error) {
 	request.Header.Set("User-Agent", userAgent)
 
 	//处理返回结果
-	client := &http.Client{}
+	client := httpclient.GetClient()
 	resp, err := client.Do(request)
 	//打印接口返回信息
 	if err != nil {
@@ -114,28 +184,80 @@ func (pbr *ProgressByteReader) Read(p []byte) (nr int, err error) {
 	return
 }
 
+// WireProgressReader 按已写入连接的字节数上报进度
+// 每次读取时才上报上一次读取的字节数，此时上一段数据已被写入连接，进度不会领先于实际发送
+type WireProgressReader struct {
+	io.Reader
+	Reporter func(int64)
+
+	mu      sync.Mutex
+	pending int64
+}
+
+func (r *WireProgressReader) Read(p []byte) (int, error) {
+	r.Flush()
+	nr, err := r.Reader.Read(p)
+	if nr > 0 {
+		r.mu.Lock()
+		r.pending += int64(nr)
+		r.mu.Unlock()
+	}
+	if err != nil {
+		r.Flush()
+	}
+	return nr, err
+}
+
+// 上报尚未上报的字节数，请求体写完时调用
+func (r *WireProgressReader) Flush() {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = 0
+	r.mu.Unlock()
+	if pending > 0 && r.Reporter != nil {
+		r.Reporter(pending)
+	}
+}
+
 // 直接通过字节上传
 func (u *Uploader) UploadByByte(ctx context.Context, fileByte []byte, progressHandler func(int64)) ([]byte, error) {
+	return u.UploadBySection(ctx, io.NewSectionReader(bytes.NewReader(fileByte), 0, int64(len(fileByte))), progressHandler)
+}
+
+// 上传文件的一段，multipart请求体不在内存中拼接，数据直接从section拷贝到连接
+// 进度只统计文件数据，按已写入连接的字节数上报
+func (u *Uploader) UploadBySection(ctx context.Context, section *io.SectionReader, progressHandler func(int64)) ([]byte, error) {
 	ret := []byte("")
 	bodyBuf := &bytes.Buffer{}
 	bodyWriter := multipart.NewWriter(bodyBuf)
-	//"file" 为接收时定义的参数名
-	fileWriter, err := bodyWriter.CreateFormFile("file", filepath.Base(u.FilePath))
-	if err != nil {
-		return ret, err
-	}
-
-	_, err = io.Copy(fileWriter, bytes.NewReader(fileByte))
+	_, err := u.createFormFile(bodyWriter)
 	if err != nil {
 		return ret, err
 	}
+	bodyHeader := make([]byte, bodyBuf.Len())
+	copy(bodyHeader, bodyBuf.Bytes())
+	bodyBuf.Reset()
 	contentType := bodyWriter.FormDataContentType()
 	bodyWriter.Close()
-	contentLength := bodyBuf.Len()
+	bodyFooter := bodyBuf.Bytes()
+	contentLength := int64(len(bodyHeader)) + section.Size() + int64(len(bodyFooter))
+	sectionReader := &WireProgressReader{Reader: u.RateLimiter.Reader(ctx, section), Reporter: progressHandler}
+	body := io.MultiReader(bytes.NewReader(bodyHeader), sectionReader, bytes.NewReader(bodyFooter))
+
+	//请求体全部写入连接后上报剩余进度
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err == nil {
+				sectionReader.Flush()
+			}
+		},
+	}
 
 	//提交请求
-	request, err := http.NewRequestWithContext(ctx, "POST", u.Url, &ProgressByteReader{bodyBuf, progressHandler})
+	ctx, timer := u.Timeouts.withTrace(httptrace.WithClientTrace(ctx, trace))
+	request, err := http.NewRequestWithContext(ctx, "POST", u.Url, body)
 	if err != nil {
+		timer.stop(nil)
 		return ret, err
 	}
 
@@ -143,24 +265,72 @@ func (u *Uploader) UploadByByte(ctx context.Context, fileByte []byte, progressHa
 	//随机设置一个User-Agent
 	userAgent := httpclient.GetRandomUserAgent()
 	request.Header.Set("User-Agent", userAgent)
-	request.ContentLength = int64(contentLength)
+	request.ContentLength = contentLength
 
 	//处理返回结果
-	client := &http.Client{}
+	client := httpclient.GetClientWithContext(request.Context())
 	resp, err := client.Do(request)
 	if err != nil {
-		return ret, err
+		return ret, timer.stop(err)
 	}
+	defer timer.stop(nil)
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return ret, errors.New(fmt.Sprintf("http error status: %d msg: %s", resp.StatusCode, resp.Status))
+		errBody, _ := ioutil.ReadAll(resp.Body)
+		if err := errno.CheckJSON(resp.StatusCode, resp.Header, errBody); err != nil {
+			return ret, err
+		}
+		return ret, &errno.HTTPError{StatusCode: resp.StatusCode, Body: string(errBody)}
 	}
 
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return ret, err
 	}
+	if err := errno.CheckJSON(resp.StatusCode, resp.Header, respBody); err != nil {
+		return ret, err
+	}
 
 	return respBody, nil
 }
+
+// 写入其他表单参数并创建文件部分，设置了ContentMd5时携带Content-MD5头
+func (u *Uploader) createFormFile(bodyWriter *multipart.Writer) (io.Writer, error) {
+	names := make([]string, 0, len(u.FormFields))
+	for name := range u.FormFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := bodyWriter.WriteField(name, u.FormFields[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	fieldName := u.FieldName
+	if fieldName == "" {
+		fieldName = defaultFieldName
+	}
+	fileName := u.FileName
+	if fileName == "" {
+		fileName = filepath.Base(u.FilePath)
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(fieldName), escapeQuotes(fileName)))
+	header.Set("Content-Type", "application/octet-stream")
+	if u.ContentMd5 != "" {
+		sum, err := hex.DecodeString(u.ContentMd5)
+		if err != nil {
+			return nil, err
+		}
+		header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum))
+	}
+	return bodyWriter.CreatePart(header)
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -0,0 +1,97 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// 上传请求各阶段的超时，为0时不限制
+type UploadTimeouts struct {
+	Connect        time.Duration // 获取连接的超时，包括DNS解析、建立TCP连接和TLS握手
+	Write          time.Duration // 写入请求的超时，从获取到连接到请求体全部写入连接，需按分片大小和上行带宽设置得足够长
+	ResponseHeader time.Duration // 请求写完后等待响应头的超时
+}
+
+// 上传请求某一阶段超时，可以重试
+type PhaseTimeoutError struct {
+	Phase   string
+	Timeout time.Duration
+}
+
+func (e *PhaseTimeoutError) Error() string {
+	return fmt.Sprintf("upload %s timeout after %v", e.Phase, e.Timeout)
+}
+
+const (
+	PhaseConnect        = "connect"
+	PhaseWrite          = "write"
+	PhaseResponseHeader = "response header"
+)
+
+// 按阶段计时，超时时取消请求
+type phaseTimer struct {
+	timeouts UploadTimeouts
+	cancel   context.CancelFunc
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	expired *PhaseTimeoutError
+}
+
+// 返回带有各阶段计时的ctx，请求结束后需调用stop
+func (t *UploadTimeouts) withTrace(ctx context.Context) (context.Context, *phaseTimer) {
+	ctx, cancel := context.WithCancel(ctx)
+	pt := &phaseTimer{timeouts: *t, cancel: cancel}
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			pt.start(PhaseConnect, t.Connect)
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			pt.start(PhaseWrite, t.Write)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			pt.start(PhaseResponseHeader, t.ResponseHeader)
+		},
+		GotFirstResponseByte: func() {
+			pt.start("", 0)
+		},
+	}
+	return httptrace.WithClientTrace(ctx, trace), pt
+}
+
+// 进入下一阶段，timeout为0时不计时
+func (t *phaseTimer) start(phase string, timeout time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	if t.expired != nil || timeout <= 0 {
+		return
+	}
+	t.timer = time.AfterFunc(timeout, func() {
+		t.mu.Lock()
+		t.expired = &PhaseTimeoutError{Phase: phase, Timeout: timeout}
+		t.mu.Unlock()
+		t.cancel()
+	})
+}
+
+// 停止计时，请求因阶段超时失败时返回*PhaseTimeoutError，否则原样返回err
+func (t *phaseTimer) stop(err error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.cancel()
+	if err != nil && t.expired != nil {
+		return t.expired
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// 下载文件校验钩子，文件写入完成、移动到保存路径前调用，如病毒扫描、内容检查
+// path为待校验的临时文件路径，size为文件大小，返回error时拒绝本次下载，临时文件被删除
+type ValidateHook func(ctx context.Context, path string, size int64) error
+
+// 下载的文件未通过校验，可通过errors.Is判断，通过errors.As获取ValidationError
+var ErrValidationRejected = errors.New("downloaded file rejected by validation")
+
+// 待校验文件的后缀，校验通过后重命名为保存路径
+const validatingSuffix = ".validating"
+
+// 下载的文件未通过校验
+type ValidationError struct {
+	Path string // 保存路径
+	Err  error  // 校验钩子返回的错误
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("downloaded file rejected by validation, path: %s err: %v", e.Path, e.Err)
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidationRejected
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// 设置校验钩子，设置后文件先写入保存路径旁的临时文件，校验通过后重命名，保存路径不会出现未校验的内容
+func (d *Downloader) SetValidateHook(validate ValidateHook) {
+	d.Validate = validate
+}
+
+// 文件写入的路径，设置了校验钩子时为临时文件
+func (d *Downloader) writePath() string {
+	if d.Validate == nil {
+		return d.FilePath
+	}
+	return d.FilePath + validatingSuffix
+}
+
+// 校验写入完成的文件，通过后重命名为保存路径，未设置校验钩子时直接返回
+func (d *Downloader) finalize(ctx context.Context) error {
+	if d.Validate == nil {
+		return nil
+	}
+	tempPath := d.writePath()
+	if err := d.Validate(ctx, tempPath, d.FileSize); err != nil {
+		log.Printf("download validate rejected savePath: %s err: %v", d.FilePath, err)
+		os.Remove(tempPath)
+		return &ValidationError{Path: d.FilePath, Err: err}
+	}
+	if err := os.Rename(tempPath, d.FilePath); err != nil {
+		log.Printf("download validated file rename failed savePath: %s err: %v", d.FilePath, err)
+		return err
+	}
+	return nil
+}
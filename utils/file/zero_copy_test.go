@@ -0,0 +1,168 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// 写入分片文件，返回分片列表
+func writeParts(tb testing.TB, dir string, data []byte, partSize int) []Part {
+	parts := []Part{}
+	for from := 0; from < len(data); from += partSize {
+		to := from + partSize
+		if to > len(data) {
+			to = len(data)
+		}
+		partPath := filepath.Join(dir, "part"+strconv.Itoa(len(parts)))
+		if err := ioutil.WriteFile(partPath, data[from:to], 0644); err != nil {
+			tb.Fatal(err)
+		}
+		parts = append(parts, Part{Index: len(parts), From: int64(from), To: int64(to - 1), FilePath: partPath})
+	}
+	return parts
+}
+
+func TestMergeFileParts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "panmerge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := make([]byte, 3*100000+17)
+	rand.New(rand.NewSource(5)).Read(data)
+	parts := writeParts(t, dir, data, 100000)
+
+	savePath := filepath.Join(dir, "merged.bin")
+	if err := ioutil.WriteFile(savePath, bytes.Repeat([]byte("x"), len(data)*2), 0644); err != nil { //已存在的更大文件被覆盖
+		t.Fatal(err)
+	}
+	d := NewFileDownloader("", savePath)
+	d.FileSize = int64(len(data))
+	var progress int64
+	if err := d.mergeFileParts(context.Background(), parts, func(n int64) { progress += n }); err != nil {
+		t.Fatalf("mergeFileParts: %v", err)
+	}
+	got, err := ioutil.ReadFile(savePath)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("merged content mismatch, err: %v", err)
+	}
+	if progress != int64(len(data)) {
+		t.Fatalf("progress %d, want %d", progress, len(data))
+	}
+
+	d.FileSize = int64(len(data)) + 1
+	if err := d.mergeFileParts(context.Background(), parts, func(int64) {}); err != ErrFileIncomplete {
+		t.Fatalf("mergeFileParts size mismatch err: %v, want ErrFileIncomplete", err)
+	}
+}
+
+// 文件的一段直接作为multipart请求体上传，重试时从分片开头重新读取
+func TestUploadBySection(t *testing.T) {
+	data := make([]byte, 200000)
+	rand.New(rand.NewSource(6)).Read(data)
+	f, err := ioutil.TempFile("", "pansection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.ContentLength <= 0 || len(r.TransferEncoding) != 0 {
+			t.Errorf("request not sent with Content-Length: %d %v", r.ContentLength, r.TransferEncoding)
+		}
+		part, _, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("FormFile: %v", err)
+			return
+		}
+		defer part.Close()
+		got, _ := ioutil.ReadAll(part)
+		if !bytes.Equal(got, data[1000:151000]) {
+			t.Errorf("uploaded section mismatch, len: %d", len(got))
+		}
+		w.Write([]byte(`{"md5":"ok"}`))
+	}))
+	defer server.Close()
+
+	section := io.NewSectionReader(f, 1000, 150000)
+	u := NewFileUploader(server.URL, f.Name())
+	for i := 0; i < 2; i++ {
+		var progress int64
+		resp, err := u.UploadBySection(context.Background(), io.NewSectionReader(section, 0, section.Size()), func(n int64) { atomic.AddInt64(&progress, n) })
+		if err != nil {
+			t.Fatalf("UploadBySection: %v", err)
+		}
+		if string(resp) != `{"md5":"ok"}` {
+			t.Fatalf("unexpected response: %s", resp)
+		}
+		if atomic.LoadInt64(&progress) != section.Size() {
+			t.Fatalf("progress %d, want %d", progress, section.Size())
+		}
+	}
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Fatalf("requests %d, want 2", requests)
+	}
+}
+
+// 内核拷贝合并与用户态缓冲区拷贝的对比
+func BenchmarkMergeFileParts(b *testing.B) {
+	dir, err := ioutil.TempDir("", "panmerge")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := make([]byte, 32*1024*1024)
+	rand.New(rand.NewSource(7)).Read(data)
+	parts := writeParts(b, dir, data, 8*1024*1024)
+	savePath := filepath.Join(dir, "merged.bin")
+
+	b.Run("ReadFrom", func(b *testing.B) {
+		d := NewFileDownloader("", savePath)
+		d.FileSize = int64(len(data))
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := d.mergeFileParts(context.Background(), parts, func(int64) {}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("CopyBuffer", func(b *testing.B) {
+		buffer := make([]byte, 4*1024*1024)
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			merged, err := os.Create(savePath)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, p := range parts {
+				partFile, err := os.Open(p.FilePath)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.CopyBuffer(struct{ io.Writer }{merged}, struct{ io.Reader }{partFile}, buffer); err != nil {
+					b.Fatal(err)
+				}
+				partFile.Close()
+			}
+			merged.Close()
+		}
+	})
+}
@@ -1,15 +1,19 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
 	"net/url"
 	"strconv"
+	"time"
 )
 
 func InterfaceToString(val interface{}) string {
 	switch val.(type) {
 	case string:
 		return val.(string)
+	case json.Number:
+		return val.(json.Number).String()
 	case int:
 		return strconv.FormatInt(int64(val.(int)), 10)
 	case int64:
@@ -35,8 +39,11 @@ func StructToUrlQuery(m interface{}) (string, error) {
 		return query, err
 	}
 
+	// 使用json.Number解析，避免fs_id等超过float64精度的整数被截断
 	var f map[string]interface{}
-	err = json.Unmarshal(b, &f)
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	err = decoder.Decode(&f)
 	if err != nil {
 		return query, err
 	}
@@ -48,3 +55,11 @@ func StructToUrlQuery(m interface{}) (string, error) {
 	query = v.Encode()
 	return query, nil
 }
+
+// 将unix秒级时间戳转成time.Time，为0时返回零值
+func UnixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
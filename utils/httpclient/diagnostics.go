@@ -0,0 +1,183 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// 单个host的请求耗时统计，各耗时为全部请求的累计值，除以对应次数得到平均值
+type HostTiming struct {
+	Requests     int64         // 请求次数
+	Errors       int64         // 请求失败次数
+	ReusedConns  int64         // 复用已有连接的次数
+	DNSCount     int64         // 进行DNS解析的次数
+	DNS          time.Duration // DNS解析耗时
+	ConnectCount int64         // 新建TCP连接的次数
+	Connect      time.Duration // 建立TCP连接耗时
+	TLSCount     int64         // 进行TLS握手的次数
+	TLS          time.Duration // TLS握手耗时
+	TTFB         time.Duration // 请求写完到收到响应首字节的耗时，即服务端处理时间
+	Total        time.Duration // 从发起请求到收到响应头的耗时，不含读取响应体
+}
+
+// Diagnostics 实现http.RoundTripper，通过httptrace统计接口请求和分片传输各阶段的耗时，按host汇总
+// 默认不开启，需要排查上传下载慢的问题时通过SetTransport开启
+type Diagnostics struct {
+	Transport http.RoundTripper // 实际发起请求的Transport，为nil时使用DefaultTransport()
+
+	mu      sync.Mutex
+	timings map[string]*HostTiming
+}
+
+func NewDiagnostics() *Diagnostics {
+	return &Diagnostics{
+		timings: map[string]*HostTiming{},
+	}
+}
+
+func (d *Diagnostics) SetTransport(transport http.RoundTripper) {
+	d.Transport = transport
+}
+
+// 单次请求各阶段的时间点
+type requestTrace struct {
+	mu                               sync.Mutex
+	reused                           bool
+	dnsStart, connectStart, tlsStart time.Time
+	dns, connect, tlsHandshake, ttfb time.Duration
+	dnsDone, connectDone, tlsDone    bool
+	wroteRequest                     time.Time
+}
+
+func (t *requestTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.dns = time.Since(t.dnsStart)
+			t.dnsDone = true
+			t.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			if t.connectStart.IsZero() { // 同时尝试多个地址时只统计第一次
+				t.connectStart = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			if err == nil {
+				t.connect = time.Since(t.connectStart)
+				t.connectDone = true
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			t.mu.Lock()
+			if err == nil {
+				t.tlsHandshake = time.Since(t.tlsStart)
+				t.tlsDone = true
+			}
+			t.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			t.wroteRequest = time.Now()
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			if !t.wroteRequest.IsZero() {
+				t.ttfb = time.Since(t.wroteRequest)
+			}
+			t.mu.Unlock()
+		},
+	}
+}
+
+func (d *Diagnostics) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := d.Transport
+	if transport == nil {
+		transport = DefaultTransport()
+	}
+
+	t := &requestTrace{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), t.clientTrace()))
+	start := time.Now()
+	resp, err := transport.RoundTrip(req)
+	total := time.Since(start)
+	d.record(req.URL.Hostname(), t, total, err)
+	return resp, err
+}
+
+func (d *Diagnostics) record(host string, t *requestTrace, total time.Duration, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timings == nil {
+		d.timings = map[string]*HostTiming{}
+	}
+	timing, ok := d.timings[host]
+	if !ok {
+		timing = &HostTiming{}
+		d.timings[host] = timing
+	}
+	timing.Requests++
+	timing.Total += total
+	timing.TTFB += t.ttfb
+	if err != nil {
+		timing.Errors++
+	}
+	if t.reused {
+		timing.ReusedConns++
+	}
+	if t.dnsDone {
+		timing.DNSCount++
+		timing.DNS += t.dns
+	}
+	if t.connectDone {
+		timing.ConnectCount++
+		timing.Connect += t.connect
+	}
+	if t.tlsDone {
+		timing.TLSCount++
+		timing.TLS += t.tlsHandshake
+	}
+}
+
+// 获取各host的耗时统计
+func (d *Diagnostics) Timings() map[string]HostTiming {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ret := make(map[string]HostTiming, len(d.timings))
+	for host, timing := range d.timings {
+		ret[host] = *timing
+	}
+	return ret
+}
+
+// 清空统计
+func (d *Diagnostics) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.timings = map[string]*HostTiming{}
+}
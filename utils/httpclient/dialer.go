@@ -0,0 +1,204 @@
+package httpclient
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 单个host的连接统计
+type HostMetric struct {
+	Dials      int64         // 建立连接次数
+	DialErrors int64         // 建立连接失败次数
+	DialTime   time.Duration // 建立连接的总耗时
+	LastAddr   string        // 最近一次连接的地址
+}
+
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// 建立连接时的IP协议偏好
+type IPPreference int
+
+const (
+	IPPreferAuto IPPreference = iota // 由系统决定
+	IPPreferIPv4                     // 优先IPv4，失败时尝试IPv6
+	IPPreferIPv6                     // 优先IPv6，失败时尝试IPv4
+	IPv4Only                         // 禁用IPv6
+	IPv6Only                         // 只使用IPv6
+)
+
+var (
+	dialMu       sync.RWMutex
+	dialFunc     DialFunc
+	resolver     *net.Resolver
+	hostMapping  = map[string]string{}
+	ipPreference IPPreference
+
+	metricsMu   sync.Mutex
+	hostMetrics = map[string]*HostMetric{}
+)
+
+// 全部请求默认使用的Transport，连接通过dialContext建立
+var sharedTransport = newSharedTransport()
+
+func newSharedTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = dialContext
+	return t
+}
+
+// 获取默认使用的Transport，自定义Transport(如Recorder)可以基于它发起请求以保留连接设置
+func DefaultTransport() http.RoundTripper {
+	return sharedTransport
+}
+
+// 设置建立连接的函数，为nil时使用net.Dialer
+// 设置了IP协议偏好时传入的地址已解析为IP，自定义DNS解析应使用SetResolver
+func SetDialContext(dial DialFunc) {
+	dialMu.Lock()
+	defer dialMu.Unlock()
+	dialFunc = dial
+}
+
+// 设置DNS解析器，用于默认的net.Dialer和按IP协议偏好解析地址，为nil时使用net.DefaultResolver
+func SetResolver(r *net.Resolver) {
+	dialMu.Lock()
+	defer dialMu.Unlock()
+	resolver = r
+}
+
+// 设置host到IP的静态映射，如将PCS、CDN域名固定到速度较快的节点
+func SetHostMapping(mapping map[string]string) {
+	dialMu.Lock()
+	defer dialMu.Unlock()
+	hostMapping = map[string]string{}
+	for host, ip := range mapping {
+		hostMapping[host] = ip
+	}
+}
+
+// 设置建立连接时的IP协议偏好，不同协议下的下载速度可能差别很大
+func SetIPPreference(preference IPPreference) {
+	dialMu.Lock()
+	defer dialMu.Unlock()
+	ipPreference = preference
+}
+
+// 获取各host的连接统计
+func HostMetrics() map[string]HostMetric {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	ret := make(map[string]HostMetric, len(hostMetrics))
+	for host, metric := range hostMetrics {
+		ret[host] = *metric
+	}
+	return ret
+}
+
+func dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	dialMu.RLock()
+	dial := dialFunc
+	dialHost := host
+	if ip, ok := hostMapping[host]; ok {
+		dialHost = ip
+	}
+	preference := ipPreference
+	r := resolver
+	dialMu.RUnlock()
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	if dial == nil {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Resolver:  r,
+		}
+		dial = dialer.DialContext
+	}
+
+	start := time.Now()
+	var conn net.Conn
+	if preference == IPPreferAuto {
+		conn, err = dial(ctx, network, net.JoinHostPort(dialHost, port))
+	} else {
+		conn, err = dialWithPreference(ctx, dial, r, network, dialHost, port, preference)
+	}
+	recordDial(host, conn, time.Since(start), err)
+	return conn, err
+}
+
+// 按IP协议偏好排序解析出的地址，依次尝试建立连接
+func dialWithPreference(ctx context.Context, dial DialFunc, r *net.Resolver, network, host, port string, preference IPPreference) (net.Conn, error) {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := r.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	switch preference {
+	case IPPreferIPv4:
+		ips = append(v4, v6...)
+	case IPPreferIPv6:
+		ips = append(v6, v4...)
+	case IPv4Only:
+		ips = v4
+	case IPv6Only:
+		ips = v6
+	}
+	if len(ips) == 0 {
+		return nil, &net.AddrError{Err: "no address matches ip preference", Addr: host}
+	}
+
+	var err error
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = dial(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+func recordDial(host string, conn net.Conn, dialTime time.Duration, err error) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metric, ok := hostMetrics[host]
+	if !ok {
+		metric = &HostMetric{}
+		hostMetrics[host] = metric
+	}
+	metric.Dials++
+	metric.DialTime += dialTime
+	if err != nil {
+		metric.DialErrors++
+		return
+	}
+	metric.LastAddr = conn.RemoteAddr().String()
+}
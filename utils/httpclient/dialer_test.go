@@ -0,0 +1,34 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// 设置IP协议偏好时使用SetResolver设置的解析器解析地址
+func TestDialWithPreferenceUsesResolver(t *testing.T) {
+	var lookups int32
+	SetResolver(&net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			atomic.AddInt32(&lookups, 1)
+			return nil, errors.New("fake dns unavailable")
+		},
+	})
+	defer SetResolver(nil)
+
+	for _, preference := range []IPPreference{IPPreferAuto, IPPreferIPv4, IPv6Only} {
+		SetIPPreference(preference)
+		atomic.StoreInt32(&lookups, 0)
+		if _, err := dialContext(context.Background(), "tcp", "pan-resolver.test:80"); err == nil {
+			t.Fatalf("dial succeeded without dns, preference: %d", preference)
+		}
+		if atomic.LoadInt32(&lookups) == 0 {
+			t.Errorf("configured resolver not used, preference: %d", preference)
+		}
+	}
+	SetIPPreference(IPPreferAuto)
+}
@@ -6,7 +6,10 @@ import (
 	"math/rand"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/jsyzchen/pan/errno"
 )
 
 type HttpResponse struct {
@@ -15,8 +18,69 @@ type HttpResponse struct {
 	Body       []byte
 }
 
+// 全部请求共用的Transport，保存transportValue，其中的Transport为nil时使用sharedTransport
+var transport atomic.Value
+
+// atomic.Value不能保存nil，也不能保存不同类型的值，统一包装后保存
+type transportValue struct {
+	rt http.RoundTripper
+}
+
+// 设置全部请求共用的Transport，如用于录制/回放接口请求的Recorder，可以在请求进行中调用
+func SetTransport(rt http.RoundTripper) {
+	transport.Store(transportValue{rt})
+}
+
+// 是否关闭HTML等非JSON错误页面的检测，为0时检测，默认开启，通过atomic读写
+var nonJSONCheckDisabled int32
+
+// 设置是否检测非JSON响应，开启时接口返回HTML错误页面等非JSON内容时返回errno.NonJSONError
+// 调用返回非JSON内容的接口时可关闭，可以在请求进行中调用
+func SetNonJSONCheck(enable bool) {
+	var disabled int32
+	if !enable {
+		disabled = 1
+	}
+	atomic.StoreInt32(&nonJSONCheckDisabled, disabled)
+}
+
+// 获取使用共用Transport的http.Client
+func GetClient() *http.Client {
+	value, _ := transport.Load().(transportValue)
+	if value.rt == nil {
+		return &http.Client{Transport: sharedTransport}
+	}
+	return &http.Client{Transport: value.rt}
+}
+
+type transportWrapperKey struct{}
+
+// 返回的ctx发起的请求经wrap包装共用Transport后发出，用于只为单个客户端开启审计等，不影响其他请求
+// ctx中已有包装时，wrap包装在外层
+func WithTransportWrapper(ctx context.Context, wrap func(http.RoundTripper) http.RoundTripper) context.Context {
+	if prev, ok := ctx.Value(transportWrapperKey{}).(func(http.RoundTripper) http.RoundTripper); ok {
+		inner := wrap
+		wrap = func(rt http.RoundTripper) http.RoundTripper {
+			return inner(prev(rt))
+		}
+	}
+	return context.WithValue(ctx, transportWrapperKey{}, wrap)
+}
+
+// 获取ctx对应的http.Client，ctx通过WithTransportWrapper设置了包装时使用包装后的Transport
+func GetClientWithContext(ctx context.Context) *http.Client {
+	client := GetClient()
+	if ctx == nil {
+		return client
+	}
+	if wrap, ok := ctx.Value(transportWrapperKey{}).(func(http.RoundTripper) http.RoundTripper); ok {
+		client.Transport = wrap(client.Transport)
+	}
+	return client
+}
+
 func SendRequest(ctx context.Context, method string, url string, header map[string]string, body string) (HttpResponse, error) {
-	client := &http.Client{}
+	client := GetClientWithContext(ctx)
 	var res HttpResponse
 	var request *http.Request
 	var err error
@@ -66,6 +130,11 @@ func SendRequest(ctx context.Context, method string, url string, header map[stri
 	if err != nil {
 		return res, err
 	}
+	if atomic.LoadInt32(&nonJSONCheckDisabled) == 0 && method != "HEAD" {
+		if err := errno.CheckJSON(res.StatusCode, res.Header, res.Body); err != nil {
+			return res, err
+		}
+	}
 	return res, nil
 }
 
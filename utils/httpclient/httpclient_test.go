@@ -0,0 +1,56 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jsyzchen/pan/errno"
+)
+
+// 请求进行中可以切换非JSON检测和错误信息长度，-race下不报数据竞争
+func TestNonJSONSettingsConcurrent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>" + strings.Repeat("x", 1000) + "</html>"))
+	}))
+	defer server.Close()
+	defer SetNonJSONCheck(true)
+	defer errno.SetNonJSONSnippetLen(256)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				SetNonJSONCheck(j%2 == 0)
+				errno.SetNonJSONSnippetLen(j)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				Get(context.Background(), server.URL, map[string]string{})
+			}
+		}()
+	}
+	wg.Wait()
+
+	SetNonJSONCheck(true)
+	errno.SetNonJSONSnippetLen(10)
+	_, err := Get(context.Background(), server.URL, map[string]string{})
+	var nonJSONErr *errno.NonJSONError
+	if !errors.As(err, &nonJSONErr) || len([]rune(nonJSONErr.Body)) != 13 {
+		t.Fatalf("Get err: %v, want NonJSONError with a 10 character snippet", err)
+	}
+	SetNonJSONCheck(false)
+	if _, err := Get(context.Background(), server.URL, map[string]string{}); errors.As(err, &nonJSONErr) {
+		t.Fatalf("Get err: %v with the non-json check disabled", err)
+	}
+}
@@ -0,0 +1,256 @@
+package httpclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	RecorderModeRecord = "record" // 请求真实接口并记录
+	RecorderModeReplay = "replay" // 只从录制文件回放，不发起网络请求
+)
+
+// 脱敏后的参数值
+const sanitizedValue = "SANITIZED"
+
+// 默认脱敏的请求参数
+var defaultSanitizeParams = []string{"access_token", "refresh_token", "client_id", "client_secret", "code", "pwd", "sekey", "randsk"}
+
+// 录制的单次请求
+type Interaction struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	Range       string      `json:"range,omitempty"`
+	RequestBody string      `json:"request_body,omitempty"` // 只记录表单请求，上传分片等二进制请求不记录
+	StatusCode  int         `json:"status_code"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// Recorder 实现http.RoundTripper，录制接口请求为脱敏的录制文件，或从录制文件回放
+// 配合SetTransport使用，使上传、下载等流程在没有授权信息时也可以重复运行
+type Recorder struct {
+	Mode           string
+	FixturePath    string
+	Transport      http.RoundTripper // 录制时实际发起请求的Transport，为nil时使用DefaultTransport()
+	SanitizeParams []string          // 需要脱敏的请求参数，参数值在URL、请求体和返回结果中都会被替换
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+	recorded     []bool // 录制时对应的请求是否已读取完返回结果
+	secrets      map[string]bool
+}
+
+// 录制文件中没有匹配的请求
+var ErrInteractionNotFound = errors.New("recorder interaction not found")
+
+// 创建Recorder，回放模式下加载录制文件
+func NewRecorder(mode, fixturePath string) (*Recorder, error) {
+	r := &Recorder{
+		Mode:           mode,
+		FixturePath:    fixturePath,
+		SanitizeParams: defaultSanitizeParams,
+		secrets:        map[string]bool{},
+	}
+	if mode != RecorderModeReplay {
+		return r, nil
+	}
+
+	data, err := ioutil.ReadFile(fixturePath)
+	if err != nil {
+		log.Println("recorder ioutil.ReadFile failed, err:", err)
+		return r, err
+	}
+	if err := json.Unmarshal(data, &r.interactions); err != nil {
+		log.Println("recorder json.Unmarshal failed, err:", err)
+		return r, err
+	}
+	r.used = make([]bool, len(r.interactions))
+	return r, nil
+}
+
+func (r *Recorder) SetTransport(transport http.RoundTripper) {
+	r.Transport = transport
+}
+
+func (r *Recorder) SetSanitizeParams(params []string) {
+	r.SanitizeParams = params
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestBody, err := r.readFormBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	key := Interaction{
+		Method:      req.Method,
+		URL:         r.sanitizeURL(req.URL),
+		Range:       req.Header.Get("Range"),
+		RequestBody: r.sanitizeForm(requestBody),
+	}
+	if r.Mode == RecorderModeReplay {
+		defer r.mu.Unlock()
+		// 按录制顺序取第一条未使用的匹配请求，同一接口多次请求时依次回放
+		for i, interaction := range r.interactions {
+			if r.used[i] || interaction.Method != key.Method || interaction.URL != key.URL ||
+				interaction.Range != key.Range || interaction.RequestBody != key.RequestBody {
+				continue
+			}
+			r.used[i] = true
+			return interaction.response(req), nil
+		}
+		return nil, errors.New(fmt.Sprintf("%v, method: %s url: %s", ErrInteractionNotFound, key.Method, key.URL))
+	}
+	// 发起请求前按请求顺序占位，请求并发进行，回放时同一请求的顺序与录制时一致
+	index := len(r.interactions)
+	r.interactions = append(r.interactions, key)
+	r.recorded = append(r.recorded, false)
+	r.mu.Unlock()
+
+	transport := r.Transport
+	if transport == nil {
+		transport = DefaultTransport()
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	r.mu.Lock()
+	r.interactions[index].StatusCode = resp.StatusCode
+	r.interactions[index].Header = header
+	r.mu.Unlock()
+	// 返回结果边读取边记录，读取结束或关闭时写入录制文件
+	resp.Body = &recordingBody{ReadCloser: resp.Body, finish: func(body []byte) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.interactions[index].Body = r.sanitizeBody(body)
+		r.recorded[index] = true
+	}}
+	return resp, nil
+}
+
+// 将录制的请求保存到录制文件，请求失败或返回结果还未读取完的请求不保存
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	interactions := []Interaction{}
+	for i, interaction := range r.interactions {
+		if i < len(r.recorded) && !r.recorded[i] {
+			continue
+		}
+		interactions = append(interactions, interaction)
+	}
+	data, err := json.MarshalIndent(interactions, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(r.FixturePath, data, 0644); err != nil {
+		log.Println("recorder ioutil.WriteFile failed, err:", err)
+		return err
+	}
+	return nil
+}
+
+// 录制时包装返回结果，记录调用方读取到的内容，提前关闭时只记录已读取的部分
+type recordingBody struct {
+	io.ReadCloser
+	buf    bytes.Buffer
+	finish func([]byte)
+	once   sync.Once
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.done()
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.done()
+	return b.ReadCloser.Close()
+}
+
+func (b *recordingBody) done() {
+	b.once.Do(func() {
+		b.finish(b.buf.Bytes())
+	})
+}
+
+// 读取表单请求体，读取后重新设置req.Body
+func (r *Recorder) readFormBody(req *http.Request) (string, error) {
+	if req.Body == nil || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return "", nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return string(body), nil
+}
+
+func (r *Recorder) sanitizeURL(u *url.URL) string {
+	sanitized := *u
+	sanitized.RawQuery = r.sanitizeForm(u.RawQuery)
+	return sanitized.String()
+}
+
+// 替换需要脱敏的参数值，并记录原值用于替换返回结果中的同一值
+func (r *Recorder) sanitizeForm(form string) string {
+	if form == "" {
+		return form
+	}
+	values, err := url.ParseQuery(form)
+	if err != nil {
+		return form
+	}
+	for _, param := range r.SanitizeParams {
+		for i, v := range values[param] {
+			if v != "" {
+				r.secrets[v] = true
+			}
+			values[param][i] = sanitizedValue
+		}
+	}
+	return values.Encode()
+}
+
+func (r *Recorder) sanitizeBody(body []byte) []byte {
+	for secret := range r.secrets {
+		body = bytes.Replace(body, []byte(secret), []byte(sanitizedValue), -1)
+	}
+	return body
+}
+
+func (i Interaction) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.StatusCode, http.StatusText(i.StatusCode)),
+		StatusCode:    i.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        i.Header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(i.Body)),
+		ContentLength: int64(len(i.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,182 @@
+package httpclient
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 录制时请求不互相阻塞，敏感参数脱敏，保存后可按录制顺序回放
+func TestRecorderRecordReplay(t *testing.T) {
+	fastDone := make(chan struct{})
+	var counter int
+	var counterMu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow": //慢请求等快请求完成后才返回，录制时串行化请求会超时
+			select {
+			case <-fastDone:
+			case <-time.After(5 * time.Second):
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+			w.Write([]byte(`{"slow":true}`))
+		case "/fast":
+			w.Write([]byte(`{"token":"` + r.URL.Query().Get("access_token") + `"}`))
+		case "/counter":
+			counterMu.Lock()
+			counter++
+			n := counter
+			counterMu.Unlock()
+			w.Write([]byte(strings.Repeat("n", n)))
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "panrecorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixturePath := filepath.Join(dir, "fixture.json")
+
+	recorder, err := NewRecorder(RecorderModeRecord, fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTransport(recorder)
+	defer SetTransport(nil)
+
+	slowResult := make(chan HttpResponse, 1)
+	go func() {
+		res, _ := Get(context.Background(), server.URL+"/slow", nil)
+		slowResult <- res
+	}()
+	res, err := Get(context.Background(), server.URL+"/fast?access_token=secret123", nil)
+	close(fastDone)
+	if err != nil || string(res.Body) != `{"token":"secret123"}` {
+		t.Fatalf("fast request: %s %v", res.Body, err)
+	}
+	if res := <-slowResult; res.StatusCode != http.StatusOK {
+		t.Fatalf("slow request blocked by recorder, status: %d", res.StatusCode)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := Get(context.Background(), server.URL+"/counter", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+	fixture, _ := ioutil.ReadFile(fixturePath)
+	if strings.Contains(string(fixture), "secret123") {
+		t.Fatal("secret not sanitized in fixture")
+	}
+
+	replay, err := NewRecorder(RecorderModeReplay, fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTransport(replay)
+	server.Close() //回放不发起网络请求
+	res, err = Get(context.Background(), server.URL+"/fast?access_token=other", nil)
+	if err != nil || string(res.Body) != `{"token":"SANITIZED"}` {
+		t.Fatalf("replay fast: %s %v", res.Body, err)
+	}
+	for _, want := range []string{"n", "nn"} {
+		res, err := Get(context.Background(), server.URL+"/counter", nil)
+		if err != nil || string(res.Body) != want {
+			t.Fatalf("replay counter: %s %v, want %s", res.Body, err, want)
+		}
+	}
+	if _, err := Get(context.Background(), server.URL+"/counter", nil); err == nil || !strings.Contains(err.Error(), ErrInteractionNotFound.Error()) {
+		t.Fatalf("replay beyond fixture err: %v", err)
+	}
+}
+
+// 返回结果未读取完时不保存，提前关闭时只保存已读取的部分
+func TestRecorderPartialBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "panrecorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixturePath := filepath.Join(dir, "fixture.json")
+	recorder, _ := NewRecorder(RecorderModeRecord, fixturePath)
+	client := &http.Client{Transport: recorder}
+
+	resp, err := client.Get(server.URL + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+	fixture, _ := ioutil.ReadFile(fixturePath)
+	if strings.TrimSpace(string(fixture)) != "[]" {
+		t.Fatalf("unread response saved: %s", fixture)
+	}
+	buf := make([]byte, 4)
+	if _, err := resp.Body.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+	replay, err := NewRecorder(RecorderModeReplay, fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = (&http.Client{Transport: replay}).Get(server.URL + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(body) == 0 || !strings.HasPrefix("0123456789", string(body)) {
+		t.Fatalf("replayed body: %q", body)
+	}
+}
+
+// 请求进行中切换Transport，-race下不能有数据竞争
+func TestSetTransportConcurrent(t *testing.T) {
+	defer SetTransport(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				SetTransport(http.DefaultTransport)
+				SetTransport(nil)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if GetClient().Transport == nil {
+					t.Errorf("GetClient returned nil Transport")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	SetTransport(nil)
+	if GetClient().Transport != sharedTransport {
+		t.Fatal("SetTransport(nil) did not restore sharedTransport")
+	}
+}
@@ -0,0 +1,672 @@
+// 内存中的网盘模拟服务，实现用户信息、列表、搜索、文件信息、文件管理、上传、下载等接口
+// 用于测试和基准测试，通过httpclient.SetTransport接入，不访问网络
+package mockpan
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jsyzchen/pan/utils/httpclient"
+)
+
+// 第一个fs_id，19位，超过float64可精确表示的范围，用于发现fs_id精度问题
+const firstFsID uint64 = 1000000000000000001
+
+// 模拟的网盘容量
+const quotaTotal int64 = 2 << 40
+
+const (
+	errnoFileExists   = -8
+	errnoDirNotFound  = -9
+	errnoParamError   = 2
+	errnoBatchFailed  = 12
+	errnoSliceMissing = 31363
+)
+
+type node struct {
+	fsID  uint64
+	path  string
+	isDir bool
+	data  []byte
+	md5   string
+	ctime int64
+	mtime int64
+}
+
+// Server 网盘模拟服务，实现了http.Handler和http.RoundTripper，可并发使用
+type Server struct {
+	VipType int // 会员类型，0普通用户、1普通会员、2超级会员，影响分片大小
+
+	mu      sync.Mutex
+	nodes   map[string]*node
+	byID    map[uint64]*node
+	uploads map[string]map[int][]byte // uploadid => 分片序号 => 分片数据
+	nextID  uint64
+	calls   map[string]int
+}
+
+func NewServer() *Server {
+	s := &Server{
+		nodes:   map[string]*node{},
+		byID:    map[uint64]*node{},
+		uploads: map[string]map[int][]byte{},
+		nextID:  firstFsID,
+		calls:   map[string]int{},
+	}
+	s.nodes["/"] = &node{path: "/", isDir: true}
+	return s
+}
+
+// 设置为全部请求共用的Transport，返回的函数恢复默认Transport
+func (s *Server) Install() func() {
+	httpclient.SetTransport(s)
+	return func() {
+		httpclient.SetTransport(nil)
+	}
+}
+
+// 直接在内存中处理请求，不经过网络
+func (s *Server) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// 启动本地HTTP服务，返回将请求转发到该服务的Transport，用于测量包括网络栈在内的吞吐
+func (s *Server) StartLoopback() (http.RoundTripper, func()) {
+	ts := httptest.NewServer(s)
+	target, _ := url.Parse(ts.URL)
+	rt := &loopbackTransport{target: target, transport: &http.Transport{MaxIdleConnsPerHost: 64}}
+	return rt, func() {
+		rt.transport.CloseIdleConnections()
+		ts.Close()
+	}
+}
+
+type loopbackTransport struct {
+	target    *url.URL
+	transport *http.Transport
+}
+
+func (t *loopbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	r.Host = t.target.Host
+	return t.transport.RoundTrip(r)
+}
+
+// 接口被调用的次数，name为method参数，如precreate、upload、create，下载为download
+func (s *Server) Calls(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[name]
+}
+
+// 写入文件，上级目录不存在时创建，返回fs_id
+func (s *Server) PutFile(filePath string, data []byte) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.putFile(path.Clean(filePath), append([]byte{}, data...))
+	return n.fsID
+}
+
+// 读取文件内容
+func (s *Server) ReadFile(filePath string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[path.Clean(filePath)]
+	if !ok || n.isDir {
+		return nil, false
+	}
+	return append([]byte{}, n.data...), true
+}
+
+// 判断文件或目录是否存在
+func (s *Server) Exists(filePath string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.nodes[path.Clean(filePath)]
+	return ok
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	method := query.Get("method")
+	if strings.HasPrefix(r.URL.Path, "/file/") {
+		method = "download"
+	} else if r.URL.Path == "/api/quota" {
+		method = "quota"
+	}
+	s.mu.Lock()
+	s.calls[method]++
+	s.mu.Unlock()
+
+	switch method {
+	case "uinfo":
+		s.writeJSON(w, map[string]interface{}{"errno": 0, "vip_type": s.VipType, "uk": 1, "baidu_name": "mock", "netdisk_name": "mock", "request_id": "1"})
+	case "quota":
+		s.quota(w)
+	case "list":
+		s.list(w, query)
+	case "listall":
+		s.listAll(w, query)
+	case "search":
+		s.search(w, query)
+	case "filemetas":
+		s.metas(w, query)
+	case "filemanager":
+		s.manage(w, r, query.Get("opera"))
+	case "precreate":
+		s.preCreate(w, r)
+	case "create":
+		s.create(w, r)
+	case "upload":
+		s.uploadSlice(w, r, query)
+	case "download":
+		s.download(w, r)
+	default:
+		s.writeError(w, "errno", errnoParamError)
+	}
+}
+
+func (s *Server) quota(w http.ResponseWriter) {
+	s.mu.Lock()
+	var used int64
+	for _, n := range s.nodes {
+		used += int64(len(n.data))
+	}
+	s.mu.Unlock()
+	s.writeJSON(w, map[string]interface{}{"errno": 0, "total": quotaTotal, "used": used, "free": quotaTotal - used, "expire": false})
+}
+
+func (s *Server) list(w http.ResponseWriter, query url.Values) {
+	dir := path.Clean(query.Get("dir"))
+	start, _ := strconv.Atoi(query.Get("start"))
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 {
+		limit = 1000
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n, ok := s.nodes[dir]; !ok || !n.isDir {
+		s.writeError(w, "errno", errnoDirNotFound)
+		return
+	}
+	children := []*node{}
+	for p, n := range s.nodes {
+		if p != "/" && path.Dir(p) == dir {
+			children = append(children, n)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].isDir != children[j].isDir {
+			return children[i].isDir
+		}
+		return children[i].path < children[j].path
+	})
+	s.writeJSON(w, map[string]interface{}{"errno": 0, "list": s.page(children, start, limit), "request_id": s.requestID()})
+}
+
+func (s *Server) listAll(w http.ResponseWriter, query url.Values) {
+	dir := path.Clean(query.Get("path"))
+	start, _ := strconv.Atoi(query.Get("start"))
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 {
+		limit = 1000
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n, ok := s.nodes[dir]; !ok || !n.isDir {
+		s.writeError(w, "errno", errnoDirNotFound)
+		return
+	}
+	items := s.descendants(dir)
+	list := s.page(items, start, limit)
+	hasMore := 0
+	if start+len(list) < len(items) {
+		hasMore = 1
+	}
+	s.writeJSON(w, map[string]interface{}{"errno": 0, "list": list, "has_more": hasMore, "cursor": start + len(list)})
+}
+
+func (s *Server) search(w http.ResponseWriter, query url.Values) {
+	dir := path.Clean(query.Get("dir"))
+	key := query.Get("key")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []map[string]interface{}{}
+	for _, n := range s.descendants(dir) {
+		if strings.Contains(path.Base(n.path), key) {
+			list = append(list, s.item(n))
+		}
+	}
+	s.writeJSON(w, map[string]interface{}{"errno": 0, "list": list, "has_more": 0, "request_id": s.requestID()})
+}
+
+func (s *Server) metas(w http.ResponseWriter, query url.Values) {
+	fsIDs := []uint64{}
+	if err := json.Unmarshal([]byte(query.Get("fsids")), &fsIDs); err != nil {
+		s.writeError(w, "errno", errnoParamError)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []map[string]interface{}{}
+	for _, fsID := range fsIDs {
+		n, ok := s.byID[fsID]
+		if !ok {
+			continue
+		}
+		item := s.item(n)
+		item["filename"] = path.Base(n.path)
+		if !n.isDir {
+			item["dlink"] = fmt.Sprintf("https://d.pcs.baidu.com/file/%d?fid=%d", n.fsID, n.fsID)
+		}
+		list = append(list, item)
+	}
+	s.writeJSON(w, map[string]interface{}{"errno": 0, "list": list, "request_id": strconv.FormatUint(s.requestID(), 10)})
+}
+
+type manageTask struct {
+	Path    string `json:"path"`
+	Dest    string `json:"dest"`
+	NewName string `json:"newname"`
+}
+
+func (s *Server) manage(w http.ResponseWriter, r *http.Request, opera string) {
+	r.ParseForm()
+	fileList := r.PostForm.Get("filelist")
+	ondup := r.PostForm.Get("ondup")
+	tasks := []manageTask{}
+	if opera == "delete" {
+		paths := []string{}
+		if err := json.Unmarshal([]byte(fileList), &paths); err != nil {
+			s.writeError(w, "errno", errnoParamError)
+			return
+		}
+		for _, p := range paths {
+			tasks = append(tasks, manageTask{Path: p})
+		}
+	} else if err := json.Unmarshal([]byte(fileList), &tasks); err != nil {
+		s.writeError(w, "errno", errnoParamError)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := []map[string]interface{}{}
+	ret := 0
+	for _, task := range tasks {
+		code := s.manageOne(opera, task, ondup)
+		if code != 0 {
+			ret = errnoBatchFailed
+		}
+		info = append(info, map[string]interface{}{"path": task.Path, "errno": code})
+	}
+	s.writeJSON(w, map[string]interface{}{"errno": ret, "info": info, "taskid": 0, "request_id": s.requestID()})
+}
+
+func (s *Server) manageOne(opera string, task manageTask, ondup string) int {
+	src := path.Clean(task.Path)
+	n, ok := s.nodes[src]
+	if !ok || src == "/" {
+		return errnoDirNotFound
+	}
+	if opera == "delete" {
+		s.remove(src)
+		return 0
+	}
+	destDir := path.Clean(task.Dest)
+	if opera == "rename" {
+		destDir = path.Dir(src)
+	}
+	newName := task.NewName
+	if newName == "" {
+		newName = path.Base(src)
+	}
+	dest := path.Join(destDir, newName)
+	if dest == src {
+		return 0
+	}
+	if strings.HasPrefix(dest, src+"/") {
+		return errnoParamError
+	}
+	if _, exists := s.nodes[dest]; exists {
+		switch ondup {
+		case "overwrite":
+			s.remove(dest)
+		case "newcopy":
+			dest = s.freePath(dest)
+		case "skip":
+			return 0
+		default:
+			return errnoFileExists
+		}
+	}
+	s.mkdirAll(destDir)
+	items := append([]*node{n}, s.descendants(src)...)
+	for _, item := range items {
+		newPath := dest + strings.TrimPrefix(item.path, src)
+		if opera == "copy" {
+			c := *item
+			c.path = newPath
+			c.fsID = s.newID()
+			s.nodes[newPath] = &c
+			s.byID[c.fsID] = &c
+			continue
+		}
+		delete(s.nodes, item.path)
+		item.path = newPath
+		s.nodes[newPath] = item
+	}
+	return 0
+}
+
+func (s *Server) preCreate(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	form := r.PostForm
+	filePath := path.Clean(form.Get("path"))
+	size, _ := strconv.ParseInt(form.Get("size"), 10, 64)
+	blockList := []string{}
+	if err := json.Unmarshal([]byte(form.Get("block_list")), &blockList); err != nil {
+		s.writeError(w, "errno", errnoParamError)
+		return
+	}
+	rtype := form.Get("rtype")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.nodes[filePath]; exists && (rtype == "" || rtype == "0") {
+		s.writeError(w, "errno", errnoFileExists)
+		return
+	}
+	if contentMd5 := form.Get("content-md5"); contentMd5 != "" { //秒传
+		for _, n := range s.nodes {
+			if !n.isDir && n.md5 == contentMd5 && int64(len(n.data)) == size {
+				created, code := s.commitFile(filePath, n.data, rtype)
+				if code != 0 {
+					s.writeError(w, "errno", code)
+					return
+				}
+				info := s.item(created)
+				info["request_id"] = s.requestID()
+				s.writeJSON(w, map[string]interface{}{"errno": 0, "return_type": 2, "info": info, "request_id": s.requestID()})
+				return
+			}
+		}
+	}
+	uploadID := fmt.Sprintf("mock-%d", s.newID())
+	s.uploads[uploadID] = map[int][]byte{}
+	needed := make([]int, len(blockList))
+	for i := range needed {
+		needed[i] = i
+	}
+	s.writeJSON(w, map[string]interface{}{"errno": 0, "return_type": 1, "uploadid": uploadID, "path": filePath, "block_list": needed, "request_id": s.requestID()})
+}
+
+func (s *Server) uploadSlice(w http.ResponseWriter, r *http.Request, query url.Values) {
+	partSeq, err := strconv.Atoi(query.Get("partseq"))
+	if err != nil {
+		s.writeError(w, "error_code", errnoParamError)
+		return
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		s.writeError(w, "error_code", errnoParamError)
+		return
+	}
+	var data []byte
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		if part.FileName() != "" {
+			if data, err = ioutil.ReadAll(part); err != nil {
+				s.writeError(w, "error_code", errnoParamError)
+				return
+			}
+		}
+	}
+	uploadID := query.Get("uploadid")
+	s.mu.Lock()
+	parts, ok := s.uploads[uploadID]
+	if ok {
+		parts[partSeq] = data
+	}
+	s.mu.Unlock()
+	if !ok {
+		s.writeError(w, "error_code", errnoSliceMissing)
+		return
+	}
+	sum := md5.Sum(data)
+	s.writeJSON(w, map[string]interface{}{"md5": hex.EncodeToString(sum[:]), "partseq": strconv.Itoa(partSeq), "uploadid": uploadID, "request_id": s.requestID()})
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	form := r.PostForm
+	filePath := path.Clean(form.Get("path"))
+	rtype := form.Get("rtype")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if form.Get("isdir") == "1" {
+		if _, exists := s.nodes[filePath]; exists {
+			if rtype != "1" && rtype != "2" {
+				s.writeError(w, "errno", errnoFileExists)
+				return
+			}
+			filePath = s.freePath(filePath)
+		}
+		n := s.mkdirAll(filePath)
+		s.writeJSON(w, map[string]interface{}{"errno": 0, "fs_id": n.fsID, "path": n.path, "isdir": 1, "category": 6})
+		return
+	}
+
+	parts, ok := s.uploads[form.Get("uploadid")]
+	blockList := []string{}
+	if !ok || json.Unmarshal([]byte(form.Get("block_list")), &blockList) != nil {
+		s.writeError(w, "errno", errnoParamError)
+		return
+	}
+	data := []byte{}
+	for i, blockMd5 := range blockList {
+		part, ok := parts[i]
+		sum := md5.Sum(part)
+		if !ok || hex.EncodeToString(sum[:]) != blockMd5 {
+			s.writeError(w, "errno", errnoSliceMissing)
+			return
+		}
+		data = append(data, part...)
+	}
+	if size, _ := strconv.ParseInt(form.Get("size"), 10, 64); size != int64(len(data)) {
+		s.writeError(w, "errno", errnoParamError)
+		return
+	}
+	n, code := s.commitFile(filePath, data, rtype)
+	if code != 0 {
+		s.writeError(w, "errno", code)
+		return
+	}
+	delete(s.uploads, form.Get("uploadid"))
+	ret := s.item(n)
+	ret["errno"] = 0
+	ret["request_id"] = s.requestID()
+	s.writeJSON(w, ret)
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	fsID, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/file/"), 10, 64)
+	s.mu.Lock()
+	n, ok := s.byID[fsID]
+	var data []byte
+	var name string
+	var mtime int64
+	if ok {
+		data, name, mtime = n.data, path.Base(n.path), n.mtime
+	}
+	s.mu.Unlock()
+	if err != nil || !ok || n.isDir {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, name, time.Unix(mtime, 0), bytes.NewReader(data))
+}
+
+// 按rtype处理同名文件后写入，调用方需持有锁
+func (s *Server) commitFile(filePath string, data []byte, rtype string) (*node, int) {
+	if _, exists := s.nodes[filePath]; exists {
+		switch rtype {
+		case "3":
+			s.remove(filePath)
+		case "1", "2":
+			filePath = s.freePath(filePath)
+		default:
+			return nil, errnoFileExists
+		}
+	}
+	return s.putFile(filePath, data), 0
+}
+
+// 调用方需持有锁
+func (s *Server) putFile(filePath string, data []byte) *node {
+	s.mkdirAll(path.Dir(filePath))
+	sum := md5.Sum(data)
+	now := time.Now().Unix()
+	if old, ok := s.nodes[filePath]; ok {
+		s.remove(old.path)
+	}
+	n := &node{fsID: s.newID(), path: filePath, data: data, md5: hex.EncodeToString(sum[:]), ctime: now, mtime: now}
+	s.nodes[filePath] = n
+	s.byID[n.fsID] = n
+	return n
+}
+
+// 调用方需持有锁
+func (s *Server) mkdirAll(dir string) *node {
+	if n, ok := s.nodes[dir]; ok {
+		return n
+	}
+	s.mkdirAll(path.Dir(dir))
+	now := time.Now().Unix()
+	n := &node{fsID: s.newID(), path: dir, isDir: true, ctime: now, mtime: now}
+	s.nodes[dir] = n
+	s.byID[n.fsID] = n
+	return n
+}
+
+// 删除文件或目录及其下全部文件，调用方需持有锁
+func (s *Server) remove(p string) {
+	for _, n := range append(s.descendants(p), s.nodes[p]) {
+		if n == nil {
+			continue
+		}
+		delete(s.nodes, n.path)
+		delete(s.byID, n.fsID)
+	}
+}
+
+// 目录下的全部文件和目录，按路径排序，调用方需持有锁
+func (s *Server) descendants(dir string) []*node {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	items := []*node{}
+	for p, n := range s.nodes {
+		if p != "/" && strings.HasPrefix(p, prefix) {
+			items = append(items, n)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].path < items[j].path
+	})
+	return items
+}
+
+// 与网盘相同的重命名规则，如a.txt已存在时返回a(1).txt，调用方需持有锁
+func (s *Server) freePath(p string) string {
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s(%d)%s", base, i, ext)
+		if _, exists := s.nodes[candidate]; !exists {
+			return candidate
+		}
+	}
+}
+
+func (s *Server) page(items []*node, start, limit int) []map[string]interface{} {
+	list := []map[string]interface{}{}
+	for i := start; i < len(items) && i < start+limit; i++ {
+		list = append(list, s.item(items[i]))
+	}
+	return list
+}
+
+func (s *Server) item(n *node) map[string]interface{} {
+	isDir, category := 0, 6
+	if n.isDir {
+		isDir = 1
+	}
+	item := map[string]interface{}{
+		"fs_id":           n.fsID,
+		"path":            n.path,
+		"server_filename": path.Base(n.path),
+		"size":            len(n.data),
+		"isdir":           isDir,
+		"category":        category,
+		"server_ctime":    n.ctime,
+		"server_mtime":    n.mtime,
+		"local_ctime":     n.ctime,
+		"local_mtime":     n.mtime,
+	}
+	if !n.isDir {
+		item["md5"] = n.md5
+	}
+	return item
+}
+
+// 调用方需持有锁
+func (s *Server) newID() uint64 {
+	id := s.nextID
+	s.nextID++
+	return id
+}
+
+func (s *Server) requestID() uint64 {
+	return uint64(time.Now().UnixNano())
+}
+
+func (s *Server) writeError(w http.ResponseWriter, field string, code int) {
+	s.writeJSON(w, map[string]interface{}{field: code, "request_id": s.requestID()})
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
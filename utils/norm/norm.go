@@ -0,0 +1,180 @@
+// Unicode规范化，macOS文件名为NFD形式，网盘服务端保存为NFC形式，上传路径和文件比较前需要统一
+package norm
+
+import "sort"
+
+type Form int
+
+const (
+	None Form = iota // 不做处理
+	NFC              // 规范组合
+	NFD              // 规范分解
+)
+
+const (
+	hangulSBase  = 0xAC00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11A7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+	hangulNCount = hangulVCount * hangulTCount
+	hangulSCount = hangulLCount * hangulNCount
+)
+
+type combiningClassRange struct {
+	Lo    rune
+	Hi    rune
+	Class uint8
+}
+
+// 规范组合表，由分解表去掉单字符分解和组合排除字符得到
+var compositions = map[[2]rune]rune{}
+
+func init() {
+	for composite, pair := range decompositions {
+		if pair[1] != 0 && !compositionExclusions[composite] && combiningClass(pair[0]) == 0 {
+			compositions[pair] = composite
+		}
+	}
+}
+
+// 按规范化形式处理字符串
+func (f Form) String(s string) string {
+	switch f {
+	case NFC:
+		return NFCString(s)
+	case NFD:
+		return NFDString(s)
+	}
+	return s
+}
+
+// 转换为NFC形式
+func NFCString(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	return string(compose(decompose(s)))
+}
+
+// 转换为NFD形式
+func NFDString(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	return string(decompose(s))
+}
+
+// 判断两个字符串规范化后是否相同，如NFD形式的文件名与NFC形式的网盘文件名
+func Equal(a, b string) bool {
+	return a == b || NFCString(a) == NFCString(b)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// 完全分解并按规范组合类排序
+func decompose(s string) []rune {
+	ret := make([]rune, 0, len(s))
+	for _, r := range s {
+		ret = appendDecomposed(ret, r)
+	}
+
+	// 连续的非0组合类字符按组合类稳定排序
+	for i := 0; i < len(ret); {
+		if combiningClass(ret[i]) == 0 {
+			i++
+			continue
+		}
+		j := i
+		for j < len(ret) && combiningClass(ret[j]) != 0 {
+			j++
+		}
+		marks := ret[i:j]
+		sort.SliceStable(marks, func(a, b int) bool {
+			return combiningClass(marks[a]) < combiningClass(marks[b])
+		})
+		i = j
+	}
+	return ret
+}
+
+func appendDecomposed(ret []rune, r rune) []rune {
+	if r >= hangulSBase && r < hangulSBase+hangulSCount {
+		sIndex := r - hangulSBase
+		ret = append(ret, hangulLBase+sIndex/hangulNCount, hangulVBase+(sIndex%hangulNCount)/hangulTCount)
+		if t := sIndex % hangulTCount; t != 0 {
+			ret = append(ret, hangulTBase+t)
+		}
+		return ret
+	}
+	pair, ok := decompositions[r]
+	if !ok {
+		return append(ret, r)
+	}
+	ret = appendDecomposed(ret, pair[0])
+	if pair[1] != 0 {
+		ret = appendDecomposed(ret, pair[1])
+	}
+	return ret
+}
+
+// 规范组合，组合标记被其他组合类相同或为0的字符阻断时不组合
+func compose(runes []rune) []rune {
+	ret := make([]rune, 0, len(runes))
+	starter := -1
+	var lastClass uint8
+	for _, r := range runes {
+		class := combiningClass(r)
+		if starter >= 0 {
+			adjacent := len(ret)-1 == starter
+			if adjacent || (lastClass != 0 && lastClass < class) {
+				if composite, ok := composePair(ret[starter], r); ok {
+					ret[starter] = composite
+					continue
+				}
+			}
+		}
+		if class == 0 {
+			starter = len(ret)
+		}
+		ret = append(ret, r)
+		lastClass = class
+	}
+	return ret
+}
+
+func composePair(a, b rune) (rune, bool) {
+	// 韩文 L+V -> LV
+	if a >= hangulLBase && a < hangulLBase+hangulLCount && b >= hangulVBase && b < hangulVBase+hangulVCount {
+		return hangulSBase + ((a-hangulLBase)*hangulVCount+(b-hangulVBase))*hangulTCount, true
+	}
+	// 韩文 LV+T -> LVT
+	if a >= hangulSBase && a < hangulSBase+hangulSCount && (a-hangulSBase)%hangulTCount == 0 &&
+		b > hangulTBase && b < hangulTBase+hangulTCount {
+		return a + (b - hangulTBase), true
+	}
+	composite, ok := compositions[[2]rune{a, b}]
+	return composite, ok
+}
+
+func combiningClass(r rune) uint8 {
+	if r < 0x0300 {
+		return 0
+	}
+	i := sort.Search(len(combiningClasses), func(i int) bool {
+		return combiningClasses[i].Hi >= r
+	})
+	if i < len(combiningClasses) && combiningClasses[i].Lo <= r {
+		return combiningClasses[i].Class
+	}
+	return 0
+}
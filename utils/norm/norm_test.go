@@ -0,0 +1,32 @@
+package norm
+
+import "testing"
+
+func TestForms(t *testing.T) {
+	cases := []struct {
+		nfc, nfd string
+	}{
+		{"caf\u00E9.txt", "cafe\u0301.txt"},
+		{"\u00C5ngstr\u00F6m", "A\u030Angstro\u0308m"},
+		{"\uD55C\uAE00", "\u1112\u1161\u11AB\u1100\u1173\u11AF"}, //韩文音节
+		{"\u1EC7", "e\u0323\u0302"},                              //组合标记按组合类排序
+		{"\u4E2D\u6587.txt", "\u4E2D\u6587.txt"},
+	}
+	for _, c := range cases {
+		if got := NFCString(c.nfd); got != c.nfc {
+			t.Errorf("NFCString(%+q) = %+q, want %+q", c.nfd, got, c.nfc)
+		}
+		if got := NFDString(c.nfc); got != c.nfd {
+			t.Errorf("NFDString(%+q) = %+q, want %+q", c.nfc, got, c.nfd)
+		}
+		if !Equal(c.nfc, c.nfd) {
+			t.Errorf("Equal(%+q, %+q) = false", c.nfc, c.nfd)
+		}
+	}
+	if got := NFCString("e\u0302\u0323"); got != "\u1EC7" {
+		t.Errorf("NFCString with unordered marks = %+q", got)
+	}
+	if None.String("cafe\u0301") != "cafe\u0301" {
+		t.Error("None changed the string")
+	}
+}
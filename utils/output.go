@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"text/tabwriter"
+)
+
+// 将表头和行数据格式化为以空格对齐的文本表格，每行以换行结尾
+func FormatTable(header []string, rows [][]string) string {
+	buf := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	w.Write([]byte(strings.Join(header, "\t") + "\n"))
+	for _, row := range rows {
+		w.Write([]byte(strings.Join(row, "\t") + "\n"))
+	}
+	w.Flush()
+	return buf.String()
+}